@@ -0,0 +1,81 @@
+// identity-audit 驗證 event log 的 hash chain, 有任何 namespace 被竄改時以 exit code 1 結束
+//
+//	identity-audit [-namespace identity.account]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"identity/internal/pkg/database"
+	startup "identity/internal/pkg/initialize"
+	identityMysql "identity/pkg/identity/repository/mysql"
+	"identity/pkg/identity/usecase"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	namespace := flag.String("namespace", "", "only verify this namespace, e.g. identity.account")
+	flag.Parse()
+
+	broken, err := verify(*namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "identity-audit: %v\n", err)
+		os.Exit(2)
+	}
+
+	if broken {
+		os.Exit(1)
+	}
+}
+
+func verify(namespace string) (bool, error) {
+	err := startup.InitConfig()
+	if err != nil {
+		return false, err
+	}
+
+	err = startup.InitLogger()
+	if err != nil {
+		return false, err
+	}
+
+	db, err := startup.InitDatabase("identity_db")
+	if err != nil {
+		return false, err
+	}
+	database.SetDB(db)
+
+	auditSetting, err := startup.InitAudit()
+	if err != nil {
+		return false, err
+	}
+
+	chainSvc := usecase.NewEventLogChainUsecase(identityMysql.NewEventLogChainRepo(), usecase.EventLogChainOptions{
+		CheckpointKey: auditSetting.CheckpointKey,
+	})
+
+	verifications, err := chainSvc.VerifyEventLogChains(context.Background(), namespace)
+	if err != nil {
+		return false, err
+	}
+
+	broken := false
+	for _, verification := range verifications {
+		if verification.Break != nil {
+			broken = true
+			fmt.Printf("BROKEN %s: event log %d %s (expected %q, actual %q), %d event logs verified before the break\n",
+				verification.Namespace, verification.Break.EventLogID, verification.Break.Reason,
+				verification.Break.Expected, verification.Break.Actual, verification.ChainedCount)
+			continue
+		}
+
+		fmt.Printf("OK %s: %d event logs, %d unchained, %d checkpoints, last event log %d %s\n",
+			verification.Namespace, verification.ChainedCount, verification.UnchainedCount,
+			verification.CheckpointCount, verification.LastEventLogID, verification.LastHash)
+	}
+
+	return broken, nil
+}
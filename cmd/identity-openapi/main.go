@@ -0,0 +1,18 @@
+// identity-openapi 從 identity.proto 產生 gateway 的 OpenAPI v3 文件, 輸出到 stdout
+package main
+
+import (
+	"fmt"
+	identityHTTP "identity/pkg/identity/delivery/http"
+	"os"
+)
+
+func main() {
+	data, err := identityHTTP.NewGateway(nil, nil).MarshalOpenAPI()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "identity-openapi: marshal openapi document failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(string(data))
+}
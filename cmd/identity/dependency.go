@@ -1,36 +1,248 @@
 package main
 
 import (
+	"context"
+	"identity/internal/pkg/database"
+	"identity/internal/pkg/global"
+	startup "identity/internal/pkg/initialize"
+	"identity/pkg/domain"
 	identityGRPC "identity/pkg/identity/delivery/grpc"
+	identityHTTP "identity/pkg/identity/delivery/http"
+	identityFile "identity/pkg/identity/repository/file"
+	identityMysql "identity/pkg/identity/repository/mysql"
+	identityNATS "identity/pkg/identity/repository/nats"
+	identityRedis "identity/pkg/identity/repository/redis"
+	identitySIEM "identity/pkg/identity/repository/siem"
+	"identity/pkg/identity/usecase"
+	"time"
 )
 
 var (
-	_identityServer *identityGRPC.IdentityServer
+	_identityServer      *identityGRPC.IdentityServer
+	_identityHandler     *identityHTTP.IdentityHandler
+	_keySvc              domain.KeyUsecase
+	_webhookSvc          domain.WebhookUsecase
+	_webhookPollInterval time.Duration
+	_outboxSvc           domain.OutboxUsecase
+	_outboxPollInterval  time.Duration
+	_eventLogChainSvc    domain.EventLogChainUsecase
+	_checkpointInterval  time.Duration
+	_siemSvc             domain.SIEMUsecase
+	_siemPollInterval    time.Duration
+	_trustedProxies      domain.TrustedProxies
 )
 
 func initialize() error {
-	// ctx := context.Background()
+	err := startup.InitConfig()
+	if err != nil {
+		return err
+	}
 
-	// err := startup.InitConfig()
-	// if err != nil {
-	// 	return err
-	// }
+	err = startup.InitLogger()
+	if err != nil {
+		return err
+	}
 
-	// err = startup.InitLogger()
-	// if err != nil {
-	// 	return err
-	// }
+	db, err := startup.InitDatabase("identity_db")
+	if err != nil {
+		return err
+	}
+	database.SetDB(db)
+	global.DB = db
 
-	// db, err := setupDatabase(cfg)
-	// if err != nil {
-	// 	return err
-	// }
+	rdb, err := startup.InitRedis()
+	if err != nil {
+		return err
+	}
 
-	// accountRepo := identityDatabase.NewAccountRepo(db)
-	// accountSvc := usecase.NewAccountUsecase(accountRepo)
+	ipDB, err := startup.InitGeoIPDB()
+	if err != nil {
+		return err
+	}
 
-	// _identityServer = identityGRPC.NewIdentityServer(accountSvc)
+	sessionPolicies, err := startup.InitSessionPolicies()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	refreshTokenPolicy, err := startup.InitRefreshTokenPolicy()
+	if err != nil {
+		return err
+	}
+
+	jwtSetting, err := startup.InitJWT()
+	if err != nil {
+		return err
+	}
+
+	webhookSetting, err := startup.InitWebhook()
+	if err != nil {
+		return err
+	}
+
+	natsConn, natsSetting, err := startup.InitNATS()
+	if err != nil {
+		return err
+	}
+
+	outboxSetting, err := startup.InitOutbox()
+	if err != nil {
+		return err
+	}
+
+	changeFeedSetting, err := startup.InitChangeFeed()
+	if err != nil {
+		return err
+	}
+
+	auditSetting, err := startup.InitAudit()
+	if err != nil {
+		return err
+	}
+
+	siemSetting, err := startup.InitSIEM()
+	if err != nil {
+		return err
+	}
+
+	_trustedProxies, err = startup.InitTrustedProxies()
+	if err != nil {
+		return err
+	}
+
+	accessTokenFormat := domain.AccessTokenFormatOpaque
+	if jwtSetting.Enabled {
+		keyRepo, err := identityFile.NewKeyRepo(jwtSetting.KeystoreDir)
+		if err != nil {
+			return err
+		}
+
+		keySvc, err := usecase.NewKeyUsecase(keyRepo, usecase.KeyOptions{
+			Algorithm:        jwtSetting.Algorithm,
+			Issuer:           jwtSetting.Issuer,
+			RotationInterval: jwtSetting.RotationInterval,
+			PublishAhead:     jwtSetting.PublishAhead,
+			Retention:        jwtSetting.Retention,
+		})
+		if err != nil {
+			return err
+		}
 
+		// 啟動時先確保有可以簽章的金鑰
+		err = keySvc.RotateKeys(context.Background())
+		if err != nil {
+			return err
+		}
+
+		_keySvc = keySvc
+		accessTokenFormat = domain.AccessTokenFormatJWT
+	}
+
+	accountRepo := identityMysql.NewAccountRepo()
+	// 所有寫入的 event log 都串上所屬 namespace 的 hash chain
+	eventLogChainRepo := identityMysql.NewEventLogChainRepo()
+	chainedEventLogRepo := usecase.NewHashChainEventLogRepo(identityMysql.NewEventLogRepo(), eventLogChainRepo)
+	webhookSvc := usecase.NewWebhookUsecase(identityMysql.NewWebhookRepo(), chainedEventLogRepo, usecase.WebhookOptions{
+		MaxAttempts:    webhookSetting.MaxAttempts,
+		InitialBackoff: webhookSetting.InitialBackoff,
+		MaxBackoff:     webhookSetting.MaxBackoff,
+		Timeout:        webhookSetting.Timeout,
+		Workers:        webhookSetting.Workers,
+	})
+	// 寫入 event log 與 login log 時, 在同一個 transaction 建立 webhook 的 delivery
+	var eventLogRepo domain.EventLogRepository = usecase.NewWebhookEventLogRepo(chainedEventLogRepo, webhookSvc)
+	if natsConn != nil {
+		publisher, err := identityNATS.NewEventPublisher(natsConn, natsSetting.Stream)
+		if err != nil {
+			return err
+		}
+
+		// 有設定 nats 時, 領域事件也在同一個 transaction 寫入 outbox, 再由 relay 發佈到 JetStream
+		outboxRepo := identityMysql.NewOutboxRepo()
+		eventLogRepo = usecase.NewOutboxEventLogRepo(eventLogRepo, outboxRepo)
+		_outboxSvc = usecase.NewOutboxUsecase(outboxRepo, publisher, usecase.OutboxOptions{
+			BatchSize:      outboxSetting.BatchSize,
+			PublishTimeout: outboxSetting.PublishTimeout,
+		})
+		_outboxPollInterval = outboxSetting.PollInterval
+	}
+	loginLogRepo := usecase.NewWebhookLoginLogRepo(identityMysql.NewLoginLogRepo(), webhookSvc)
+	tokenRepo := identityRedis.NewTokenRepo(rdb)
+	sessionRepo := identityRedis.NewSessionRepo(rdb)
+	oauthClientRepo := identityMysql.NewOAuthClientRepo()
+	authorizationCodeRepo := identityRedis.NewAuthorizationCodeRepo(rdb)
+	deviceAuthorizationRepo := identityRedis.NewDeviceAuthorizationRepo(rdb)
+	permissionRepo := identityMysql.NewPermissionRepo()
+	roleRepo := identityMysql.NewRoleRepo()
+	apiKeyRepo := identityMysql.NewAPIKeyRepo()
+
+	accountSvc := usecase.NewAccountUsecase(accountRepo, roleRepo, eventLogRepo, loginLogRepo, ipDB)
+	apiKeySvc := usecase.NewAPIKeyUsecase(apiKeyRepo, accountRepo, roleRepo, eventLogRepo)
+	tokenSvc := usecase.NewTokenUsecase(tokenRepo, sessionRepo, eventLogRepo, ipDB, usecase.TokenOptions{
+		SessionPolicies:    sessionPolicies,
+		RefreshTokenPolicy: refreshTokenPolicy,
+		AccessTokenFormat:  accessTokenFormat,
+		KeySvc:             _keySvc,
+		APIKeySvc:          apiKeySvc,
+	})
+	sessionSvc := usecase.NewSessionUsecase(sessionRepo)
+	oauthSvc := usecase.NewOAuthUsecase(oauthClientRepo, authorizationCodeRepo, deviceAuthorizationRepo, eventLogRepo, accountSvc, tokenSvc, usecase.OAuthOptions{
+		Issuer: jwtSetting.Issuer,
+		KeySvc: _keySvc,
+	})
+
+	impersonationSvc := usecase.NewImpersonationUsecase(accountRepo, permissionRepo, eventLogRepo, tokenSvc)
+	scimSvc := usecase.NewSCIMUsecase(accountSvc, accountRepo, roleRepo, permissionRepo, eventLogRepo)
+	eventLogSvc := usecase.NewEventLogUsecase(identityMysql.NewEventLogRepo())
+	loginLogSvc := usecase.NewLoginLogUsecase(identityMysql.NewLoginLogRepo())
+	changeFeedSvc := usecase.NewChangeFeedUsecase(identityMysql.NewEventLogRepo(), usecase.ChangeFeedOptions{
+		PollInterval: changeFeedSetting.PollInterval,
+		BatchSize:    changeFeedSetting.BatchSize,
+		GapTimeout:   changeFeedSetting.GapTimeout,
+	})
+
+	eventLogChainSvc := usecase.NewEventLogChainUsecase(eventLogChainRepo, usecase.EventLogChainOptions{
+		CheckpointKey: auditSetting.CheckpointKey,
+	})
+
+	_identityServer = identityGRPC.NewIdentityServer(accountSvc, tokenSvc, sessionSvc, oauthSvc, impersonationSvc, apiKeySvc, webhookSvc, changeFeedSvc, eventLogSvc, loginLogSvc, eventLogChainSvc, _trustedProxies)
+	_identityHandler = identityHTTP.NewIdentityHandler(_keySvc, oauthSvc, tokenSvc, scimSvc, _trustedProxies)
+	if siemSetting.Sink != "" {
+		var sink domain.SIEMSink
+		if siemSetting.Sink == domain.SIEMSinkFile {
+			sink, err = identitySIEM.NewFileSink(siemSetting.Path)
+		} else {
+			sink, err = identitySIEM.NewNetworkSink(identitySIEM.NetworkSinkOptions{
+				Network:       siemSetting.Sink,
+				Address:       siemSetting.Address,
+				TLSConfig:     siemSetting.TLSConfig,
+				OctetCounting: siemSetting.Format == domain.SIEMFormatSyslog,
+				Timeout:       siemSetting.Timeout,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		_siemSvc, err = usecase.NewSIEMUsecase(identityMysql.NewSIEMRepo(), identityMysql.NewEventLogRepo(), identityMysql.NewLoginLogRepo(), sink, usecase.SIEMOptions{
+			Name:       siemSetting.Name,
+			Format:     siemSetting.Format,
+			BatchSize:  siemSetting.BatchSize,
+			GapTimeout: siemSetting.GapTimeout,
+			Hostname:   siemSetting.Hostname,
+		})
+		if err != nil {
+			return err
+		}
+		_siemPollInterval = siemSetting.PollInterval
+	}
+
+	_webhookSvc = webhookSvc
+	_webhookPollInterval = webhookSetting.PollInterval
+	if auditSetting.CheckpointKey != nil {
+		_eventLogChainSvc = eventLogChainSvc
+		_checkpointInterval = auditSetting.CheckpointInterval
+	}
+
+	return nil
 }
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	identityGRPC "identity/pkg/identity/delivery/grpc"
+	identityHTTP "identity/pkg/identity/delivery/http"
 	identityProto "identity/pkg/identity/proto"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/nite-coder/blackbear/pkg/config"
 	"github.com/nite-coder/blackbear/pkg/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
@@ -33,11 +42,20 @@ func main() {
 	}
 
 	// start grpc server
-	lis, err := net.Listen("tcp", "cfg.Identity.GRPCBind")
+	grpcBind, err := config.String("identity.grpc_bind", ":17486")
+	if err != nil {
+		log.Fatalf("main: read identity grpc bind failed: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", grpcBind)
 	if err != nil {
 		log.Fatalf("main: bind identity grpc failed: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	// recovery 放在最外層, 連驗證過程中的 panic 都不會讓 process 結束
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(identityGRPC.RecoveryInterceptor, _identityServer.AuthInterceptor),
+		grpc.ChainStreamInterceptor(identityGRPC.StreamRecoveryInterceptor, _identityServer.StreamAuthInterceptor),
+	)
 
 	identityProto.RegisterIdentityServiceServer(grpcServer, _identityServer)
 	log.Info("main: grpc service started")
@@ -48,10 +66,177 @@ func main() {
 		}
 	}()
 
+	// start http server
+	httpBind, err := config.String("identity.http_bind", ":17487")
+	if err != nil {
+		log.Fatalf("main: read identity http bind failed: %v", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:    httpBind,
+		Handler: _identityHandler.Routes(),
+	}
+	log.Info("main: http service started")
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("main: failed to start http server: %v", err)
+		}
+	}()
+
+	// start http/json gateway, 透過 grpc 轉送 request, 給無法使用 grpc 的服務呼叫
+	gatewayBind, err := config.String("identity.gateway_bind", ":17488")
+	if err != nil {
+		log.Fatalf("main: read identity gateway bind failed: %v", err)
+	}
+
+	gatewayConn, err := grpc.Dial(loopbackAddr(grpcBind), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("main: dial identity grpc for gateway failed: %v", err)
+	}
+	defer gatewayConn.Close()
+
+	gatewayServer := &http.Server{
+		Addr:    gatewayBind,
+		Handler: identityHTTP.NewGateway(gatewayConn, _trustedProxies).Routes(),
+	}
+	log.Info("main: gateway service started")
+
+	go func() {
+		if err := gatewayServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("main: failed to start gateway server: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _keySvc != nil {
+		go rotateKeys(ctx)
+	}
+
+	go dispatchWebhooks(ctx)
+
+	if _outboxSvc != nil {
+		go relayOutbox(ctx)
+	}
+
+	if _eventLogChainSvc != nil {
+		go checkpointEventLogs(ctx)
+	}
+
+	if _siemSvc != nil {
+		go exportSIEM(ctx)
+	}
+
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
 	<-stopChan
 	log.Info("main: shutting down server...")
 
+	cancel()
 	grpcServer.GracefulStop()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	_ = httpServer.Shutdown(shutdownCtx)
+	_ = gatewayServer.Shutdown(shutdownCtx)
+}
+
+// loopbackAddr 把 ":17486" 這種只有 port 的 bind 轉成可以連線的位址
+func loopbackAddr(bind string) string {
+	if strings.HasPrefix(bind, ":") {
+		return "localhost" + bind
+	}
+	return bind
+}
+
+// rotateKeys 定期檢查是否需要建立新的 JWT 簽章金鑰, 多個 instance 共用 keystore 時由 keystore 的 lock 互斥, 同一時間只有一個 instance 會輪替
+func rotateKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := _keySvc.RotateKeys(ctx)
+			if err != nil {
+				log.Err(err).Error("main: rotate signing keys failed")
+			}
+		}
+	}
+}
+
+// dispatchWebhooks 定期發送到期的 webhook delivery
+func dispatchWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(_webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := _webhookSvc.Dispatch(ctx)
+			if err != nil {
+				log.Err(err).Error("main: dispatch webhooks failed")
+			}
+		}
+	}
+}
+
+// relayOutbox 定期把 outbox 的領域事件發佈到 nats
+func relayOutbox(ctx context.Context) {
+	ticker := time.NewTicker(_outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := _outboxSvc.Relay(ctx)
+			if err != nil {
+				log.Err(err).Error("main: relay outbox events failed")
+			}
+		}
+	}
+}
+
+// checkpointEventLogs 定期簽署 event log hash chain 的最後位置
+func checkpointEventLogs(ctx context.Context) {
+	ticker := time.NewTicker(_checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := _eventLogChainSvc.CreateEventLogCheckpoints(ctx)
+			if err != nil {
+				log.Err(err).Error("main: create eventLog checkpoints failed")
+			}
+		}
+	}
+}
+
+// exportSIEM 定期把新的 event log 與 login log 匯出到 SIEM
+func exportSIEM(ctx context.Context) {
+	ticker := time.NewTicker(_siemPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := _siemSvc.Export(ctx)
+			if err != nil {
+				log.Err(err).Error("main: export siem records failed")
+			}
+		}
+	}
 }
@@ -0,0 +1,14 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for otp_recovery_codes
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `otp_recovery_codes`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `account_id` bigint NOT NULL,
+  `code_hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `used_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_account_code`(`account_id`, `code_hash`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = latin1 COLLATE = latin1_swedish_ci ROW_FORMAT = DYNAMIC;
@@ -0,0 +1,23 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for oauth_clients
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `oauth_clients`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `client_id` varchar(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `secret_hash` varchar(128) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `name` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `redirect_uris` varchar(2048) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `grant_types` varchar(256) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `scopes` varchar(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `public` tinyint NOT NULL DEFAULT 0,
+  `creator_id` bigint NOT NULL,
+  `creator_name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `updated_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE INDEX `uniq_client_id`(`client_id`) USING BTREE,
+  INDEX `idx_namespace`(`namespace`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
@@ -0,0 +1,16 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for permissions
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `permissions`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `account_id` bigint NOT NULL,
+  `creator_id` bigint NOT NULL,
+  `creator_name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE INDEX `uniq_code`(`namespace`, `name`, `account_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
@@ -0,0 +1,20 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for api_keys
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `api_keys`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `account_id` bigint NOT NULL,
+  `name` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `key_id` varchar(32) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `secret_hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `scopes` varchar(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `expires_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `last_used_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE INDEX `uniq_key_id`(`key_id`) USING BTREE,
+  INDEX `idx_account`(`namespace`, `account_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
@@ -0,0 +1,41 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for webhooks
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `webhooks`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `url` varchar(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `secret` varchar(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `events` varchar(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `state` int NOT NULL,
+  `creator_name` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `updated_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_namespace`(`namespace`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
+
+-- ----------------------------
+-- Table structure for webhook_deliveries
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `webhook_deliveries`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `webhook_id` bigint UNSIGNED NOT NULL,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `event_id` varchar(36) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `event_type` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `payload` json NOT NULL,
+  `state` int NOT NULL,
+  `attempts` int NOT NULL,
+  `response_status` int NOT NULL,
+  `last_error` varchar(512) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `next_attempt_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `delivered_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `updated_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_webhook`(`webhook_id`) USING BTREE,
+  INDEX `idx_state`(`state`, `next_attempt_at`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
@@ -0,0 +1,20 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for outbox_events
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `outbox_events`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `aggregate_type` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `aggregate_id` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `event_id` varchar(36) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `event_type` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `subject` varchar(512) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `payload` json NOT NULL,
+  `state` int NOT NULL,
+  `published_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_state`(`state`, `id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
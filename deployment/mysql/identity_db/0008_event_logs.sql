@@ -0,0 +1,23 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for event_logs
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `event_logs`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `action` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `target_id` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `message` varchar(512) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `old_status` json NOT NULL,
+  `new_status` json NOT NULL,
+  `state` int NOT NULL,
+  `client_ip` varchar(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `actor` varchar(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_namespace_action`(`namespace`, `action`) USING BTREE,
+  INDEX `idx_target_id`(`target_id`) USING BTREE,
+  INDEX `idx_actor`(`actor`) USING BTREE,
+  INDEX `idx_created_at`(`created_at`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
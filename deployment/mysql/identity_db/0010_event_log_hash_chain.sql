@@ -0,0 +1,35 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Hash chain columns for event_logs
+-- ----------------------------
+ALTER TABLE `event_logs`
+  ADD COLUMN `prev_hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL DEFAULT '' AFTER `created_at`,
+  ADD COLUMN `hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL DEFAULT '' AFTER `prev_hash`,
+  ADD INDEX `idx_namespace`(`namespace`) USING BTREE;
+
+-- ----------------------------
+-- Table structure for event_log_chains
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `event_log_chains`  (
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `last_event_log_id` bigint UNSIGNED NOT NULL DEFAULT 0,
+  `last_hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL DEFAULT '',
+  `updated_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`namespace`) USING BTREE
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
+
+-- ----------------------------
+-- Table structure for event_log_checkpoints
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `event_log_checkpoints`  (
+  `id` bigint UNSIGNED NOT NULL AUTO_INCREMENT,
+  `namespace` varchar(256) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `event_log_id` bigint UNSIGNED NOT NULL,
+  `hash` char(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `key_id` varchar(64) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `signature` varchar(256) CHARACTER SET latin1 COLLATE latin1_swedish_ci NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`id`) USING BTREE,
+  INDEX `idx_namespace_event_log_id`(`namespace`, `event_log_id`) USING BTREE
+) ENGINE = InnoDB AUTO_INCREMENT = 1 CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
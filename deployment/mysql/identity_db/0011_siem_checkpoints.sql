@@ -0,0 +1,12 @@
+SET NAMES utf8mb4;
+
+-- ----------------------------
+-- Table structure for siem_checkpoints
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS `siem_checkpoints`  (
+  `name` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL,
+  `last_event_log_id` bigint UNSIGNED NOT NULL DEFAULT 0,
+  `last_login_log_id` bigint UNSIGNED NOT NULL DEFAULT 0,
+  `updated_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+  PRIMARY KEY (`name`) USING BTREE
+) ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE = utf8mb4_general_ci ROW_FORMAT = DYNAMIC;
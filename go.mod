@@ -3,18 +3,27 @@ module identity
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.22.0
 	github.com/cenkalti/backoff v2.2.1+incompatible
+	github.com/go-redis/redis/v8 v8.11.5
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golang-jwt/jwt/v4 v4.4.2
 	github.com/golang-migrate/migrate/v4 v4.15.2
 	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/google/uuid v1.3.0
 	github.com/lib/pq v1.10.6 // indirect
+	github.com/nats-io/nats-server/v2 v2.8.4
+	github.com/nats-io/nats.go v1.16.0
 	github.com/nite-coder/blackbear v0.0.0-20220615151045-ffadaa53245f
 	github.com/oschwald/geoip2-golang v1.7.0
+	github.com/pquerna/otp v1.3.0
 	github.com/stretchr/testify v1.8.0
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
+	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad
+	google.golang.org/genproto v0.0.0-20220314164441-57ef72a4c106
 	google.golang.org/grpc v1.48.0
 	google.golang.org/protobuf v1.28.0
+	gopkg.in/square/go-jose.v2 v2.6.0
 	gorm.io/datatypes v1.0.7
 	gorm.io/driver/mysql v1.3.5
 	gorm.io/driver/postgres v1.3.8
@@ -0,0 +1,74 @@
+package initialize
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type Audit struct {
+	// CheckpointKey 是簽署 event log hash chain checkpoint 的 Ed25519 私鑰, 沒有設定 checkpoint_key_file 時為 nil
+	CheckpointKey      ed25519.PrivateKey
+	CheckpointInterval time.Duration
+}
+
+// InitAudit 讀取 event log hash chain 的設定
+// 金鑰可以用 openssl genpkey -algorithm ed25519 -out checkpoint.pem 產生
+func InitAudit() (Audit, error) {
+	setting := Audit{}
+
+	var err error
+	setting.CheckpointInterval, err = config.Duration("identity.audit.checkpoint_interval", time.Hour)
+	if err != nil {
+		return setting, err
+	}
+
+	keyFile, err := config.String("identity.audit.checkpoint_key_file", "")
+	if err != nil {
+		return setting, err
+	}
+
+	if keyFile == "" {
+		return setting, nil
+	}
+
+	if setting.CheckpointInterval <= 0 {
+		return setting, fmt.Errorf("startup: audit checkpoint_interval is invalid. checkpoint_interval: %s", setting.CheckpointInterval)
+	}
+
+	setting.CheckpointKey, err = loadEd25519PrivateKey(keyFile)
+	if err != nil {
+		return setting, fmt.Errorf("startup: load audit checkpoint key failed: %w", err)
+	}
+
+	return setting, nil
+}
+
+func loadEd25519PrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,36 @@
+package initialize
+
+import (
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type ChangeFeed struct {
+	PollInterval time.Duration
+	BatchSize    int
+	GapTimeout   time.Duration
+}
+
+// InitChangeFeed 讀取 Watch RPC 的設定, 沒有設定的欄位使用 usecase 的預設值
+func InitChangeFeed() (ChangeFeed, error) {
+	setting := ChangeFeed{}
+
+	var err error
+	setting.PollInterval, err = config.Duration("identity.change_feed.poll_interval", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.BatchSize, err = config.Int("identity.change_feed.batch_size", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.GapTimeout, err = config.Duration("identity.change_feed.gap_timeout", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	return setting, nil
+}
@@ -0,0 +1,66 @@
+package initialize
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type JWT struct {
+	Enabled          bool
+	Algorithm        string
+	Issuer           string
+	KeystoreDir      string
+	RotationInterval time.Duration
+	PublishAhead     time.Duration
+	Retention        time.Duration
+}
+
+// InitJWT 讀取 JWT accessToken 的設定, 沒有設定時代表不啟用
+func InitJWT() (JWT, error) {
+	setting := JWT{}
+
+	var err error
+	setting.Enabled, err = config.Bool("identity.jwt.enabled", false)
+	if err != nil {
+		return setting, err
+	}
+
+	if !setting.Enabled {
+		return setting, nil
+	}
+
+	setting.Algorithm, err = config.String("identity.jwt.algorithm", "RS256")
+	if err != nil {
+		return setting, err
+	}
+
+	setting.Issuer, err = config.String("identity.jwt.issuer", "")
+	if err != nil {
+		return setting, err
+	}
+
+	setting.KeystoreDir, err = config.String("identity.jwt.keystore_dir", "./keystore")
+	if err != nil {
+		return setting, err
+	}
+
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"identity.jwt.rotation_interval", &setting.RotationInterval},
+		{"identity.jwt.publish_ahead", &setting.PublishAhead},
+		{"identity.jwt.retention", &setting.Retention},
+	}
+	for _, d := range durations {
+		*d.value, err = config.Duration(d.key, 0)
+		if err != nil && !errors.Is(err, config.ErrKeyNotFound) {
+			return setting, fmt.Errorf("startup: read %s failed: %w", d.key, err)
+		}
+	}
+
+	return setting, nil
+}
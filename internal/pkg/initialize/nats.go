@@ -0,0 +1,88 @@
+package initialize
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/nats-io/nats.go"
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type NATS struct {
+	Address  string
+	Username string
+	Password string
+	Stream   string
+}
+
+type Outbox struct {
+	PollInterval   time.Duration
+	BatchSize      int
+	PublishTimeout time.Duration
+}
+
+// InitNATS 沒有設定 address 時回傳 nil, 代表不發佈領域事件
+func InitNATS() (*nats.Conn, NATS, error) {
+	setting := NATS{}
+	err := config.Scan("nats", &setting)
+	if err != nil {
+		return nil, setting, err
+	}
+
+	if setting.Address == "" {
+		return nil, setting, nil
+	}
+	if setting.Stream == "" {
+		setting.Stream = "IDENTITY"
+	}
+
+	opts := []nats.Option{
+		nats.Name("identity"),
+		nats.MaxReconnects(-1),
+	}
+	if setting.Username != "" {
+		opts = append(opts, nats.UserInfo(setting.Username, setting.Password))
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = time.Duration(30) * time.Second
+
+	var conn *nats.Conn
+	err = backoff.Retry(func() error {
+		conn, err = nats.Connect(setting.Address, opts...)
+		return err
+	}, bo)
+	if err != nil {
+		return nil, setting, fmt.Errorf("startup: nats connect failed. address: %s, error: %w", setting.Address, err)
+	}
+
+	return conn, setting, nil
+}
+
+// InitOutbox 讀取 outbox relay 的設定, 沒有設定的欄位使用 usecase 的預設值
+func InitOutbox() (Outbox, error) {
+	setting := Outbox{}
+
+	var err error
+	setting.PollInterval, err = config.Duration("identity.outbox.poll_interval", time.Second)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.BatchSize, err = config.Int("identity.outbox.batch_size", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.PublishTimeout, err = config.Duration("identity.outbox.publish_timeout", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	if setting.PollInterval <= 0 {
+		return setting, fmt.Errorf("startup: outbox poll_interval is invalid. poll_interval: %s", setting.PollInterval)
+	}
+
+	return setting, nil
+}
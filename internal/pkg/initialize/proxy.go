@@ -0,0 +1,19 @@
+package initialize
+
+import (
+	"errors"
+	"identity/pkg/domain"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+// InitTrustedProxies 讀取可以信任 X-Forwarded-For 的 proxy 位址, 沒有設定時只信任 loopback
+func InitTrustedProxies() (domain.TrustedProxies, error) {
+	values := []string{}
+	err := config.Scan("identity.trusted_proxies", &values)
+	if err != nil && !errors.Is(err, config.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	return domain.ParseTrustedProxies(values)
+}
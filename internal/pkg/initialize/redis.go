@@ -1,7 +1,43 @@
 package initialize
 
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-redis/redis/v8"
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
 type Redis struct {
 	Address  string
 	Password string
 	DB       int
 }
+
+func InitRedis() (*redis.Client, error) {
+	setting := Redis{}
+	err := config.Scan("redis", &setting)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     setting.Address,
+		Password: setting.Password,
+		DB:       setting.DB,
+	})
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = time.Duration(30) * time.Second
+
+	err = backoff.Retry(func() error {
+		return client.Ping(context.Background()).Err()
+	}, bo)
+	if err != nil {
+		return nil, fmt.Errorf("startup: redis connect failed. address: %s, error: %w", setting.Address, err)
+	}
+
+	return client, nil
+}
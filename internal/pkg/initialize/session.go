@@ -0,0 +1,54 @@
+package initialize
+
+import (
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+// InitSessionPolicies 讀取每個 namespace 的 session 上限設定, 沒有設定時代表不限制
+func InitSessionPolicies() ([]domain.SessionPolicy, error) {
+	policies := []domain.SessionPolicy{}
+	err := config.Scan("identity.session_policies", &policies)
+	if err != nil {
+		if errors.Is(err, config.ErrKeyNotFound) {
+			return policies, nil
+		}
+		return nil, err
+	}
+
+	for _, policy := range policies {
+		switch policy.OnLimit {
+		case "", domain.SessionLimitEvictOldest, domain.SessionLimitReject:
+		default:
+			return nil, fmt.Errorf("startup: session policy on_limit is invalid. namespace: %s, on_limit: %s", policy.Namespace, policy.OnLimit)
+		}
+	}
+
+	return policies, nil
+}
+
+// InitRefreshTokenPolicy 讀取 refreshToken 輪替的限制, 沒有設定時代表不限制
+func InitRefreshTokenPolicy() (domain.RefreshTokenPolicy, error) {
+	policy := domain.RefreshTokenPolicy{}
+
+	absoluteLifetime, err := config.Duration("identity.refresh_token.absolute_lifetime", 0)
+	if err != nil {
+		return policy, err
+	}
+
+	idleTimeout, err := config.Duration("identity.refresh_token.idle_timeout", 0)
+	if err != nil {
+		return policy, err
+	}
+
+	if absoluteLifetime < 0 || idleTimeout < 0 {
+		return policy, fmt.Errorf("startup: refresh token policy is invalid. absolute_lifetime: %s, idle_timeout: %s", absoluteLifetime, idleTimeout)
+	}
+
+	policy.AbsoluteLifetime = absoluteLifetime
+	policy.IdleTimeout = idleTimeout
+	return policy, nil
+}
@@ -0,0 +1,134 @@
+package initialize
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"identity/pkg/domain"
+	"io/ioutil"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type SIEM struct {
+	// Sink 是 domain.SIEMSinkFile, domain.SIEMSinkTCP, domain.SIEMSinkUDP 或 domain.SIEMSinkTLS, 沒有設定時不匯出
+	Sink      string
+	Format    string
+	Name      string
+	Address   string
+	Path      string
+	TLSConfig *tls.Config
+	Hostname  string
+
+	PollInterval time.Duration
+	BatchSize    int
+	GapTimeout   time.Duration
+	Timeout      time.Duration
+}
+
+// InitSIEM 讀取匯出 event log 與 login log 到 SIEM 的設定
+func InitSIEM() (SIEM, error) {
+	setting := SIEM{}
+
+	var err error
+	values := []struct {
+		key   string
+		value *string
+		def   string
+	}{
+		{"identity.siem.sink", &setting.Sink, ""},
+		{"identity.siem.format", &setting.Format, domain.SIEMFormatJSON},
+		{"identity.siem.name", &setting.Name, "default"},
+		{"identity.siem.address", &setting.Address, ""},
+		{"identity.siem.path", &setting.Path, ""},
+		{"identity.siem.hostname", &setting.Hostname, ""},
+	}
+	for _, s := range values {
+		*s.value, err = config.String(s.key, s.def)
+		if err != nil {
+			return setting, err
+		}
+	}
+
+	if setting.Sink == "" {
+		return setting, nil
+	}
+
+	setting.PollInterval, err = config.Duration("identity.siem.poll_interval", 5*time.Second)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.BatchSize, err = config.Int("identity.siem.batch_size", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.GapTimeout, err = config.Duration("identity.siem.gap_timeout", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.Timeout, err = config.Duration("identity.siem.timeout", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	if setting.PollInterval <= 0 {
+		return setting, fmt.Errorf("startup: siem poll_interval is invalid. poll_interval: %s", setting.PollInterval)
+	}
+
+	switch setting.Sink {
+	case domain.SIEMSinkFile:
+		if setting.Path == "" {
+			return setting, fmt.Errorf("startup: siem path is required for file sink")
+		}
+	case domain.SIEMSinkTCP, domain.SIEMSinkUDP, domain.SIEMSinkTLS:
+		if setting.Address == "" {
+			return setting, fmt.Errorf("startup: siem address is required for %s sink", setting.Sink)
+		}
+	default:
+		return setting, fmt.Errorf("startup: siem sink is invalid. sink: %s", setting.Sink)
+	}
+
+	if setting.Sink == domain.SIEMSinkTLS {
+		setting.TLSConfig, err = siemTLSConfig()
+		if err != nil {
+			return setting, err
+		}
+	}
+
+	return setting, nil
+}
+
+// siemTLSConfig 有設定 tls_ca_file 時只信任這個 CA, 否則使用系統的 CA
+func siemTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	var err error
+	tlsConfig.ServerName, err = config.String("identity.siem.tls_server_name", "")
+	if err != nil {
+		return nil, err
+	}
+
+	caFile, err := config.String("identity.siem.tls_ca_file", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("startup: read siem tls_ca_file failed: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("startup: siem tls_ca_file %s has no certificate", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,60 @@
+package initialize
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/config"
+)
+
+type Webhook struct {
+	PollInterval   time.Duration
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	Workers        int
+}
+
+// InitWebhook 讀取 webhook 發送的設定, 沒有設定的欄位使用 usecase 的預設值
+func InitWebhook() (Webhook, error) {
+	setting := Webhook{}
+
+	var err error
+	setting.PollInterval, err = config.Duration("identity.webhook.poll_interval", 5*time.Second)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.MaxAttempts, err = config.Int("identity.webhook.max_attempts", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	setting.Workers, err = config.Int("identity.webhook.workers", 0)
+	if err != nil {
+		return setting, err
+	}
+
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"identity.webhook.initial_backoff", &setting.InitialBackoff},
+		{"identity.webhook.max_backoff", &setting.MaxBackoff},
+		{"identity.webhook.timeout", &setting.Timeout},
+	}
+	for _, d := range durations {
+		*d.value, err = config.Duration(d.key, 0)
+		if err != nil && !errors.Is(err, config.ErrKeyNotFound) {
+			return setting, fmt.Errorf("startup: read %s failed: %w", d.key, err)
+		}
+	}
+
+	if setting.PollInterval <= 0 {
+		return setting, fmt.Errorf("startup: webhook poll_interval is invalid. poll_interval: %s", setting.PollInterval)
+	}
+
+	return setting, nil
+}
@@ -18,6 +18,27 @@ const (
 
 type AccountState int32
 
+// AccountType 帳號的種類
+type AccountType int32
+
+const (
+	// AccountTypeUser 一般使用者, 可以用密碼登入
+	AccountTypeUser AccountType = 0
+	// AccountTypeService service account, 給 CI 或其他系統使用, 不能用密碼登入, 只能使用 API key
+	AccountTypeService AccountType = 1
+)
+
+func (t AccountType) String() string {
+	switch t {
+	case AccountTypeUser:
+		return "user"
+	case AccountTypeService:
+		return "service"
+	default:
+		return "unknown"
+	}
+}
+
 func (state AccountState) String() string {
 	switch state {
 	case AccountStatusNormal:
@@ -36,7 +57,7 @@ type Account struct {
 	ID                    uint64         `gorm:"column:id;primaryKey;autoIncrement;not null"`
 	UUID                  string         `gorm:"column:uuid;type:char(36); size:36; uniqueIndex:uniq_uuid; default:''; not null"`
 	Namespace             string         `gorm:"column:namespace; type:string; size:256; uniqueIndex:uniq_username; uniqueIndex:uniq_email; uniqueIndex:uniq_mobile; default:''; not null"`
-	Type                  int32          `gorm:"column:type;type:int; default:0; not null"`
+	Type                  AccountType    `gorm:"column:type;type:int; default:0; not null"`
 	Username              sql.NullString `gorm:"column:username;type:string;size:24;uniqueIndex:uniq_username;"`
 	PasswordEncrypt       string         `gorm:"column:password_encrypt;type:string;size:128;not null"`
 	NickName              string         `gorm:"column:nick_name;type:string;size:24;not null"`
@@ -79,6 +100,7 @@ type FindAccountOptions struct {
 	Namespace         string
 	Username          string
 	Email             string
+	EmailContains     string
 	MobileCountryCode string
 	Mobile            string
 	Role              []string
@@ -93,7 +115,7 @@ type FindAccountOptions struct {
 	LoginTimeStart    time.Time
 	LoginTimeEnd      time.Time
 	Keyword           string
-	Type              int32
+	Type              AccountType
 }
 
 type LoginType uint32
@@ -181,8 +203,10 @@ type AddRolesToAccountRequest struct {
 }
 
 type ResetOTPSecretRequest struct {
-	Namespace string
-	AccountID uint64
+	Namespace   string
+	AccountID   uint64
+	UpdaterID   uint64
+	UpdaterName string
 }
 
 type VerifyOTPRequest struct {
@@ -191,6 +215,36 @@ type VerifyOTPRequest struct {
 	OTPCode   string
 }
 
+type ClearOTPRequest struct {
+	Namespace   string
+	AccountID   uint64
+	UpdaterID   uint64
+	UpdaterName string
+}
+
+type GenerateOTPRecoveryCodesRequest struct {
+	Namespace   string
+	AccountID   uint64
+	UpdaterID   uint64
+	UpdaterName string
+}
+
+// OTPAuth 重置 OTP 後回傳給使用者的資訊, 恢復碼只會在產生時出現一次
+type OTPAuth struct {
+	Secret        string
+	URL           string
+	RecoveryCodes []string
+}
+
+// OTPRecoveryCode 手機遺失時用來取代 OTP code 的一次性恢復碼, 只儲存 hash
+type OTPRecoveryCode struct {
+	ID        uint64    `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	AccountID uint64    `gorm:"column:account_id;type:bigint;index:idx_account_code;not null"`
+	CodeHash  string    `gorm:"column:code_hash;type:char(64);size:64;index:idx_account_code;not null"`
+	UsedAt    time.Time `gorm:"column:used_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
 // AccountUsecase 用來處理 Account 相關業務操作的場景
 type AccountUsecase interface {
 	Account(ctx context.Context, namespace string, accountID uint64) (*Account, error)
@@ -203,8 +257,10 @@ type AccountUsecase interface {
 	ForceUpdateAccountPassword(ctx context.Context, request ForceUpdateAccountPasswordRequest) error
 	ChangeState(ctx context.Context, request ChangeStateRequest) error
 	Login(ctx context.Context, loginInfo LoginInfo) (*Account, error)
-	ResetOTPSecret(ctx context.Context, request ResetOTPSecretRequest) (string, error)
+	ResetOTPSecret(ctx context.Context, request ResetOTPSecretRequest) (*OTPAuth, error)
+	ClearOTP(ctx context.Context, request ClearOTPRequest) error
 	VerifyOTP(ctx context.Context, accountUUID string, request VerifyOTPRequest) error
+	GenerateOTPRecoveryCodes(ctx context.Context, request GenerateOTPRecoveryCodesRequest) ([]string, error)
 	AddRolesToAccount(ctx context.Context, request AddRolesToAccountRequest) error
 }
 
@@ -219,10 +275,20 @@ type AccountRepository interface {
 	UpdateAccountPassword(ctx context.Context, account *Account) error
 	UpdateState(ctx context.Context, account *Account) error
 	UpdateOTPSecret(ctx context.Context, account *Account) (string, error)
+	CreateOTPRecoveryCodes(ctx context.Context, accountID uint64, codes []*OTPRecoveryCode) error
+	DeleteOTPRecoveryCodes(ctx context.Context, accountID uint64) error
+	UseOTPRecoveryCode(ctx context.Context, accountID uint64, codeHash string) error
+	CountOTPRecoveryCodes(ctx context.Context, accountID uint64) (int64, error)
 	CountAccounts(ctx context.Context, options FindAccountOptions) (int64, error)
 	AddRolesToAccount(ctx context.Context, request AddRolesToAccountRequest) error
 }
 
 type LoginLogRepository interface {
 	CreateLoginLog(ctx context.Context, loginLog *LoginLog) error
+	// LoginLogsAfter 依照 ID 的順序回傳 ID 大於 afterID 的 login log
+	LoginLogsAfter(ctx context.Context, afterID uint64, limit int) ([]LoginLog, error)
+	LoginLogs(ctx context.Context, opts FindLoginLogOptions) ([]LoginLog, error)
+	CountLoginLogs(ctx context.Context, opts FindLoginLogOptions) (int64, error)
+	// LoginCountries 回傳符合條件的 login log 中出現過的國家代碼, 不包含查不到位置的紀錄
+	LoginCountries(ctx context.Context, opts FindLoginLogOptions) ([]string, error)
 }
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrServiceAccountLogin service account 不能用密碼登入
+	ErrServiceAccountLogin = &AppError{Code: "SERVICE_ACCOUNT_LOGIN", Message: "service account can not log in with password", Status: codes.PermissionDenied}
+)
+
+const (
+	// APIKeyPrefix API key 的開頭, 方便 secret scanner 辨識外洩的 key
+	APIKeyPrefix = "idk_"
+
+	// ClaimRole 帳號的角色, 多個值用空白分隔
+	ClaimRole = "role"
+	// ClaimAPIKeyID 透過 API key 驗證時, 使用的 API key id
+	ClaimAPIKeyID = "api_key_id"
+
+	// AMRAPIKey 使用 API key 驗證
+	AMRAPIKey = "apikey"
+)
+
+// APIKey 帳號自己建立的長效憑證, 給 CI 或 script 使用, secret 只儲存 hash
+// 完整的 key 為 KeyID + "_" + secret, KeyID 以 APIKeyPrefix 開頭, 用來查詢 key
+type APIKey struct {
+	ID         uint64    `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	Namespace  string    `gorm:"column:namespace;type:string;size:256;index:idx_account;not null"`
+	AccountID  uint64    `gorm:"column:account_id;type:bigint;index:idx_account;not null"`
+	Name       string    `gorm:"column:name;type:string;size:64;not null"`
+	KeyID      string    `gorm:"column:key_id;type:string;size:32;uniqueIndex:uniq_key_id;not null"`
+	SecretHash string    `gorm:"column:secret_hash;type:char(64);size:64;not null"`
+	Scopes     string    `gorm:"column:scopes;type:string;size:1024;not null"`
+	ExpiresAt  time.Time `gorm:"column:expires_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	LastUsedAt time.Time `gorm:"column:last_used_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	CreatedAt  time.Time `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// IsExpired 判斷 key 是否過期, 沒有設定 ExpiresAt 的 key 不會過期
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt.Unix() > 0 && !now.Before(k.ExpiresAt)
+}
+
+// IsAPIKey 判斷字串是不是 API key, 用來跟 accessToken 區分
+func IsAPIKey(key string) bool {
+	return strings.HasPrefix(key, APIKeyPrefix)
+}
+
+type CreateAPIKeyRequest struct {
+	Namespace string
+	AccountID uint64
+	Name      string
+	Scopes    string
+	// ExpiresIn key 的存活時間, 0 代表不會過期
+	ExpiresIn time.Duration
+}
+
+type RevokeAPIKeyRequest struct {
+	Namespace string
+	AccountID uint64
+	KeyID     string
+}
+
+// APIKeyUsecase 用來處理 API key 的場景
+type APIKeyUsecase interface {
+	// CreateAPIKey 建立 API key, 完整的 key 只會在建立時回傳一次
+	CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (*APIKey, string, error)
+	APIKeys(ctx context.Context, namespace string, accountID uint64) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, request RevokeAPIKeyRequest) error
+	// ResolveAPIKey 驗證 API key 並回傳跟 accessToken 一樣的 Token, 包含帳號的角色與 key 的 scope
+	ResolveAPIKey(ctx context.Context, key string) (*Token, error)
+}
+
+// APIKeyRepository 用來處理 API key 物件的存儲的行為 repository layer
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	APIKey(ctx context.Context, keyID string) (*APIKey, error)
+	APIKeysByAccountID(ctx context.Context, namespace string, accountID uint64) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, keyID string) error
+	TouchAPIKey(ctx context.Context, keyID string, lastUsedAt time.Time) error
+}
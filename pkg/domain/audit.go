@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"context"
+	"strconv"
+)
+
+var (
+	auditKey = &struct {
+		name string
+	}{
+		name: "audit",
+	}
+)
+
+// Audit 是寫入 event log 時的呼叫端資訊, 由 delivery 層依照呼叫端的 token 與連線放進 context
+type Audit struct {
+	Actor    string
+	ClientIP string
+}
+
+// NewAuditContext 產生一個包含呼叫端資訊的新 context
+func NewAuditContext(ctx context.Context, audit Audit) context.Context {
+	return context.WithValue(ctx, auditKey, audit)
+}
+
+// AuditFromContext 從 context 裡面取得呼叫端資訊, 沒有時回傳空的 Audit
+func AuditFromContext(ctx context.Context) Audit {
+	audit, _ := ctx.Value(auditKey).(Audit)
+	return audit
+}
+
+// Actor 回傳 claims 代表的操作者, 模擬登入時是實際操作的 admin
+func (claims Claims) Actor() string {
+	if name, ok := claims[ClaimImpersonatorName].(string); ok && name != "" {
+		return name
+	}
+	if username, ok := claims[ClaimUsername].(string); ok && username != "" {
+		return username
+	}
+	if accountID, ok := claims[ClaimAccountID].(int64); ok && accountID != 0 {
+		return strconv.FormatInt(accountID, 10)
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ChangeEventHeartbeat 沒有符合條件的事件但是 cursor 已經前進時送出, 讓 client 更新 cursor, 重新連線時不用重新掃描
+const ChangeEventHeartbeat = "heartbeat"
+
+// ChangeEvent 是 Watch 送出的帳號或角色異動, Cursor 是 event log 的 ID
+// 同一筆 event log 可能產生多個事件 (例如角色成員異動), 只有最後一個事件的 Cursor 是這筆 event log 的 ID,
+// 前面的事件帶的是上一個位置, 所以用任何收到的 Cursor 重新連線都不會漏掉事件
+type ChangeEvent struct {
+	Cursor     uint64
+	Type       string
+	Namespace  string
+	TargetID   string
+	Actor      string
+	Data       json.RawMessage
+	OccurredAt time.Time
+}
+
+type WatchRequest struct {
+	Namespace string
+	// Cursor 為 0 時從目前最新的位置開始, 否則從這個 event log ID 之後開始
+	Cursor uint64
+	// Events 事件類型的過濾條件, 與 webhook 相同可以使用 "account.*", 沒有設定時回傳全部帳號與角色的事件
+	Events []string
+}
+
+// MatchEventType 判斷事件類型是否符合過濾條件, 支援 "*" 與 "account.*"
+func MatchEventType(filters []string, eventType string) bool {
+	for _, filter := range filters {
+		if filter == "*" || filter == eventType {
+			return true
+		}
+		if strings.HasSuffix(filter, ".*") && strings.HasPrefix(eventType, filter[:len(filter)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeFeedUsecase 從 event log 提供帳號與角色異動的 change feed
+type ChangeFeedUsecase interface {
+	// Watch 依照順序把事件交給 send, 直到 ctx 結束或 send 回傳錯誤
+	Watch(ctx context.Context, request WatchRequest, send func(event *ChangeEvent) error) error
+}
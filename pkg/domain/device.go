@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// OAuth 2.0 device authorization grant (RFC 8628 3.5) 定義的錯誤
+	ErrOAuthAuthorizationPending = &AppError{Code: "AUTHORIZATION_PENDING", Message: "the user has not yet completed the authorization", Status: codes.FailedPrecondition}
+	ErrOAuthSlowDown             = &AppError{Code: "SLOW_DOWN", Message: "polling too frequently, increase the interval", Status: codes.ResourceExhausted}
+	ErrOAuthExpiredToken         = &AppError{Code: "EXPIRED_TOKEN", Message: "the device code has expired", Status: codes.DeadlineExceeded}
+)
+
+// GrantTypeDeviceCode 是 device authorization grant 在 /token 使用的 grant_type
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+type DeviceAuthorizationStatus string
+
+const (
+	DeviceAuthorizationPending  DeviceAuthorizationStatus = "pending"
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	DeviceAuthorizationDenied   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization 是 CLI 或 TV 等輸入不便的裝置發起的授權, 只存在 redis
+// 使用者在另一台已經登入的裝置上輸入 UserCode 並同意之後, 裝置才能用 DeviceCode 換到 token
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ClientID   string
+	Namespace  string
+	Scope      string
+	Status     DeviceAuthorizationStatus
+	AccountID  uint64
+	AMR        string
+	AuthTime   time.Time
+	ExpiresAt  time.Time
+}
+
+// DeviceAuthorizationRequest 是 /device_authorization 的參數 (RFC 8628 3.1)
+type DeviceAuthorizationRequest struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// DeviceAuthorizationResponse 是 /device_authorization 的回應 (RFC 8628 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// ApproveDeviceRequest 已經登入的帳號同意或拒絕裝置的授權
+type ApproveDeviceRequest struct {
+	Namespace string
+	AccountID uint64
+	UserCode  string
+	Approved  bool
+	AMR       string
+}
+
+// VerifyDeviceRequest 是 verification page 的參數, 使用者輸入帳號密碼之後同意或拒絕裝置的授權
+type VerifyDeviceRequest struct {
+	UserCode  string
+	Approved  bool
+	OTPCode   string
+	LoginInfo LoginInfo
+}
+
+// DeviceAuthorizationRepository 用來處理 DeviceAuthorization 物件存儲的行為 repository layer
+type DeviceAuthorizationRepository interface {
+	CreateDeviceAuthorization(ctx context.Context, authorization *DeviceAuthorization, d time.Duration) error
+	DeviceAuthorization(ctx context.Context, deviceCode string) (*DeviceAuthorization, error)
+	DeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*DeviceAuthorization, error)
+	// UpdateDeviceAuthorization 更新授權的狀態, 不會改變存活時間
+	UpdateDeviceAuthorization(ctx context.Context, authorization *DeviceAuthorization) error
+	// PollDeviceAuthorization 記錄裝置 polling 的時間, 在 interval 之內重複 polling 時回傳 false
+	PollDeviceAuthorization(ctx context.Context, deviceCode string, interval time.Duration) (bool, error)
+	// DeleteDeviceAuthorization 刪除授權, 已經被刪除時回傳 ErrKeyNotFound, 用來確保 device code 只能換一次 token
+	DeleteDeviceAuthorization(ctx context.Context, authorization *DeviceAuthorization) error
+}
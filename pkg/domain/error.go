@@ -28,4 +28,34 @@ var (
 	ErrUsernameOrPasswordIncorrect = &AppError{Code: "USERNAME_OR_PASSWORD_INCORRECT", Message: "username or paassword is incorrect", Status: codes.InvalidArgument}
 	ErrAccountDisabled             = &AppError{Code: "ACCOUNT_DISABLE", Message: "the account was disabled", Status: codes.Unauthenticated}
 	ErrAccountLocked               = &AppError{Code: "ACCOUNT_Locked", Message: "the account was locked", Status: codes.Unauthenticated}
+	ErrOTPNotEnabled               = &AppError{Code: "OTP_NOT_ENABLED", Message: "otp is not enabled for the account", Status: codes.FailedPrecondition}
+	ErrOTPCodeIncorrect            = &AppError{Code: "OTP_CODE_INCORRECT", Message: "otp code or recovery code is incorrect", Status: codes.InvalidArgument}
+	ErrOTPRequired                 = &AppError{Code: "OTP_REQUIRED", Message: "otp code is required for the account", Status: codes.Unauthenticated}
+	ErrUnauthorized                = &AppError{Code: "UNAUTHORIZED", Message: "authentication is required", Status: codes.Unauthenticated}
+	ErrNotImplemented              = &AppError{Code: "NOT_IMPLEMENTED", Message: "method is not implemented", Status: codes.Unimplemented}
+	ErrForbidden                   = &AppError{Code: "FORBIDDEN", Message: "the caller is not allowed to access the account", Status: codes.PermissionDenied}
 )
+
+// appErrors 是 identity 會回傳的所有 AppError, 用 Code 找回原本的 AppError
+var appErrors = []*AppError{
+	ErrNotFound, ErrWrongStatus, ErrStale, ErrInvalidInput, ErrAlreadyExists,
+	ErrUsernameOrPasswordIncorrect, ErrAccountDisabled, ErrAccountLocked,
+	ErrOTPNotEnabled, ErrOTPCodeIncorrect, ErrOTPRequired, ErrUnauthorized, ErrNotImplemented, ErrForbidden,
+	ErrKeyNotFound, ErrStepUpRequired, ErrRefreshTokenReused, ErrRefreshTokenExpired,
+	ErrInvalidToken, ErrSessionLimitExceeded,
+	ErrOAuthInvalidRequest, ErrOAuthInvalidClient, ErrOAuthInvalidGrant, ErrOAuthUnauthorizedClient,
+	ErrOAuthUnsupportedGrantType, ErrOAuthInvalidScope, ErrOAuthAccessDenied, ErrOAuthUnsupportedResponseType,
+	ErrOAuthAuthorizationPending, ErrOAuthSlowDown, ErrOAuthExpiredToken, ErrOAuthInsufficientScope,
+	ErrImpersonationForbidden, ErrImpersonationRestricted, ErrServiceAccountLogin, ErrSCIMForbidden,
+	ErrWebhookEventInvalid,
+}
+
+// LookupAppError 用 Code 找到對應的 AppError, 讓 client 可以從 grpc status 的 ErrorInfo.Reason 還原錯誤
+func LookupAppError(code string) (*AppError, bool) {
+	for _, appErr := range appErrors {
+		if appErr.Code == code {
+			return appErr, true
+		}
+	}
+	return nil, false
+}
@@ -1,7 +1,11 @@
 package domain
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"sort"
+	"strings"
 	"time"
 
 	"gorm.io/datatypes"
@@ -29,10 +33,228 @@ type EventLog struct {
 	ClientIP  string         `gorm:"column:client_ip;type:string;size:64;not null"`
 	Actor     string         `gorm:"column:actor;type:string;size:32;not null"`
 	CreatedAt time.Time      `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	// PrevHash 與 Hash 把同一個 namespace 的 event log 串成 hash chain, 見 EventLogHash
+	PrevHash string `gorm:"column:prev_hash;type:string;size:64;not null"`
+	Hash     string `gorm:"column:hash;type:string;size:64;not null"`
+	// Changes 是 OldStatus 與 NewStatus 的欄位差異, 查詢時計算, 不會存到資料庫
+	Changes []FieldChange `gorm:"-"`
+}
+
+// FindEventLogOptions 是 event log 的查詢條件, 結果由新到舊排序
+type FindEventLogOptions struct {
+	Namespace        string
+	Action           string
+	TargetID         string
+	Actor            string
+	State            EventLogState
+	CreatedTimeStart time.Time
+	CreatedTimeEnd   time.Time
+	// Cursor 是上一頁最後一筆的 ID, 只回傳 ID 小於 Cursor 的 event log, 0 代表第一頁
+	Cursor uint64
+	Limit  int
+}
+
+// FieldChange 是一個欄位的異動, Field 是用 "." 連接的路徑, 例如 "Role.Name"
+// OldValue 與 NewValue 是 JSON, 欄位不存在時為 null
+type FieldChange struct {
+	Field    string
+	OldValue json.RawMessage
+	NewValue json.RawMessage
+}
+
+// RedactedValue 取代敏感欄位的值
+const RedactedValue = `"[REDACTED]"`
+
+// RedactedChangedValue 寫入 event log 時取代有異動的敏感欄位, 讓 DiffStatus 仍然可以標示這個欄位有異動
+const RedactedChangedValue = `"[REDACTED:CHANGED]"`
+
+// sensitiveStatusFields 是不能出現在 event log 查詢結果的欄位, 比對時忽略大小寫與底線
+var sensitiveStatusFields = map[string]bool{
+	"passwordencrypt": true,
+	"otpsecret":       true,
+	"secret":          true,
+	"secrethash":      true,
+	"keyhash":         true,
+}
+
+// IsSensitiveStatusField 判斷欄位是否需要遮蔽, field 可以是完整路徑
+func IsSensitiveStatusField(field string) bool {
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		field = field[i+1:]
+	}
+	return sensitiveStatusFields[strings.ToLower(strings.ReplaceAll(field, "_", ""))]
+}
+
+// DiffStatus 比較兩個 JSON 物件, 回傳依照欄位路徑排序的差異, 巢狀物件會展開, 陣列視為一個值
+// 敏感欄位只標示有異動, 值會被 RedactedValue 取代
+func DiffStatus(oldStatus, newStatus datatypes.JSON) []FieldChange {
+	oldFields := map[string]json.RawMessage{}
+	newFields := map[string]json.RawMessage{}
+	flattenStatus("", json.RawMessage(oldStatus), oldFields)
+	flattenStatus("", json.RawMessage(newStatus), newFields)
+
+	fields := map[string]bool{}
+	for field := range oldFields {
+		fields[field] = true
+	}
+	for field := range newFields {
+		fields[field] = true
+	}
+
+	changes := []FieldChange{}
+	for field := range fields {
+		oldValue, newValue := oldFields[field], newFields[field]
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+
+		change := FieldChange{Field: field, OldValue: oldValue, NewValue: newValue}
+		if change.OldValue == nil {
+			change.OldValue = json.RawMessage("null")
+		}
+		if change.NewValue == nil {
+			change.NewValue = json.RawMessage("null")
+		}
+		if IsSensitiveStatusField(field) {
+			change.OldValue = json.RawMessage(RedactedValue)
+			change.NewValue = json.RawMessage(RedactedValue)
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// RedactStatus 把 JSON 物件裡敏感欄位的值換成 RedactedValue, 不是 JSON 物件時原樣回傳
+func RedactStatus(status datatypes.JSON) datatypes.JSON {
+	fields := map[string]json.RawMessage{}
+	if len(status) == 0 || json.Unmarshal(status, &fields) != nil {
+		return status
+	}
+
+	for field, value := range fields {
+		if IsSensitiveStatusField(field) {
+			fields[field] = json.RawMessage(RedactedValue)
+			continue
+		}
+		if len(value) > 0 && value[0] == '{' {
+			fields[field] = json.RawMessage(RedactStatus(datatypes.JSON(value)))
+		}
+	}
+
+	result, err := json.Marshal(fields)
+	if err != nil {
+		return status
+	}
+	return result
+}
+
+// RedactStatusChange 在寫入 event log 之前遮蔽異動前後狀態裡的敏感欄位, 敏感欄位的值不同時
+// newStatus 的值會換成 RedactedChangedValue, 不是 JSON 物件時退回 RedactStatus
+func RedactStatusChange(oldStatus, newStatus datatypes.JSON) (datatypes.JSON, datatypes.JSON) {
+	oldFields := map[string]json.RawMessage{}
+	newFields := map[string]json.RawMessage{}
+	if json.Unmarshal(oldStatus, &oldFields) != nil || json.Unmarshal(newStatus, &newFields) != nil {
+		return RedactStatus(oldStatus), RedactStatus(newStatus)
+	}
+
+	for field, newValue := range newFields {
+		oldValue, ok := oldFields[field]
+		switch {
+		case IsSensitiveStatusField(field):
+			if ok {
+				oldFields[field] = json.RawMessage(RedactedValue)
+			}
+			if ok && equalJSON(oldValue, newValue) {
+				newFields[field] = json.RawMessage(RedactedValue)
+			} else {
+				newFields[field] = json.RawMessage(RedactedChangedValue)
+			}
+		case ok && isJSONObject(oldValue) && isJSONObject(newValue):
+			oldRedacted, newRedacted := RedactStatusChange(datatypes.JSON(oldValue), datatypes.JSON(newValue))
+			oldFields[field] = json.RawMessage(oldRedacted)
+			newFields[field] = json.RawMessage(newRedacted)
+		default:
+			newFields[field] = json.RawMessage(RedactStatus(datatypes.JSON(newValue)))
+			if ok {
+				oldFields[field] = json.RawMessage(RedactStatus(datatypes.JSON(oldValue)))
+			}
+		}
+	}
+	for field, oldValue := range oldFields {
+		if _, ok := newFields[field]; ok {
+			continue
+		}
+		if IsSensitiveStatusField(field) {
+			oldFields[field] = json.RawMessage(RedactedValue)
+			continue
+		}
+		oldFields[field] = json.RawMessage(RedactStatus(datatypes.JSON(oldValue)))
+	}
+
+	oldResult, err := json.Marshal(oldFields)
+	if err != nil {
+		return RedactStatus(oldStatus), RedactStatus(newStatus)
+	}
+	newResult, err := json.Marshal(newFields)
+	if err != nil {
+		return RedactStatus(oldStatus), RedactStatus(newStatus)
+	}
+	return oldResult, newResult
+}
+
+func isJSONObject(value json.RawMessage) bool {
+	return len(value) > 0 && value[0] == '{'
+}
+
+func equalJSON(a, b json.RawMessage) bool {
+	bufA := bytes.Buffer{}
+	bufB := bytes.Buffer{}
+	if json.Compact(&bufA, a) != nil || json.Compact(&bufB, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes())
+}
+
+func flattenStatus(prefix string, value json.RawMessage, result map[string]json.RawMessage) {
+	fields := map[string]json.RawMessage{}
+	if len(value) == 0 || value[0] != '{' || json.Unmarshal(value, &fields) != nil {
+		if prefix != "" {
+			buf := bytes.Buffer{}
+			if json.Compact(&buf, value) == nil {
+				value = buf.Bytes()
+			}
+			result[prefix] = value
+		}
+		return
+	}
+
+	for field, v := range fields {
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		flattenStatus(field, v, result)
+	}
 }
 
 
 
 type EventLogRepository interface {
 	CreateEventLog(ctx context.Context, eventLog *EventLog) error
+	// EventLogsAfter 依照 ID 的順序回傳 ID 大於 afterID 的 event log
+	EventLogsAfter(ctx context.Context, afterID uint64, limit int) ([]EventLog, error)
+	// LatestEventLogID 回傳目前最大的 event log ID, 沒有任何 event log 時回傳 0
+	LatestEventLogID(ctx context.Context) (uint64, error)
+	EventLogs(ctx context.Context, opts FindEventLogOptions) ([]EventLog, error)
+	CountEventLogs(ctx context.Context, opts FindEventLogOptions) (int64, error)
+}
+
+// EventLogUsecase 用來查詢 event log, 回傳的 OldStatus 與 NewStatus 已經遮蔽敏感欄位, 並計算 Changes
+type EventLogUsecase interface {
+	// EventLogs 回傳一頁 event log 與下一頁的 cursor, 沒有下一頁時 cursor 為 0
+	EventLogs(ctx context.Context, opts FindEventLogOptions) ([]EventLog, uint64, error)
+	CountEventLogs(ctx context.Context, opts FindEventLogOptions) (int64, error)
 }
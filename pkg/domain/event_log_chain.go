@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// EventLogChainBreak 的原因
+const (
+	// EventLogChainHashMismatch event log 的內容與 Hash 不符, 代表這筆 event log 被修改
+	EventLogChainHashMismatch = "hash_mismatch"
+	// EventLogChainPrevHashMismatch PrevHash 與前一筆的 Hash 不符, 代表前面有 event log 被刪除或插入
+	EventLogChainPrevHashMismatch = "prev_hash_mismatch"
+	// EventLogChainUnchained hash chain 開始之後出現沒有 Hash 的 event log
+	EventLogChainUnchained = "unchained"
+	// EventLogChainHeadMismatch 最後一筆 event log 與 EventLogChain 記錄的不符, 代表最後面的 event log 被刪除
+	EventLogChainHeadMismatch = "head_mismatch"
+	// EventLogChainCheckpointMismatch checkpoint 簽章的 event log 不存在或 Hash 不符, 代表整段 hash chain 被重新計算
+	EventLogChainCheckpointMismatch = "checkpoint_mismatch"
+	// EventLogChainCheckpointSignatureInvalid checkpoint 的簽章驗證失敗
+	EventLogChainCheckpointSignatureInvalid = "checkpoint_signature_invalid"
+)
+
+// EventLogChain 是每個 namespace 的 hash chain 最後的位置, 寫入 event log 時鎖住這一筆,
+// 讓同一個 namespace 的 event log 依照 commit 的順序串接
+type EventLogChain struct {
+	Namespace      string    `gorm:"column:namespace;type:string;size:256;primaryKey;not null"`
+	LastEventLogID uint64    `gorm:"column:last_event_log_id;not null"`
+	LastHash       string    `gorm:"column:last_hash;type:string;size:64;not null"`
+	UpdatedAt      time.Time `gorm:"column:updated_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// EventLogCheckpoint 是用本地金鑰對 hash chain 某個位置的簽章
+// 有資料庫權限的人可以重新計算整段 hash chain, 但是無法偽造 checkpoint 的簽章
+type EventLogCheckpoint struct {
+	ID         uint64    `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	Namespace  string    `gorm:"column:namespace;type:string;size:256;index:idx_namespace_event_log_id,priority:1;not null"`
+	EventLogID uint64    `gorm:"column:event_log_id;index:idx_namespace_event_log_id,priority:2;not null"`
+	Hash       string    `gorm:"column:hash;type:string;size:64;not null"`
+	KeyID      string    `gorm:"column:key_id;type:string;size:64;not null"`
+	Signature  string    `gorm:"column:signature;type:string;size:256;not null"`
+	CreatedAt  time.Time `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// SigningPayload 回傳 checkpoint 簽章的內容
+func (checkpoint *EventLogCheckpoint) SigningPayload() []byte {
+	payload, _ := json.Marshal(struct {
+		Namespace  string `json:"namespace"`
+		EventLogID uint64 `json:"event_log_id"`
+		Hash       string `json:"hash"`
+		CreatedAt  string `json:"created_at"`
+	}{
+		Namespace:  checkpoint.Namespace,
+		EventLogID: checkpoint.EventLogID,
+		Hash:       checkpoint.Hash,
+		CreatedAt:  checkpoint.CreatedAt.UTC().Format(time.RFC3339),
+	})
+	return payload
+}
+
+type EventLogChainBreak struct {
+	EventLogID uint64
+	Reason     string
+	// Expected 與 Actual 是不符的 hash
+	Expected string
+	Actual   string
+}
+
+// EventLogChainVerification 是一個 namespace 的 hash chain 驗證結果, Break 為 nil 代表沒有被竄改
+type EventLogChainVerification struct {
+	Namespace string
+	// ChainedCount 是驗證過的 event log 數量, UnchainedCount 是 hash chain 開始之前就存在的 event log 數量
+	ChainedCount   uint64
+	UnchainedCount uint64
+	LastEventLogID uint64
+	LastHash       string
+	// CheckpointCount 是驗證過簽章的 checkpoint 數量
+	CheckpointCount uint64
+	Break           *EventLogChainBreak
+	VerifiedAt      time.Time
+}
+
+// EventLogHash 計算 event log 在 hash chain 中的 SHA-256, 內容包含 PrevHash
+// 資料庫的 JSON 欄位不保留原本的格式, 所以 OldStatus 與 NewStatus 先轉成排序過 key 的 JSON,
+// CreatedAt 只取到秒, 與 datetime 欄位的精度相同
+func EventLogHash(eventLog *EventLog) (string, error) {
+	oldStatus, err := canonicalJSON(eventLog.OldStatus)
+	if err != nil {
+		return "", err
+	}
+
+	newStatus, err := canonicalJSON(eventLog.NewStatus)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := json.Marshal(struct {
+		PrevHash  string          `json:"prev_hash"`
+		Namespace string          `json:"namespace"`
+		Action    string          `json:"action"`
+		TargetID  string          `json:"target_id"`
+		Message   string          `json:"message"`
+		OldStatus json.RawMessage `json:"old_status"`
+		NewStatus json.RawMessage `json:"new_status"`
+		State     EventLogState   `json:"state"`
+		ClientIP  string          `json:"client_ip"`
+		Actor     string          `json:"actor"`
+		CreatedAt string          `json:"created_at"`
+	}{
+		PrevHash:  eventLog.PrevHash,
+		Namespace: eventLog.Namespace,
+		Action:    eventLog.Action,
+		TargetID:  eventLog.TargetID,
+		Message:   eventLog.Message,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		State:     eventLog.State,
+		ClientIP:  eventLog.ClientIP,
+		Actor:     eventLog.Actor,
+		CreatedAt: eventLog.CreatedAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON 把 JSON 轉成排序過 key 且沒有空白的格式, 數字保留原本的寫法
+func canonicalJSON(data []byte) (json.RawMessage, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return json.RawMessage("null"), nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+type EventLogChainRepository interface {
+	// LockEventLogChain 在目前的 transaction 鎖住 namespace 的 EventLogChain, 不存在時建立
+	LockEventLogChain(ctx context.Context, namespace string) (*EventLogChain, error)
+	UpdateEventLogChain(ctx context.Context, chain *EventLogChain) error
+	EventLogChains(ctx context.Context) ([]EventLogChain, error)
+	// ChainNamespaces 回傳有 event log 串上 hash chain 的 namespace, 用來發現 EventLogChain 被刪除的 namespace
+	ChainNamespaces(ctx context.Context) ([]string, error)
+	// ChainEventLogs 依照 ID 的順序回傳 namespace 中 ID 在 afterID 之後, 不超過 untilID 的 event log
+	ChainEventLogs(ctx context.Context, namespace string, afterID uint64, untilID uint64, limit int) ([]EventLog, error)
+	CreateEventLogCheckpoint(ctx context.Context, checkpoint *EventLogCheckpoint) error
+	// EventLogCheckpoints 依照 EventLogID 的順序回傳 namespace 的 checkpoint
+	EventLogCheckpoints(ctx context.Context, namespace string) ([]EventLogCheckpoint, error)
+}
+
+// EventLogChainUsecase 驗證 event log 的 hash chain, 並定期對 hash chain 的最後位置簽章
+type EventLogChainUsecase interface {
+	// VerifyEventLogChains 驗證 namespace 的 hash chain, namespace 為空時驗證全部
+	VerifyEventLogChains(ctx context.Context, namespace string) ([]EventLogChainVerification, error)
+	CreateEventLogCheckpoints(ctx context.Context) error
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrImpersonationForbidden 沒有權限以其他帳號的身分登入, 或是目標帳號不允許被模擬
+	ErrImpersonationForbidden = &AppError{Code: "IMPERSONATION_FORBIDDEN", Message: "the account is not allowed to impersonate the target account", Status: codes.PermissionDenied}
+	// ErrImpersonationRestricted 模擬登入的 token 不能進行敏感操作, 例如修改密碼或 OTP
+	ErrImpersonationRestricted = &AppError{Code: "IMPERSONATION_RESTRICTED", Message: "the operation is not allowed while impersonating", Status: codes.PermissionDenied}
+)
+
+const (
+	// PermissionImpersonate 擁有這個權限的帳號就算不是 admin 也可以模擬登入其他帳號
+	PermissionImpersonate = "identity.impersonate"
+
+	// ClaimImpersonatorID 模擬登入時, 實際操作的 admin 帳號 id
+	ClaimImpersonatorID = "impersonator_id"
+	// ClaimImpersonatorName 模擬登入時, 實際操作的 admin 帳號名稱
+	ClaimImpersonatorName = "impersonator_name"
+)
+
+// ImpersonateAccountRequest admin 以目標帳號的身分登入, 用來重現使用者遇到的問題
+// 實際操作的 admin 一律取自 context 裡呼叫端的 claims, 不接受由參數指定
+type ImpersonateAccountRequest struct {
+	Namespace string
+	AccountID uint64
+	Reason    string
+	// Duration token 的存活時間, 沒有設定時使用預設值, 不能超過上限
+	Duration time.Duration
+	ClientIP string
+}
+
+// Impersonation 模擬登入取得的 accessToken, 不會有 refreshToken 與 session
+type Impersonation struct {
+	AccessToken    string
+	ExpiresIn      int64
+	AccountID      uint64
+	ImpersonatorID uint64
+}
+
+// EndImpersonationRequest 提早結束模擬登入, 只有發起的 admin 可以結束, 呼叫端同樣取自 context 裡的 claims
+type EndImpersonationRequest struct {
+	Namespace   string
+	AccessToken string
+	ClientIP    string
+}
+
+// ImpersonationUsecase 用來處理 admin 模擬登入的場景
+type ImpersonationUsecase interface {
+	ImpersonateAccount(ctx context.Context, request ImpersonateAccountRequest) (*Impersonation, error)
+	EndImpersonation(ctx context.Context, request EndImpersonationRequest) error
+}
+
+// Impersonator 回傳模擬登入的 admin 帳號 id, 不是模擬登入的 token 回傳 false
+func (t *Token) Impersonator() (uint64, bool) {
+	val, ok := t.Claims[ClaimImpersonatorID]
+	if !ok {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// RequireNotImpersonated 給其他 service 在敏感操作前檢查 token 是不是模擬登入的 token
+func RequireNotImpersonated(token *Token) error {
+	if token == nil {
+		return nil
+	}
+
+	if _, ok := token.Impersonator(); ok {
+		return ErrImpersonationRestricted
+	}
+	return nil
+}
+
+// IsImpersonating 判斷 context 裡面的 claims 是否來自模擬登入的 token
+func IsImpersonating(ctx context.Context) bool {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	_, ok = claims[ClaimImpersonatorID]
+	return ok
+}
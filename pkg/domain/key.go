@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"context"
+	"crypto"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrInvalidToken JWT 的簽章, 期限或格式不正確
+	ErrInvalidToken = &AppError{Code: "INVALID_TOKEN", Message: "token is invalid", Status: codes.Unauthenticated}
+)
+
+const (
+	// AccessTokenFormatOpaque accessToken 是存在 redis 的隨機字串, 驗證時需要查詢 identity
+	AccessTokenFormatOpaque = "opaque"
+	// AccessTokenFormatJWT accessToken 是簽章過的 JWT, 其他 service 可以用 JWKS 離線驗證
+	AccessTokenFormatJWT = "jwt"
+)
+
+// SigningKey 用來簽發 JWT 的金鑰
+// 新的金鑰在 ActivatesAt 之前就會發佈到 JWKS, 讓其他 service 先快取起來;
+// 被新的金鑰取代之後, 仍然會保留在 JWKS 中一段時間, 讓已經簽發的 JWT 可以繼續被驗證
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+}
+
+// PublicKey 回傳驗證簽章用的公鑰
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// KeyUsecase 用來處理 JWT 簽章金鑰相關業務操作的場景
+type KeyUsecase interface {
+	// RotateKeys 依照設定建立新的金鑰並移除已經過期的金鑰, 需要定期呼叫
+	RotateKeys(ctx context.Context) error
+	// PublicKeys 回傳所有可以用來驗證 JWT 的金鑰, 用來產生 JWKS
+	PublicKeys(ctx context.Context) ([]SigningKey, error)
+	SignToken(ctx context.Context, token Token) (string, error)
+	// SignIDToken 把 OpenID Connect 的 ID token 簽成 JWT
+	SignIDToken(ctx context.Context, idToken IDToken) (string, error)
+	ParseToken(ctx context.Context, tokenString string) (*Token, error)
+}
+
+// KeyRepository 用來處理 SigningKey 物件存儲的行為 repository layer
+type KeyRepository interface {
+	Keys(ctx context.Context) ([]SigningKey, error)
+	CreateKey(ctx context.Context, key *SigningKey) error
+	DeleteKey(ctx context.Context, keyID string) error
+	// LockKeys 取得跨 instance 的排他鎖, 讓同一時間只有一個 instance 在輪替金鑰, 用完需要呼叫 unlock
+	LockKeys(ctx context.Context) (unlock func(), err error)
+}
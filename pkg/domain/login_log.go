@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// FindLoginLogOptions 是 login log 的查詢條件, 結果由新到舊排序
+type FindLoginLogOptions struct {
+	Namespace string
+	// TargetID 是登入的帳號 ID
+	TargetID         string
+	State            LoginLogState
+	DeviceType       DeviceType
+	CountryCode      string
+	CreatedTimeStart time.Time
+	CreatedTimeEnd   time.Time
+	// Cursor 是上一頁最後一筆的 ID, 只回傳 ID 小於 Cursor 的 login log, 0 代表第一頁
+	Cursor uint64
+	Limit  int
+}
+
+type LoginSummaryRequest struct {
+	Namespace string
+	AccountID uint64
+	// Since 統計的起始時間, 沒有設定時統計最近 30 天
+	Since time.Time
+}
+
+// LoginSummary 是帳號最近的登入狀況, 給使用者查看最近的登入活動或是管理者調查異常登入
+type LoginSummary struct {
+	Namespace string
+	AccountID uint64
+	Since     time.Time
+	// LastSuccessLogin 最後一次成功登入, 不受 Since 限制, 從來沒有成功登入時為 nil
+	LastSuccessLogin *LoginLog
+	// FailureCount 是 Since 之後登入失敗的次數, RecentFailures 只包含其中最新的幾筆
+	FailureCount   int64
+	RecentFailures []LoginLog
+	// CountryCodes 是 Since 之後登入過的國家
+	CountryCodes []string
+}
+
+type LoginLogUsecase interface {
+	// LoginLogs 回傳一頁 login log 與下一頁的 cursor, 沒有下一頁時 cursor 為 0
+	LoginLogs(ctx context.Context, opts FindLoginLogOptions) ([]LoginLog, uint64, error)
+	CountLoginLogs(ctx context.Context, opts FindLoginLogOptions) (int64, error)
+	LoginSummary(ctx context.Context, request LoginSummaryRequest) (*LoginSummary, error)
+}
@@ -0,0 +1,248 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// OAuth 2.0 (RFC 6749) 定義的錯誤, Code 轉成小寫就是 RFC 中的 error code
+	ErrOAuthInvalidRequest          = &AppError{Code: "INVALID_REQUEST", Message: "the request is missing a required parameter or is otherwise malformed", Status: codes.InvalidArgument}
+	ErrOAuthInvalidClient           = &AppError{Code: "INVALID_CLIENT", Message: "client authentication failed", Status: codes.Unauthenticated}
+	ErrOAuthInvalidGrant            = &AppError{Code: "INVALID_GRANT", Message: "the authorization grant or refresh token is invalid", Status: codes.InvalidArgument}
+	ErrOAuthUnauthorizedClient      = &AppError{Code: "UNAUTHORIZED_CLIENT", Message: "the client is not authorized to use this grant type", Status: codes.PermissionDenied}
+	ErrOAuthUnsupportedGrantType    = &AppError{Code: "UNSUPPORTED_GRANT_TYPE", Message: "the grant type is not supported", Status: codes.InvalidArgument}
+	ErrOAuthInvalidScope            = &AppError{Code: "INVALID_SCOPE", Message: "the requested scope is invalid", Status: codes.InvalidArgument}
+	ErrOAuthAccessDenied            = &AppError{Code: "ACCESS_DENIED", Message: "the resource owner denied the request", Status: codes.PermissionDenied}
+	ErrOAuthUnsupportedResponseType = &AppError{Code: "UNSUPPORTED_RESPONSE_TYPE", Message: "the response type is not supported", Status: codes.InvalidArgument}
+)
+
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// token exchange (RFC 8693 3) 使用的 token type
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+
+	CodeChallengeMethodPlain = "plain"
+	CodeChallengeMethodS256  = "S256"
+
+	// ClaimClientID 透過 OAuth 簽發的 token 所屬的 client
+	ClaimClientID = "client_id"
+	// ClaimScope 透過 OAuth 簽發的 token 的 scope, 多個值用空白分隔
+	ClaimScope = "scope"
+)
+
+// OAuthClient 是註冊在 namespace 底下的 OAuth client
+// RedirectURIs, GrantTypes 與 Scopes 都是用空白分隔的字串
+type OAuthClient struct {
+	ID           uint64    `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	Namespace    string    `gorm:"column:namespace;type:string;size:256;index:idx_namespace;not null"`
+	ClientID     string    `gorm:"column:client_id;type:string;size:64;uniqueIndex:uniq_client_id;not null"`
+	SecretHash   string    `gorm:"column:secret_hash;type:string;size:128;not null"`
+	Name         string    `gorm:"column:name;type:string;size:64;not null"`
+	RedirectURIs string    `gorm:"column:redirect_uris;type:string;size:2048;not null"`
+	GrantTypes   string    `gorm:"column:grant_types;type:string;size:256;not null"`
+	Scopes       string    `gorm:"column:scopes;type:string;size:1024;not null"`
+	Public       int32     `gorm:"column:public;type:tinyint;not null"`
+	CreatorID    uint64    `gorm:"column:creator_id;type:bigint;not null"`
+	CreatorName  string    `gorm:"column:creator_name;type:string;size:32;default:'';not null"`
+	CreatedAt    time.Time `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// IsPublic public client (例如 SPA 或 mobile app) 沒有 secret, 必須使用 PKCE
+func (c *OAuthClient) IsPublic() bool {
+	return c.Public == 1
+}
+
+func (c *OAuthClient) AllowGrantType(grantType string) bool {
+	return containsField(c.GrantTypes, grantType)
+}
+
+func (c *OAuthClient) AllowRedirectURI(redirectURI string) bool {
+	return containsField(c.RedirectURIs, redirectURI)
+}
+
+// AllowScope 判斷 scope 中的每個值是否都有註冊在 client 上
+func (c *OAuthClient) AllowScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !containsField(c.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasScope 判斷用空白分隔的 scope 中是否包含 val
+func HasScope(scope string, val string) bool {
+	return containsField(scope, val)
+}
+
+func containsField(fields string, val string) bool {
+	for _, f := range strings.Fields(fields) {
+		if f == val {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationCode 是 /authorize 簽發的一次性 code, 只存在 redis
+type AuthorizationCode struct {
+	ClientID            string
+	Namespace           string
+	AccountID           uint64
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	AMR                 string
+	AuthTime            time.Time
+	// RedirectURIProvided /authorize 有帶 redirect_uri, 這時 /token 必須帶一樣的 redirect_uri (RFC 6749 4.1.3)
+	RedirectURIProvided bool
+}
+
+type CreateOAuthClientRequest struct {
+	Namespace    string
+	Name         string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	Public       bool
+	CreatorID    uint64
+	CreatorName  string
+}
+
+// AuthorizeRequest 是 /authorize 的參數, 帳號密碼驗證的部分沿用 LoginInfo
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	OTPCode             string
+	LoginInfo           LoginInfo
+	// RedirectURIDefaulted 沒有帶 redirect_uri, 使用的是 client 唯一註冊的 redirect_uri
+	RedirectURIDefaulted bool
+}
+
+// OAuthTokenRequest 是 /token 的參數
+type OAuthTokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+	Scope        string
+	ClientIP     string
+	UserAgent    string
+
+	// token exchange (RFC 8693 2.1) 的參數
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	RequestedTokenType string
+}
+
+// OAuthTokenResponse 是 /token 的回應 (RFC 6749 5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	// IssuedTokenType 只有 token exchange 會回傳 (RFC 8693 2.2.1)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// IntrospectTokenRequest 是 /introspect 的參數 (RFC 7662 2.1)
+type IntrospectTokenRequest struct {
+	ClientID      string
+	ClientSecret  string
+	Token         string
+	TokenTypeHint string
+}
+
+// TokenIntrospection 是 /introspect 的回應 (RFC 7662 2.2), token 無效時只有 active 為 false
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// RevokeTokenRequest 是 /revoke 的參數 (RFC 7009 2.1)
+type RevokeTokenRequest struct {
+	ClientID      string
+	ClientSecret  string
+	Token         string
+	TokenTypeHint string
+}
+
+// OAuthUsecase 用來處理 OAuth 2.0 authorization server 相關業務操作的場景
+type OAuthUsecase interface {
+	// CreateClient 建立 client, 回傳的 secret 只會出現這一次
+	CreateClient(ctx context.Context, request CreateOAuthClientRequest) (*OAuthClient, string, error)
+	Client(ctx context.Context, namespace, clientID string) (*OAuthClient, error)
+	Clients(ctx context.Context, namespace string) ([]OAuthClient, error)
+	DeleteClient(ctx context.Context, namespace, clientID string) error
+	// ValidateAuthorizeRequest 檢查 client 與 redirect_uri, 檢查失敗時不可以 redirect 回 client
+	ValidateAuthorizeRequest(ctx context.Context, request *AuthorizeRequest) (*OAuthClient, error)
+	// Authorize 驗證帳號密碼之後簽發 authorization code
+	Authorize(ctx context.Context, request AuthorizeRequest) (string, error)
+	Token(ctx context.Context, request OAuthTokenRequest) (*OAuthTokenResponse, error)
+	// DeviceAuthorization 發起 device authorization grant, 回傳 device code 與 user code
+	DeviceAuthorization(ctx context.Context, request DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error)
+	// ApproveDevice 已經登入的帳號同意或拒絕 user code 對應的裝置授權
+	ApproveDevice(ctx context.Context, request ApproveDeviceRequest) (*OAuthClient, error)
+	// VerifyDevice 驗證帳號密碼之後同意或拒絕 user code 對應的裝置授權
+	VerifyDevice(ctx context.Context, request VerifyDeviceRequest) (*OAuthClient, error)
+	// IntrospectToken 只允許 confidential client 查詢同一個 namespace 的 token
+	IntrospectToken(ctx context.Context, request IntrospectTokenRequest) (*TokenIntrospection, error)
+	// RevokeToken 撤銷 token, token 不存在時不會回傳錯誤
+	RevokeToken(ctx context.Context, request RevokeTokenRequest) error
+	// OpenIDConfiguration 回傳 discovery document, 沒有啟用 JWT 時回傳 ErrNotFound
+	OpenIDConfiguration(ctx context.Context) (*OpenIDConfiguration, error)
+	// UserInfo 用 accessToken 取得帳號的 claims, accessToken 必須有 openid scope
+	UserInfo(ctx context.Context, accessToken string) (*UserInfo, error)
+}
+
+// OAuthClientRepository 用來處理 OAuthClient 物件存儲的行為 repository layer
+type OAuthClientRepository interface {
+	CreateOAuthClient(ctx context.Context, client *OAuthClient) error
+	OAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	OAuthClients(ctx context.Context, namespace string) ([]OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+}
+
+// AuthorizationCodeRepository 用來處理 AuthorizationCode 物件存儲的行為 repository layer
+type AuthorizationCodeRepository interface {
+	CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode, d time.Duration) (string, error)
+	// ConsumeAuthorizationCode 取出並刪除 code, 同一個 code 只能使用一次
+	ConsumeAuthorizationCode(ctx context.Context, code string) (*AuthorizationCode, error)
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrOAuthInsufficientScope accessToken 沒有包含需要的 scope (RFC 6750 3.1)
+	ErrOAuthInsufficientScope = &AppError{Code: "INSUFFICIENT_SCOPE", Message: "the access token does not have the required scope", Status: codes.PermissionDenied}
+)
+
+const (
+	// OpenID Connect 定義的 scope, 決定 ID token 與 userinfo 會回傳哪些 claims
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	ScopePhone   = "phone"
+
+	// ClaimNonce client 在 /authorize 帶的 nonce, 會原封不動的放進 ID token
+	ClaimNonce = "nonce"
+)
+
+// UserInfo 是 OpenID Connect 的標準 claims, 依照授權的 scope 決定要回傳哪些欄位
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Nickname          string `json:"nickname,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+	Email             string `json:"email,omitempty"`
+	PhoneNumber       string `json:"phone_number,omitempty"`
+}
+
+// IDToken 是簽成 JWT 之前的 ID token 內容, Audience 為 client_id
+type IDToken struct {
+	UserInfo
+	Audience  string
+	Nonce     string
+	AuthTime  time.Time
+	AMR       []string
+	ExpiresIn int64
+}
+
+// OpenIDConfiguration 是 /.well-known/openid-configuration 的內容 (OpenID Connect Discovery 1.0)
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+type OutboxState uint32
+
+const (
+	OutboxStateDefault   OutboxState = 0
+	OutboxStatePending   OutboxState = 1
+	OutboxStatePublished OutboxState = 2
+)
+
+// OutboxEvent 是等待發佈到 message broker 的領域事件, 與 event log 在同一個 transaction 建立
+// 同一個 aggregate (AggregateType + AggregateID) 的事件依照 ID 的順序發佈
+type OutboxEvent struct {
+	ID            uint64         `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	AggregateType string         `gorm:"column:aggregate_type;type:string;size:64;not null"`
+	AggregateID   string         `gorm:"column:aggregate_id;type:string;size:64;not null"`
+	Namespace     string         `gorm:"column:namespace;type:string;size:256;not null"`
+	EventID       string         `gorm:"column:event_id;type:string;size:36;not null"`
+	EventType     string         `gorm:"column:event_type;type:string;size:64;not null"`
+	Subject       string         `gorm:"column:subject;type:string;size:512;not null"`
+	Payload       datatypes.JSON `gorm:"column:payload;type:json;not null"`
+	State         OutboxState    `gorm:"column:state;type:int;index:idx_state;not null"`
+	PublishedAt   time.Time      `gorm:"column:published_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	CreatedAt     time.Time      `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// OutboxSubject 回傳事件發佈的 subject, 例如 identity.<namespace>.account.updated
+// namespace 裡面 subject 不允許的字元會被換成 "_"
+func OutboxSubject(namespace string, eventType string) string {
+	namespace = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n', '*', '>':
+			return '_'
+		}
+		return r
+	}, namespace)
+	return "identity." + namespace + "." + eventType
+}
+
+// OutboxUsecase 用來把 outbox 的事件發佈到 message broker
+type OutboxUsecase interface {
+	// Relay 依照順序發佈尚未發佈的事件, 由背景的 worker 定期呼叫
+	Relay(ctx context.Context) error
+}
+
+// OutboxRepository 用來處理 outbox 事件的存儲的行為 repository layer
+type OutboxRepository interface {
+	CreateOutboxEvents(ctx context.Context, events []*OutboxEvent) error
+	// PendingOutboxEvents 依照 ID 的順序回傳尚未發佈的事件
+	PendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkOutboxEventsPublished(ctx context.Context, eventIDs []uint64, publishedAt time.Time) error
+	// WithRelayLock 取得 relay 的鎖之後才執行 fn, 確保同一時間只有一個 instance 在發佈, 回傳 false 代表鎖被其他 instance 持有
+	WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+// EventPublisher 把事件發佈到 message broker, msgID 用來讓 broker 去除重複的訊息
+type EventPublisher interface {
+	Publish(ctx context.Context, subject string, msgID string, payload []byte) error
+}
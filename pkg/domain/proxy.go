@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TrustedProxies 可以信任 X-Forwarded-For 的 proxy 位址, loopback 一律信任,
+// 因為內建的 http/json gateway 是透過 loopback 轉送到 grpc
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies 解析設定的 proxy 位址, 可以是單一 IP 或是 CIDR
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an ip or cidr. %w", value, ErrInvalidInput)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an ip or cidr. %w", value, ErrInvalidInput)
+		}
+		proxies = append(proxies, ipNet)
+	}
+
+	return proxies, nil
+}
+
+// Contains 判斷 ip 是不是信任的 proxy
+func (p TrustedProxies) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if ip.IsLoopback() {
+		return true
+	}
+
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 從連線的來源位址開始, 由右往左走 X-Forwarded-For, 只有信任的 proxy 附加的位址才採用,
+// 回傳第一個不是信任 proxy 的位址, 呼叫端自己帶的 X-Forwarded-For 不會被當成來源
+func (p TrustedProxies) ClientIP(remoteAddr string, forwardedFor []string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	hops := []string{}
+	for _, value := range forwardedFor {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && p.Contains(ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+
+	return ip.String()
+}
+
+// ForwardedFor 回傳轉送給下一層時使用的 X-Forwarded-For, 來源不是信任的 proxy 時丟掉呼叫端自己帶的值,
+// 最後附加上連線的來源位址
+func (p TrustedProxies) ForwardedFor(remoteAddr string, forwardedFor string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if !p.Contains(net.ParseIP(host)) {
+		forwardedFor = ""
+	}
+
+	if host == "" {
+		return forwardedFor
+	}
+	if forwardedFor != "" {
+		forwardedFor += ", "
+	}
+	return forwardedFor + host
+}
@@ -46,7 +46,7 @@ type RoleUsecase interface {
 	RolesByAccountID(ctx context.Context, namespace string, accountID uint64) ([]Role, error)
 	// Count(ctx context.Context, opts FindRoleOptions) (uint64, error)
 	UpdateRole(ctx context.Context, role *Role) error
-	AddAccountsToRole(ctx context.Context, accountIDs []uint64, roleID uint64) error
+	AddAccountsToRole(ctx context.Context, namespace string, accountIDs []uint64, roleID uint64) error
 }
 
 // RoleRepository 用來處理 Role 物件的存儲的行為 repository layer
@@ -58,4 +58,5 @@ type RoleRepository interface {
 	// CountRoles(ctx context.Context, namespace string) (int32, error)
 	UpdateRole(ctx context.Context, role *Role) error
 	AddAccountsToRole(ctx context.Context, accountIDs []uint64, roleID uint64) error
+	AccountIDsByRoleID(ctx context.Context, roleID uint64) ([]uint64, error)
 }
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+)
+
+// PermissionSCIM 可以透過 SCIM 佈建帳號與角色, 通常授權給 Okta 或 Azure AD 使用的 service account
+const PermissionSCIM = "identity.scim"
+
+var (
+	ErrSCIMForbidden = &AppError{Code: "SCIM_FORBIDDEN", Message: "the account is not allowed to use scim", Status: codes.PermissionDenied}
+)
+
+// SCIMGroup 是 SCIM 的 Group, 對應到 Role 與它的成員
+type SCIMGroup struct {
+	Role Role
+	// Members 只需要 ID 或 UUID 其中一個
+	Members []Account
+}
+
+// SCIMUsecase 把 SCIM 的 Users 與 Groups 對應到 Account 與 Role
+type SCIMUsecase interface {
+	// Authorize token 的帳號必須是 admin 或擁有 PermissionSCIM
+	Authorize(ctx context.Context, token *Token) error
+	// Users 回傳符合條件的帳號與不分頁的總數
+	Users(ctx context.Context, opts FindAccountOptions) ([]Account, int64, error)
+	User(ctx context.Context, namespace string, uuid string) (*Account, []Role, error)
+	CreateUser(ctx context.Context, account *Account) error
+	// UpdateUser account.Version 必須與目前的版本相同, active 對應到帳號的 State
+	UpdateUser(ctx context.Context, account *Account, active bool) error
+	// DeactivateUser SCIM 的 DELETE, 帳號不會被刪除而是停用
+	DeactivateUser(ctx context.Context, namespace string, uuid string, actor string) error
+	Groups(ctx context.Context, opts FindRoleOptions) ([]SCIMGroup, int64, error)
+	Group(ctx context.Context, namespace string, roleID uint64) (*SCIMGroup, error)
+	CreateGroup(ctx context.Context, group *SCIMGroup) error
+	// UpdateGroup 會用 group.Members 取代原本的成員
+	UpdateGroup(ctx context.Context, group *SCIMGroup) error
+	// DeleteGroup 角色不會被刪除而是停用, 並且清空成員
+	DeleteGroup(ctx context.Context, namespace string, roleID uint64, actor string) error
+}
@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	ErrSessionLimitExceeded = &AppError{Code: "SESSION_LIMIT_EXCEEDED", Message: "the account has reached the maximum number of active sessions", Status: codes.ResourceExhausted}
+)
+
+// Session 代表一次登入所產生的 accessToken 與 refreshToken, 以及登入時的裝置資訊
+type Session struct {
+	ID         string
+	Namespace  string
+	AccountID  int64
+	AccessKey  string
+	RefreshKey string
+	// AccessToken 是簽發給 client 的 accessToken, JWT 模式下為簽章過的 JWT, 不會被儲存
+	AccessToken string
+	DeviceType  DeviceType
+	ClientIP    string
+	CountryCode string
+	CityName    string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+}
+
+// CreateTokenRequest 建立 token 時同時建立 session 所需要的資訊
+type CreateTokenRequest struct {
+	Token      Token
+	Prefix     string
+	DeviceType DeviceType
+	ClientIP   string
+	UserAgent  string
+	// ServerClaims 由 identity 內部流程設定的 claims, 例如 amr, act 與 impersonator_id,
+	// Token.Claims 中同名的 claims 會被移除, delivery 層不可以把呼叫端的資料放進來
+	ServerClaims map[string]string
+}
+
+type RevokeSessionRequest struct {
+	Namespace string
+	AccountID int64
+	SessionID string
+}
+
+// RevokeOtherSessionsRequest 登出除了目前 session 以外的所有裝置
+type RevokeOtherSessionsRequest struct {
+	Namespace        string
+	AccountID        int64
+	CurrentSessionID string
+}
+
+// SessionLimitAction 決定新的登入超過 session 上限時的處理方式
+type SessionLimitAction string
+
+const (
+	// SessionLimitEvictOldest 踢掉最舊的 session, 讓新的登入成功
+	SessionLimitEvictOldest SessionLimitAction = "evict_oldest"
+	// SessionLimitReject 拒絕新的登入
+	SessionLimitReject SessionLimitAction = "reject"
+)
+
+// DeviceSessionLimit 單一 DeviceType 可以同時存在的 session 數量
+type DeviceSessionLimit struct {
+	DeviceType  DeviceType `mapstructure:"device_type"`
+	MaxSessions int        `mapstructure:"max_sessions"`
+}
+
+// SessionPolicy 是 namespace 的 session 上限設定, MaxSessions 為 0 代表不限制
+type SessionPolicy struct {
+	Namespace   string
+	MaxSessions int                  `mapstructure:"max_sessions"`
+	Devices     []DeviceSessionLimit `mapstructure:"devices"`
+	OnLimit     SessionLimitAction   `mapstructure:"on_limit"`
+}
+
+// SessionLimit 是建立 session 時實際套用的上限
+type SessionLimit struct {
+	MaxSessions       int
+	MaxDeviceSessions int
+	Action            SessionLimitAction
+}
+
+// Limit 回傳 policy 套用在 deviceType 上的 SessionLimit
+func (p SessionPolicy) Limit(deviceType DeviceType) SessionLimit {
+	limit := SessionLimit{
+		MaxSessions: p.MaxSessions,
+		Action:      p.OnLimit,
+	}
+
+	if limit.Action == "" {
+		limit.Action = SessionLimitEvictOldest
+	}
+
+	for _, device := range p.Devices {
+		if device.DeviceType == deviceType {
+			limit.MaxDeviceSessions = device.MaxSessions
+			break
+		}
+	}
+
+	return limit
+}
+
+// SessionUsecase 用來處理 Session 相關業務操作的場景
+type SessionUsecase interface {
+	Session(ctx context.Context, namespace string, accountID int64, sessionID string) (*Session, error)
+	Sessions(ctx context.Context, namespace string, accountID int64) ([]Session, error)
+	RevokeSession(ctx context.Context, request RevokeSessionRequest) error
+	RevokeOtherSessions(ctx context.Context, request RevokeOtherSessionsRequest) error
+}
+
+// SessionRepository 用來處理 Session 物件存儲的行為 repository layer
+type SessionRepository interface {
+	// CreateSession 建立 session 並套用 limit, 回傳被踢掉的 session id
+	CreateSession(ctx context.Context, session *Session, d time.Duration, limit SessionLimit) ([]string, error)
+	Session(ctx context.Context, sessionID string) (*Session, error)
+	SessionsByAccountID(ctx context.Context, accountID int64) ([]Session, error)
+	UpdateSessionTokens(ctx context.Context, sessionID, accessKey, refreshKey string, d time.Duration) error
+	TouchSession(ctx context.Context, sessionID string, lastSeenAt time.Time) error
+	DeleteSession(ctx context.Context, sessionID string) error
+}
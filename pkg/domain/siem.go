@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// SIEM 匯出的格式
+const (
+	SIEMFormatCEF    = "cef"
+	SIEMFormatJSON   = "jsonl"
+	SIEMFormatSyslog = "syslog"
+)
+
+// SIEM 匯出的目的地
+const (
+	SIEMSinkFile = "file"
+	SIEMSinkTCP  = "tcp"
+	SIEMSinkUDP  = "udp"
+	SIEMSinkTLS  = "tls"
+)
+
+// SIEMRecordKind 是匯出紀錄的來源
+const (
+	SIEMRecordEventLog = "event_log"
+	SIEMRecordLoginLog = "login_log"
+)
+
+// SIEMCheckpoint 記錄每個 exporter 已經匯出到哪一筆 event log 與 login log, 重新啟動之後從這裡接續
+type SIEMCheckpoint struct {
+	Name           string    `gorm:"column:name;type:string;size:64;primaryKey;not null"`
+	LastEventLogID uint64    `gorm:"column:last_event_log_id;not null"`
+	LastLoginLogID uint64    `gorm:"column:last_login_log_id;not null"`
+	UpdatedAt      time.Time `gorm:"column:updated_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+type SIEMRepository interface {
+	// SIEMCheckpoint 回傳 exporter 的 checkpoint, 還沒有匯出過時回傳 ID 都是 0 的 checkpoint
+	SIEMCheckpoint(ctx context.Context, name string) (*SIEMCheckpoint, error)
+	SaveSIEMCheckpoint(ctx context.Context, checkpoint *SIEMCheckpoint) error
+	// WithExportLock 取得 exporter 的鎖之後才執行 fn, 避免多個 instance 重複匯出, 回傳 false 代表鎖被其他 instance 持有
+	WithExportLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error)
+}
+
+// SIEMSink 把格式化好的紀錄送到 SIEM, Write 成功回傳代表這批紀錄都已經送出 (檔案已經 sync)
+type SIEMSink interface {
+	Write(ctx context.Context, messages [][]byte) error
+	Close() error
+}
+
+type SIEMUsecase interface {
+	// Export 從 checkpoint 之後匯出一批 event log 與 login log, 送出成功才更新 checkpoint
+	Export(ctx context.Context) error
+}
@@ -2,13 +2,23 @@ package domain
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
+
+	"google.golang.org/grpc/codes"
 )
 
 var (
 	// ErrKeyNotFound returns errors.ResourceNotFound
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyNotFound = &AppError{Code: "KEY_NOT_FOUND", Message: "key not found", Status: codes.NotFound}
+	// ErrStepUpRequired 敏感操作需要先通過第二因子驗證
+	ErrStepUpRequired = &AppError{Code: "STEP_UP_REQUIRED", Message: "a recent second factor verification is required", Status: codes.PermissionDenied}
+	// ErrRefreshTokenReused 已經被輪替過的 refreshToken 又被使用, 整個 token family 會被撤銷
+	ErrRefreshTokenReused = &AppError{Code: "REFRESH_TOKEN_REUSED", Message: "refresh token was already used", Status: codes.Unauthenticated}
+	// ErrRefreshTokenExpired token family 超過絕對存活時間, 必須重新登入
+	ErrRefreshTokenExpired = &AppError{Code: "REFRESH_TOKEN_EXPIRED", Message: "refresh token family is expired", Status: codes.Unauthenticated}
 )
 
 // Claims 用來代表登入後的資料
@@ -37,40 +47,194 @@ const (
 	PairTokenKey = "PairTokenKey"
 	// BindHashKey 額外綁定的hashKey(目前只支援 1對1)
 	BindHashKey = "BindHashKey"
+	// SessionKey token 所屬的 session id
+	SessionKey = "SessionKey"
+	// TokenTypeKey 標記 token 的種類, refreshToken 的值為 TokenTypeRefresh
+	TokenTypeKey = "TokenType"
+	// TokenTypeRefresh refreshToken 的 TokenTypeKey
+	TokenTypeRefresh = "refresh"
+
+	// ClaimAMR 驗證方式 (RFC 8176), 多個值用空白分隔, 例如 "pwd otp"
+	ClaimAMR = "amr"
+	// ClaimAuthTime 最後一次通過驗證的時間 (unix timestamp)
+	ClaimAuthTime = "auth_time"
+	// ClaimElevatedUntil 第二因子驗證後, 可以進行敏感操作的期限 (unix timestamp)
+	ClaimElevatedUntil = "elevated_until"
+
+	// ClaimActor 代替帳號呼叫的 service (RFC 8693 4.1), 值為 Actor 的 JSON
+	ClaimActor = "act"
+
+	// ClaimAccountID, ClaimNamespace, ClaimUsername 與 ClaimAccountType 是 NewClaims 放入 Claims 的帳號資訊
+	ClaimAccountID   = "account_id"
+	ClaimNamespace   = "namespace"
+	ClaimUsername    = "username"
+	ClaimAccountType = "account_type"
+	// ClaimPermission 帳號的權限, 多個值用空白分隔, 由建立 token 的 service 帶入
+	ClaimPermission = "permission"
+
+	// AMROTP 使用 OTP 驗證
+	AMROTP = "otp"
 )
 
+// Actor 是 token exchange 之後代替帳號呼叫其他 service 的一方, 多次 exchange 時 Actor 會是巢狀的
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
 // NewContext 產生一個新的包含 claims 的新 context
 func NewContext(ctx context.Context, claim Claims) context.Context {
 	return context.WithValue(ctx, IdentityClaims, claim)
 }
 
-var (
-	ctxKey = &struct {
-		name string
-	}{
-		name: "log",
+// NewClaims 把驗證過的 token 轉成放在 context 裡面的 claims, token.Claims 會一起帶入,
+// 但是配對的 refreshToken 等內部使用的 key 不會
+func NewClaims(token *Token) Claims {
+	claims := make(Claims, len(token.Claims)+4)
+	for k, v := range token.Claims {
+		if k == PairTokenKey || k == BindHashKey {
+			continue
+		}
+		claims[k] = v
 	}
-)
+	claims[ClaimAccountID] = token.AccountID
+	claims[ClaimNamespace] = token.Namespace
+	claims[ClaimUsername] = token.Username
+	claims[ClaimAccountType] = token.AccountType
+	return claims
+}
 
 // FromContext 從 context 裡面取得 claims
 func FromContext(ctx context.Context) (Claims, bool) {
-	val, ok := ctx.Value(ctxKey).(Claims)
+	val, ok := ctx.Value(IdentityClaims).(Claims)
 	if !ok {
 		return nil, false
 	}
 	return val, true
 }
 
+// HasAMR 判斷 token 是否用過某種驗證方式
+func (t *Token) HasAMR(method string) bool {
+	for _, m := range strings.Fields(t.Claims[ClaimAMR]) {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor 回傳 token 的 act claim, 不是透過 token exchange 簽發的 token 回傳 nil
+func (t *Token) Actor() *Actor {
+	val := t.Claims[ClaimActor]
+	if val == "" {
+		return nil
+	}
+
+	var actor Actor
+	err := json.Unmarshal([]byte(val), &actor)
+	if err != nil {
+		return nil
+	}
+	return &actor
+}
+
+// ElevatedUntil 回傳第二因子驗證的有效期限, 沒有驗證過會回傳 zero time
+func (t *Token) ElevatedUntil() time.Time {
+	return unixClaim(t.Claims, ClaimElevatedUntil)
+}
+
+// AuthTime 回傳最後一次通過驗證的時間, 沒有驗證過會回傳 zero time
+func (t *Token) AuthTime() time.Time {
+	return unixClaim(t.Claims, ClaimAuthTime)
+}
+
+// RequireStepUp 給其他 service 在敏感操作 (例如修改 email 或密碼) 前檢查 token 是否有近期的第二因子驗證
+// maxAge 大於 0 時, 除了 elevated_until 之外, auth_time 也必須在 maxAge 之內
+func RequireStepUp(token *Token, maxAge time.Duration) error {
+	if token == nil || !token.HasAMR(AMROTP) {
+		return ErrStepUpRequired
+	}
+
+	// 模擬登入的 token 不能通過 step-up, 避免 admin 用使用者的身分進行敏感操作
+	if _, ok := token.Impersonator(); ok {
+		return ErrImpersonationRestricted
+	}
+
+	now := time.Now()
+	if !now.Before(token.ElevatedUntil()) {
+		return ErrStepUpRequired
+	}
+
+	if maxAge > 0 && token.AuthTime().Add(maxAge).Before(now) {
+		return ErrStepUpRequired
+	}
+
+	return nil
+}
+
+func unixClaim(claims map[string]string, key string) time.Time {
+	val, ok := claims[key]
+	if !ok {
+		return time.Time{}
+	}
+
+	sec, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
+
+// ElevateTokenRequest 第二因子驗證通過後, 用來標記 token 的請求
+type ElevateTokenRequest struct {
+	TokenKey  string
+	AccountID int64
+	Method    string
+	Duration  time.Duration
+}
+
+// ElevatedToken step-up 之後的 token, AccessToken 是 client 之後要使用的 accessToken
+// JWT 模式下 claims 簽在 JWT 裡面, 所以會重新簽發一個帶有 amr, auth_time 與 elevated_until 的 JWT,
+// 原本的 JWT 仍然有效, 只是沒有 step-up 的 claims; opaque 模式下就是原本的 token key
+type ElevatedToken struct {
+	*Token
+	AccessToken string
+}
+
+// RefreshTokenPolicy refreshToken 輪替的限制, 值為 0 代表不限制
+// 同一次登入 (session) 輪替出來的 refreshToken 屬於同一個 family, family id 就是 session id
+type RefreshTokenPolicy struct {
+	// AbsoluteLifetime family 從登入開始可以存活的最長時間, 超過之後必須重新登入
+	AbsoluteLifetime time.Duration
+	// IdleTimeout 每次輪替後新的 refreshToken 的存活時間, 沒有設定時使用 token 的 RefreshExpiresIn
+	IdleTimeout time.Duration
+}
+
 // TokenUsecase 用來處理 Token 相關業務操作的場景
 type TokenUsecase interface {
-	CreateToken(ctx context.Context, accessToken Token, prefixTokens ...string) (string, string, error)
+	CreateToken(ctx context.Context, request CreateTokenRequest) (*Session, error)
+	// CreateAccessToken 只建立 accessToken, 不會建立 refreshToken 與 session, 例如 OAuth client_credentials
+	CreateAccessToken(ctx context.Context, request CreateTokenRequest) (string, error)
+	// Token 回傳 accessToken 的內容, refreshToken 會回傳 ErrInvalidToken
 	Token(ctx context.Context, tokenKey string) (*Token, error)
+	// LookupToken 與 Token 相同, 但是 refreshToken 也會回傳, 給 OAuth refresh, introspection 與 revocation 這類需要辨識 token 種類的流程使用
+	LookupToken(ctx context.Context, tokenKey string) (*Token, error)
 	RefreshToken(ctx context.Context, tokenKey string) (string, string, error)
+	// RefreshTokenWithClaims 與 RefreshToken 相同, claims 只會覆蓋在新的 accessToken 上, 新的 refreshToken 維持原本的 claims,
+	// 例如 OAuth 2.0 refresh 時縮小 accessToken 的 scope (RFC 6749 6)
+	RefreshTokenWithClaims(ctx context.Context, tokenKey string, claims map[string]string) (string, string, error)
 	BindHashToken(ctx context.Context, hashKey, accessTokenKey string) error
 	DeleteHash(ctx context.Context, hashKey string) error
 	DeleteTokenByAccountID(ctx context.Context, accountID int64, prefixTokens ...string) error
 	RenewToken(ctx context.Context, tokenKey string, duration int64) error
 	CreateRefreshToken(ctx context.Context, token *Token) (string, error)
+	ElevateToken(ctx context.Context, request ElevateTokenRequest) (*ElevatedToken, error)
+	// TokenExpiresAt 回傳 token 的到期時間, 沒有期限時回傳 zero time
+	TokenExpiresAt(ctx context.Context, tokenKey string) (time.Time, error)
+	// RevokeToken 撤銷 token, 屬於 session 的 token 會連同 session 一起撤銷, 否則撤銷 token 與配對的 token
+	RevokeToken(ctx context.Context, tokenKey string) error
 }
 
 // TokenRepository 用來處理 token 物件存儲的行為 repository layer
@@ -80,9 +244,16 @@ type TokenRepository interface {
 	DeleteHash(ctx context.Context, hashID string) error
 	SetToken(ctx context.Context, prefix string, token Token, d time.Duration) (string, error)
 	DeleteToken(ctx context.Context, token string) error
-	RefreshToken(ctx context.Context, token string) (string, string, error)
+	// RefreshToken 輪替 refreshToken, 舊的 refreshToken 會被標記為已輪替, 再次使用時回傳 ErrRefreshTokenReused
+	RefreshToken(ctx context.Context, token string, d time.Duration) (string, string, error)
+	// RotatedTokenFamily 回傳已輪替的 refreshToken 所屬的 family id
+	RotatedTokenFamily(ctx context.Context, token string) (string, error)
 	GetToken(ctx context.Context, tokenString string) (Token, error)
+	// TokenTTL 回傳 token 剩餘的存活時間, 沒有期限時回傳 0
+	TokenTTL(ctx context.Context, tokenString string) (time.Duration, error)
 	RenewToken(ctx context.Context, tokenString string, d time.Duration) error
+	UpdateToken(ctx context.Context, token Token) error
+	DeleteTokenByAccountID(ctx context.Context, accountID int64, prefixTokens ...string) error
 	GetRcc() interface{}
 
 	//need to suspend
@@ -0,0 +1,193 @@
+package domain
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"gorm.io/datatypes"
+)
+
+var (
+	ErrWebhookEventInvalid = &AppError{Code: "WEBHOOK_EVENT_INVALID", Message: "the webhook event filter is invalid", Status: codes.InvalidArgument}
+)
+
+// webhook 的事件類型, 訂閱時可以用 "*" 或 "account.*" 訂閱全部或某一類的事件
+const (
+	WebhookEventAccountCreated   = "account.created"
+	WebhookEventAccountUpdated   = "account.updated"
+	WebhookEventAccountLocked    = "account.locked"
+	WebhookEventAccountDisabled  = "account.disabled"
+	WebhookEventAccountActivated = "account.activated"
+	WebhookEventRoleCreated      = "role.created"
+	WebhookEventRoleUpdated      = "role.updated"
+	WebhookEventRoleDeleted      = "role.deleted"
+	WebhookEventRoleAssigned     = "role.assigned"
+	WebhookEventRoleUnassigned   = "role.unassigned"
+	WebhookEventLoginSucceeded   = "login.succeeded"
+	WebhookEventLoginFailed      = "login.failed"
+	WebhookEventAPIKeyCreated    = "api_key.created"
+	WebhookEventAPIKeyRevoked    = "api_key.revoked"
+	WebhookEventTokenReused      = "token.reused"
+)
+
+// WebhookEventTypes 所有可以訂閱的事件類型
+var WebhookEventTypes = []string{
+	WebhookEventAccountCreated,
+	WebhookEventAccountUpdated,
+	WebhookEventAccountLocked,
+	WebhookEventAccountDisabled,
+	WebhookEventAccountActivated,
+	WebhookEventRoleCreated,
+	WebhookEventRoleUpdated,
+	WebhookEventRoleDeleted,
+	WebhookEventRoleAssigned,
+	WebhookEventRoleUnassigned,
+	WebhookEventLoginSucceeded,
+	WebhookEventLoginFailed,
+	WebhookEventAPIKeyCreated,
+	WebhookEventAPIKeyRevoked,
+	WebhookEventTokenReused,
+}
+
+const (
+	// WebhookSignatureHeader 格式為 "t=<unix time>,v1=<hex>", v1 是用 secret 對 "<unix time>.<body>" 做的 HMAC-SHA256
+	WebhookSignatureHeader = "X-Identity-Signature"
+	WebhookEventHeader     = "X-Identity-Event"
+	WebhookDeliveryHeader  = "X-Identity-Delivery"
+)
+
+type WebhookState uint32
+
+const (
+	WebhookStateDefault  WebhookState = 0
+	WebhookStateEnabled  WebhookState = 1
+	WebhookStateDisabled WebhookState = 2
+)
+
+// Webhook 是某一個 namespace 的 webhook 訂閱, Events 用空白分隔
+// Secret 用來簽章, 需要保存明碼所以只在建立時回傳給呼叫端
+type Webhook struct {
+	ID          uint64       `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	Namespace   string       `gorm:"column:namespace;type:string;size:256;index:idx_namespace;not null"`
+	URL         string       `gorm:"column:url;type:string;size:1024;not null"`
+	Secret      string       `gorm:"column:secret;type:string;size:64;not null"`
+	Events      string       `gorm:"column:events;type:string;size:1024;not null"`
+	State       WebhookState `gorm:"column:state;type:int;not null"`
+	CreatorName string       `gorm:"column:creator_name;type:string;size:32;not null"`
+	CreatedAt   time.Time    `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	UpdatedAt   time.Time    `gorm:"column:updated_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// Subscribes 判斷 webhook 是否訂閱了這個事件類型
+func (w *Webhook) Subscribes(eventType string) bool {
+	return MatchEventType(strings.Fields(w.Events), eventType)
+}
+
+type WebhookDeliveryState uint32
+
+const (
+	WebhookDeliveryDefault   WebhookDeliveryState = 0
+	WebhookDeliveryPending   WebhookDeliveryState = 1
+	WebhookDeliverySucceeded WebhookDeliveryState = 2
+	// WebhookDeliveryDead 重試次數用完仍然失敗, 需要透過 Redeliver 重新發送
+	WebhookDeliveryDead WebhookDeliveryState = 3
+)
+
+// WebhookDelivery 是一個事件送到一個 webhook 的紀錄, 與 event log 在同一個 transaction 建立
+// NextAttemptAt 在發送期間會被設定成租約的到期時間, 避免多個 instance 重複發送
+type WebhookDelivery struct {
+	ID             uint64               `gorm:"column:id;primaryKey;autoIncrement;not null"`
+	WebhookID      uint64               `gorm:"column:webhook_id;type:bigint;index:idx_webhook;not null"`
+	Namespace      string               `gorm:"column:namespace;type:string;size:256;not null"`
+	EventID        string               `gorm:"column:event_id;type:string;size:36;not null"`
+	EventType      string               `gorm:"column:event_type;type:string;size:64;not null"`
+	Payload        datatypes.JSON       `gorm:"column:payload;type:json;not null"`
+	State          WebhookDeliveryState `gorm:"column:state;type:int;index:idx_state;not null"`
+	Attempts       int32                `gorm:"column:attempts;type:int;not null"`
+	ResponseStatus int32                `gorm:"column:response_status;type:int;not null"`
+	LastError      string               `gorm:"column:last_error;type:string;size:512;not null"`
+	NextAttemptAt  time.Time            `gorm:"column:next_attempt_at;type:datetime;index:idx_state;default:1970-01-01 00:00:00;not null"`
+	DeliveredAt    time.Time            `gorm:"column:delivered_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	CreatedAt      time.Time            `gorm:"column:created_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+	UpdatedAt      time.Time            `gorm:"column:updated_at;type:datetime;default:1970-01-01 00:00:00;not null"`
+}
+
+// WebhookEvent 是送給 webhook 的 payload, 同一個事件送到不同 webhook 時 ID 相同, 接收端可以用來去除重複
+type WebhookEvent struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Namespace  string          `json:"namespace"`
+	TargetID   string          `json:"target_id"`
+	Actor      string          `json:"actor,omitempty"`
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// WebhookSignature 計算 payload 的簽章, 接收端用相同的方式計算後比對 WebhookSignatureHeader
+func WebhookSignature(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type CreateWebhookRequest struct {
+	Namespace   string
+	URL         string
+	Events      []string
+	CreatorName string
+}
+
+type DeleteWebhookRequest struct {
+	Namespace   string
+	WebhookID   uint64
+	UpdaterName string
+}
+
+type FindWebhookDeliveryOptions struct {
+	Namespace string
+	WebhookID uint64
+	State     WebhookDeliveryState
+	Limit     int
+}
+
+// WebhookUsecase 用來處理 webhook 訂閱與發送的場景
+type WebhookUsecase interface {
+	// CreateWebhook 建立訂閱, 回傳的 Webhook 包含簽章用的 secret
+	CreateWebhook(ctx context.Context, request CreateWebhookRequest) (*Webhook, error)
+	// Webhooks 回傳的 Webhook 不包含 secret
+	Webhooks(ctx context.Context, namespace string) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, request DeleteWebhookRequest) error
+	// Publish 為訂閱這個事件的 webhook 建立 delivery, 需要在寫入 event log 的 transaction 裡面呼叫
+	Publish(ctx context.Context, event *WebhookEvent) error
+	// DeadLetters 回傳重試次數用完的 delivery, webhookID 為 0 時回傳 namespace 全部的 delivery
+	DeadLetters(ctx context.Context, namespace string, webhookID uint64) ([]WebhookDelivery, error)
+	// Redeliver 把 delivery 重新排入發送佇列
+	Redeliver(ctx context.Context, namespace string, deliveryID uint64) error
+	// Dispatch 發送到期的 delivery, 由背景的 worker 定期呼叫
+	Dispatch(ctx context.Context) error
+}
+
+// WebhookRepository 用來處理 webhook 物件的存儲的行為 repository layer
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	Webhook(ctx context.Context, namespace string, webhookID uint64) (*Webhook, error)
+	Webhooks(ctx context.Context, namespace string) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, namespace string, webhookID uint64) error
+	CreateWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error
+	WebhookDelivery(ctx context.Context, namespace string, deliveryID uint64) (*WebhookDelivery, error)
+	WebhookDeliveries(ctx context.Context, opts FindWebhookDeliveryOptions) ([]WebhookDelivery, error)
+	// DueWebhookDeliveries 回傳所有 namespace 中 NextAttemptAt 已經到期的 pending delivery
+	DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	// ClaimWebhookDelivery 把到期的 delivery 的 NextAttemptAt 延後到 leaseUntil, 回傳 false 代表已經被其他 worker 取走
+	ClaimWebhookDelivery(ctx context.Context, deliveryID uint64, now time.Time, leaseUntil time.Time) (bool, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+}
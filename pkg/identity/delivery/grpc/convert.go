@@ -0,0 +1,284 @@
+package grpc
+
+import (
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func toAccountProto(account *domain.Account) *identityProto.Account {
+	return &identityProto.Account{
+		Id:                    strconv.FormatUint(account.ID, 10),
+		Uuid:                  account.UUID,
+		Namespace:             account.Namespace,
+		Type:                  int32(account.Type),
+		Username:              account.Username.String,
+		OtpEnable:             account.OTPEnable == 1,
+		FirstName:             account.FirstName,
+		LastName:              account.LastName,
+		NickName:              account.NickName,
+		Avatar:                account.Avatar,
+		Email:                 account.Email.String,
+		MobileCountryCode:     account.MobileCountryCode.String,
+		Mobile:                account.Mobile.String,
+		ExternalId:            account.ExternalID,
+		FailedPasswordAttempt: account.FailedPasswordAttempt,
+		ClientIp:              account.ClientIP,
+		Notes:                 account.Note,
+		LastLoginAt:           account.LastLoginAt.Unix(),
+		IsAdmin:               account.IsAdmin == 1,
+		State:                 int32(account.State),
+		CreatorId:             strconv.FormatUint(account.CreatorID, 10),
+		CreatorName:           account.CreatorName,
+		CreatedAt:             timestamppb.New(account.CreatedAt),
+		UpdaterId:             strconv.FormatUint(account.UpdaterID, 10),
+		UpdaterName:           account.UpdaterName,
+		UpdatedAt:             timestamppb.New(account.UpdatedAt),
+	}
+}
+
+func toTokenProto(token *domain.Token) *identityProto.Token {
+	return &identityProto.Token{
+		AccountId:        token.AccountID,
+		Namespace:        token.Namespace,
+		ExpiresIn:        token.ExpiresIn,
+		TokenString:      token.TokenString,
+		Claims:           token.Claims,
+		Username:         token.Username,
+		AccountType:      token.AccountType,
+		RefreshExpiresIn: token.RefreshExpiresIn,
+	}
+}
+
+func fromTokenProto(token *identityProto.Token) domain.Token {
+	return domain.Token{
+		AccountID:        token.AccountId,
+		Namespace:        token.Namespace,
+		ExpiresIn:        token.ExpiresIn,
+		TokenString:      token.TokenString,
+		Claims:           token.Claims,
+		Username:         token.Username,
+		AccountType:      token.AccountType,
+		RefreshExpiresIn: token.RefreshExpiresIn,
+	}
+}
+
+func toSessionProto(session *domain.Session) *identityProto.Session {
+	return &identityProto.Session{
+		Id:          session.ID,
+		Namespace:   session.Namespace,
+		AccountId:   session.AccountID,
+		DeviceType:  int32(session.DeviceType),
+		ClientIp:    session.ClientIP,
+		CountryCode: session.CountryCode,
+		CityName:    session.CityName,
+		UserAgent:   session.UserAgent,
+		CreatedAt:   timestamppb.New(session.CreatedAt),
+		LastSeenAt:  timestamppb.New(session.LastSeenAt),
+	}
+}
+
+func toOAuthClientProto(client *domain.OAuthClient) *identityProto.OAuthClient {
+	return &identityProto.OAuthClient{
+		ClientId:     client.ClientID,
+		Namespace:    client.Namespace,
+		Name:         client.Name,
+		RedirectUris: strings.Fields(client.RedirectURIs),
+		GrantTypes:   strings.Fields(client.GrantTypes),
+		Scopes:       strings.Fields(client.Scopes),
+		Public:       client.IsPublic(),
+		CreatorId:    int64(client.CreatorID),
+		CreatorName:  client.CreatorName,
+		CreatedAt:    timestamppb.New(client.CreatedAt),
+	}
+}
+
+func toAPIKeyProto(key *domain.APIKey) *identityProto.APIKey {
+	result := &identityProto.APIKey{
+		KeyId:     key.KeyID,
+		Namespace: key.Namespace,
+		AccountId: int64(key.AccountID),
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+
+	if key.ExpiresAt.Unix() > 0 {
+		result.ExpiresAt = timestamppb.New(key.ExpiresAt)
+	}
+
+	if key.LastUsedAt.Unix() > 0 {
+		result.LastUsedAt = timestamppb.New(key.LastUsedAt)
+	}
+
+	return result
+}
+
+func toWebhookProto(webhook *domain.Webhook) *identityProto.Webhook {
+	return &identityProto.Webhook{
+		Id:          webhook.ID,
+		Namespace:   webhook.Namespace,
+		Url:         webhook.URL,
+		Events:      strings.Fields(webhook.Events),
+		State:       int32(webhook.State),
+		CreatorName: webhook.CreatorName,
+		CreatedAt:   timestamppb.New(webhook.CreatedAt),
+	}
+}
+
+func toWebhookDeliveryProto(delivery *domain.WebhookDelivery) *identityProto.WebhookDelivery {
+	return &identityProto.WebhookDelivery{
+		Id:             delivery.ID,
+		WebhookId:      delivery.WebhookID,
+		Namespace:      delivery.Namespace,
+		EventId:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Payload:        string(delivery.Payload),
+		State:          int32(delivery.State),
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		CreatedAt:      timestamppb.New(delivery.CreatedAt),
+		UpdatedAt:      timestamppb.New(delivery.UpdatedAt),
+	}
+}
+
+func toWatchResponseProto(event *domain.ChangeEvent) *identityProto.WatchResponse {
+	return &identityProto.WatchResponse{
+		Cursor:     event.Cursor,
+		Type:       event.Type,
+		Namespace:  event.Namespace,
+		TargetId:   event.TargetID,
+		Actor:      event.Actor,
+		Data:       string(event.Data),
+		OccurredAt: timestamppb.New(event.OccurredAt),
+	}
+}
+
+func toEventLogProto(eventLog *domain.EventLog) *identityProto.EventLog {
+	changes := make([]*identityProto.FieldChange, 0, len(eventLog.Changes))
+	for _, change := range eventLog.Changes {
+		changes = append(changes, &identityProto.FieldChange{
+			Field:    change.Field,
+			OldValue: string(change.OldValue),
+			NewValue: string(change.NewValue),
+		})
+	}
+
+	return &identityProto.EventLog{
+		Id:        eventLog.ID,
+		Namespace: eventLog.Namespace,
+		Action:    eventLog.Action,
+		TargetId:  eventLog.TargetID,
+		Message:   eventLog.Message,
+		OldStatus: string(eventLog.OldStatus),
+		NewStatus: string(eventLog.NewStatus),
+		State:     int32(eventLog.State),
+		ClientIp:  eventLog.ClientIP,
+		Actor:     eventLog.Actor,
+		CreatedAt: timestamppb.New(eventLog.CreatedAt),
+		Changes:   changes,
+	}
+}
+
+func fromFindEventLogOptionsProto(opts *identityProto.FindEventLogOptions) domain.FindEventLogOptions {
+	result := domain.FindEventLogOptions{
+		Namespace: opts.GetNamespace(),
+		Action:    opts.GetAction(),
+		TargetID:  opts.GetTargetId(),
+		Actor:     opts.GetActor(),
+		State:     domain.EventLogState(opts.GetState()),
+	}
+
+	if opts.GetCreatedAtStart() != nil {
+		result.CreatedTimeStart = opts.GetCreatedAtStart().AsTime()
+	}
+
+	if opts.GetCreatedAtEnd() != nil {
+		result.CreatedTimeEnd = opts.GetCreatedAtEnd().AsTime()
+	}
+
+	return result
+}
+
+func toLoginLogProto(loginLog *domain.LoginLog) *identityProto.LoginLog {
+	return &identityProto.LoginLog{
+		Id:          loginLog.ID,
+		Namespace:   loginLog.Namespace,
+		TargetId:    loginLog.TargetID,
+		CountryCode: loginLog.CountryCode,
+		CityName:    loginLog.CityName,
+		DeviceType:  int32(loginLog.DeviceType),
+		State:       int32(loginLog.State),
+		ClientIp:    loginLog.ClientIP,
+		CreatedAt:   timestamppb.New(loginLog.CreatedAt),
+	}
+}
+
+func fromFindLoginLogOptionsProto(opts *identityProto.FindLoginLogOptions) domain.FindLoginLogOptions {
+	result := domain.FindLoginLogOptions{
+		Namespace:   opts.GetNamespace(),
+		TargetID:    opts.GetTargetId(),
+		State:       domain.LoginLogState(opts.GetState()),
+		DeviceType:  domain.DeviceType(opts.GetDeviceType()),
+		CountryCode: opts.GetCountryCode(),
+	}
+
+	if opts.GetCreatedAtStart() != nil {
+		result.CreatedTimeStart = opts.GetCreatedAtStart().AsTime()
+	}
+
+	if opts.GetCreatedAtEnd() != nil {
+		result.CreatedTimeEnd = opts.GetCreatedAtEnd().AsTime()
+	}
+
+	return result
+}
+
+func toLoginSummaryProto(summary *domain.LoginSummary) *identityProto.LoginSummaryResponse {
+	recentFailures := make([]*identityProto.LoginLog, 0, len(summary.RecentFailures))
+	for i := range summary.RecentFailures {
+		recentFailures = append(recentFailures, toLoginLogProto(&summary.RecentFailures[i]))
+	}
+
+	result := &identityProto.LoginSummaryResponse{
+		Namespace:      summary.Namespace,
+		AccountId:      summary.AccountID,
+		Since:          timestamppb.New(summary.Since),
+		FailureCount:   summary.FailureCount,
+		RecentFailures: recentFailures,
+		CountryCodes:   summary.CountryCodes,
+	}
+
+	if summary.LastSuccessLogin != nil {
+		result.LastSuccessLogin = toLoginLogProto(summary.LastSuccessLogin)
+	}
+
+	return result
+}
+
+func toEventLogChainVerificationProto(verification *domain.EventLogChainVerification) *identityProto.EventLogChainVerification {
+	result := &identityProto.EventLogChainVerification{
+		Namespace:       verification.Namespace,
+		ChainedCount:    verification.ChainedCount,
+		UnchainedCount:  verification.UnchainedCount,
+		LastEventLogId:  verification.LastEventLogID,
+		LastHash:        verification.LastHash,
+		CheckpointCount: verification.CheckpointCount,
+		VerifiedAt:      timestamppb.New(verification.VerifiedAt),
+	}
+
+	if verification.Break != nil {
+		result.Break = &identityProto.EventLogChainBreak{
+			EventLogId: verification.Break.EventLogID,
+			Reason:     verification.Break.Reason,
+			Expected:   verification.Break.Expected,
+			Actual:     verification.Break.Actual,
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,52 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain 放在 ErrorInfo 裡, 讓 client 端可以辨識是 identity 回傳的 AppError
+const ErrorDomain = "identity"
+
+// toStatusError 把 AppError 轉成 grpc status, AppError.Code 會放在 ErrorInfo.Reason
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var appErr *domain.AppError
+	if !errors.As(err, &appErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code := appErr.Status
+	if code == codes.OK {
+		code = codes.Unknown
+	}
+
+	info := &errdetails.ErrorInfo{
+		Reason: appErr.Code,
+		Domain: ErrorDomain,
+	}
+
+	// AppError.Details 放在 ErrorInfo.Metadata, 值會轉成字串
+	if len(appErr.Details) > 0 {
+		info.Metadata = make(map[string]string, len(appErr.Details))
+		for k, v := range appErr.Details {
+			info.Metadata[k] = fmt.Sprint(v)
+		}
+	}
+
+	st := status.New(code, appErr.Message)
+	detailed, detailErr := st.WithDetails(info)
+	if detailErr != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
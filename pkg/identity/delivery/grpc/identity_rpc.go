@@ -4,17 +4,44 @@ import (
 	"context"
 	"identity/pkg/domain"
 	identityProto "identity/pkg/identity/proto"
+	"time"
+
+	"google.golang.org/grpc/status"
 )
 
 // IdentityServer is server
 type IdentityServer struct {
 	accountSvc domain.AccountUsecase
+	tokenSvc   domain.TokenUsecase
+	sessionSvc domain.SessionUsecase
+	oauthSvc   domain.OAuthUsecase
+
+	impersonationSvc domain.ImpersonationUsecase
+	apiKeySvc        domain.APIKeyUsecase
+	webhookSvc       domain.WebhookUsecase
+	changeFeedSvc    domain.ChangeFeedUsecase
+	eventLogSvc      domain.EventLogUsecase
+	loginLogSvc      domain.LoginLogUsecase
+	eventLogChainSvc domain.EventLogChainUsecase
+
+	trustedProxies domain.TrustedProxies
 }
 
 // NewIdentityServer generate a new identity server instance
-func NewIdentityServer(accountSvc domain.AccountUsecase) *IdentityServer {
+func NewIdentityServer(accountSvc domain.AccountUsecase, tokenSvc domain.TokenUsecase, sessionSvc domain.SessionUsecase, oauthSvc domain.OAuthUsecase, impersonationSvc domain.ImpersonationUsecase, apiKeySvc domain.APIKeyUsecase, webhookSvc domain.WebhookUsecase, changeFeedSvc domain.ChangeFeedUsecase, eventLogSvc domain.EventLogUsecase, loginLogSvc domain.LoginLogUsecase, eventLogChainSvc domain.EventLogChainUsecase, trustedProxies domain.TrustedProxies) *IdentityServer {
 	return &IdentityServer{
-		accountSvc: accountSvc,
+		accountSvc:       accountSvc,
+		tokenSvc:         tokenSvc,
+		sessionSvc:       sessionSvc,
+		oauthSvc:         oauthSvc,
+		impersonationSvc: impersonationSvc,
+		apiKeySvc:        apiKeySvc,
+		webhookSvc:       webhookSvc,
+		changeFeedSvc:    changeFeedSvc,
+		eventLogSvc:      eventLogSvc,
+		loginLogSvc:      loginLogSvc,
+		eventLogChainSvc: eventLogChainSvc,
+		trustedProxies:   trustedProxies,
 	}
 }
 func (s *IdentityServer) Account(ctx context.Context, _ *identityProto.AccountRequest) (*identityProto.AccountResponse, error) {
@@ -46,12 +73,51 @@ func (s *IdentityServer) UpdateAccount(ctx context.Context, _ *identityProto.Upd
 	panic("not implemented")
 }
 
-func (s *IdentityServer) UpdateAccountPassword(ctx context.Context, _ *identityProto.UpdateAccountPasswordRequest) (*identityProto.UpdateAccountPasswordResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) UpdateAccountPassword(ctx context.Context, in *identityProto.UpdateAccountPasswordRequest) (*identityProto.UpdateAccountPasswordResponse, error) {
+	namespace := callerNamespace(ctx)
+	err := s.authorizeAccount(ctx, namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.UpdateAccountPasswordRequest{
+		Namespace:   namespace,
+		AccountID:   uint64(in.AccountId),
+		OldPassword: in.OldPassword,
+		NewPassword: in.NewPassword,
+		UpdaterID:   uint64(in.UpdaterAccountId),
+		UpdaterName: in.UpdaterName,
+	}
+
+	err = s.accountSvc.UpdateAccountPassword(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.UpdateAccountPasswordResponse{}, nil
 }
 
-func (s *IdentityServer) ForcedUpdatePassword(ctx context.Context, _ *identityProto.ForcedUpdatePasswordRequest) (*identityProto.ForcedUpdatePasswordResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) ForcedUpdatePassword(ctx context.Context, in *identityProto.ForcedUpdatePasswordRequest) (*identityProto.ForcedUpdatePasswordResponse, error) {
+	namespace := callerNamespace(ctx)
+	err := s.authorizeAdmin(ctx, namespace)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.ForceUpdateAccountPasswordRequest{
+		Namespace:   namespace,
+		AccountID:   uint64(in.AccountId),
+		NewPassword: in.NewPassword,
+		UpdaterID:   uint64(in.UpdaterAccountId),
+		UpdaterName: in.UpdaterName,
+	}
+
+	err = s.accountSvc.ForceUpdateAccountPassword(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.ForcedUpdatePasswordResponse{}, nil
 }
 
 func (s *IdentityServer) LockAccount(ctx context.Context, _ *identityProto.LockAccountRequest) (*identityProto.LockAccountResponse, error) {
@@ -74,20 +140,119 @@ func (s *IdentityServer) Login(ctx context.Context, _ *identityProto.LoginReques
 	panic("not implemented")
 }
 
-func (s *IdentityServer) ClearOTP(ctx context.Context, _ *identityProto.ClearOTPRequest) (*identityProto.ClearOTPResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) ClearOTP(ctx context.Context, in *identityProto.ClearOTPRequest) (*identityProto.ClearOTPResponse, error) {
+	account, err := s.accountSvc.AccountByUUID(ctx, in.Namespace, in.AccountUuid)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	err = s.authorizeAccount(ctx, in.Namespace, account.ID)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.ClearOTPRequest{
+		Namespace:   in.Namespace,
+		AccountID:   account.ID,
+		UpdaterID:   uint64(in.UpdaterAccountId),
+		UpdaterName: in.UpdaterName,
+	}
+
+	err = s.accountSvc.ClearOTP(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.ClearOTPResponse{}, nil
 }
 
-func (s *IdentityServer) GenerateOTPAuth(ctx context.Context, _ *identityProto.GenerateOTPAuthRequest) (*identityProto.GenerateOTPAuthResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) GenerateOTPAuth(ctx context.Context, in *identityProto.GenerateOTPAuthRequest) (*identityProto.GenerateOTPAuthResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.ResetOTPSecretRequest{
+		Namespace:   in.Namespace,
+		AccountID:   uint64(in.AccountId),
+		UpdaterID:   uint64(in.UpdaterAccountId),
+		UpdaterName: in.UpdaterName,
+	}
+
+	otpAuth, err := s.accountSvc.ResetOTPSecret(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.GenerateOTPAuthResponse{
+		OtpToken:      otpAuth.Secret,
+		OtpAuthUrl:    otpAuth.URL,
+		RecoveryCodes: otpAuth.RecoveryCodes,
+	}, nil
 }
 
 func (s *IdentityServer) SetOTPExpireTime(ctx context.Context, _ *identityProto.SetOTPExpireTimeRequest) (*identityProto.SetOTPExpireTimeResponse, error) {
 	panic("not implemented")
 }
 
-func (s *IdentityServer) VerifyOTP(ctx context.Context, _ *identityProto.VerifyOTPRequest) (*identityProto.VerifyOTPResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) VerifyOTP(ctx context.Context, in *identityProto.VerifyOTPRequest) (*identityProto.VerifyOTPResponse, error) {
+	request := domain.VerifyOTPRequest{
+		Namespace: in.Namespace,
+		OTPCode:   in.OtpCode,
+	}
+
+	err := s.accountSvc.VerifyOTP(ctx, in.AccountUuid, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	account, err := s.accountSvc.AccountByUUID(ctx, in.Namespace, in.AccountUuid)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := identityProto.VerifyOTPResponse{
+		Account: toAccountProto(account),
+	}
+
+	if in.TokenKey != "" {
+		token, err := s.tokenSvc.ElevateToken(ctx, domain.ElevateTokenRequest{
+			TokenKey:  in.TokenKey,
+			AccountID: int64(account.ID),
+			Method:    domain.AMROTP,
+			Duration:  time.Duration(in.ElevatedDuration) * time.Second,
+		})
+		if err != nil {
+			return nil, toStatusError(err)
+		}
+		resp.ElevatedUntil = token.ElevatedUntil().Unix()
+		resp.AccessToken = token.AccessToken
+	}
+
+	return &resp, nil
+}
+
+func (s *IdentityServer) GenerateOTPRecoveryCodes(ctx context.Context, in *identityProto.GenerateOTPRecoveryCodesRequest) (*identityProto.GenerateOTPRecoveryCodesResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.GenerateOTPRecoveryCodesRequest{
+		Namespace:   in.Namespace,
+		AccountID:   uint64(in.AccountId),
+		UpdaterID:   uint64(in.UpdaterAccountId),
+		UpdaterName: in.UpdaterName,
+	}
+
+	codes, err := s.accountSvc.GenerateOTPRecoveryCodes(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.GenerateOTPRecoveryCodesResponse{
+		RecoveryCodes: codes,
+	}, nil
 }
 
 func (s *IdentityServer) Role(ctx context.Context, _ *identityProto.RoleRequest) (*identityProto.RoleResponse, error) {
@@ -114,38 +279,550 @@ func (s *IdentityServer) AccountRoles(ctx context.Context, _ *identityProto.Acco
 	panic("not implemented")
 }
 
-func (s *IdentityServer) CreateToken(ctx context.Context, _ *identityProto.CreateTokenRequest) (*identityProto.CreateTokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) CreateToken(ctx context.Context, in *identityProto.CreateTokenRequest) (*identityProto.CreateTokenResponse, error) {
+	if in.Token == nil {
+		return nil, toStatusError(domain.ErrInvalidInput)
+	}
+
+	request := domain.CreateTokenRequest{
+		Token:      fromTokenProto(in.Token),
+		Prefix:     in.Namespace,
+		DeviceType: domain.DeviceType(in.DeviceType),
+		ClientIP:   in.ClientIp,
+		UserAgent:  in.UserAgent,
+	}
+
+	session, err := s.tokenSvc.CreateToken(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CreateTokenResponse{
+		Token:      session.AccessToken,
+		AccessKey:  session.AccessToken,
+		RefreshKey: session.RefreshKey,
+		SessionId:  session.ID,
+	}, nil
 }
 
-func (s *IdentityServer) CreateRefreshToken(ctx context.Context, _ *identityProto.CreateRefreshTokenRequest) (*identityProto.CreateRefreshTokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) CreateRefreshToken(ctx context.Context, in *identityProto.CreateRefreshTokenRequest) (*identityProto.CreateRefreshTokenResponse, error) {
+	if in.Token == nil {
+		return nil, toStatusError(domain.ErrInvalidInput)
+	}
+
+	token := fromTokenProto(in.Token)
+
+	refreshKey, err := s.tokenSvc.CreateRefreshToken(ctx, &token)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CreateRefreshTokenResponse{
+		Token: refreshKey,
+	}, nil
 }
 
-func (s *IdentityServer) Token(ctx context.Context, _ *identityProto.TokenRequest) (*identityProto.TokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) Token(ctx context.Context, in *identityProto.TokenRequest) (*identityProto.TokenResponse, error) {
+	token, err := s.tokenSvc.Token(ctx, in.TokenKey)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.TokenResponse{
+		Token: toTokenProto(token),
+	}, nil
 }
 
 func (s *IdentityServer) DeleteTokenByRoleName(ctx context.Context, _ *identityProto.DeleteTokenByRoleNameRequest) (*identityProto.DeleteTokenByRoleNameResponse, error) {
 	panic("not implemented")
 }
 
-func (s *IdentityServer) DeleteTokenByAccountID(ctx context.Context, _ *identityProto.DeleteTokenByAccountIDRequest) (*identityProto.DeleteTokenByAccountIDResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) DeleteTokenByAccountID(ctx context.Context, in *identityProto.DeleteTokenByAccountIDRequest) (*identityProto.DeleteTokenByAccountIDResponse, error) {
+	var prefixTokens []string
+	if in.Namespace != "" {
+		prefixTokens = append(prefixTokens, in.Namespace)
+	}
+
+	err := s.tokenSvc.DeleteTokenByAccountID(ctx, in.AccountId, prefixTokens...)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.DeleteTokenByAccountIDResponse{}, nil
 }
 
-func (s *IdentityServer) RenewToken(ctx context.Context, _ *identityProto.RenewTokenRequest) (*identityProto.RenewTokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) RenewToken(ctx context.Context, in *identityProto.RenewTokenRequest) (*identityProto.RenewTokenResponse, error) {
+	err := s.tokenSvc.RenewToken(ctx, in.TokenKey, in.Duration)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RenewTokenResponse{}, nil
 }
 
-func (s *IdentityServer) RefreshToken(ctx context.Context, _ *identityProto.RefreshTokenRequest) (*identityProto.RefreshTokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) RefreshToken(ctx context.Context, in *identityProto.RefreshTokenRequest) (*identityProto.RefreshTokenResponse, error) {
+	accessKey, refreshKey, err := s.tokenSvc.RefreshToken(ctx, in.RefreshToken)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RefreshTokenResponse{
+		AuthToken:    accessKey,
+		RefreshToken: refreshKey,
+	}, nil
 }
 
-func (s *IdentityServer) BindHashToken(ctx context.Context, _ *identityProto.BindHashTokenRequest) (*identityProto.BindHashTokenResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) BindHashToken(ctx context.Context, in *identityProto.BindHashTokenRequest) (*identityProto.BindHashTokenResponse, error) {
+	err := s.tokenSvc.BindHashToken(ctx, in.HashKey, in.AccessTokenKey)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.BindHashTokenResponse{}, nil
 }
 
-func (s *IdentityServer) DeleteHash(ctx context.Context, _ *identityProto.DeleteHashRequest) (*identityProto.DeleteHashResponse, error) {
-	panic("not implemented")
+func (s *IdentityServer) DeleteHash(ctx context.Context, in *identityProto.DeleteHashRequest) (*identityProto.DeleteHashResponse, error) {
+	err := s.tokenSvc.DeleteHash(ctx, in.HashKey)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.DeleteHashResponse{}, nil
+}
+
+func (s *IdentityServer) Session(ctx context.Context, in *identityProto.SessionRequest) (*identityProto.SessionResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	session, err := s.sessionSvc.Session(ctx, in.Namespace, in.AccountId, in.SessionId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.SessionResponse{
+		Session: toSessionProto(session),
+	}, nil
+}
+
+func (s *IdentityServer) Sessions(ctx context.Context, in *identityProto.SessionsRequest) (*identityProto.SessionsResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	sessions, err := s.sessionSvc.Sessions(ctx, in.Namespace, in.AccountId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := identityProto.SessionsResponse{
+		Sessions: make([]*identityProto.Session, 0, len(sessions)),
+	}
+	for i := range sessions {
+		resp.Sessions = append(resp.Sessions, toSessionProto(&sessions[i]))
+	}
+
+	return &resp, nil
+}
+
+func (s *IdentityServer) RevokeSession(ctx context.Context, in *identityProto.RevokeSessionRequest) (*identityProto.RevokeSessionResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.RevokeSessionRequest{
+		Namespace: in.Namespace,
+		AccountID: in.AccountId,
+		SessionID: in.SessionId,
+	}
+
+	err = s.sessionSvc.RevokeSession(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RevokeSessionResponse{}, nil
+}
+
+func (s *IdentityServer) RevokeOtherSessions(ctx context.Context, in *identityProto.RevokeOtherSessionsRequest) (*identityProto.RevokeOtherSessionsResponse, error) {
+	err := s.authorizeAccount(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	request := domain.RevokeOtherSessionsRequest{
+		Namespace:        in.Namespace,
+		AccountID:        in.AccountId,
+		CurrentSessionID: in.CurrentSessionId,
+	}
+
+	err = s.sessionSvc.RevokeOtherSessions(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RevokeOtherSessionsResponse{}, nil
+}
+
+func (s *IdentityServer) CreateOAuthClient(ctx context.Context, in *identityProto.CreateOAuthClientRequest) (*identityProto.CreateOAuthClientResponse, error) {
+	request := domain.CreateOAuthClientRequest{
+		Namespace:    in.Namespace,
+		Name:         in.Name,
+		RedirectURIs: in.RedirectUris,
+		GrantTypes:   in.GrantTypes,
+		Scopes:       in.Scopes,
+		Public:       in.Public,
+		CreatorID:    uint64(in.CreatorId),
+		CreatorName:  in.CreatorName,
+	}
+
+	client, secret, err := s.oauthSvc.CreateClient(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CreateOAuthClientResponse{
+		Client:       toOAuthClientProto(client),
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *IdentityServer) OAuthClient(ctx context.Context, in *identityProto.OAuthClientRequest) (*identityProto.OAuthClientResponse, error) {
+	client, err := s.oauthSvc.Client(ctx, in.Namespace, in.ClientId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.OAuthClientResponse{
+		Client: toOAuthClientProto(client),
+	}, nil
+}
+
+func (s *IdentityServer) OAuthClients(ctx context.Context, in *identityProto.OAuthClientsRequest) (*identityProto.OAuthClientsResponse, error) {
+	clients, err := s.oauthSvc.Clients(ctx, in.Namespace)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := identityProto.OAuthClientsResponse{
+		Clients: make([]*identityProto.OAuthClient, 0, len(clients)),
+	}
+	for i := range clients {
+		resp.Clients = append(resp.Clients, toOAuthClientProto(&clients[i]))
+	}
+
+	return &resp, nil
+}
+
+func (s *IdentityServer) DeleteOAuthClient(ctx context.Context, in *identityProto.DeleteOAuthClientRequest) (*identityProto.DeleteOAuthClientResponse, error) {
+	err := s.oauthSvc.DeleteClient(ctx, in.Namespace, in.ClientId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.DeleteOAuthClientResponse{}, nil
+}
+
+func (s *IdentityServer) ApproveDevice(ctx context.Context, in *identityProto.ApproveDeviceRequest) (*identityProto.ApproveDeviceResponse, error) {
+	request := domain.ApproveDeviceRequest{
+		Namespace: in.Namespace,
+		AccountID: uint64(in.AccountId),
+		UserCode:  in.UserCode,
+		Approved:  in.Approved,
+		AMR:       in.Amr,
+	}
+
+	client, err := s.oauthSvc.ApproveDevice(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.ApproveDeviceResponse{
+		Client: toOAuthClientProto(client),
+	}, nil
+}
+
+func (s *IdentityServer) ImpersonateAccount(ctx context.Context, in *identityProto.ImpersonateAccountRequest) (*identityProto.ImpersonateAccountResponse, error) {
+	request := domain.ImpersonateAccountRequest{
+		Namespace: in.Namespace,
+		AccountID: uint64(in.AccountId),
+		Reason:    in.Reason,
+		Duration:  time.Duration(in.Duration) * time.Second,
+		ClientIP:  in.ClientIp,
+	}
+
+	impersonation, err := s.impersonationSvc.ImpersonateAccount(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.ImpersonateAccountResponse{
+		AccessToken:    impersonation.AccessToken,
+		ExpiresIn:      impersonation.ExpiresIn,
+		ImpersonatorId: int64(impersonation.ImpersonatorID),
+	}, nil
+}
+
+func (s *IdentityServer) EndImpersonation(ctx context.Context, in *identityProto.EndImpersonationRequest) (*identityProto.EndImpersonationResponse, error) {
+	request := domain.EndImpersonationRequest{
+		Namespace:   in.Namespace,
+		AccessToken: in.AccessToken,
+		ClientIP:    in.ClientIp,
+	}
+
+	err := s.impersonationSvc.EndImpersonation(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.EndImpersonationResponse{}, nil
+}
+
+func (s *IdentityServer) CreateAPIKey(ctx context.Context, in *identityProto.CreateAPIKeyRequest) (*identityProto.CreateAPIKeyResponse, error) {
+	request := domain.CreateAPIKeyRequest{
+		Namespace: in.Namespace,
+		AccountID: uint64(in.AccountId),
+		Name:      in.Name,
+		Scopes:    in.Scopes,
+		ExpiresIn: time.Duration(in.ExpiresIn) * time.Second,
+	}
+
+	key, apiKey, err := s.apiKeySvc.CreateAPIKey(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CreateAPIKeyResponse{
+		ApiKey: toAPIKeyProto(key),
+		Key:    apiKey,
+	}, nil
+}
+
+func (s *IdentityServer) APIKeys(ctx context.Context, in *identityProto.APIKeysRequest) (*identityProto.APIKeysResponse, error) {
+	keys, err := s.apiKeySvc.APIKeys(ctx, in.Namespace, uint64(in.AccountId))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.APIKey, 0, len(keys))
+	for i := range keys {
+		result = append(result, toAPIKeyProto(&keys[i]))
+	}
+
+	return &identityProto.APIKeysResponse{
+		ApiKeys: result,
+	}, nil
+}
+
+func (s *IdentityServer) RevokeAPIKey(ctx context.Context, in *identityProto.RevokeAPIKeyRequest) (*identityProto.RevokeAPIKeyResponse, error) {
+	request := domain.RevokeAPIKeyRequest{
+		Namespace: in.Namespace,
+		AccountID: uint64(in.AccountId),
+		KeyID:     in.KeyId,
+	}
+
+	err := s.apiKeySvc.RevokeAPIKey(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RevokeAPIKeyResponse{}, nil
+}
+
+func (s *IdentityServer) CreateWebhook(ctx context.Context, in *identityProto.CreateWebhookRequest) (*identityProto.CreateWebhookResponse, error) {
+	request := domain.CreateWebhookRequest{
+		Namespace:   in.Namespace,
+		URL:         in.Url,
+		Events:      in.Events,
+		CreatorName: in.CreatorName,
+	}
+
+	webhook, err := s.webhookSvc.CreateWebhook(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CreateWebhookResponse{
+		Webhook: toWebhookProto(webhook),
+		Secret:  webhook.Secret,
+	}, nil
+}
+
+func (s *IdentityServer) Webhooks(ctx context.Context, in *identityProto.WebhooksRequest) (*identityProto.WebhooksResponse, error) {
+	webhooks, err := s.webhookSvc.Webhooks(ctx, in.Namespace)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.Webhook, 0, len(webhooks))
+	for i := range webhooks {
+		result = append(result, toWebhookProto(&webhooks[i]))
+	}
+
+	return &identityProto.WebhooksResponse{
+		Webhooks: result,
+	}, nil
+}
+
+func (s *IdentityServer) DeleteWebhook(ctx context.Context, in *identityProto.DeleteWebhookRequest) (*identityProto.DeleteWebhookResponse, error) {
+	request := domain.DeleteWebhookRequest{
+		Namespace:   in.Namespace,
+		WebhookID:   in.WebhookId,
+		UpdaterName: in.UpdaterName,
+	}
+
+	err := s.webhookSvc.DeleteWebhook(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.DeleteWebhookResponse{}, nil
+}
+
+func (s *IdentityServer) WebhookDeadLetters(ctx context.Context, in *identityProto.WebhookDeadLettersRequest) (*identityProto.WebhookDeadLettersResponse, error) {
+	deliveries, err := s.webhookSvc.DeadLetters(ctx, in.Namespace, in.WebhookId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.WebhookDelivery, 0, len(deliveries))
+	for i := range deliveries {
+		result = append(result, toWebhookDeliveryProto(&deliveries[i]))
+	}
+
+	return &identityProto.WebhookDeadLettersResponse{
+		Deliveries: result,
+	}, nil
+}
+
+func (s *IdentityServer) RedeliverWebhook(ctx context.Context, in *identityProto.RedeliverWebhookRequest) (*identityProto.RedeliverWebhookResponse, error) {
+	err := s.webhookSvc.Redeliver(ctx, in.Namespace, in.DeliveryId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.RedeliverWebhookResponse{}, nil
+}
+
+// Watch 持續送出 namespace 的帳號與角色異動, client 斷線後用最後收到的 cursor 重新呼叫即可接續
+func (s *IdentityServer) Watch(in *identityProto.WatchRequest, stream identityProto.IdentityService_WatchServer) error {
+	ctx := stream.Context()
+	request := domain.WatchRequest{
+		Namespace: in.Namespace,
+		Cursor:    in.Cursor,
+		Events:    in.Events,
+	}
+
+	err := s.changeFeedSvc.Watch(ctx, request, func(event *domain.ChangeEvent) error {
+		return stream.Send(toWatchResponseProto(event))
+	})
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	return toStatusError(err)
+}
+
+// EventLogs 由新到舊回傳 event log, 每一筆都包含 OldStatus 與 NewStatus 的欄位差異
+func (s *IdentityServer) EventLogs(ctx context.Context, in *identityProto.EventLogsRequest) (*identityProto.EventLogsResponse, error) {
+	opts := fromFindEventLogOptionsProto(in.FindEventLogOptions)
+	opts.Cursor = in.Cursor
+	opts.Limit = int(in.Limit)
+
+	eventLogs, nextCursor, err := s.eventLogSvc.EventLogs(ctx, opts)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.EventLog, 0, len(eventLogs))
+	for i := range eventLogs {
+		result = append(result, toEventLogProto(&eventLogs[i]))
+	}
+
+	return &identityProto.EventLogsResponse{
+		EventLogs:  result,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func (s *IdentityServer) CountEventLogs(ctx context.Context, in *identityProto.CountEventLogsRequest) (*identityProto.CountEventLogsResponse, error) {
+	total, err := s.eventLogSvc.CountEventLogs(ctx, fromFindEventLogOptionsProto(in.FindEventLogOptions))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CountEventLogsResponse{
+		EventLogCounts: total,
+	}, nil
+}
+
+// LoginLogs 由新到舊回傳 login log
+func (s *IdentityServer) LoginLogs(ctx context.Context, in *identityProto.LoginLogsRequest) (*identityProto.LoginLogsResponse, error) {
+	opts := fromFindLoginLogOptionsProto(in.FindLoginLogOptions)
+	opts.Cursor = in.Cursor
+	opts.Limit = int(in.Limit)
+
+	loginLogs, nextCursor, err := s.loginLogSvc.LoginLogs(ctx, opts)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.LoginLog, 0, len(loginLogs))
+	for i := range loginLogs {
+		result = append(result, toLoginLogProto(&loginLogs[i]))
+	}
+
+	return &identityProto.LoginLogsResponse{
+		LoginLogs:  result,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func (s *IdentityServer) CountLoginLogs(ctx context.Context, in *identityProto.CountLoginLogsRequest) (*identityProto.CountLoginLogsResponse, error) {
+	total, err := s.loginLogSvc.CountLoginLogs(ctx, fromFindLoginLogOptionsProto(in.FindLoginLogOptions))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &identityProto.CountLoginLogsResponse{
+		LoginLogCounts: total,
+	}, nil
+}
+
+// LoginSummary 回傳帳號最後一次成功登入, 最近的登入失敗與登入過的國家
+func (s *IdentityServer) LoginSummary(ctx context.Context, in *identityProto.LoginSummaryRequest) (*identityProto.LoginSummaryResponse, error) {
+	request := domain.LoginSummaryRequest{
+		Namespace: in.Namespace,
+		AccountID: in.AccountId,
+	}
+	if in.Since != nil {
+		request.Since = in.Since.AsTime()
+	}
+
+	summary, err := s.loginLogSvc.LoginSummary(ctx, request)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return toLoginSummaryProto(summary), nil
+}
+
+// VerifyEventLogChains 重新計算 event log 的 hash chain, 回傳每個 namespace 第一個被竄改的位置
+func (s *IdentityServer) VerifyEventLogChains(ctx context.Context, in *identityProto.VerifyEventLogChainsRequest) (*identityProto.VerifyEventLogChainsResponse, error) {
+	verifications, err := s.eventLogChainSvc.VerifyEventLogChains(ctx, in.Namespace)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	result := make([]*identityProto.EventLogChainVerification, 0, len(verifications))
+	for i := range verifications {
+		result = append(result, toEventLogChainVerificationProto(&verifications[i]))
+	}
+
+	return &identityProto.VerifyEventLogChainsResponse{
+		Verifications: result,
+	}, nil
 }
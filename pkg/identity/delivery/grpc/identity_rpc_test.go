@@ -0,0 +1,261 @@
+package grpc
+
+import (
+	"context"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"identity/pkg/identity/usecase"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testNamespace = "test.identity"
+
+type IdentityRPCTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenSvc    domain.TokenUsecase
+	accountSvc  *fakeAccountUsecase
+	sessionSvc  *fakeSessionUsecase
+	server      *IdentityServer
+}
+
+func TestIdentityRPCTestSuite(t *testing.T) {
+	suite.Run(t, new(IdentityRPCTestSuite))
+}
+
+func (suite *IdentityRPCTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	suite.tokenSvc = usecase.NewTokenUsecase(identityRedis.NewTokenRepo(client), identityRedis.NewSessionRepo(client), nil, nil, usecase.TokenOptions{})
+	suite.accountSvc = &fakeAccountUsecase{
+		accounts: map[uint64]*domain.Account{
+			1: {ID: 1, UUID: "owner", Namespace: testNamespace, State: domain.AccountStatusNormal},
+			2: {ID: 2, UUID: "other", Namespace: testNamespace, State: domain.AccountStatusNormal},
+			3: {ID: 3, UUID: "admin", Namespace: testNamespace, State: domain.AccountStatusNormal, IsAdmin: 1},
+		},
+	}
+	suite.sessionSvc = &fakeSessionUsecase{}
+	suite.server = NewIdentityServer(suite.accountSvc, suite.tokenSvc, suite.sessionSvc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+}
+
+func (suite *IdentityRPCTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+// call 用 accountID 的 access token 經過 AuthInterceptor 呼叫 handler
+func (suite *IdentityRPCTestSuite) call(fullMethod string, accountID int64, namespace string, handler func(ctx context.Context) error) error {
+	session, err := suite.tokenSvc.CreateToken(context.Background(), domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: accountID,
+			Namespace: namespace,
+		},
+		Prefix: "web_",
+	})
+	suite.Require().NoError(err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+session.AccessKey))
+	_, err = suite.server.AuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: fullMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, handler(ctx)
+	})
+	return err
+}
+
+func (suite *IdentityRPCTestSuite) TestOTPRequiresOwnerOrAdmin() {
+	clearOTP := func(ctx context.Context) error {
+		_, err := suite.server.ClearOTP(ctx, &identityProto.ClearOTPRequest{Namespace: testNamespace, AccountUuid: "owner"})
+		return err
+	}
+	generateOTPAuth := func(ctx context.Context) error {
+		_, err := suite.server.GenerateOTPAuth(ctx, &identityProto.GenerateOTPAuthRequest{Namespace: testNamespace, AccountId: 1})
+		return err
+	}
+	generateRecoveryCodes := func(ctx context.Context) error {
+		_, err := suite.server.GenerateOTPRecoveryCodes(ctx, &identityProto.GenerateOTPRecoveryCodesRequest{Namespace: testNamespace, AccountId: 1})
+		return err
+	}
+
+	for method, handler := range map[string]func(ctx context.Context) error{
+		"ClearOTP":                 clearOTP,
+		"GenerateOTPAuth":          generateOTPAuth,
+		"GenerateOTPRecoveryCodes": generateRecoveryCodes,
+	} {
+		fullMethod := "/identity.IdentityService/" + method
+
+		err := suite.call(fullMethod, 2, testNamespace, handler)
+		suite.Assert().Equal(codes.PermissionDenied, status.Code(err), method)
+
+		err = suite.call(fullMethod, 3, "other.identity", handler)
+		suite.Assert().Equal(codes.PermissionDenied, status.Code(err), method)
+
+		err = suite.call(fullMethod, 1, testNamespace, handler)
+		suite.Assert().NoError(err, method)
+
+		err = suite.call(fullMethod, 3, testNamespace, handler)
+		suite.Assert().NoError(err, method)
+	}
+
+	suite.Assert().Equal(2, suite.accountSvc.otpCalls["ClearOTP"])
+	suite.Assert().Equal(2, suite.accountSvc.otpCalls["ResetOTPSecret"])
+	suite.Assert().Equal(2, suite.accountSvc.otpCalls["GenerateOTPRecoveryCodes"])
+}
+
+func (suite *IdentityRPCTestSuite) TestUpdatePassword() {
+	updatePassword := func(ctx context.Context) error {
+		_, err := suite.server.UpdateAccountPassword(ctx, &identityProto.UpdateAccountPasswordRequest{AccountId: 1, OldPassword: "old", NewPassword: "new"})
+		return err
+	}
+
+	err := suite.call("/identity.IdentityService/UpdateAccountPassword", 2, testNamespace, updatePassword)
+	suite.Assert().Equal(codes.PermissionDenied, status.Code(err))
+
+	err = suite.call("/identity.IdentityService/UpdateAccountPassword", 1, testNamespace, updatePassword)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(testNamespace, suite.accountSvc.passwordRequest.Namespace)
+	suite.Assert().Equal(uint64(1), suite.accountSvc.passwordRequest.AccountID)
+
+	forceUpdatePassword := func(ctx context.Context) error {
+		_, err := suite.server.ForcedUpdatePassword(ctx, &identityProto.ForcedUpdatePasswordRequest{AccountId: 1, NewPassword: "new"})
+		return err
+	}
+
+	// 帳號本人也不能略過舊密碼
+	err = suite.call("/identity.IdentityService/ForcedUpdatePassword", 1, testNamespace, forceUpdatePassword)
+	suite.Assert().Equal(codes.PermissionDenied, status.Code(err))
+
+	err = suite.call("/identity.IdentityService/ForcedUpdatePassword", 3, testNamespace, forceUpdatePassword)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(uint64(1), suite.accountSvc.forcePasswordRequest.AccountID)
+}
+
+func (suite *IdentityRPCTestSuite) TestSessionsRequireOwnerOrAdmin() {
+	for method, handler := range map[string]func(ctx context.Context) error{
+		"Session": func(ctx context.Context) error {
+			_, err := suite.server.Session(ctx, &identityProto.SessionRequest{Namespace: testNamespace, AccountId: 1, SessionId: "session"})
+			return err
+		},
+		"Sessions": func(ctx context.Context) error {
+			_, err := suite.server.Sessions(ctx, &identityProto.SessionsRequest{Namespace: testNamespace, AccountId: 1})
+			return err
+		},
+		"RevokeSession": func(ctx context.Context) error {
+			_, err := suite.server.RevokeSession(ctx, &identityProto.RevokeSessionRequest{Namespace: testNamespace, AccountId: 1, SessionId: "session"})
+			return err
+		},
+		"RevokeOtherSessions": func(ctx context.Context) error {
+			_, err := suite.server.RevokeOtherSessions(ctx, &identityProto.RevokeOtherSessionsRequest{Namespace: testNamespace, AccountId: 1, CurrentSessionId: "session"})
+			return err
+		},
+	} {
+		fullMethod := "/identity.IdentityService/" + method
+
+		suite.Assert().True(authRequiredMethods[method], method)
+
+		err := suite.call(fullMethod, 2, testNamespace, handler)
+		suite.Assert().Equal(codes.PermissionDenied, status.Code(err), method)
+
+		err = suite.call(fullMethod, 1, testNamespace, handler)
+		suite.Assert().NoError(err, method)
+
+		err = suite.call(fullMethod, 3, testNamespace, handler)
+		suite.Assert().NoError(err, method)
+	}
+
+	suite.Assert().Equal(8, suite.sessionSvc.calls)
+}
+
+// fakeAccountUsecase 只實作 handler 用到的方法, 其他方法呼叫時會 panic
+type fakeAccountUsecase struct {
+	domain.AccountUsecase
+	accounts             map[uint64]*domain.Account
+	otpCalls             map[string]int
+	passwordRequest      domain.UpdateAccountPasswordRequest
+	forcePasswordRequest domain.ForceUpdateAccountPasswordRequest
+}
+
+func (uc *fakeAccountUsecase) record(method string) {
+	if uc.otpCalls == nil {
+		uc.otpCalls = map[string]int{}
+	}
+	uc.otpCalls[method]++
+}
+
+func (uc *fakeAccountUsecase) Account(ctx context.Context, namespace string, accountID uint64) (*domain.Account, error) {
+	account, ok := uc.accounts[accountID]
+	if !ok || account.Namespace != namespace {
+		return nil, domain.ErrNotFound
+	}
+	return account, nil
+}
+
+func (uc *fakeAccountUsecase) AccountByUUID(ctx context.Context, namespace string, uuid string) (*domain.Account, error) {
+	for _, account := range uc.accounts {
+		if account.UUID == uuid && account.Namespace == namespace {
+			return account, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (uc *fakeAccountUsecase) ClearOTP(ctx context.Context, request domain.ClearOTPRequest) error {
+	uc.record("ClearOTP")
+	return nil
+}
+
+func (uc *fakeAccountUsecase) ResetOTPSecret(ctx context.Context, request domain.ResetOTPSecretRequest) (*domain.OTPAuth, error) {
+	uc.record("ResetOTPSecret")
+	return &domain.OTPAuth{}, nil
+}
+
+func (uc *fakeAccountUsecase) GenerateOTPRecoveryCodes(ctx context.Context, request domain.GenerateOTPRecoveryCodesRequest) ([]string, error) {
+	uc.record("GenerateOTPRecoveryCodes")
+	return nil, nil
+}
+
+func (uc *fakeAccountUsecase) UpdateAccountPassword(ctx context.Context, request domain.UpdateAccountPasswordRequest) error {
+	uc.passwordRequest = request
+	return nil
+}
+
+func (uc *fakeAccountUsecase) ForceUpdateAccountPassword(ctx context.Context, request domain.ForceUpdateAccountPasswordRequest) error {
+	uc.forcePasswordRequest = request
+	return nil
+}
+
+type fakeSessionUsecase struct {
+	calls int
+}
+
+func (uc *fakeSessionUsecase) Session(ctx context.Context, namespace string, accountID int64, sessionID string) (*domain.Session, error) {
+	uc.calls++
+	return &domain.Session{ID: sessionID, Namespace: namespace, AccountID: accountID}, nil
+}
+
+func (uc *fakeSessionUsecase) Sessions(ctx context.Context, namespace string, accountID int64) ([]domain.Session, error) {
+	uc.calls++
+	return nil, nil
+}
+
+func (uc *fakeSessionUsecase) RevokeSession(ctx context.Context, request domain.RevokeSessionRequest) error {
+	uc.calls++
+	return nil
+}
+
+func (uc *fakeSessionUsecase) RevokeOtherSessions(ctx context.Context, request domain.RevokeOtherSessionsRequest) error {
+	uc.calls++
+	return nil
+}
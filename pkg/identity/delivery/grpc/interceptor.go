@@ -0,0 +1,218 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"identity/pkg/domain"
+	"path"
+	"runtime/debug"
+	"strings"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// authRequiredMethods 這些 RPC 一定要帶 authorization, usecase 需要依呼叫端的身分判斷能不能操作,
+// 例如模擬登入的 token 不能修改密碼, 沒有帶 token 就會繞過這個限制;
+// 操作單一帳號的 RPC 還會在 handler 用 authorizeAccount 確認呼叫端是帳號本人或是 admin
+var authRequiredMethods = map[string]bool{
+	"UpdateAccountPassword":    true,
+	"ForcedUpdatePassword":     true,
+	"ClearOTP":                 true,
+	"GenerateOTPAuth":          true,
+	"GenerateOTPRecoveryCodes": true,
+	"Session":                  true,
+	"Sessions":                 true,
+	"RevokeSession":            true,
+	"RevokeOtherSessions":      true,
+	"ImpersonateAccount":       true,
+	"EndImpersonation":         true,
+	"CreateAPIKey":             true,
+}
+
+// AuthInterceptor 呼叫端在 metadata 帶了 authorization 時, 把 token 的 claims 放進 context,
+// usecase 可以用 domain.FromContext 判斷呼叫的身分, 例如模擬登入的 token 不能修改密碼
+// authRequiredMethods 以外沒有帶 authorization 的呼叫維持原本的行為
+func (s *IdentityServer) AuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// RecoveryInterceptor 把 handler 的 panic 轉成 Internal 錯誤, 避免一個 request 讓整個 process 結束
+func RecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(ctx, info.FullMethod, r)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// StreamRecoveryInterceptor 與 RecoveryInterceptor 相同, 給 server-streaming 的 RPC 使用
+func StreamRecoveryInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(ss.Context(), info.FullMethod, r)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func logPanic(ctx context.Context, method string, r interface{}) {
+	log.FromContext(ctx).
+		Str("method", method).
+		Any("panic", r).
+		Str("stack", string(debug.Stack())).
+		Error("grpc: handler panic")
+}
+
+// StreamAuthInterceptor 與 AuthInterceptor 相同, 給 Watch 這類 server-streaming 的 RPC 使用
+func (s *IdentityServer) StreamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate 同時把呼叫端的 IP 與 token 代表的操作者放進 context, 寫入 event log 時使用
+func (s *IdentityServer) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	audit := domain.Audit{ClientIP: s.clientIP(ctx)}
+	ctx = domain.NewAuditContext(ctx, audit)
+
+	var values []string
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values = md.Get("authorization")
+	}
+
+	if len(values) == 0 {
+		if authRequiredMethods[path.Base(fullMethod)] {
+			return nil, toStatusError(domain.ErrUnauthorized)
+		}
+		return ctx, nil
+	}
+
+	tokenKey := strings.TrimSpace(values[0])
+	if len(tokenKey) > 7 && strings.EqualFold(tokenKey[:7], "bearer ") {
+		tokenKey = strings.TrimSpace(tokenKey[7:])
+	}
+
+	token, err := s.tokenSvc.Token(ctx, tokenKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			err = domain.ErrInvalidToken
+		}
+		return nil, toStatusError(err)
+	}
+
+	claims := domain.NewClaims(token)
+	audit.Actor = claims.Actor()
+	ctx = domain.NewAuditContext(ctx, audit)
+	return domain.NewContext(ctx, claims), nil
+}
+
+// clientIP 只有連線來自信任的 proxy (例如內建的 gateway) 時才採用 x-forwarded-for, 否則使用連線的來源位址
+func (s *IdentityServer) clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	var forwardedFor []string
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		forwardedFor = md.Get("x-forwarded-for")
+	}
+
+	return s.trustedProxies.ClientIP(p.Addr.String(), forwardedFor)
+}
+
+// callerAccountID 回傳 token 代表的帳號, token 的 namespace 必須與操作的 namespace 相同
+func callerAccountID(ctx context.Context, namespace string) (uint64, error) {
+	claims, ok := domain.FromContext(ctx)
+	if !ok {
+		return 0, domain.ErrUnauthorized
+	}
+
+	accountID, _ := claims[domain.ClaimAccountID].(int64)
+	if accountID <= 0 {
+		return 0, domain.ErrUnauthorized
+	}
+
+	if ns, _ := claims[domain.ClaimNamespace].(string); ns != namespace {
+		return 0, domain.ErrForbidden
+	}
+
+	return uint64(accountID), nil
+}
+
+// callerNamespace 回傳 token 的 namespace, 給 request 沒有帶 namespace 的 RPC 使用
+func callerNamespace(ctx context.Context) string {
+	claims, _ := domain.FromContext(ctx)
+	namespace, _ := claims[domain.ClaimNamespace].(string)
+	return namespace
+}
+
+// authorizeAccount 只有帳號本人或是同一個 namespace 的 admin 可以操作帳號, 例如重設 OTP 或是撤銷 session
+func (s *IdentityServer) authorizeAccount(ctx context.Context, namespace string, accountID uint64) error {
+	callerID, err := callerAccountID(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	if callerID == accountID {
+		return nil
+	}
+
+	return s.requireAdmin(ctx, namespace, callerID)
+}
+
+// authorizeAdmin 只有同一個 namespace 的 admin 可以呼叫, 例如不需要舊密碼就能修改密碼的 ForcedUpdatePassword
+func (s *IdentityServer) authorizeAdmin(ctx context.Context, namespace string) error {
+	callerID, err := callerAccountID(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	return s.requireAdmin(ctx, namespace, callerID)
+}
+
+func (s *IdentityServer) requireAdmin(ctx context.Context, namespace string, callerID uint64) error {
+	caller, err := s.accountSvc.Account(ctx, namespace, callerID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.ErrForbidden
+		}
+		return err
+	}
+
+	if caller.IsAdmin != 1 || caller.State != domain.AccountStatusNormal {
+		return domain.ErrForbidden
+	}
+
+	return nil
+}
+
+// authServerStream 讓 handler 從 Context() 拿到帶有 claims 的 context
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *authServerStream) Context() context.Context {
+	return ss.ctx
+}
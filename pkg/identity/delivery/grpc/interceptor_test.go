@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+	"identity/pkg/domain"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"identity/pkg/identity/usecase"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type InterceptorTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenSvc    domain.TokenUsecase
+	server      *IdentityServer
+}
+
+func TestInterceptorTestSuite(t *testing.T) {
+	suite.Run(t, new(InterceptorTestSuite))
+}
+
+func (suite *InterceptorTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	suite.tokenSvc = usecase.NewTokenUsecase(identityRedis.NewTokenRepo(client), identityRedis.NewSessionRepo(client), nil, nil, usecase.TokenOptions{})
+	suite.server = NewIdentityServer(nil, suite.tokenSvc, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+}
+
+func (suite *InterceptorTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+// intercept 用帶著 authorization 的 context 呼叫 AuthInterceptor, 回傳 handler 收到的 claims
+func (suite *InterceptorTestSuite) intercept(fullMethod string, tokenKey string) (domain.Claims, error) {
+	ctx := context.Background()
+	if tokenKey != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tokenKey))
+	}
+
+	var claims domain.Claims
+	_, err := suite.server.AuthInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: fullMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		claims, _ = domain.FromContext(ctx)
+		return nil, nil
+	})
+
+	return claims, err
+}
+
+func (suite *InterceptorTestSuite) TestRefreshTokenIsRejected() {
+	ctx := context.Background()
+
+	session, err := suite.tokenSvc.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: "test.identity",
+		},
+		Prefix: "web_",
+	})
+	suite.Require().NoError(err)
+
+	claims, err := suite.intercept("/identity.IdentityService/Token", session.AccessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), claims[domain.ClaimAccountID])
+
+	_, err = suite.intercept("/identity.IdentityService/Token", session.RefreshKey)
+	suite.Require().Error(err)
+	suite.Assert().Equal(codes.Unauthenticated, status.Code(err))
+
+	_, err = suite.intercept("/identity.IdentityService/ClearOTP", "")
+	suite.Assert().Equal(codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"errors"
+	"html/template"
+	"identity/pkg/domain"
+	"net/http"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+// deviceTemplate 是 device authorization grant 的 verification page
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Device sign in</title></head>
+<body>
+{{if .Done}}
+<h1>{{if .Approved}}Device connected to {{.ClientName}}{{else}}Request denied{{end}}</h1>
+<p>You can close this window and return to your device.</p>
+{{else}}
+<h1>Sign in to a device</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/device">
+<label>Code <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off"></label>
+<label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+{{if .OTPRequired}}<label>OTP code <input type="text" name="otp_code" autocomplete="one-time-code"></label>{{end}}
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePage struct {
+	UserCode    string
+	Username    string
+	Error       string
+	OTPRequired bool
+	Done        bool
+	Approved    bool
+	ClientName  string
+}
+
+// DeviceAuthorization 是 device authorization endpoint (RFC 8628 3.1)
+func (h *IdentityHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, domain.ErrOAuthInvalidRequest, false)
+		return
+	}
+
+	request := domain.DeviceAuthorizationRequest{
+		Scope: r.PostForm.Get("scope"),
+	}
+	var basicAuth bool
+	request.ClientID, request.ClientSecret, basicAuth = clientCredentials(r)
+
+	resp, err := h.oauthSvc.DeviceAuthorization(r.Context(), request)
+	if err != nil {
+		logger.Err(err).Str("client_id", request.ClientID).Warn("http: device authorization failed")
+		writeOAuthError(w, err, basicAuth)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// VerifyDevice 是 device authorization grant 的 verification page, 使用者輸入 user code 並登入之後同意或拒絕
+func (h *IdentityHandler) VerifyDevice(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "request is invalid", http.StatusBadRequest)
+		return
+	}
+
+	page := devicePage{
+		UserCode: r.Form.Get("user_code"),
+		Username: r.PostForm.Get("username"),
+	}
+
+	if r.Method == http.MethodGet {
+		renderDevice(w, http.StatusOK, page)
+		return
+	}
+
+	request := domain.VerifyDeviceRequest{
+		UserCode: page.UserCode,
+		Approved: r.PostForm.Get("action") == "approve",
+		OTPCode:  r.PostForm.Get("otp_code"),
+		LoginInfo: domain.LoginInfo{
+			DeviceType: domain.DeviceTypeWeb,
+			LoginType:  domain.LoginTypeUsername,
+			Username:   page.Username,
+			Password:   r.PostForm.Get("password"),
+			ClientIP:   h.clientIP(r),
+		},
+	}
+
+	client, err := h.oauthSvc.VerifyDevice(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrOTPRequired):
+			page.OTPRequired = true
+			renderDevice(w, http.StatusOK, page)
+		case errors.Is(err, domain.ErrNotFound), errors.Is(err, domain.ErrOAuthExpiredToken):
+			page.Error = "the code is invalid or expired"
+			renderDevice(w, http.StatusBadRequest, page)
+		case errors.Is(err, domain.ErrUsernameOrPasswordIncorrect),
+			errors.Is(err, domain.ErrAccountLocked),
+			errors.Is(err, domain.ErrAccountDisabled),
+			errors.Is(err, domain.ErrOTPCodeIncorrect),
+			errors.Is(err, domain.ErrOAuthAccessDenied),
+			errors.Is(err, domain.ErrInvalidInput):
+			page.Error = errorMessage(err)
+			page.OTPRequired = request.OTPCode != ""
+			renderDevice(w, http.StatusUnauthorized, page)
+		default:
+			logger.Err(err).Warn("http: verify device failed")
+			page.Error = "internal error"
+			renderDevice(w, http.StatusInternalServerError, page)
+		}
+		return
+	}
+
+	page.Done = true
+	page.Approved = request.Approved
+	page.ClientName = client.Name
+	renderDevice(w, http.StatusOK, page)
+}
+
+func renderDevice(w http.ResponseWriter, status int, page devicePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	_ = deviceTemplate.Execute(w, page)
+}
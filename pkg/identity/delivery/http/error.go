@@ -0,0 +1,49 @@
+package http
+
+import (
+	"errors"
+	"identity/pkg/domain"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+type errorResponse struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// writeError 把 domain.AppError 轉成對應的 http status code
+func writeError(w http.ResponseWriter, err error) {
+	var appErr *domain.AppError
+	if !errors.As(err, &appErr) {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Code: "INTERNAL", Message: "internal error"})
+		return
+	}
+
+	writeJSON(w, httpStatus(appErr.Status), errorResponse{Code: appErr.Code, Message: appErr.Message, Details: appErr.Details})
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,242 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// GatewayPathPrefix 每一個 RPC 對應到 POST /v1/identity/{method}
+	GatewayPathPrefix  = "/v1/identity/"
+	maxRequestBodySize = 1 << 20
+	// errorDomain 與 delivery/grpc 的 ErrorDomain 相同
+	errorDomain = "identity"
+)
+
+// gatewayMethods 是 gateway 開放的 RPC, 只包含已經實作的 RPC, 其他 RPC 回傳 501
+var gatewayMethods = map[string]bool{
+	"CreateAccount":            true,
+	"UpdateAccountPassword":    true,
+	"ForcedUpdatePassword":     true,
+	"ClearOTP":                 true,
+	"GenerateOTPAuth":          true,
+	"VerifyOTP":                true,
+	"GenerateOTPRecoveryCodes": true,
+	"CreateToken":              true,
+	"CreateRefreshToken":       true,
+	"Token":                    true,
+	"DeleteTokenByAccountID":   true,
+	"RenewToken":               true,
+	"RefreshToken":             true,
+	"BindHashToken":            true,
+	"DeleteHash":               true,
+	"Session":                  true,
+	"Sessions":                 true,
+	"RevokeSession":            true,
+	"RevokeOtherSessions":      true,
+	"CreateOAuthClient":        true,
+	"OAuthClient":              true,
+	"OAuthClients":             true,
+	"DeleteOAuthClient":        true,
+	"ApproveDevice":            true,
+	"ImpersonateAccount":       true,
+	"EndImpersonation":         true,
+	"CreateAPIKey":             true,
+	"APIKeys":                  true,
+	"RevokeAPIKey":             true,
+	"CreateWebhook":            true,
+	"Webhooks":                 true,
+	"DeleteWebhook":            true,
+	"WebhookDeadLetters":       true,
+	"RedeliverWebhook":         true,
+	"EventLogs":                true,
+	"CountEventLogs":           true,
+	"LoginLogs":                true,
+	"CountLoginLogs":           true,
+	"LoginSummary":             true,
+	"VerifyEventLogChains":     true,
+}
+
+// Gateway 把 IdentityService 的 unary RPC 轉成 HTTP/JSON, 給無法使用 grpc 的服務呼叫
+// request 會透過 conn 轉送到 grpc server, 所以與 grpc 走一樣的驗證與錯誤處理, 每一個 request 都必須帶 bearer token
+type Gateway struct {
+	conn           grpc.ClientConnInterface
+	service        protoreflect.ServiceDescriptor
+	methods        map[string]protoreflect.MethodDescriptor
+	trustedProxies domain.TrustedProxies
+}
+
+// NewGateway conn 通常是連到本機 grpc_bind 的 *grpc.ClientConn, 只有 trustedProxies 轉送的 X-Forwarded-For 會被保留
+func NewGateway(conn grpc.ClientConnInterface, trustedProxies domain.TrustedProxies) *Gateway {
+	service := identityProto.File_pkg_identity_proto_identity_proto.Services().ByName("IdentityService")
+
+	methods := map[string]protoreflect.MethodDescriptor{}
+	for i := 0; i < service.Methods().Len(); i++ {
+		method := service.Methods().Get(i)
+		if method.IsStreamingClient() || method.IsStreamingServer() || !gatewayMethods[string(method.Name())] {
+			continue
+		}
+		methods[string(method.Name())] = method
+	}
+
+	return &Gateway{
+		conn:           conn,
+		service:        service,
+		methods:        methods,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// Routes 回傳註冊好所有 RPC 與 OpenAPI 文件的 http.Handler
+func (g *Gateway) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(GatewayPathPrefix, g.Invoke)
+	mux.HandleFunc("/openapi.json", g.OpenAPI)
+	return mux
+}
+
+// Invoke request body 與 response 都是 proto 的 JSON 格式 (protojson), 欄位名稱為 lowerCamelCase
+func (g *Gateway) Invoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, GatewayPathPrefix)
+	method, ok := g.methods[name]
+	if !ok {
+		if g.service.Methods().ByName(protoreflect.Name(name)) != nil {
+			writeError(w, domain.ErrNotImplemented)
+			return
+		}
+		writeError(w, domain.ErrNotFound)
+		return
+	}
+
+	// token 由 grpc server 的 AuthInterceptor 驗證
+	if bearerToken(r) == "" {
+		w.Header().Set("WWW-Authenticate", bearerChallenge("", true))
+		writeError(w, domain.ErrUnauthorized)
+		return
+	}
+
+	reqType, err := protoregistry.GlobalTypes.FindMessageByName(method.Input().FullName())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	respType, err := protoregistry.GlobalTypes.FindMessageByName(method.Output().FullName())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err != nil {
+		writeError(w, fmt.Errorf("http: read request body failed: %v. %w", err, domain.ErrInvalidInput))
+		return
+	}
+
+	req := reqType.New().Interface()
+	if len(body) > 0 {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+		if err != nil {
+			writeError(w, fmt.Errorf("http: decode request body failed: %v. %w", err, domain.ErrInvalidInput))
+			return
+		}
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", g.service.FullName(), method.Name())
+	resp := respType.New().Interface()
+
+	err = g.conn.Invoke(g.forwardMetadata(r), fullMethod, req, resp)
+	if err != nil {
+		log.FromContext(r.Context()).Err(err).Debugf("http: gateway invoke %s failed", fullMethod)
+		writeStatusError(w, err)
+		return
+	}
+
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(resp)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// forwardMetadata 把 Authorization header 與 client 的 IP 轉成 grpc metadata,
+// 連線的來源位址一定附加在 x-forwarded-for 的最右邊, grpc server 會從右邊開始判斷
+func (g *Gateway) forwardMetadata(r *http.Request) context.Context {
+	md := metadata.MD{}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		md.Set("authorization", authorization)
+	}
+
+	forwardedFor := g.trustedProxies.ForwardedFor(r.RemoteAddr, r.Header.Get("X-Forwarded-For"))
+	if forwardedFor != "" {
+		md.Set("x-forwarded-for", forwardedFor)
+	}
+
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// writeStatusError 把 grpc status 轉回與 writeError 相同格式的 JSON
+func writeStatusError(w http.ResponseWriter, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		writeError(w, err)
+		return
+	}
+
+	resp := errorResponse{Code: codeName(st.Code()), Message: st.Message()}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+
+		resp.Code = info.Reason
+		if len(info.Metadata) > 0 {
+			resp.Details = make(map[string]interface{}, len(info.Metadata))
+			for k, v := range info.Metadata {
+				resp.Details[k] = v
+			}
+		}
+	}
+
+	writeJSON(w, httpStatus(st.Code()), resp)
+}
+
+// codeName 把 grpc code 轉成與 AppError.Code 相同的格式, 例如 DeadlineExceeded 轉成 DEADLINE_EXCEEDED
+func codeName(code codes.Code) string {
+	var sb strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
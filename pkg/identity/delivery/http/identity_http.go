@@ -0,0 +1,109 @@
+package http
+
+import (
+	"encoding/json"
+	"identity/pkg/domain"
+	"net/http"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// IdentityHandler 提供不適合走 grpc 的 http endpoint, 例如 JWKS 與 OAuth 2.0
+type IdentityHandler struct {
+	keySvc   domain.KeyUsecase
+	oauthSvc domain.OAuthUsecase
+	tokenSvc domain.TokenUsecase
+	scimSvc  domain.SCIMUsecase
+
+	trustedProxies domain.TrustedProxies
+}
+
+// NewIdentityHandler generate a new identity http handler, keySvc 為 nil 時代表沒有啟用 JWT
+func NewIdentityHandler(keySvc domain.KeyUsecase, oauthSvc domain.OAuthUsecase, tokenSvc domain.TokenUsecase, scimSvc domain.SCIMUsecase, trustedProxies domain.TrustedProxies) *IdentityHandler {
+	return &IdentityHandler{
+		keySvc:         keySvc,
+		oauthSvc:       oauthSvc,
+		tokenSvc:       tokenSvc,
+		scimSvc:        scimSvc,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// Routes 回傳註冊好所有 endpoint 的 http.Handler
+func (h *IdentityHandler) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jwks.json", h.JWKS)
+	mux.HandleFunc("/.well-known/openid-configuration", h.OpenIDConfiguration)
+	mux.HandleFunc("/authorize", h.Authorize)
+	mux.HandleFunc("/token", h.Token)
+	mux.HandleFunc("/userinfo", h.UserInfo)
+	mux.HandleFunc("/introspect", h.Introspect)
+	mux.HandleFunc("/revoke", h.Revoke)
+	mux.HandleFunc("/device_authorization", h.DeviceAuthorization)
+	mux.HandleFunc("/device", h.VerifyDevice)
+	mux.HandleFunc(scimPathPrefix, h.SCIM)
+	return h.auditContext(mux)
+}
+
+// auditContext 與 grpc 的 AuthInterceptor 相同, 把呼叫端的 IP 與操作者放進 context, 寫入 event log 時使用
+// 操作者優先使用 bearer token 代表的帳號, 其次是 OAuth client 的 client_id;
+// client 的認證在 usecase 裡面進行, 認證失敗時不會寫入 event log, 所以這裡不重複認證
+func (h *IdentityHandler) auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		audit := domain.Audit{ClientIP: h.clientIP(r)}
+
+		if tokenKey := bearerToken(r); tokenKey != "" && h.tokenSvc != nil {
+			token, err := h.tokenSvc.Token(ctx, tokenKey)
+			if err == nil {
+				audit.Actor = domain.NewClaims(token).Actor()
+			}
+		} else if _, _, ok := r.BasicAuth(); ok {
+			audit.Actor, _, _ = clientCredentials(r)
+		} else if r.ParseForm() == nil {
+			audit.Actor = r.Form.Get("client_id")
+		}
+
+		next.ServeHTTP(w, r.WithContext(domain.NewAuditContext(ctx, audit)))
+	})
+}
+
+// JWKS 發佈所有可以用來驗證 JWT 的公鑰, 包含還沒有生效與已經被取代但還在保留期間的金鑰
+func (h *IdentityHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{},
+	}
+
+	if h.keySvc != nil {
+		keys, err := h.keySvc.PublicKeys(r.Context())
+		if err != nil {
+			log.FromContext(r.Context()).Err(err).Error("http: get public keys failed")
+			writeError(w, err)
+			return
+		}
+
+		for i := range keys {
+			jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+				Key:       keys[i].PublicKey(),
+				KeyID:     keys[i].ID,
+				Algorithm: keys[i].Algorithm,
+				Use:       "sig",
+			})
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, jwks)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
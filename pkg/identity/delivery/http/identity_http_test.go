@@ -0,0 +1,84 @@
+package http
+
+import (
+	"context"
+	"identity/pkg/domain"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"identity/pkg/identity/usecase"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdentityHandlerTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenSvc    domain.TokenUsecase
+	handler     *IdentityHandler
+}
+
+func TestIdentityHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(IdentityHandlerTestSuite))
+}
+
+func (suite *IdentityHandlerTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	suite.tokenSvc = usecase.NewTokenUsecase(identityRedis.NewTokenRepo(client), identityRedis.NewSessionRepo(client), nil, nil, usecase.TokenOptions{})
+
+	trustedProxies, err := domain.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	suite.Require().NoError(err)
+	suite.handler = NewIdentityHandler(nil, nil, suite.tokenSvc, nil, trustedProxies)
+}
+
+func (suite *IdentityHandlerTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+// audit 用 auditContext 處理 request, 回傳 handler 收到的呼叫端資訊
+func (suite *IdentityHandlerTestSuite) audit(r *http.Request) domain.Audit {
+	var audit domain.Audit
+	suite.handler.auditContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit = domain.AuditFromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return audit
+}
+
+func (suite *IdentityHandlerTestSuite) TestAuditContext() {
+	session, err := suite.tokenSvc.CreateToken(context.Background(), domain.CreateTokenRequest{
+		Token: domain.Token{AccountID: 1, Namespace: "test.identity", Username: "angela"},
+	})
+	suite.Require().NoError(err)
+
+	// bearer token 代表的帳號, IP 來自信任的 proxy 附加的 X-Forwarded-For
+	r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	r.RemoteAddr = "10.0.0.2:52000"
+	r.Header.Set("X-Forwarded-For", "182.48.113.104")
+	r.Header.Set("Authorization", "Bearer "+session.AccessKey)
+	suite.Equal(domain.Audit{Actor: "angela", ClientIP: "182.48.113.104"}, suite.audit(r))
+
+	// HTTP Basic 的 client
+	r = httptest.NewRequest(http.MethodPost, "/token", nil)
+	r.RemoteAddr = "182.48.113.104:52000"
+	r.SetBasicAuth("orders", "secret")
+	suite.Equal(domain.Audit{Actor: "orders", ClientIP: "182.48.113.104"}, suite.audit(r))
+
+	// form 帶的 client_id, 不是信任的 proxy 時忽略 X-Forwarded-For
+	r = httptest.NewRequest(http.MethodPost, "/device_authorization", strings.NewReader(url.Values{"client_id": {"tv"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	r.RemoteAddr = "182.48.113.104:52000"
+	suite.Equal(domain.Audit{Actor: "tv", ClientIP: "182.48.113.104"}, suite.audit(r))
+}
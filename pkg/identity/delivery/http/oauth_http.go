@@ -0,0 +1,369 @@
+package http
+
+import (
+	"errors"
+	"html/template"
+	"identity/pkg/domain"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+// loginTemplate 是 /authorize 的登入頁面, OAuth 的參數放在 hidden field 裡面
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+{{if not .Request.RedirectURIDefaulted}}<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">{{end}}
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<label>Username <input type="text" name="username" value="{{.Request.LoginInfo.Username}}" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+{{if .OTPRequired}}<label>OTP code <input type="text" name="otp_code" autocomplete="one-time-code"></label>{{end}}
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+type loginPage struct {
+	ClientName  string
+	Request     domain.AuthorizeRequest
+	Error       string
+	OTPRequired bool
+}
+
+// Authorize 是 authorization code flow 的 /authorize endpoint (RFC 6749 4.1.1)
+// GET 顯示登入頁面, POST 驗證帳號密碼後帶著 code redirect 回 client
+func (h *IdentityHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "request is invalid", http.StatusBadRequest)
+		return
+	}
+
+	request := domain.AuthorizeRequest{
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		ResponseType:        r.Form.Get("response_type"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+		OTPCode:             r.PostForm.Get("otp_code"),
+		LoginInfo: domain.LoginInfo{
+			DeviceType: domain.DeviceTypeWeb,
+			LoginType:  domain.LoginTypeUsername,
+			Username:   r.PostForm.Get("username"),
+			Password:   r.PostForm.Get("password"),
+			ClientIP:   h.clientIP(r),
+		},
+	}
+
+	// client 或 redirect_uri 不正確時不可以 redirect, 直接顯示錯誤
+	client, err := h.oauthSvc.ValidateAuthorizeRequest(r.Context(), &request)
+	if err != nil {
+		logger.Err(err).Str("client_id", request.ClientID).Warn("http: invalid authorize request")
+		http.Error(w, oauthErrorDescription(err), http.StatusBadRequest)
+		return
+	}
+
+	page := loginPage{
+		ClientName: client.Name,
+		Request:    request,
+	}
+
+	if r.Method == http.MethodGet {
+		renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	code, err := h.oauthSvc.Authorize(r.Context(), request)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrOTPRequired):
+			page.OTPRequired = true
+			renderLogin(w, http.StatusOK, page)
+		case errors.Is(err, domain.ErrUsernameOrPasswordIncorrect),
+			errors.Is(err, domain.ErrAccountLocked),
+			errors.Is(err, domain.ErrAccountDisabled),
+			errors.Is(err, domain.ErrOTPCodeIncorrect),
+			errors.Is(err, domain.ErrInvalidInput):
+			page.Error = errorMessage(err)
+			page.OTPRequired = request.OTPCode != ""
+			renderLogin(w, http.StatusUnauthorized, page)
+		default:
+			logger.Err(err).Str("client_id", request.ClientID).Warn("http: authorize failed")
+			redirectWithParams(w, r, request.RedirectURI, url.Values{
+				"error":             {oauthErrorCode(err)},
+				"error_description": {oauthErrorDescription(err)},
+				"state":             {request.State},
+			})
+		}
+		return
+	}
+
+	redirectWithParams(w, r, request.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+	})
+}
+
+// Token 是 /token endpoint (RFC 6749 3.2), client 可以用 HTTP Basic 或是 form 帶 client_id 與 client_secret
+func (h *IdentityHandler) Token(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, domain.ErrOAuthInvalidRequest, false)
+		return
+	}
+
+	request := domain.OAuthTokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		Scope:              r.PostForm.Get("scope"),
+		ClientIP:           h.clientIP(r),
+		UserAgent:          r.UserAgent(),
+	}
+
+	var basicAuth bool
+	request.ClientID, request.ClientSecret, basicAuth = clientCredentials(r)
+
+	resp, err := h.oauthSvc.Token(r.Context(), request)
+	if err != nil {
+		logger.Err(err).Str("client_id", request.ClientID).Str("grant_type", request.GrantType).Warn("http: issue oauth token failed")
+		writeOAuthError(w, err, basicAuth)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Introspect 是 token introspection endpoint (RFC 7662), 給 API gateway 驗證 token 使用
+func (h *IdentityHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, domain.ErrOAuthInvalidRequest, false)
+		return
+	}
+
+	request := domain.IntrospectTokenRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}
+	var basicAuth bool
+	request.ClientID, request.ClientSecret, basicAuth = clientCredentials(r)
+
+	resp, err := h.oauthSvc.IntrospectToken(r.Context(), request)
+	if err != nil {
+		logger.Err(err).Str("client_id", request.ClientID).Warn("http: introspect token failed")
+		writeOAuthError(w, err, basicAuth)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Revoke 是 token revocation endpoint (RFC 7009), token 無效時一樣回傳 200
+func (h *IdentityHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeOAuthError(w, domain.ErrOAuthInvalidRequest, false)
+		return
+	}
+
+	request := domain.RevokeTokenRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}
+	var basicAuth bool
+	request.ClientID, request.ClientSecret, basicAuth = clientCredentials(r)
+
+	err = h.oauthSvc.RevokeToken(r.Context(), request)
+	if err != nil {
+		logger.Err(err).Str("client_id", request.ClientID).Warn("http: revoke token failed")
+		writeOAuthError(w, err, basicAuth)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// clientCredentials 取得 client 的認證資訊, 優先使用 HTTP Basic, 其次是 form 的 client_id 與 client_secret
+func clientCredentials(r *http.Request) (string, string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+	}
+
+	// RFC 6749 2.3.1 client_id 與 client_secret 需要先做 form url encode
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		clientID = username
+	}
+
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		clientSecret = password
+	}
+
+	return clientID, clientSecret, true
+}
+
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(w http.ResponseWriter, err error, basicAuth bool) {
+	code := oauthErrorCode(err)
+
+	status := http.StatusBadRequest
+	switch code {
+	case "invalid_client":
+		status = http.StatusUnauthorized
+		if basicAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="identity"`)
+		}
+	case "server_error":
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, oauthErrorResponse{
+		Error:            code,
+		ErrorDescription: oauthErrorDescription(err),
+	})
+}
+
+// oauthErrorCode 把 domain error 轉成 RFC 6749 的 error code
+func oauthErrorCode(err error) string {
+	oauthErrors := []*domain.AppError{
+		domain.ErrOAuthInvalidRequest,
+		domain.ErrOAuthInvalidClient,
+		domain.ErrOAuthInvalidGrant,
+		domain.ErrOAuthUnauthorizedClient,
+		domain.ErrOAuthUnsupportedGrantType,
+		domain.ErrOAuthInvalidScope,
+		domain.ErrOAuthAccessDenied,
+		domain.ErrOAuthUnsupportedResponseType,
+		domain.ErrOAuthAuthorizationPending,
+		domain.ErrOAuthSlowDown,
+		domain.ErrOAuthExpiredToken,
+	}
+
+	for _, oauthErr := range oauthErrors {
+		if errors.Is(err, oauthErr) {
+			return strings.ToLower(oauthErr.Code)
+		}
+	}
+
+	if errors.Is(err, domain.ErrInvalidInput) {
+		return "invalid_request"
+	}
+
+	var appErr *domain.AppError
+	if errors.As(err, &appErr) {
+		return "access_denied"
+	}
+
+	return "server_error"
+}
+
+func oauthErrorDescription(err error) string {
+	var appErr *domain.AppError
+	if errors.As(err, &appErr) {
+		return appErr.Message
+	}
+	return "internal error"
+}
+
+func errorMessage(err error) string {
+	if errors.Is(err, domain.ErrInvalidInput) {
+		return "username and password are required"
+	}
+	return oauthErrorDescription(err)
+}
+
+func renderLogin(w http.ResponseWriter, status int, page loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	_ = loginTemplate.Execute(w, page)
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "redirect_uri is invalid", http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			query.Set(k, v[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// clientIP 只有連線來自信任的 proxy 時才採用 X-Forwarded-For, 與 grpc server 使用相同的判斷
+func (h *IdentityHandler) clientIP(r *http.Request) string {
+	return h.trustedProxies.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For"))
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"net/http"
+	"strings"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+// OpenIDConfiguration 是 OpenID Connect Discovery 的 /.well-known/openid-configuration
+func (h *IdentityHandler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	configuration, err := h.oauthSvc.OpenIDConfiguration(r.Context())
+	if err != nil {
+		if !errors.Is(err, domain.ErrNotFound) {
+			log.FromContext(r.Context()).Err(err).Error("http: get openid configuration failed")
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, configuration)
+}
+
+// UserInfo 是 OpenID Connect 的 userinfo endpoint, accessToken 放在 Authorization header 或是 form 的 access_token
+func (h *IdentityHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	accessToken := bearerToken(r)
+	if accessToken == "" && r.Method == http.MethodPost {
+		accessToken = r.PostFormValue("access_token")
+	}
+
+	userInfo, err := h.oauthSvc.UserInfo(r.Context(), accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_token", accessToken == ""))
+			w.WriteHeader(http.StatusUnauthorized)
+		case errors.Is(err, domain.ErrOAuthInsufficientScope):
+			w.Header().Set("WWW-Authenticate", bearerChallenge("insufficient_scope", false))
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			log.FromContext(r.Context()).Err(err).Error("http: get userinfo failed")
+			writeError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userInfo)
+}
+
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// bearerChallenge 產生 RFC 6750 3 的 WWW-Authenticate, 沒有帶 token 時不回傳 error code
+func bearerChallenge(code string, missing bool) string {
+	if missing {
+		return `Bearer realm="identity"`
+	}
+	return fmt.Sprintf(`Bearer realm="identity", error="%s"`, code)
+}
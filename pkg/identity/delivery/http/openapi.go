@@ -0,0 +1,176 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// OpenAPI 發佈 gateway 的 OpenAPI v3 文件
+func (g *Gateway) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g.OpenAPIDocument())
+}
+
+// OpenAPIDocument 從 identity.proto 的 descriptor 產生 OpenAPI v3 文件,
+// 每一個 RPC 都是 POST, request 與 response 的 schema 與 protojson 的格式相同
+func (g *Gateway) OpenAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"code", "message"},
+			"properties": map[string]interface{}{
+				"code":    map[string]interface{}{"type": "string", "description": "AppError.Code, 例如 ACCOUNT_Locked"},
+				"message": map[string]interface{}{"type": "string"},
+				"details": map[string]interface{}{"type": "object", "additionalProperties": true},
+			},
+		},
+	}
+
+	paths := map[string]interface{}{}
+	for i := 0; i < g.service.Methods().Len(); i++ {
+		method := g.service.Methods().Get(i)
+		if _, ok := g.methods[string(method.Name())]; !ok {
+			continue
+		}
+
+		addSchema(schemas, method.Input())
+		addSchema(schemas, method.Output())
+
+		paths[GatewayPathPrefix+string(method.Name())] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": string(method.Name()),
+				"tags":        []string{string(g.service.Name())},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": schemaRef(method.Input())},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "OK",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": schemaRef(method.Output())},
+						},
+					},
+					"default": map[string]interface{}{
+						"description": "AppError",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Identity API",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []map[string][]string{{"bearerAuth": {}}},
+	}
+}
+
+// MarshalOpenAPI 回傳排版過的 OpenAPI 文件, 用來產生 repo 裡的 openapi.json
+func (g *Gateway) MarshalOpenAPI() ([]byte, error) {
+	return json.MarshalIndent(g.OpenAPIDocument(), "", "  ")
+}
+
+func schemaName(message protoreflect.MessageDescriptor) string {
+	return strings.ReplaceAll(string(message.FullName()), ".", "_")
+}
+
+func schemaRef(message protoreflect.MessageDescriptor) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + schemaName(message)}
+}
+
+// addSchema 加入 message 與它用到的所有 message 的 schema
+func addSchema(schemas map[string]interface{}, message protoreflect.MessageDescriptor) {
+	name := schemaName(message)
+	if _, ok := schemas[name]; ok {
+		return
+	}
+
+	properties := map[string]interface{}{}
+	schemas[name] = map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	fields := message.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+
+		var schema map[string]interface{}
+		switch {
+		case field.IsMap():
+			schema = map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": fieldSchema(schemas, field.MapValue()),
+			}
+		case field.IsList():
+			schema = map[string]interface{}{
+				"type":  "array",
+				"items": fieldSchema(schemas, field),
+			}
+		default:
+			schema = fieldSchema(schemas, field)
+		}
+
+		properties[field.JSONName()] = schema
+	}
+}
+
+// fieldSchema 與 protojson 相同, 64 位元的整數會編碼成字串
+func fieldSchema(schemas map[string]interface{}, field protoreflect.FieldDescriptor) map[string]interface{} {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.EnumKind:
+		values := field.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if field.Message().FullName() == "google.protobuf.Timestamp" {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		addSchema(schemas, field.Message())
+		return schemaRef(field.Message())
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
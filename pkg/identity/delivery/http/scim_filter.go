@@ -0,0 +1,374 @@
+package http
+
+import (
+	"encoding/json"
+	"identity/pkg/domain"
+	"net/http"
+	"strings"
+)
+
+// scimFilter 是 filter 裡面的一個比較式, 例如 userName eq "angela"
+type scimFilter struct {
+	attr  string
+	op    string
+	value string
+}
+
+// parseSCIMFilter 只支援用 and 連接的比較式 (RFC 7644 3.4.2.2), 不支援 or, not 與括號
+func parseSCIMFilter(filter string) ([]scimFilter, error) {
+	tokens, err := scimFilterTokens(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []scimFilter
+	for i := 0; i < len(tokens); {
+		if i+2 >= len(tokens) {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "filter %q is incomplete", filter)
+		}
+
+		filters = append(filters, scimFilter{
+			attr:  strings.ToLower(tokens[i]),
+			op:    strings.ToLower(tokens[i+1]),
+			value: tokens[i+2],
+		})
+		i += 3
+
+		if i < len(tokens) {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "only \"and\" is supported in filter %q", filter)
+			}
+			i++
+		}
+	}
+
+	return filters, nil
+}
+
+// scimFilterTokens 用空白切開 filter, 雙引號裡面的字串依照 JSON 的規則處理跳脫字元
+func scimFilterTokens(filter string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ':
+			i++
+		case filter[i] == '"':
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(filter) {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "filter %q has an unterminated string", filter)
+			}
+
+			var val string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &val); err != nil {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "filter %q has an invalid string", filter)
+			}
+			tokens = append(tokens, val)
+			i = end + 1
+		default:
+			end := strings.IndexByte(filter[i:], ' ')
+			if end < 0 {
+				end = len(filter) - i
+			}
+			tokens = append(tokens, filter[i:i+end])
+			i += end
+		}
+	}
+
+	return tokens, nil
+}
+
+// scimUserFilter 把 Users 的 filter 轉成 FindAccountOptions
+func scimUserFilter(filter string) (domain.FindAccountOptions, error) {
+	var opts domain.FindAccountOptions
+
+	filters, err := parseSCIMFilter(filter)
+	if err != nil {
+		return opts, err
+	}
+
+	for _, f := range filters {
+		switch {
+		case f.attr == "username" && f.op == "eq":
+			opts.Username = f.value
+		case f.attr == "externalid" && f.op == "eq":
+			opts.ExternalID = f.value
+		case f.attr == "id" && f.op == "eq":
+			opts.UUID = f.value
+		case (f.attr == "emails" || f.attr == "emails.value") && f.op == "eq":
+			opts.Email = f.value
+		case (f.attr == "emails" || f.attr == "emails.value") && f.op == "co":
+			opts.EmailContains = f.value
+		case f.attr == "name.givenname" && f.op == "eq":
+			opts.FirstName = f.value
+		case f.attr == "active" && f.op == "eq" && strings.EqualFold(f.value, "true"):
+			opts.State = domain.AccountStatusNormal
+		case f.attr == "active" && f.op == "eq" && strings.EqualFold(f.value, "false"):
+			opts.State = domain.AccountStatusDisabled
+		default:
+			return opts, newSCIMError(http.StatusBadRequest, "invalidFilter", "filter %s %s is not supported", f.attr, f.op)
+		}
+	}
+
+	return opts, nil
+}
+
+// scimGroupFilter 把 Groups 的 filter 轉成 FindRoleOptions
+func scimGroupFilter(filter string) (domain.FindRoleOptions, error) {
+	var opts domain.FindRoleOptions
+
+	filters, err := parseSCIMFilter(filter)
+	if err != nil {
+		return opts, err
+	}
+
+	for _, f := range filters {
+		switch {
+		case f.attr == "displayname" && f.op == "eq":
+			opts.Name = f.value
+		default:
+			return opts, newSCIMError(http.StatusBadRequest, "invalidFilter", "filter %s %s is not supported", f.attr, f.op)
+		}
+	}
+
+	return opts, nil
+}
+
+// scimPatchRequest 是 PATCH 的 request (RFC 7644 3.5.2)
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyUser Account 沒有對應欄位的屬性 (例如 enterprise extension) 會被忽略
+func (p *scimPatchRequest) applyUser(user *scimUser) error {
+	return p.apply(func(op, path string, value json.RawMessage) error {
+		return patchUser(user, op, path, value)
+	})
+}
+
+func (p *scimPatchRequest) applyGroup(group *scimGroup) error {
+	return p.apply(func(op, path string, value json.RawMessage) error {
+		return patchGroup(group, op, path, value)
+	})
+}
+
+// apply 沒有 path 時 value 是一個物件, 每一個 key 都當成 path 處理
+func (p *scimPatchRequest) apply(patch func(op, path string, value json.RawMessage) error) error {
+	for _, operation := range p.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return newSCIMError(http.StatusBadRequest, "invalidSyntax", "op %q is not supported", operation.Op)
+		}
+
+		if operation.Path != "" {
+			if err := patch(op, operation.Path, operation.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if op == "remove" {
+			return newSCIMError(http.StatusBadRequest, "noTarget", "path is required for remove")
+		}
+
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(operation.Value, &values); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "value must be an object when path is empty")
+		}
+
+		for path, value := range values {
+			if err := patch(op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// scimPatchPath 去掉 value filter, 例如 emails[type eq "work"].value 轉成 emails.value
+func scimPatchPath(path string) (string, string) {
+	path = strings.ToLower(path)
+
+	start := strings.IndexByte(path, '[')
+	end := strings.LastIndexByte(path, ']')
+	if start < 0 || end < start {
+		return path, ""
+	}
+
+	return path[:start] + path[end+1:], path[start+1 : end]
+}
+
+func patchUser(user *scimUser, op, path string, value json.RawMessage) error {
+	path, _ = scimPatchPath(path)
+
+	switch path {
+	case "username":
+		return patchString(&user.UserName, op, value)
+	case "externalid":
+		return patchString(&user.ExternalID, op, value)
+	case "displayname":
+		return patchString(&user.DisplayName, op, value)
+	case "name.givenname":
+		return patchString(&user.Name.GivenName, op, value)
+	case "name.familyname":
+		return patchString(&user.Name.FamilyName, op, value)
+	case "name":
+		if op == "remove" {
+			user.Name = scimName{}
+			return nil
+		}
+		return decodePatchValue(value, &user.Name)
+	case "active":
+		active := false
+		if op != "remove" {
+			if err := decodePatchBool(value, &active); err != nil {
+				return err
+			}
+		}
+		user.Active = &active
+		return nil
+	case "emails", "emails.value":
+		return patchMultiValue(&user.Emails, op, path != "emails", "work", value)
+	case "phonenumbers", "phonenumbers.value":
+		return patchMultiValue(&user.PhoneNumbers, op, path != "phonenumbers", "mobile", value)
+	}
+
+	return nil
+}
+
+func patchGroup(group *scimGroup, op, path string, value json.RawMessage) error {
+	path, filter := scimPatchPath(path)
+
+	switch path {
+	case "displayname":
+		return patchString(&group.DisplayName, op, value)
+	case "members":
+		var members []scimMultiValue
+		if len(value) > 0 && string(value) != "null" {
+			if err := decodePatchValue(value, &members); err != nil {
+				return err
+			}
+		}
+
+		switch op {
+		case "replace":
+			group.Members = members
+		case "add":
+			for _, member := range members {
+				if !hasMember(group.Members, member.Value) {
+					group.Members = append(group.Members, member)
+				}
+			}
+		case "remove":
+			// members[value eq "id"] 或是在 value 列出要移除的成員, 兩者都沒有時移除全部
+			if filter != "" {
+				filters, err := parseSCIMFilter(filter)
+				if err != nil {
+					return err
+				}
+				for _, f := range filters {
+					if f.attr != "value" || f.op != "eq" {
+						return newSCIMError(http.StatusBadRequest, "invalidPath", "members filter %s %s is not supported", f.attr, f.op)
+					}
+					members = append(members, scimMultiValue{Value: f.value})
+				}
+			}
+
+			if len(members) == 0 {
+				group.Members = nil
+				return nil
+			}
+
+			kept := group.Members[:0]
+			for _, member := range group.Members {
+				if !hasMember(members, member.Value) {
+					kept = append(kept, member)
+				}
+			}
+			group.Members = kept
+		}
+	}
+
+	return nil
+}
+
+func hasMember(members []scimMultiValue, value string) bool {
+	for _, member := range members {
+		if member.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func patchString(field *string, op string, value json.RawMessage) error {
+	if op == "remove" {
+		*field = ""
+		return nil
+	}
+	return decodePatchValue(value, field)
+}
+
+// patchMultiValue single 為 true 時 value 是字串 (例如 emails.value), 否則是陣列
+func patchMultiValue(field *[]scimMultiValue, op string, single bool, valueType string, value json.RawMessage) error {
+	if op == "remove" {
+		*field = nil
+		return nil
+	}
+
+	if single {
+		var val string
+		if err := decodePatchValue(value, &val); err != nil {
+			return err
+		}
+		*field = []scimMultiValue{{Value: val, Type: valueType, Primary: true}}
+		return nil
+	}
+
+	var values []scimMultiValue
+	if err := decodePatchValue(value, &values); err != nil {
+		return err
+	}
+
+	if op == "add" {
+		values = append(values, *field...)
+	}
+	*field = values
+	return nil
+}
+
+// decodePatchBool Azure AD 會把 boolean 送成 "True" 或 "False"
+func decodePatchBool(value json.RawMessage, field *bool) error {
+	var val string
+	if json.Unmarshal(value, &val) == nil {
+		switch strings.ToLower(val) {
+		case "true":
+			*field = true
+			return nil
+		case "false":
+			*field = false
+			return nil
+		}
+	}
+	return decodePatchValue(value, field)
+}
+
+func decodePatchValue(value json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "patch value is invalid: %v", err)
+	}
+	return nil
+}
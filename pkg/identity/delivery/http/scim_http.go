@@ -0,0 +1,687 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+const (
+	scimPathPrefix    = "/scim/v2/"
+	scimContentType   = "application/scim+json"
+	scimSchemaUser    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaList    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaPatchOp = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimSchemaError   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaConfig  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimDefaultCount  = 100
+	scimMaxCount      = 1000
+)
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Version      string    `json:"version"`
+	Location     string    `json:"location"`
+}
+
+// scimUser 是 SCIM 的 User (RFC 7643 4.1), 只包含 Account 有對應欄位的屬性
+type scimUser struct {
+	Schemas      []string         `json:"schemas"`
+	ID           string           `json:"id,omitempty"`
+	ExternalID   string           `json:"externalId,omitempty"`
+	UserName     string           `json:"userName"`
+	Name         scimName         `json:"name"`
+	DisplayName  string           `json:"displayName,omitempty"`
+	Password     string           `json:"password,omitempty"`
+	Active       *bool            `json:"active,omitempty"`
+	Emails       []scimMultiValue `json:"emails,omitempty"`
+	PhoneNumbers []scimMultiValue `json:"phoneNumbers,omitempty"`
+	Groups       []scimMultiValue `json:"groups,omitempty"`
+	Meta         *scimMeta        `json:"meta,omitempty"`
+}
+
+// scimGroup 是 SCIM 的 Group (RFC 7643 4.2)
+type scimGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type scimErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimError 是 SCIM 協定本身的錯誤, 例如 filter 或 PATCH 的 path 不正確
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+func newSCIMError(status int, scimType string, format string, args ...interface{}) *scimError {
+	return &scimError{status: status, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+// SCIM 提供 SCIM 2.0 (RFC 7644) 的 /Users 與 /Groups, Users 對應 Account, Groups 對應 Role
+// namespace 來自 bearer token, 通常是 service account 的 API key
+func (h *IdentityHandler) SCIM(w http.ResponseWriter, r *http.Request) {
+	token, ok := h.scimAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	resource, id := strings.TrimPrefix(r.URL.Path, scimPathPrefix), ""
+	if i := strings.Index(resource, "/"); i >= 0 {
+		resource, id = resource[:i], resource[i+1:]
+	}
+
+	switch {
+	case resource == "ServiceProviderConfig" && id == "":
+		h.scimServiceProviderConfig(w, r)
+	case resource == "Users" && id == "":
+		h.scimUsers(w, r, token)
+	case resource == "Users":
+		h.scimUser(w, r, token, id)
+	case resource == "Groups" && id == "":
+		h.scimGroups(w, r, token)
+	case resource == "Groups":
+		h.scimGroup(w, r, token, id)
+	default:
+		writeSCIMError(w, newSCIMError(http.StatusNotFound, "", "resource %s was not found", r.URL.Path))
+	}
+}
+
+func (h *IdentityHandler) scimAuthenticate(w http.ResponseWriter, r *http.Request) (*domain.Token, bool) {
+	tokenKey := bearerToken(r)
+	if tokenKey == "" {
+		w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_token", true))
+		writeSCIMError(w, newSCIMError(http.StatusUnauthorized, "", "bearer token is required"))
+		return nil, false
+	}
+
+	token, err := h.tokenSvc.Token(r.Context(), tokenKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_token", false))
+			writeSCIMError(w, newSCIMError(http.StatusUnauthorized, "", "bearer token is invalid"))
+			return nil, false
+		}
+		writeSCIMError(w, err)
+		return nil, false
+	}
+
+	err = h.scimSvc.Authorize(r.Context(), token)
+	if err != nil {
+		writeSCIMError(w, err)
+		return nil, false
+	}
+
+	return token, true
+}
+
+func (h *IdentityHandler) scimServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSchemaConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": true},
+		"authenticationSchemes": []map[string]string{{
+			"type": "oauthbearertoken",
+			"name": "OAuth Bearer Token",
+		}},
+	})
+}
+
+func (h *IdentityHandler) scimUsers(w http.ResponseWriter, r *http.Request, token *domain.Token) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		startIndex, count, err := scimPagination(r)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		opts, err := scimUserFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		opts.Namespace = token.Namespace
+		opts.Offset = startIndex - 1
+		opts.Limit = count
+		opts.Sort = "id"
+
+		accounts, total, err := h.scimSvc.Users(ctx, opts)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim list users failed")
+			writeSCIMError(w, err)
+			return
+		}
+
+		resources := make([]interface{}, 0, len(accounts))
+		for i := range accounts {
+			resources = append(resources, toSCIMUser(&accounts[i], nil))
+		}
+		writeSCIMList(w, total, startIndex, resources)
+	case http.MethodPost:
+		var user scimUser
+		if err := decodeSCIM(w, r, &user); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		if user.UserName == "" {
+			writeSCIMError(w, newSCIMError(http.StatusBadRequest, "invalidValue", "userName is required"))
+			return
+		}
+
+		account := domain.Account{
+			Namespace:       token.Namespace,
+			PasswordEncrypt: user.Password,
+			CreatorID:       uint64(token.AccountID),
+			CreatorName:     scimActor(token),
+		}
+		applySCIMUser(&account, &user)
+
+		err := h.scimSvc.CreateUser(ctx, &account)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim create user failed")
+			writeSCIMError(w, err)
+			return
+		}
+
+		resp := toSCIMUser(&account, nil)
+		w.Header().Set("Location", resp.Meta.Location)
+		w.Header().Set("ETag", resp.Meta.Version)
+		writeSCIM(w, http.StatusCreated, resp)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *IdentityHandler) scimUser(w http.ResponseWriter, r *http.Request, token *domain.Token, id string) {
+	ctx := r.Context()
+
+	if r.Method == http.MethodDelete {
+		err := h.scimSvc.DeactivateUser(ctx, token.Namespace, id, scimActor(token))
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	account, roles, err := h.scimSvc.User(ctx, token.Namespace, id)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		if !scimIfMatch(r, account.Version) {
+			writeSCIMError(w, newSCIMError(http.StatusPreconditionFailed, "", "the resource has been modified"))
+			return
+		}
+
+		user := toSCIMUser(account, roles)
+		if r.Method == http.MethodPut {
+			user = scimUser{}
+			err = decodeSCIM(w, r, &user)
+		} else {
+			var patch scimPatchRequest
+			err = decodeSCIM(w, r, &patch)
+			if err == nil {
+				err = patch.applyUser(&user)
+			}
+		}
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		if user.UserName == "" {
+			writeSCIMError(w, newSCIMError(http.StatusBadRequest, "invalidValue", "userName is required"))
+			return
+		}
+
+		applySCIMUser(account, &user)
+		account.UpdaterID = uint64(token.AccountID)
+		account.UpdaterName = scimActor(token)
+
+		err = h.scimSvc.UpdateUser(ctx, account, user.Active == nil || *user.Active)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim update user failed")
+			writeSCIMError(w, err)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := toSCIMUser(account, roles)
+	w.Header().Set("ETag", resp.Meta.Version)
+	writeSCIM(w, http.StatusOK, resp)
+}
+
+func (h *IdentityHandler) scimGroups(w http.ResponseWriter, r *http.Request, token *domain.Token) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		startIndex, count, err := scimPagination(r)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		opts, err := scimGroupFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		opts.Namespace = token.Namespace
+		opts.Offset = startIndex - 1
+		opts.Limit = count
+		opts.Sort = "id"
+
+		groups, total, err := h.scimSvc.Groups(ctx, opts)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim list groups failed")
+			writeSCIMError(w, err)
+			return
+		}
+
+		resources := make([]interface{}, 0, len(groups))
+		for i := range groups {
+			resources = append(resources, toSCIMGroup(&groups[i]))
+		}
+		writeSCIMList(w, total, startIndex, resources)
+	case http.MethodPost:
+		var group scimGroup
+		if err := decodeSCIM(w, r, &group); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		request := domain.SCIMGroup{
+			Role: domain.Role{
+				Namespace:   token.Namespace,
+				Name:        group.DisplayName,
+				CreatorID:   uint64(token.AccountID),
+				CreatorName: scimActor(token),
+			},
+			Members: fromSCIMMembers(group.Members),
+		}
+
+		err := h.scimSvc.CreateGroup(ctx, &request)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim create group failed")
+			writeSCIMError(w, err)
+			return
+		}
+
+		resp := toSCIMGroup(&request)
+		w.Header().Set("Location", resp.Meta.Location)
+		w.Header().Set("ETag", resp.Meta.Version)
+		writeSCIM(w, http.StatusCreated, resp)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *IdentityHandler) scimGroup(w http.ResponseWriter, r *http.Request, token *domain.Token, id string) {
+	ctx := r.Context()
+
+	roleID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		writeSCIMError(w, fmt.Errorf("group %s was not found. %w", id, domain.ErrNotFound))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = h.scimSvc.DeleteGroup(ctx, token.Namespace, roleID, scimActor(token))
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	group, err := h.scimSvc.Group(ctx, token.Namespace, roleID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		if !scimIfMatch(r, group.Role.Version) {
+			writeSCIMError(w, newSCIMError(http.StatusPreconditionFailed, "", "the resource has been modified"))
+			return
+		}
+
+		resource := toSCIMGroup(group)
+		if r.Method == http.MethodPut {
+			resource = scimGroup{}
+			err = decodeSCIM(w, r, &resource)
+		} else {
+			var patch scimPatchRequest
+			err = decodeSCIM(w, r, &patch)
+			if err == nil {
+				err = patch.applyGroup(&resource)
+			}
+		}
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+
+		if resource.DisplayName == "" {
+			writeSCIMError(w, newSCIMError(http.StatusBadRequest, "invalidValue", "displayName is required"))
+			return
+		}
+
+		group.Role.Name = resource.DisplayName
+		group.Role.UpdaterID = uint64(token.AccountID)
+		group.Role.UpdaterName = scimActor(token)
+		group.Members = fromSCIMMembers(resource.Members)
+
+		err = h.scimSvc.UpdateGroup(ctx, group)
+		if err != nil {
+			log.FromContext(ctx).Err(err).Error("http: scim update group failed")
+			writeSCIMError(w, err)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := toSCIMGroup(group)
+	w.Header().Set("ETag", resp.Meta.Version)
+	writeSCIM(w, http.StatusOK, resp)
+}
+
+// scimPagination startIndex 從 1 開始 (RFC 7644 3.4.2.4)
+func scimPagination(r *http.Request) (int, int, error) {
+	startIndex, count := 1, scimDefaultCount
+
+	if val := r.URL.Query().Get("startIndex"); val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "startIndex is invalid")
+		}
+		if i > 1 {
+			startIndex = i
+		}
+	}
+
+	if val := r.URL.Query().Get("count"); val != "" {
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, 0, newSCIMError(http.StatusBadRequest, "invalidValue", "count is invalid")
+		}
+		count = i
+	}
+
+	if count <= 0 {
+		count = 1
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	return startIndex, count, nil
+}
+
+// scimETag 用 Version 當作 weak ETag
+func scimETag(version uint32) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+// scimIfMatch 沒有帶 If-Match 時不檢查版本
+func scimIfMatch(r *http.Request, version uint32) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+
+	for _, val := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(val) == scimETag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+func scimActor(token *domain.Token) string {
+	if token.Username != "" {
+		return token.Username
+	}
+	return "scim"
+}
+
+func toSCIMUser(account *domain.Account, roles []domain.Role) scimUser {
+	active := account.State == domain.AccountStatusNormal
+
+	user := scimUser{
+		Schemas:     []string{scimSchemaUser},
+		ID:          account.UUID,
+		ExternalID:  account.ExternalID,
+		UserName:    account.Username.String,
+		Name:        scimName{GivenName: account.FirstName, FamilyName: account.LastName},
+		DisplayName: account.NickName,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      account.CreatedAt,
+			LastModified: account.UpdatedAt,
+			Version:      scimETag(account.Version),
+			Location:     scimPathPrefix + "Users/" + account.UUID,
+		},
+	}
+
+	if user.Meta.LastModified.Before(user.Meta.Created) {
+		user.Meta.LastModified = user.Meta.Created
+	}
+
+	if account.Email.Valid {
+		user.Emails = []scimMultiValue{{Value: account.Email.String, Type: "work", Primary: true}}
+	}
+
+	if account.Mobile.Valid {
+		user.PhoneNumbers = []scimMultiValue{{Value: account.Mobile.String, Type: "mobile"}}
+	}
+
+	for _, role := range roles {
+		user.Groups = append(user.Groups, scimMultiValue{
+			Value:   strconv.FormatUint(role.ID, 10),
+			Display: role.Name,
+			Ref:     scimPathPrefix + "Groups/" + strconv.FormatUint(role.ID, 10),
+		})
+	}
+
+	return user
+}
+
+// applySCIMUser 把 SCIM 的屬性寫回 Account, emails 與 phoneNumbers 只使用 primary 或第一筆
+func applySCIMUser(account *domain.Account, user *scimUser) {
+	account.Username = sql.NullString{String: user.UserName, Valid: user.UserName != ""}
+	account.ExternalID = user.ExternalID
+	account.FirstName = user.Name.GivenName
+	account.LastName = user.Name.FamilyName
+	account.NickName = user.DisplayName
+
+	email := primaryValue(user.Emails)
+	account.Email = sql.NullString{String: email, Valid: email != ""}
+
+	mobile := primaryValue(user.PhoneNumbers)
+	account.Mobile = sql.NullString{String: mobile, Valid: mobile != ""}
+
+	if user.Active != nil && !*user.Active {
+		account.State = domain.AccountStatusDisabled
+	}
+}
+
+func primaryValue(values []scimMultiValue) string {
+	for _, val := range values {
+		if val.Primary {
+			return val.Value
+		}
+	}
+
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+func toSCIMGroup(group *domain.SCIMGroup) scimGroup {
+	id := strconv.FormatUint(group.Role.ID, 10)
+
+	resource := scimGroup{
+		Schemas:     []string{scimSchemaGroup},
+		ID:          id,
+		DisplayName: group.Role.Name,
+		Members:     make([]scimMultiValue, 0, len(group.Members)),
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      group.Role.CreatedAt,
+			LastModified: group.Role.UpdatedAt,
+			Version:      scimETag(group.Role.Version),
+			Location:     scimPathPrefix + "Groups/" + id,
+		},
+	}
+
+	if resource.Meta.LastModified.Before(resource.Meta.Created) {
+		resource.Meta.LastModified = resource.Meta.Created
+	}
+
+	for _, member := range group.Members {
+		resource.Members = append(resource.Members, scimMultiValue{
+			Value:   member.UUID,
+			Display: member.Username.String,
+			Ref:     scimPathPrefix + "Users/" + member.UUID,
+		})
+	}
+
+	return resource
+}
+
+func fromSCIMMembers(members []scimMultiValue) []domain.Account {
+	result := make([]domain.Account, 0, len(members))
+	for _, member := range members {
+		result = append(result, domain.Account{UUID: member.Value})
+	}
+	return result
+}
+
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(v)
+	if err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "request body is invalid: %v", err)
+	}
+	return nil
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeSCIMList(w http.ResponseWriter, total int64, startIndex int, resources []interface{}) {
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimSchemaList},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// writeSCIMError 回傳 RFC 7644 3.12 的錯誤格式
+func writeSCIMError(w http.ResponseWriter, err error) {
+	resp := scimErrorResponse{Schemas: []string{scimSchemaError}}
+	code := http.StatusInternalServerError
+
+	var scimErr *scimError
+	var appErr *domain.AppError
+	switch {
+	case errors.As(err, &scimErr):
+		code, resp.ScimType, resp.Detail = scimErr.status, scimErr.scimType, scimErr.detail
+	case errors.Is(err, domain.ErrStale):
+		code, resp.Detail = http.StatusPreconditionFailed, appErrMessage(err)
+	case errors.Is(err, domain.ErrAlreadyExists):
+		code, resp.ScimType, resp.Detail = http.StatusConflict, "uniqueness", appErrMessage(err)
+	case errors.Is(err, domain.ErrInvalidInput):
+		code, resp.ScimType, resp.Detail = http.StatusBadRequest, "invalidValue", err.Error()
+	case errors.As(err, &appErr):
+		code, resp.Detail = httpStatus(appErr.Status), appErr.Message
+	default:
+		resp.Detail = "internal error"
+	}
+
+	resp.Status = strconv.Itoa(code)
+	writeSCIM(w, code, resp)
+}
+
+func appErrMessage(err error) string {
+	var appErr *domain.AppError
+	if errors.As(err, &appErr) {
+		return appErr.Message
+	}
+	return err.Error()
+}
@@ -0,0 +1,210 @@
+package file
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	keyFileExt = ".json"
+	// keyLockFile 是 keystore 目錄中用來互斥輪替金鑰的 lock 檔, 副檔名不是 .json 所以不會被當成金鑰
+	keyLockFile = "keystore.lock"
+	// keyLockRetryInterval 其他 instance 持有 lock 時重試的間隔
+	keyLockRetryInterval = 100 * time.Millisecond
+)
+
+// errKeyLockBusy lock 檔目前被其他 process 持有
+var errKeyLockBusy = errors.New("file: keystore is locked")
+
+// keyFile 是 keystore 目錄中每一把金鑰的檔案格式, 檔名為 <kid>.json
+type keyFile struct {
+	JWK         jose.JSONWebKey `json:"jwk"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ActivatesAt time.Time       `json:"activates_at"`
+}
+
+// KeyRepo 把 JWT 簽章金鑰以 JWK 格式存放在本機的 keystore 目錄
+type KeyRepo struct {
+	dir string
+}
+
+func NewKeyRepo(dir string) (*KeyRepo, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("file: create keystore dir failed. dir: %s, error: %w", dir, err)
+	}
+
+	return &KeyRepo{
+		dir: dir,
+	}, nil
+}
+
+// Keys 回傳 keystore 中所有的金鑰, 依照 ActivatesAt 由舊到新排序
+func (repo *KeyRepo) Keys(ctx context.Context) ([]domain.SigningKey, error) {
+	logger := log.FromContext(ctx)
+
+	entries, err := ioutil.ReadDir(repo.dir)
+	if err != nil {
+		logger.Err(err).Error("file: read keystore dir failed")
+		return nil, err
+	}
+
+	keys := []domain.SigningKey{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), keyFileExt) {
+			continue
+		}
+
+		key, err := repo.readKey(filepath.Join(repo.dir, entry.Name()))
+		if err != nil {
+			logger.Err(err).Str("file", entry.Name()).Error("file: read key failed")
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].ActivatesAt.Before(keys[j].ActivatesAt)
+	})
+
+	return keys, nil
+}
+
+func (repo *KeyRepo) CreateKey(ctx context.Context, key *domain.SigningKey) error {
+	logger := log.FromContext(ctx)
+
+	val, err := json.MarshalIndent(keyFile{
+		JWK: jose.JSONWebKey{
+			Key:       key.PrivateKey,
+			KeyID:     key.ID,
+			Algorithm: key.Algorithm,
+			Use:       "sig",
+		},
+		CreatedAt:   key.CreatedAt,
+		ActivatesAt: key.ActivatesAt,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先寫入暫存檔再 rename, 避免其他 instance 讀到寫到一半的檔案
+	path := repo.keyPath(key.ID)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, val, 0600)
+	if err != nil {
+		logger.Err(err).Error("file: write key failed")
+		return err
+	}
+
+	_, err = os.Stat(path)
+	if err == nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("file: key is duplicated. %w", domain.ErrAlreadyExists)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		logger.Err(err).Error("file: rename key failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *KeyRepo) DeleteKey(ctx context.Context, keyID string) error {
+	logger := log.FromContext(ctx)
+
+	err := os.Remove(repo.keyPath(keyID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("file: key not found. %w", domain.ErrNotFound)
+		}
+		logger.Err(err).Error("file: delete key failed")
+		return err
+	}
+
+	return nil
+}
+
+// LockKeys 對 keystore 目錄中的 lock 檔加上 advisory lock, 多個 instance 共用同一個 keystore 時只會有一個在輪替金鑰;
+// process 結束時 lock 會由系統自動釋放
+func (repo *KeyRepo) LockKeys(ctx context.Context) (func(), error) {
+	logger := log.FromContext(ctx)
+
+	f, err := os.OpenFile(filepath.Join(repo.dir, keyLockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		logger.Err(err).Error("file: open keystore lock failed")
+		return nil, err
+	}
+
+	for {
+		err = tryLockFile(f)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, errKeyLockBusy) {
+			_ = f.Close()
+			logger.Err(err).Error("file: lock keystore failed")
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, ctx.Err()
+		case <-time.After(keyLockRetryInterval):
+		}
+	}
+
+	return func() {
+		err := unlockFile(f)
+		if err != nil {
+			logger.Err(err).Error("file: unlock keystore failed")
+		}
+		_ = f.Close()
+	}, nil
+}
+
+func (repo *KeyRepo) keyPath(keyID string) string {
+	return filepath.Join(repo.dir, filepath.Base(keyID)+keyFileExt)
+}
+
+func (repo *KeyRepo) readKey(path string) (*domain.SigningKey, error) {
+	val, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyFile
+	err = json.Unmarshal(val, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := f.JWK.Key.(crypto.Signer)
+	if !ok || f.JWK.IsPublic() {
+		return nil, fmt.Errorf("file: key %s is not a private key", f.JWK.KeyID)
+	}
+
+	return &domain.SigningKey{
+		ID:          f.JWK.KeyID,
+		Algorithm:   f.JWK.Algorithm,
+		PrivateKey:  signer,
+		CreatedAt:   f.CreatedAt,
+		ActivatesAt: f.ActivatesAt,
+	}, nil
+}
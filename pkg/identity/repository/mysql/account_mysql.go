@@ -8,6 +8,7 @@ import (
 	"identity/internal/pkg/database"
 	"identity/internal/pkg/global"
 	"identity/pkg/domain"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -191,7 +192,113 @@ func (repo *AccountRepo) UpdateState(ctx context.Context, account *domain.Accoun
 }
 
 func (repo *AccountRepo) UpdateOTPSecret(ctx context.Context, account *domain.Account) (string, error) {
-	panic("not implemented")
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	args := make(map[string]interface{})
+	args["otp_enable"] = account.OTPEnable
+	args["otp_secret"] = account.OTPSecret
+	args["otp_last_reset_at"] = account.OTPLastResetAt
+	args["updater_id"] = account.UpdaterID
+	args["updater_name"] = account.UpdaterName
+	args["updated_at"] = time.Now().UTC()
+	args["version"] = gorm.Expr(global.VersionAddOne)
+
+	result := db.Model(account).
+		Where("version = ?", account.Version).
+		Updates(args)
+
+	err := result.Error
+	if err != nil {
+		logger.Err(err).Uint64("account_id", account.ID).Error("mysql: update otp secret failed")
+		return "", err
+	}
+
+	if result.RowsAffected == 0 {
+		return "", domain.ErrStale
+	}
+
+	return account.OTPSecret, nil
+}
+
+func (repo *AccountRepo) CreateOTPRecoveryCodes(ctx context.Context, accountID uint64, codes []*domain.OTPRecoveryCode) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Where("account_id = ?", accountID).Delete(&domain.OTPRecoveryCode{}).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: delete otp recovery codes failed")
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, code := range codes {
+		code.AccountID = accountID
+		code.UsedAt = time.Unix(0, 0)
+		code.CreatedAt = now
+	}
+
+	err = db.CreateInBatches(codes, 100).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: create otp recovery codes failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *AccountRepo) DeleteOTPRecoveryCodes(ctx context.Context, accountID uint64) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Where("account_id = ?", accountID).Delete(&domain.OTPRecoveryCode{}).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: delete otp recovery codes failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *AccountRepo) UseOTPRecoveryCode(ctx context.Context, accountID uint64, codeHash string) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	// 用 used_at 當條件更新, 確保同一個恢復碼只能被使用一次
+	result := db.Model(&domain.OTPRecoveryCode{}).
+		Where("account_id = ?", accountID).
+		Where("code_hash = ?", codeHash).
+		Where("used_at = ?", time.Unix(0, 0).UTC()).
+		Update("used_at", time.Now().UTC())
+
+	err := result.Error
+	if err != nil {
+		logger.Err(err).Error("mysql: use otp recovery code failed")
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("mysql: otp recovery code not found. %w", domain.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *AccountRepo) CountOTPRecoveryCodes(ctx context.Context, accountID uint64) (int64, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	var total int64
+	err := db.Model(&domain.OTPRecoveryCode{}).
+		Where("account_id = ?", accountID).
+		Where("used_at = ?", time.Unix(0, 0).UTC()).
+		Count(&total).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: count otp recovery codes failed")
+		return 0, err
+	}
+
+	return total, nil
 }
 
 func (repo *AccountRepo) CountAccounts(ctx context.Context, options domain.FindAccountOptions) (int64, error) {
@@ -274,6 +381,13 @@ func (repo *AccountRepo) AddRolesToAccount(ctx context.Context, request domain.A
 	return nil
 }
 
+// likeEscaper 跳脫 LIKE 的萬用字元, 搭配 ESCAPE '\\' 讓查詢值的 % 與 _ 只比對字面上的字元
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
 func (repo *AccountRepo) buildWhereClause(db *gorm.DB, options domain.FindAccountOptions) *gorm.DB {
 	if options.LoginTimeEnd.Unix() > 0 {
 		db = db.Where("last_login_at BETWEEN ? AND ?", options.LoginTimeStart, options.LoginTimeEnd)
@@ -307,6 +421,10 @@ func (repo *AccountRepo) buildWhereClause(db *gorm.DB, options domain.FindAccoun
 		db = db.Where(" email = ?", options.Email)
 	}
 
+	if options.EmailContains != "" {
+		db = db.Where(` email like ? ESCAPE '\\'`, "%"+escapeLike(options.EmailContains)+"%")
+	}
+
 	if options.MobileCountryCode != "" {
 		db = db.Where(" mobile_country_code = ?", options.MobileCountryCode)
 	}
@@ -328,7 +446,7 @@ func (repo *AccountRepo) buildWhereClause(db *gorm.DB, options domain.FindAccoun
 	}
 
 	if options.Keyword != "" {
-		db = db.Where(" CONCAT(username,email,first_name) like ? ", "%"+options.Keyword+"%")
+		db = db.Where(` CONCAT(username,email,first_name) like ? ESCAPE '\\' `, "%"+escapeLike(options.Keyword)+"%")
 	}
 
 	if options.Type > 0 {
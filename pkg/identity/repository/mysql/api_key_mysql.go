@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
+)
+
+type APIKeyRepo struct {
+}
+
+func NewAPIKeyRepo() *APIKeyRepo {
+	return &APIKeyRepo{}
+}
+
+func (repo *APIKeyRepo) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	key.CreatedAt = time.Now().UTC()
+	if key.LastUsedAt.IsZero() {
+		key.LastUsedAt = time.Unix(0, 0).UTC()
+	}
+
+	if err := db.Create(key).Error; err != nil {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		if ok {
+			if mysqlErr.Number == 1062 {
+				return fmt.Errorf("mysql: the api key has already exists.  %w", domain.ErrAlreadyExists)
+			}
+		}
+		logger.Err(err).Str("key_id", key.KeyID).Error("mysql: create api key fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *APIKeyRepo) APIKey(ctx context.Context, keyID string) (*domain.APIKey, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	key := domain.APIKey{}
+	err := db.Model(domain.APIKey{}).Where("key_id = ?", keyID).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("mysql: api key %s was not found. %w", keyID, domain.ErrNotFound)
+		}
+		logger.Err(err).Str("key_id", keyID).Error("mysql: get api key fail")
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (repo *APIKeyRepo) APIKeysByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.APIKey, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	keys := []domain.APIKey{}
+	err := db.Model(domain.APIKey{}).
+		Where("namespace = ?", namespace).
+		Where("account_id = ?", accountID).
+		Order("id").
+		Find(&keys).Error
+	if err != nil {
+		logger.Err(err).Uint64("account_id", accountID).Error("mysql: get api keys fail")
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (repo *APIKeyRepo) DeleteAPIKey(ctx context.Context, keyID string) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	result := db.Where("key_id = ?", keyID).Delete(&domain.APIKey{})
+	if result.Error != nil {
+		logger.Err(result.Error).Str("key_id", keyID).Error("mysql: delete api key fail")
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("mysql: api key %s was not found. %w", keyID, domain.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *APIKeyRepo) TouchAPIKey(ctx context.Context, keyID string, lastUsedAt time.Time) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Model(domain.APIKey{}).Where("key_id = ?", keyID).Update("last_used_at", lastUsedAt).Error
+	if err != nil {
+		logger.Err(err).Str("key_id", keyID).Error("mysql: touch api key fail")
+		return err
+	}
+
+	return nil
+}
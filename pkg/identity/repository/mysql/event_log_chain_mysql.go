@@ -0,0 +1,149 @@
+package mysql
+
+import (
+	"context"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm/clause"
+)
+
+type EventLogChainRepo struct {
+}
+
+func NewEventLogChainRepo() *EventLogChainRepo {
+	return &EventLogChainRepo{}
+}
+
+// LockEventLogChain 先用 INSERT IGNORE 確保這個 namespace 有一筆 EventLogChain, 再用 SELECT ... FOR UPDATE 鎖住,
+// 同一個 namespace 寫入 event log 的 transaction 會在這裡排隊, 直到持有鎖的 transaction commit 或 rollback
+func (repo *EventLogChainRepo) LockEventLogChain(ctx context.Context, namespace string) (*domain.EventLogChain, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	chain := domain.EventLogChain{
+		Namespace: namespace,
+		UpdatedAt: time.Now().UTC(),
+	}
+	err := db.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&chain).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Error("mysql: create eventLog chain fail")
+		return nil, err
+	}
+
+	chain = domain.EventLogChain{}
+	err = db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("namespace = ?", namespace).
+		First(&chain).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Error("mysql: lock eventLog chain fail")
+		return nil, err
+	}
+
+	return &chain, nil
+}
+
+func (repo *EventLogChainRepo) UpdateEventLogChain(ctx context.Context, chain *domain.EventLogChain) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	chain.UpdatedAt = time.Now().UTC()
+
+	err := db.Model(domain.EventLogChain{}).
+		Where("namespace = ?", chain.Namespace).
+		Updates(map[string]interface{}{
+			"last_event_log_id": chain.LastEventLogID,
+			"last_hash":         chain.LastHash,
+			"updated_at":        chain.UpdatedAt,
+		}).Error
+	if err != nil {
+		logger.Err(err).Any("params", chain).Error("mysql: update eventLog chain fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *EventLogChainRepo) EventLogChains(ctx context.Context) ([]domain.EventLogChain, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	chains := []domain.EventLogChain{}
+	err := db.Model(domain.EventLogChain{}).
+		Order("namespace").
+		Find(&chains).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: get eventLog chains fail")
+		return nil, err
+	}
+
+	return chains, nil
+}
+
+func (repo *EventLogChainRepo) ChainNamespaces(ctx context.Context) ([]string, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	namespaces := []string{}
+	err := db.Model(domain.EventLog{}).
+		Where("hash <> ''").
+		Distinct("namespace").
+		Order("namespace").
+		Pluck("namespace", &namespaces).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: get chain namespaces fail")
+		return nil, err
+	}
+
+	return namespaces, nil
+}
+
+func (repo *EventLogChainRepo) ChainEventLogs(ctx context.Context, namespace string, afterID uint64, untilID uint64, limit int) ([]domain.EventLog, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	eventLogs := []domain.EventLog{}
+	err := db.Model(domain.EventLog{}).
+		Where("namespace = ? AND id > ? AND id <= ?", namespace, afterID, untilID).
+		Order("id").
+		Limit(limit).
+		Find(&eventLogs).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Uint64("after_id", afterID).Error("mysql: get chain eventLogs fail")
+		return nil, err
+	}
+
+	return eventLogs, nil
+}
+
+func (repo *EventLogChainRepo) CreateEventLogCheckpoint(ctx context.Context, checkpoint *domain.EventLogCheckpoint) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Create(checkpoint).Error
+	if err != nil {
+		logger.Err(err).Any("params", checkpoint).Error("mysql: create eventLog checkpoint fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *EventLogChainRepo) EventLogCheckpoints(ctx context.Context, namespace string) ([]domain.EventLogCheckpoint, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	checkpoints := []domain.EventLogCheckpoint{}
+	err := db.Model(domain.EventLogCheckpoint{}).
+		Where("namespace = ?", namespace).
+		Order("event_log_id").
+		Find(&checkpoints).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Error("mysql: get eventLog checkpoints fail")
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
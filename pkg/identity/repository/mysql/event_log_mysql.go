@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
 )
 
 type EventLogRepo struct {
@@ -20,7 +21,10 @@ func (repo *EventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.E
 	logger := log.FromContext(ctx)
 	db := database.FromContext(ctx)
 
-	eventLog.CreatedAt = time.Now().UTC()
+	// hash chain 在寫入前已經用 CreatedAt 計算 Hash, 這時候不能再修改
+	if eventLog.CreatedAt.IsZero() {
+		eventLog.CreatedAt = time.Now().UTC()
+	}
 
 	err := db.Create(eventLog).Error
 	if err != nil {
@@ -30,3 +34,106 @@ func (repo *EventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.E
 
 	return nil
 }
+
+func (repo *EventLogRepo) EventLogsAfter(ctx context.Context, afterID uint64, limit int) ([]domain.EventLog, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	eventLogs := []domain.EventLog{}
+	err := db.Model(domain.EventLog{}).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&eventLogs).Error
+	if err != nil {
+		logger.Err(err).Uint64("after_id", afterID).Error("mysql: get eventLogs fail")
+		return nil, err
+	}
+
+	return eventLogs, nil
+}
+
+func (repo *EventLogRepo) LatestEventLogID(ctx context.Context) (uint64, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	var latestID uint64
+	err := db.Model(domain.EventLog{}).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&latestID).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: get latest eventLog id fail")
+		return 0, err
+	}
+
+	return latestID, nil
+}
+
+func (repo *EventLogRepo) EventLogs(ctx context.Context, opts domain.FindEventLogOptions) ([]domain.EventLog, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	db = repo.buildWhereClause(db.Model(domain.EventLog{}), opts)
+	if opts.Cursor > 0 {
+		db = db.Where("id < ?", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		db = db.Limit(opts.Limit)
+	}
+
+	eventLogs := []domain.EventLog{}
+	err := db.Order("id DESC").Find(&eventLogs).Error
+	if err != nil {
+		logger.Err(err).Any("params", opts).Error("mysql: get eventLogs fail")
+		return nil, err
+	}
+
+	return eventLogs, nil
+}
+
+func (repo *EventLogRepo) CountEventLogs(ctx context.Context, opts domain.FindEventLogOptions) (int64, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	var total int64
+	err := repo.buildWhereClause(db.Model(domain.EventLog{}), opts).Count(&total).Error
+	if err != nil {
+		logger.Err(err).Any("params", opts).Error("mysql: count eventLogs fail")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// buildWhereClause 只處理過濾條件, cursor 與 limit 不影響 CountEventLogs
+func (repo *EventLogRepo) buildWhereClause(db *gorm.DB, opts domain.FindEventLogOptions) *gorm.DB {
+	if opts.Namespace != "" {
+		db = db.Where("namespace = ?", opts.Namespace)
+	}
+
+	if opts.Action != "" {
+		db = db.Where("action = ?", opts.Action)
+	}
+
+	if opts.TargetID != "" {
+		db = db.Where("target_id = ?", opts.TargetID)
+	}
+
+	if opts.Actor != "" {
+		db = db.Where("actor = ?", opts.Actor)
+	}
+
+	if opts.State > 0 {
+		db = db.Where("state = ?", opts.State)
+	}
+
+	if !opts.CreatedTimeStart.IsZero() {
+		db = db.Where("created_at >= ?", opts.CreatedTimeStart)
+	}
+
+	if !opts.CreatedTimeEnd.IsZero() {
+		db = db.Where("created_at < ?", opts.CreatedTimeEnd)
+	}
+
+	return db
+}
@@ -7,16 +7,17 @@ import (
 	"time"
 
 	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
 )
 
 type LoginLogRepo struct {
 }
 
-func NewLoginLogRepo() *EventLogRepo {
-	return &EventLogRepo{}
+func NewLoginLogRepo() *LoginLogRepo {
+	return &LoginLogRepo{}
 }
 
-func (repo *EventLogRepo) CreateLoginLog(ctx context.Context, loginLog *domain.LoginLog) error {
+func (repo *LoginLogRepo) CreateLoginLog(ctx context.Context, loginLog *domain.LoginLog) error {
 	logger := log.FromContext(ctx)
 	db := database.FromContext(ctx)
 
@@ -30,3 +31,108 @@ func (repo *EventLogRepo) CreateLoginLog(ctx context.Context, loginLog *domain.L
 
 	return nil
 }
+
+func (repo *LoginLogRepo) LoginLogsAfter(ctx context.Context, afterID uint64, limit int) ([]domain.LoginLog, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	loginLogs := []domain.LoginLog{}
+	err := db.Model(domain.LoginLog{}).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&loginLogs).Error
+	if err != nil {
+		logger.Err(err).Uint64("after_id", afterID).Error("mysql: get login logs fail")
+		return nil, err
+	}
+
+	return loginLogs, nil
+}
+
+func (repo *LoginLogRepo) LoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) ([]domain.LoginLog, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	db = repo.buildWhereClause(db.Model(domain.LoginLog{}), opts)
+	if opts.Cursor > 0 {
+		db = db.Where("id < ?", opts.Cursor)
+	}
+	if opts.Limit > 0 {
+		db = db.Limit(opts.Limit)
+	}
+
+	loginLogs := []domain.LoginLog{}
+	err := db.Order("id DESC").Find(&loginLogs).Error
+	if err != nil {
+		logger.Err(err).Any("params", opts).Error("mysql: get login logs fail")
+		return nil, err
+	}
+
+	return loginLogs, nil
+}
+
+func (repo *LoginLogRepo) CountLoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) (int64, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	var total int64
+	err := repo.buildWhereClause(db.Model(domain.LoginLog{}), opts).Count(&total).Error
+	if err != nil {
+		logger.Err(err).Any("params", opts).Error("mysql: count login logs fail")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (repo *LoginLogRepo) LoginCountries(ctx context.Context, opts domain.FindLoginLogOptions) ([]string, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	countryCodes := []string{}
+	err := repo.buildWhereClause(db.Model(domain.LoginLog{}), opts).
+		Where("country_code <> ''").
+		Distinct("country_code").
+		Order("country_code").
+		Pluck("country_code", &countryCodes).Error
+	if err != nil {
+		logger.Err(err).Any("params", opts).Error("mysql: get login countries fail")
+		return nil, err
+	}
+
+	return countryCodes, nil
+}
+
+// buildWhereClause 只處理過濾條件, cursor 與 limit 不影響 CountLoginLogs
+func (repo *LoginLogRepo) buildWhereClause(db *gorm.DB, opts domain.FindLoginLogOptions) *gorm.DB {
+	if opts.Namespace != "" {
+		db = db.Where("namespace = ?", opts.Namespace)
+	}
+
+	if opts.TargetID != "" {
+		db = db.Where("target_id = ?", opts.TargetID)
+	}
+
+	if opts.State > 0 {
+		db = db.Where("state = ?", opts.State)
+	}
+
+	if opts.DeviceType > 0 {
+		db = db.Where("device_type = ?", opts.DeviceType)
+	}
+
+	if opts.CountryCode != "" {
+		db = db.Where("country_code = ?", opts.CountryCode)
+	}
+
+	if !opts.CreatedTimeStart.IsZero() {
+		db = db.Where("created_at >= ?", opts.CreatedTimeStart)
+	}
+
+	if !opts.CreatedTimeEnd.IsZero() {
+		db = db.Where("created_at < ?", opts.CreatedTimeEnd)
+	}
+
+	return db
+}
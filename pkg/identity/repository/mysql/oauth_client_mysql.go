@@ -0,0 +1,91 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
+)
+
+type OAuthClientRepo struct {
+}
+
+func NewOAuthClientRepo() *OAuthClientRepo {
+	return &OAuthClientRepo{}
+}
+
+func (repo *OAuthClientRepo) CreateOAuthClient(ctx context.Context, client *domain.OAuthClient) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	now := time.Now().UTC()
+	client.CreatedAt = now
+	client.UpdatedAt = now
+
+	if err := db.Create(client).Error; err != nil {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		if ok {
+			if mysqlErr.Number == 1062 {
+				return fmt.Errorf("mysql: the oauth client has already exists.  %w", domain.ErrAlreadyExists)
+			}
+		}
+		logger.Err(err).Str("client_id", client.ClientID).Error("mysql: create oauth client fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *OAuthClientRepo) OAuthClient(ctx context.Context, clientID string) (*domain.OAuthClient, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	client := domain.OAuthClient{}
+	err := db.Model(domain.OAuthClient{}).Where("client_id = ?", clientID).First(&client).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("mysql: oauth client %s was not found. %w", clientID, domain.ErrNotFound)
+		}
+		logger.Err(err).Str("client_id", clientID).Error("mysql: get oauth client fail")
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func (repo *OAuthClientRepo) OAuthClients(ctx context.Context, namespace string) ([]domain.OAuthClient, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	clients := []domain.OAuthClient{}
+	err := db.Model(domain.OAuthClient{}).Where("namespace = ?", namespace).Order("id").Find(&clients).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Error("mysql: get oauth clients fail")
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (repo *OAuthClientRepo) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	result := db.Where("client_id = ?", clientID).Delete(&domain.OAuthClient{})
+	if result.Error != nil {
+		logger.Err(result.Error).Str("client_id", clientID).Error("mysql: delete oauth client fail")
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("mysql: oauth client %s was not found. %w", clientID, domain.ErrNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
+)
+
+const outboxRelayLockName = "identity.outbox.relay"
+
+type OutboxRepo struct {
+}
+
+func NewOutboxRepo() *OutboxRepo {
+	return &OutboxRepo{}
+}
+
+func (repo *OutboxRepo) CreateOutboxEvents(ctx context.Context, events []*domain.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	now := time.Now().UTC()
+	for _, event := range events {
+		event.CreatedAt = now
+	}
+
+	err := db.Create(events).Error
+	if err != nil {
+		logger.Err(err).Int("count", len(events)).Error("mysql: create outbox events fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *OutboxRepo) PendingOutboxEvents(ctx context.Context, limit int) ([]domain.OutboxEvent, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	events := []domain.OutboxEvent{}
+	err := db.Model(domain.OutboxEvent{}).
+		Where("state = ?", domain.OutboxStatePending).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: get pending outbox events fail")
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (repo *OutboxRepo) MarkOutboxEventsPublished(ctx context.Context, eventIDs []uint64, publishedAt time.Time) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Model(domain.OutboxEvent{}).
+		Where("id IN ?", eventIDs).
+		Updates(map[string]interface{}{
+			"state":        domain.OutboxStatePublished,
+			"published_at": publishedAt,
+		}).Error
+	if err != nil {
+		logger.Err(err).Any("event_ids", eventIDs).Error("mysql: mark outbox events published fail")
+		return err
+	}
+
+	return nil
+}
+
+// WithRelayLock 使用 MySQL 的 GET_LOCK, 鎖綁定在連線上, 所以 fn 裡面的查詢都使用同一個連線
+// instance 掛掉時連線中斷, 鎖會自動被釋放
+func (repo *OutboxRepo) WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	locked := false
+	err := db.Connection(func(conn *gorm.DB) error {
+		var result int
+		err := conn.Raw("SELECT GET_LOCK(?, 0)", outboxRelayLockName).Scan(&result).Error
+		if err != nil {
+			return err
+		}
+		if result != 1 {
+			return nil
+		}
+
+		locked = true
+		defer func() {
+			err := conn.Exec("SELECT RELEASE_LOCK(?)", outboxRelayLockName).Error
+			if err != nil {
+				logger.Err(err).Warn("mysql: release outbox relay lock fail")
+			}
+		}()
+
+		return fn(database.ToContext(ctx, conn))
+	})
+	if err != nil {
+		logger.Err(err).Error("mysql: run with outbox relay lock fail")
+		return locked, err
+	}
+
+	return locked, nil
+}
@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+type PermissionRepo struct {
+}
+
+func NewPermissionRepo() *PermissionRepo {
+	return &PermissionRepo{}
+}
+
+func (repo *PermissionRepo) PermissionsByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.Permission, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	permissions := []domain.Permission{}
+	err := db.Model(domain.Permission{}).
+		Where("namespace = ?", namespace).
+		Where("account_id = ?", accountID).
+		Order("id").
+		Find(&permissions).Error
+	if err != nil {
+		logger.Err(err).Uint64("account_id", accountID).Error("mysql: get permissions fail")
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (repo *PermissionRepo) DeletePermissionsByAccountID(ctx context.Context, namespace string, accountID uint64) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	err := db.Where("namespace = ?", namespace).Where("account_id = ?", accountID).Delete(&domain.Permission{}).Error
+	if err != nil {
+		logger.Err(err).Uint64("account_id", accountID).Error("mysql: delete permissions fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *PermissionRepo) CreatePermissions(ctx context.Context, permissions []*domain.Permission) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for _, permission := range permissions {
+		permission.CreatedAt = now
+	}
+
+	if err := db.Create(permissions).Error; err != nil {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		if ok {
+			if mysqlErr.Number == 1062 {
+				return fmt.Errorf("mysql: the permission has already exists.  %w", domain.ErrAlreadyExists)
+			}
+		}
+		logger.Err(err).Error("mysql: create permissions fail")
+		return err
+	}
+
+	return nil
+}
@@ -140,6 +140,22 @@ func (repo *RoleRepo) Roles(ctx context.Context, opts domain.FindRoleOptions) ([
 	return roles, nil
 }
 
+// AccountIDsByRoleID 回傳角色目前的成員, 依照 account id 排序
+func (repo *RoleRepo) AccountIDsByRoleID(ctx context.Context, roleID uint64) ([]uint64, error) {
+	db := database.FromContext(ctx)
+
+	accountIDs := []uint64{}
+	err := db.Model(&domain.AccountRole{}).
+		Where("role_id = ?", roleID).
+		Order("account_id").
+		Pluck("account_id", &accountIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("mysql: get account ids by role id failed. %w", err)
+	}
+
+	return accountIDs, nil
+}
+
 func (repo *RoleRepo) AddAccountsToRole(ctx context.Context, accountIDs []uint64, roleID uint64) error {
 	logger := log.FromContext(ctx)
 	db := database.FromContext(ctx)
@@ -160,6 +176,11 @@ func (repo *RoleRepo) AddAccountsToRole(ctx context.Context, accountIDs []uint64
 		accountRoles = append(accountRoles, accountRole)
 	}
 
+	// 沒有帳號時只清空角色的成員
+	if len(accountRoles) == 0 {
+		return nil
+	}
+
 	err = db.CreateInBatches(accountRoles, 100).Error
 	if err != nil {
 		logger.Err(err).Error("mysql: add accounts to role failed.")
@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SIEMRepo struct {
+}
+
+func NewSIEMRepo() *SIEMRepo {
+	return &SIEMRepo{}
+}
+
+func (repo *SIEMRepo) SIEMCheckpoint(ctx context.Context, name string) (*domain.SIEMCheckpoint, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	checkpoint := domain.SIEMCheckpoint{}
+	err := db.Model(domain.SIEMCheckpoint{}).
+		Where("name = ?", name).
+		First(&checkpoint).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &domain.SIEMCheckpoint{Name: name}, nil
+		}
+		logger.Err(err).Str("name", name).Error("mysql: get siem checkpoint fail")
+		return nil, err
+	}
+
+	return &checkpoint, nil
+}
+
+func (repo *SIEMRepo) SaveSIEMCheckpoint(ctx context.Context, checkpoint *domain.SIEMCheckpoint) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	checkpoint.UpdatedAt = time.Now().UTC()
+
+	err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(checkpoint).Error
+	if err != nil {
+		logger.Err(err).Any("params", checkpoint).Error("mysql: save siem checkpoint fail")
+		return err
+	}
+
+	return nil
+}
+
+// WithExportLock 與 OutboxRepo.WithRelayLock 相同, 使用綁定在連線上的 GET_LOCK
+func (repo *SIEMRepo) WithExportLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	lockName := "identity.siem." + name
+	locked := false
+	err := db.Connection(func(conn *gorm.DB) error {
+		var result int
+		err := conn.Raw("SELECT GET_LOCK(?, 0)", lockName).Scan(&result).Error
+		if err != nil {
+			return err
+		}
+		if result != 1 {
+			return nil
+		}
+
+		locked = true
+		defer func() {
+			err := conn.Exec("SELECT RELEASE_LOCK(?)", lockName).Error
+			if err != nil {
+				logger.Err(err).Warn("mysql: release siem export lock fail")
+			}
+		}()
+
+		return fn(database.ToContext(ctx, conn))
+	})
+	if err != nil {
+		logger.Err(err).Str("name", name).Error("mysql: run with siem export lock fail")
+		return locked, err
+	}
+
+	return locked, nil
+}
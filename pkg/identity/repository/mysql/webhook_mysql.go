@@ -0,0 +1,228 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/gorm"
+)
+
+type WebhookRepo struct {
+}
+
+func NewWebhookRepo() *WebhookRepo {
+	return &WebhookRepo{}
+}
+
+func (repo *WebhookRepo) CreateWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	err := db.Create(webhook).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", webhook.Namespace).Error("mysql: create webhook fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *WebhookRepo) Webhook(ctx context.Context, namespace string, webhookID uint64) (*domain.Webhook, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	webhook := domain.Webhook{}
+	err := db.Model(domain.Webhook{}).
+		Where("namespace = ?", namespace).
+		Where("id = ?", webhookID).
+		First(&webhook).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("mysql: webhook %d was not found. %w", webhookID, domain.ErrNotFound)
+		}
+		logger.Err(err).Uint64("webhook_id", webhookID).Error("mysql: get webhook fail")
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (repo *WebhookRepo) Webhooks(ctx context.Context, namespace string) ([]domain.Webhook, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	webhooks := []domain.Webhook{}
+	err := db.Model(domain.Webhook{}).
+		Where("namespace = ?", namespace).
+		Order("id").
+		Find(&webhooks).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", namespace).Error("mysql: get webhooks fail")
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (repo *WebhookRepo) DeleteWebhook(ctx context.Context, namespace string, webhookID uint64) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	result := db.Where("namespace = ?", namespace).Where("id = ?", webhookID).Delete(&domain.Webhook{})
+	if result.Error != nil {
+		logger.Err(result.Error).Uint64("webhook_id", webhookID).Error("mysql: delete webhook fail")
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("mysql: webhook %d was not found. %w", webhookID, domain.ErrNotFound)
+	}
+
+	return nil
+}
+
+func (repo *WebhookRepo) CreateWebhookDeliveries(ctx context.Context, deliveries []*domain.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	now := time.Now().UTC()
+	for _, delivery := range deliveries {
+		delivery.CreatedAt = now
+		delivery.UpdatedAt = now
+		if delivery.NextAttemptAt.IsZero() {
+			delivery.NextAttemptAt = now
+		}
+		if delivery.DeliveredAt.IsZero() {
+			delivery.DeliveredAt = time.Unix(0, 0).UTC()
+		}
+	}
+
+	err := db.Create(&deliveries).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: create webhook deliveries fail")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *WebhookRepo) WebhookDelivery(ctx context.Context, namespace string, deliveryID uint64) (*domain.WebhookDelivery, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	delivery := domain.WebhookDelivery{}
+	err := db.Model(domain.WebhookDelivery{}).
+		Where("namespace = ?", namespace).
+		Where("id = ?", deliveryID).
+		First(&delivery).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("mysql: webhook delivery %d was not found. %w", deliveryID, domain.ErrNotFound)
+		}
+		logger.Err(err).Uint64("delivery_id", deliveryID).Error("mysql: get webhook delivery fail")
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (repo *WebhookRepo) WebhookDeliveries(ctx context.Context, opts domain.FindWebhookDeliveryOptions) ([]domain.WebhookDelivery, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	deliveries := []domain.WebhookDelivery{}
+	query := db.Model(domain.WebhookDelivery{}).Where("namespace = ?", opts.Namespace)
+
+	if opts.WebhookID > 0 {
+		query = query.Where("webhook_id = ?", opts.WebhookID)
+	}
+
+	if opts.State > 0 {
+		query = query.Where("state = ?", opts.State)
+	}
+
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+
+	err := query.Order("id DESC").Find(&deliveries).Error
+	if err != nil {
+		logger.Err(err).Str("namespace", opts.Namespace).Error("mysql: get webhook deliveries fail")
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (repo *WebhookRepo) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	deliveries := []domain.WebhookDelivery{}
+	err := db.Model(domain.WebhookDelivery{}).
+		Where("state = ?", domain.WebhookDeliveryPending).
+		Where("next_attempt_at <= ?", now).
+		Order("id").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		logger.Err(err).Error("mysql: get due webhook deliveries fail")
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (repo *WebhookRepo) ClaimWebhookDelivery(ctx context.Context, deliveryID uint64, now time.Time, leaseUntil time.Time) (bool, error) {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	result := db.Model(domain.WebhookDelivery{}).
+		Where("id = ?", deliveryID).
+		Where("state = ?", domain.WebhookDeliveryPending).
+		Where("next_attempt_at <= ?", now).
+		Update("next_attempt_at", leaseUntil)
+	if result.Error != nil {
+		logger.Err(result.Error).Uint64("delivery_id", deliveryID).Error("mysql: claim webhook delivery fail")
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (repo *WebhookRepo) UpdateWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	logger := log.FromContext(ctx)
+	db := database.FromContext(ctx)
+
+	delivery.UpdatedAt = time.Now().UTC()
+
+	err := db.Model(domain.WebhookDelivery{}).
+		Where("id = ?", delivery.ID).
+		Updates(map[string]interface{}{
+			"state":           delivery.State,
+			"attempts":        delivery.Attempts,
+			"response_status": delivery.ResponseStatus,
+			"last_error":      delivery.LastError,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"delivered_at":    delivery.DeliveredAt,
+			"updated_at":      delivery.UpdatedAt,
+		}).Error
+	if err != nil {
+		logger.Err(err).Uint64("delivery_id", delivery.ID).Error("mysql: update webhook delivery fail")
+		return err
+	}
+
+	return nil
+}
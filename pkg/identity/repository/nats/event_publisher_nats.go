@@ -0,0 +1,54 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+// EventSubjects 是 identity 發佈的所有 subject
+const EventSubjects = "identity.>"
+
+// EventPublisher 把事件發佈到 JetStream, 收到 stream 的 ack 才算發佈成功
+type EventPublisher struct {
+	js nats.JetStreamContext
+}
+
+// NewEventPublisher 確保 stream 存在之後回傳 EventPublisher, stream 不存在時會建立一個保存 identity.> 的 stream
+func NewEventPublisher(conn *nats.Conn, stream string) (*EventPublisher, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("nats: get jetstream context failed: %w", err)
+	}
+
+	_, err = js.StreamInfo(stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     stream,
+			Subjects: []string{EventSubjects},
+			Storage:  nats.FileStorage,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("nats: ensure stream %s failed: %w", stream, err)
+	}
+
+	return &EventPublisher{
+		js: js,
+	}, nil
+}
+
+func (p *EventPublisher) Publish(ctx context.Context, subject string, msgID string, payload []byte) error {
+	logger := log.FromContext(ctx)
+
+	_, err := p.js.Publish(subject, payload, nats.MsgId(msgID), nats.Context(ctx))
+	if err != nil {
+		logger.Err(err).Str("subject", subject).Str("msg_id", msgID).Error("nats: publish event fail")
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+const authorizationCodeKeyPrefix = "identity:oauth_code:"
+
+type AuthorizationCodeRepo struct {
+	client *redis.Client
+}
+
+func NewAuthorizationCodeRepo(client *redis.Client) *AuthorizationCodeRepo {
+	return &AuthorizationCodeRepo{
+		client: client,
+	}
+}
+
+func (repo *AuthorizationCodeRepo) CreateAuthorizationCode(ctx context.Context, code *domain.AuthorizationCode, d time.Duration) (string, error) {
+	logger := log.FromContext(ctx)
+
+	codeString, err := newTokenString("")
+	if err != nil {
+		return "", err
+	}
+
+	val, err := json.Marshal(code)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := repo.client.SetNX(ctx, authorizationCodeKeyPrefix+codeString, val, d).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: create authorization code failed")
+		return "", err
+	}
+
+	if !ok {
+		return "", fmt.Errorf("redis: authorization code is duplicated. %w", domain.ErrAlreadyExists)
+	}
+
+	return codeString, nil
+}
+
+func (repo *AuthorizationCodeRepo) ConsumeAuthorizationCode(ctx context.Context, codeString string) (*domain.AuthorizationCode, error) {
+	logger := log.FromContext(ctx)
+
+	val, err := repo.client.GetDel(ctx, authorizationCodeKeyPrefix+codeString).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("redis: authorization code not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: consume authorization code failed")
+		return nil, err
+	}
+
+	var code domain.AuthorizationCode
+	err = json.Unmarshal(val, &code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+const (
+	deviceCodeKeyPrefix = "identity:device_code:"
+	// deviceUserCodeKeyPrefix user code -> device code
+	deviceUserCodeKeyPrefix = "identity:device_user_code:"
+	devicePollKeyPrefix     = "identity:device_poll:"
+)
+
+type DeviceAuthorizationRepo struct {
+	client *redis.Client
+}
+
+func NewDeviceAuthorizationRepo(client *redis.Client) *DeviceAuthorizationRepo {
+	return &DeviceAuthorizationRepo{
+		client: client,
+	}
+}
+
+func (repo *DeviceAuthorizationRepo) CreateDeviceAuthorization(ctx context.Context, authorization *domain.DeviceAuthorization, d time.Duration) error {
+	logger := log.FromContext(ctx)
+
+	val, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+
+	// user code 比較短, 先佔用 user code 避免重複
+	ok, err := repo.client.SetNX(ctx, deviceUserCodeKeyPrefix+authorization.UserCode, authorization.DeviceCode, d).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: create device user code failed")
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("redis: device user code is duplicated. %w", domain.ErrAlreadyExists)
+	}
+
+	ok, err = repo.client.SetNX(ctx, deviceCodeKeyPrefix+authorization.DeviceCode, val, d).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: create device authorization failed")
+		return err
+	}
+
+	if !ok {
+		repo.client.Del(ctx, deviceUserCodeKeyPrefix+authorization.UserCode)
+		return fmt.Errorf("redis: device code is duplicated. %w", domain.ErrAlreadyExists)
+	}
+
+	return nil
+}
+
+func (repo *DeviceAuthorizationRepo) DeviceAuthorization(ctx context.Context, deviceCode string) (*domain.DeviceAuthorization, error) {
+	logger := log.FromContext(ctx)
+
+	val, err := repo.client.Get(ctx, deviceCodeKeyPrefix+deviceCode).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("redis: device authorization not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: get device authorization failed")
+		return nil, err
+	}
+
+	var authorization domain.DeviceAuthorization
+	err = json.Unmarshal(val, &authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authorization, nil
+}
+
+func (repo *DeviceAuthorizationRepo) DeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*domain.DeviceAuthorization, error) {
+	logger := log.FromContext(ctx)
+
+	deviceCode, err := repo.client.Get(ctx, deviceUserCodeKeyPrefix+userCode).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("redis: device user code not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: get device user code failed")
+		return nil, err
+	}
+
+	return repo.DeviceAuthorization(ctx, deviceCode)
+}
+
+func (repo *DeviceAuthorizationRepo) UpdateDeviceAuthorization(ctx context.Context, authorization *domain.DeviceAuthorization) error {
+	logger := log.FromContext(ctx)
+
+	val, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+
+	err = repo.client.SetArgs(ctx, deviceCodeKeyPrefix+authorization.DeviceCode, val, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	}).Err()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("redis: device authorization not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: update device authorization failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *DeviceAuthorizationRepo) PollDeviceAuthorization(ctx context.Context, deviceCode string, interval time.Duration) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	ok, err := repo.client.SetNX(ctx, devicePollKeyPrefix+deviceCode, 1, interval).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: poll device authorization failed")
+		return false, err
+	}
+
+	return ok, nil
+}
+
+func (repo *DeviceAuthorizationRepo) DeleteDeviceAuthorization(ctx context.Context, authorization *domain.DeviceAuthorization) error {
+	logger := log.FromContext(ctx)
+
+	pipe := repo.client.TxPipeline()
+	deleted := pipe.Del(ctx, deviceCodeKeyPrefix+authorization.DeviceCode)
+	pipe.Del(ctx, deviceUserCodeKeyPrefix+authorization.UserCode, devicePollKeyPrefix+authorization.DeviceCode)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger.Err(err).Error("redis: delete device authorization failed")
+		return err
+	}
+
+	if deleted.Val() == 0 {
+		return fmt.Errorf("redis: device authorization not found. %w", domain.ErrKeyNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,346 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+const (
+	sessionKeyPrefix         = "identity:session:"
+	accountSessionsKeyPrefix = "identity:account_sessions:"
+
+	sessionLimitExceededReply = "SESSION_LIMIT_EXCEEDED"
+)
+
+// createSessionScript
+// KEYS: account sessions index, session key, account hash
+// ARGV: session id, score, ttl(ms), max sessions, max device sessions, device type, namespace, action,
+// token key prefix, session key prefix, access key, refresh key, session hash fields...
+var createSessionScript = redis.NewScript(`
+local indexKey, sessionKey, accountKey = KEYS[1], KEYS[2], KEYS[3]
+local sessionID = ARGV[1]
+local score = ARGV[2]
+local ttl = tonumber(ARGV[3])
+local maxSessions = tonumber(ARGV[4])
+local maxDeviceSessions = tonumber(ARGV[5])
+local deviceType = ARGV[6]
+local namespace = ARGV[7]
+local action = ARGV[8]
+local tokenPrefix, sessionPrefix = ARGV[9], ARGV[10]
+local accessKey, refreshKey = ARGV[11], ARGV[12]
+
+local function removeSession(id, access, refresh)
+	redis.call("DEL", sessionPrefix .. id)
+	if access then redis.call("DEL", tokenPrefix .. access) end
+	if refresh then
+		redis.call("DEL", tokenPrefix .. refresh)
+		redis.call("HDEL", accountKey, refresh)
+	end
+	redis.call("ZREM", indexKey, id)
+end
+
+-- index 依照建立時間排序, 越前面越舊
+local sessions, devices = {}, {}
+for _, id in ipairs(redis.call("ZRANGE", indexKey, 0, -1)) do
+	local val = redis.call("HMGET", sessionPrefix .. id, "namespace", "device_type", "access_key", "refresh_key")
+	if not val[1] or not val[4] or redis.call("EXISTS", tokenPrefix .. val[4]) == 0 then
+		removeSession(id, val[3], val[4])
+	elseif val[1] == namespace then
+		local s = {id = id, access = val[3], refresh = val[4]}
+		table.insert(sessions, s)
+		if val[2] == deviceType then
+			table.insert(devices, s)
+		end
+	end
+end
+
+local deviceOver = 0
+if maxDeviceSessions > 0 then
+	deviceOver = #devices - maxDeviceSessions + 1
+end
+local over = 0
+if maxSessions > 0 then
+	over = #sessions - maxSessions + 1
+end
+
+if (deviceOver > 0 or over > 0) and action == "reject" then
+	redis.call("DEL", tokenPrefix .. accessKey, tokenPrefix .. refreshKey)
+	redis.call("HDEL", accountKey, refreshKey)
+	return redis.error_reply("` + sessionLimitExceededReply + `")
+end
+
+local evicted, removed = {}, {}
+for i = 1, deviceOver do
+	local s = devices[i]
+	removeSession(s.id, s.access, s.refresh)
+	removed[s.id] = true
+	table.insert(evicted, s.id)
+end
+
+local remaining = #sessions - #evicted
+for _, s in ipairs(sessions) do
+	if maxSessions <= 0 or remaining < maxSessions then
+		break
+	end
+	if not removed[s.id] then
+		removeSession(s.id, s.access, s.refresh)
+		table.insert(evicted, s.id)
+		remaining = remaining - 1
+	end
+end
+
+local fields = {}
+for i = 13, #ARGV do
+	table.insert(fields, ARGV[i])
+end
+redis.call("HSET", sessionKey, unpack(fields))
+redis.call("PEXPIRE", sessionKey, ttl)
+redis.call("ZADD", indexKey, score, sessionID)
+if redis.call("PTTL", indexKey) < ttl then
+	redis.call("PEXPIRE", indexKey, ttl)
+end
+
+return evicted
+`)
+
+type SessionRepo struct {
+	client *redis.Client
+}
+
+func NewSessionRepo(client *redis.Client) *SessionRepo {
+	return &SessionRepo{
+		client: client,
+	}
+}
+
+// CreateSession 建立 session, 超過 limit 時依照 limit.Action 踢掉最舊的 session 或是拒絕這次的登入.
+// 檢查上限, 踢掉 session 與建立 session 都在同一個 lua script 內完成, 避免同時登入時超過上限
+func (repo *SessionRepo) CreateSession(ctx context.Context, session *domain.Session, d time.Duration, limit domain.SessionLimit) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	if session.ID == "" {
+		session.ID = uuid.NewString()
+	}
+
+	now := time.Now().UTC()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+
+	accountID := strconv.FormatInt(session.AccountID, 10)
+	keys := []string{
+		accountSessionsKeyPrefix + accountID,
+		sessionKeyPrefix + session.ID,
+		accountKeyPrefix + accountID,
+	}
+
+	args := []interface{}{
+		session.ID,
+		now.UnixNano(),
+		d.Milliseconds(),
+		limit.MaxSessions,
+		limit.MaxDeviceSessions,
+		uint32(session.DeviceType),
+		session.Namespace,
+		string(limit.Action),
+		tokenKeyPrefix,
+		sessionKeyPrefix,
+		session.AccessKey,
+		session.RefreshKey,
+	}
+	for field, val := range sessionToHash(session) {
+		args = append(args, field, val)
+	}
+
+	evicted, err := createSessionScript.Run(ctx, repo.client, keys, args...).StringSlice()
+	if err != nil {
+		if err.Error() == sessionLimitExceededReply {
+			return nil, fmt.Errorf("redis: session limit exceeded. %w", domain.ErrSessionLimitExceeded)
+		}
+		logger.Err(err).Error("redis: create session failed")
+		return nil, err
+	}
+
+	return evicted, nil
+}
+
+func (repo *SessionRepo) Session(ctx context.Context, sessionID string) (*domain.Session, error) {
+	logger := log.FromContext(ctx)
+
+	val, err := repo.client.HGetAll(ctx, sessionKeyPrefix+sessionID).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: get session failed")
+		return nil, err
+	}
+
+	if len(val) == 0 {
+		return nil, fmt.Errorf("redis: session not found. %w", domain.ErrNotFound)
+	}
+
+	return sessionFromHash(sessionID, val), nil
+}
+
+// SessionsByAccountID 回傳 account 目前所有的 session, refreshToken 已經不存在的 session 會順便被清除
+func (repo *SessionRepo) SessionsByAccountID(ctx context.Context, accountID int64) ([]domain.Session, error) {
+	logger := log.FromContext(ctx)
+
+	indexKey := accountSessionsKeyPrefix + strconv.FormatInt(accountID, 10)
+
+	sessionIDs, err := repo.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: get account sessions failed")
+		return nil, err
+	}
+
+	sessions := []domain.Session{}
+	for _, sessionID := range sessionIDs {
+		session, err := repo.Session(ctx, sessionID)
+		if err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				repo.client.ZRem(ctx, indexKey, sessionID)
+				continue
+			}
+			return nil, err
+		}
+
+		exists, err := repo.client.Exists(ctx, tokenKeyPrefix+session.RefreshKey).Result()
+		if err != nil {
+			logger.Err(err).Error("redis: check refresh token failed")
+			return nil, err
+		}
+
+		if exists == 0 {
+			err = repo.DeleteSession(ctx, sessionID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, nil
+}
+
+// UpdateSessionTokens refresh token 之後更新 session 所對應的 token, 並延長 session 的存活時間
+func (repo *SessionRepo) UpdateSessionTokens(ctx context.Context, sessionID, accessKey, refreshKey string, d time.Duration) error {
+	logger := log.FromContext(ctx)
+
+	session, err := repo.Session(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	key := sessionKeyPrefix + sessionID
+	indexKey := accountSessionsKeyPrefix + strconv.FormatInt(session.AccountID, 10)
+
+	pipe := repo.client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"access_key", accessKey,
+		"refresh_key", refreshKey,
+		"last_seen_at", time.Now().UTC().Unix(),
+	)
+	pipe.Expire(ctx, key, d)
+	pipe.Expire(ctx, indexKey, d)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Err(err).Error("redis: update session tokens failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *SessionRepo) TouchSession(ctx context.Context, sessionID string, lastSeenAt time.Time) error {
+	logger := log.FromContext(ctx)
+
+	key := sessionKeyPrefix + sessionID
+
+	exists, err := repo.client.Exists(ctx, key).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: check session failed")
+		return err
+	}
+
+	if exists == 0 {
+		return fmt.Errorf("redis: session not found. %w", domain.ErrNotFound)
+	}
+
+	err = repo.client.HSet(ctx, key, "last_seen_at", lastSeenAt.UTC().Unix()).Err()
+	if err != nil {
+		logger.Err(err).Error("redis: touch session failed")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSession 刪除 session 以及 session 所對應的 accessToken 與 refreshToken
+func (repo *SessionRepo) DeleteSession(ctx context.Context, sessionID string) error {
+	logger := log.FromContext(ctx)
+
+	session, err := repo.Session(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	accountID := strconv.FormatInt(session.AccountID, 10)
+
+	pipe := repo.client.TxPipeline()
+	pipe.Del(ctx, sessionKeyPrefix+sessionID, tokenKeyPrefix+session.AccessKey, tokenKeyPrefix+session.RefreshKey)
+	pipe.ZRem(ctx, accountSessionsKeyPrefix+accountID, sessionID)
+	pipe.HDel(ctx, accountKeyPrefix+accountID, session.RefreshKey)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Err(err).Error("redis: delete session failed")
+		return err
+	}
+
+	return nil
+}
+
+func sessionToHash(session *domain.Session) map[string]interface{} {
+	return map[string]interface{}{
+		"namespace":    session.Namespace,
+		"account_id":   session.AccountID,
+		"access_key":   session.AccessKey,
+		"refresh_key":  session.RefreshKey,
+		"device_type":  uint32(session.DeviceType),
+		"client_ip":    session.ClientIP,
+		"country_code": session.CountryCode,
+		"city_name":    session.CityName,
+		"user_agent":   session.UserAgent,
+		"created_at":   session.CreatedAt.Unix(),
+		"last_seen_at": session.LastSeenAt.Unix(),
+	}
+}
+
+func sessionFromHash(sessionID string, val map[string]string) *domain.Session {
+	accountID, _ := strconv.ParseInt(val["account_id"], 10, 64)
+	deviceType, _ := strconv.ParseUint(val["device_type"], 10, 32)
+	createdAt, _ := strconv.ParseInt(val["created_at"], 10, 64)
+	lastSeenAt, _ := strconv.ParseInt(val["last_seen_at"], 10, 64)
+
+	return &domain.Session{
+		ID:          sessionID,
+		Namespace:   val["namespace"],
+		AccountID:   accountID,
+		AccessKey:   val["access_key"],
+		RefreshKey:  val["refresh_key"],
+		DeviceType:  domain.DeviceType(deviceType),
+		ClientIP:    val["client_ip"],
+		CountryCode: val["country_code"],
+		CityName:    val["city_name"],
+		UserAgent:   val["user_agent"],
+		CreatedAt:   time.Unix(createdAt, 0).UTC(),
+		LastSeenAt:  time.Unix(lastSeenAt, 0).UTC(),
+	}
+}
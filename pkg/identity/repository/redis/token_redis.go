@@ -0,0 +1,497 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+const (
+	tokenKeyPrefix   = "identity:token:"
+	accountKeyPrefix = "identity:account:"
+	hashKeyPrefix    = "identity:hash:"
+	rotatedKeyPrefix = "identity:rotated:"
+
+	refreshTokenReusedReply = "REFRESH_TOKEN_REUSED"
+
+	// tokenRandomLength 是 token 隨機部分 base64 後的長度, 用來從 token 字串取回 prefix
+	tokenRandomLength = 43
+)
+
+// consumeRefreshTokenScript 取出並刪除 refreshToken, 同時留下已輪替的標記 (存活時間與舊的 refreshToken 相同)
+// 同一個 refreshToken 同時被使用兩次時, 只有一次會成功, 另外一次會被視為重複使用
+// KEYS: refresh token key, rotated key
+// ARGV: family id
+var consumeRefreshTokenScript = redis.NewScript(`
+local val = redis.call("GET", KEYS[1])
+if not val then
+	if redis.call("EXISTS", KEYS[2]) == 1 then
+		return redis.error_reply("` + refreshTokenReusedReply + `")
+	end
+	return false
+end
+
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("DEL", KEYS[1])
+if ttl > 0 then
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ttl)
+end
+
+return val
+`)
+
+type TokenRepo struct {
+	client *redis.Client
+}
+
+func NewTokenRepo(client *redis.Client) *TokenRepo {
+	return &TokenRepo{
+		client: client,
+	}
+}
+
+func (repo *TokenRepo) GetRcc() interface{} {
+	return repo.client
+}
+
+func (repo *TokenRepo) SetToken(ctx context.Context, prefix string, token domain.Token, d time.Duration) (string, error) {
+	logger := log.FromContext(ctx)
+
+	tokenString, err := newTokenString(prefix)
+	if err != nil {
+		return "", err
+	}
+	token.TokenString = tokenString
+
+	val, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	ok, err := repo.client.SetNX(ctx, tokenKeyPrefix+tokenString, val, d).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: set token failed")
+		return "", err
+	}
+
+	if !ok {
+		return "", fmt.Errorf("redis: token is duplicated. %w", domain.ErrAlreadyExists)
+	}
+
+	return tokenString, nil
+}
+
+func (repo *TokenRepo) GetToken(ctx context.Context, tokenString string) (domain.Token, error) {
+	logger := log.FromContext(ctx)
+
+	var token domain.Token
+
+	val, err := repo.client.Get(ctx, tokenKeyPrefix+tokenString).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return token, fmt.Errorf("redis: token not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: get token failed")
+		return token, err
+	}
+
+	err = json.Unmarshal(val, &token)
+	if err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+func (repo *TokenRepo) TokenTTL(ctx context.Context, tokenString string) (time.Duration, error) {
+	logger := log.FromContext(ctx)
+
+	ttl, err := repo.client.PTTL(ctx, tokenKeyPrefix+tokenString).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: get token ttl failed")
+		return 0, err
+	}
+
+	// -2 代表 key 不存在, -1 代表沒有設定期限
+	switch ttl {
+	case -2:
+		return 0, fmt.Errorf("redis: token not found. %w", domain.ErrKeyNotFound)
+	case -1:
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// UpdateToken 更新 token 的內容, 不會改變 token 的存活時間
+func (repo *TokenRepo) UpdateToken(ctx context.Context, token domain.Token) error {
+	logger := log.FromContext(ctx)
+
+	val, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	err = repo.client.SetArgs(ctx, tokenKeyPrefix+token.TokenString, val, redis.SetArgs{
+		Mode:    "XX",
+		KeepTTL: true,
+	}).Err()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("redis: token not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: update token failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *TokenRepo) DeleteToken(ctx context.Context, tokenString string) error {
+	logger := log.FromContext(ctx)
+
+	err := repo.client.Del(ctx, tokenKeyPrefix+tokenString).Err()
+	if err != nil {
+		logger.Err(err).Error("redis: delete token failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *TokenRepo) RenewToken(ctx context.Context, tokenString string, d time.Duration) error {
+	logger := log.FromContext(ctx)
+
+	ok, err := repo.client.Expire(ctx, tokenKeyPrefix+tokenString, d).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: renew token failed")
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("redis: token not found. %w", domain.ErrKeyNotFound)
+	}
+
+	return nil
+}
+
+// CreateAccountHash 記錄 account 底下的 refreshToken <-> accessToken, 用來一次刪除 account 所有的 token
+func (repo *TokenRepo) CreateAccountHash(ctx context.Context, accountID, refreshKey, accessKey string, d time.Duration) error {
+	logger := log.FromContext(ctx)
+
+	key := accountKeyPrefix + accountID
+
+	pipe := repo.client.TxPipeline()
+	pipe.HSet(ctx, key, refreshKey, accessKey)
+	pipe.Expire(ctx, key, d)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		logger.Err(err).Error("redis: create account hash failed")
+		return err
+	}
+
+	return nil
+}
+
+// RefreshToken 用 refreshToken 換一組新的 accessToken 與 refreshToken, 新的 refreshToken 存活時間為 d
+func (repo *TokenRepo) RefreshToken(ctx context.Context, tokenString string, d time.Duration) (string, string, error) {
+	refreshToken, err := repo.GetToken(ctx, tokenString)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return "", "", repo.checkRotated(ctx, tokenString, err)
+		}
+		return "", "", err
+	}
+
+	if refreshToken.Claims[domain.TokenTypeKey] != domain.TokenTypeRefresh {
+		return "", "", fmt.Errorf("redis: token is not a refresh token. %w", domain.ErrInvalidToken)
+	}
+	if d <= 0 {
+		return "", "", fmt.Errorf("redis: refresh token must expire. %w", domain.ErrInvalidInput)
+	}
+
+	err = repo.consumeRefreshToken(ctx, tokenString, refreshToken.Claims[domain.SessionKey])
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := tokenPrefix(tokenString)
+	oldAccessKey := refreshToken.Claims[domain.PairTokenKey]
+
+	accessToken := refreshToken
+	accessToken.Claims = copyClaims(refreshToken.Claims)
+	delete(accessToken.Claims, domain.PairTokenKey)
+	delete(accessToken.Claims, domain.TokenTypeKey)
+
+	accessKey, err := repo.SetToken(ctx, prefix, accessToken, time.Duration(accessToken.ExpiresIn)*time.Second)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken.Claims = copyClaims(accessToken.Claims)
+	refreshToken.Claims[domain.PairTokenKey] = accessKey
+	refreshToken.Claims[domain.TokenTypeKey] = domain.TokenTypeRefresh
+	refreshKey, err := repo.SetToken(ctx, prefix, refreshToken, d)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken.TokenString = accessKey
+	accessToken.Claims[domain.PairTokenKey] = refreshKey
+	err = repo.UpdateToken(ctx, accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	accountID := strconv.FormatInt(refreshToken.AccountID, 10)
+	err = repo.CreateAccountHash(ctx, accountID, refreshKey, accessKey, d)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = repo.client.Del(ctx, tokenKeyPrefix+oldAccessKey).Err()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = repo.client.HDel(ctx, accountKeyPrefix+accountID, tokenString).Err()
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessKey, refreshKey, nil
+}
+
+func (repo *TokenRepo) RotatedTokenFamily(ctx context.Context, tokenString string) (string, error) {
+	logger := log.FromContext(ctx)
+
+	family, err := repo.client.Get(ctx, rotatedKeyPrefix+tokenString).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", fmt.Errorf("redis: rotated token not found. %w", domain.ErrKeyNotFound)
+		}
+		logger.Err(err).Error("redis: get rotated token failed")
+		return "", err
+	}
+
+	return family, nil
+}
+
+func (repo *TokenRepo) consumeRefreshToken(ctx context.Context, tokenString, family string) error {
+	logger := log.FromContext(ctx)
+
+	keys := []string{tokenKeyPrefix + tokenString, rotatedKeyPrefix + tokenString}
+	err := consumeRefreshTokenScript.Run(ctx, repo.client, keys, family).Err()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("redis: token not found. %w", domain.ErrKeyNotFound)
+		}
+		if err.Error() == refreshTokenReusedReply {
+			return fmt.Errorf("redis: refresh token was already rotated. %w", domain.ErrRefreshTokenReused)
+		}
+		logger.Err(err).Error("redis: consume refresh token failed")
+		return err
+	}
+
+	return nil
+}
+
+// checkRotated token 不存在時, 如果 token 曾經被輪替過則回傳 ErrRefreshTokenReused, 否則回傳原本的錯誤
+func (repo *TokenRepo) checkRotated(ctx context.Context, tokenString string, notFound error) error {
+	logger := log.FromContext(ctx)
+
+	exists, err := repo.client.Exists(ctx, rotatedKeyPrefix+tokenString).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: check rotated token failed")
+		return err
+	}
+
+	if exists > 0 {
+		return fmt.Errorf("redis: refresh token was already rotated. %w", domain.ErrRefreshTokenReused)
+	}
+
+	return notFound
+}
+
+// BindHashToken 額外綁定一個 hashKey 到 accessToken, 之後可以用 hashKey 刪除 token
+func (repo *TokenRepo) BindHashToken(ctx context.Context, hashKey, accessToken string) error {
+	logger := log.FromContext(ctx)
+
+	token, err := repo.GetToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	ttl, err := repo.client.TTL(ctx, tokenKeyPrefix+accessToken).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: get token ttl failed")
+		return err
+	}
+
+	err = repo.client.Set(ctx, hashKeyPrefix+hashKey, accessToken, ttl).Err()
+	if err != nil {
+		logger.Err(err).Error("redis: bind hash token failed")
+		return err
+	}
+
+	if token.Claims == nil {
+		token.Claims = map[string]string{}
+	}
+	token.Claims[domain.BindHashKey] = hashKey
+
+	return repo.UpdateToken(ctx, token)
+}
+
+// DeleteHash 刪除 hashKey 以及綁定的 accessToken 與配對的 refreshToken
+func (repo *TokenRepo) DeleteHash(ctx context.Context, hashID string) error {
+	logger := log.FromContext(ctx)
+
+	accessKey, err := repo.client.Get(ctx, hashKeyPrefix+hashID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		logger.Err(err).Error("redis: get hash failed")
+		return err
+	}
+
+	keys := []string{hashKeyPrefix + hashID, tokenKeyPrefix + accessKey}
+
+	token, err := repo.GetToken(ctx, accessKey)
+	if err == nil && token.Claims[domain.PairTokenKey] != "" {
+		keys = append(keys, tokenKeyPrefix+token.Claims[domain.PairTokenKey])
+	}
+
+	err = repo.client.Del(ctx, keys...).Err()
+	if err != nil {
+		logger.Err(err).Error("redis: delete hash failed")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTokenByAccountID 刪除 account 所有的 token, 有帶 prefixTokens 時只刪除符合 prefix 的 token
+func (repo *TokenRepo) DeleteTokenByAccountID(ctx context.Context, accountID int64, prefixTokens ...string) error {
+	logger := log.FromContext(ctx)
+
+	key := accountKeyPrefix + strconv.FormatInt(accountID, 10)
+
+	pairs, err := repo.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		logger.Err(err).Error("redis: get account hash failed")
+		return err
+	}
+
+	keys := []string{}
+	fields := []string{}
+	for refreshKey, accessKey := range pairs {
+		if !hasAnyPrefix(refreshKey, prefixTokens) {
+			continue
+		}
+		fields = append(fields, refreshKey)
+		keys = append(keys, tokenKeyPrefix+refreshKey, tokenKeyPrefix+accessKey)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	pipe := repo.client.TxPipeline()
+	pipe.Del(ctx, keys...)
+	pipe.HDel(ctx, key, fields...)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		logger.Err(err).Error("redis: delete tokens by account id failed")
+		return err
+	}
+
+	return nil
+}
+
+func (repo *TokenRepo) GetAuthToken(ctx context.Context, tokenString string) (*domain.Token, error) {
+	token, err := repo.GetToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (repo *TokenRepo) SetAuthToken(ctx context.Context, token *domain.Token, d time.Duration) (string, error) {
+	tokenString, err := repo.SetToken(ctx, "", *token, d)
+	if err != nil {
+		return "", err
+	}
+	token.TokenString = tokenString
+	return tokenString, nil
+}
+
+func (repo *TokenRepo) DeleteAuthTokenByAccountID(ctx context.Context, accountID int64) error {
+	return repo.DeleteTokenByAccountID(ctx, accountID)
+}
+
+// CreateRefreshToken 建立與 token 配對的 refreshToken
+func (repo *TokenRepo) CreateRefreshToken(ctx context.Context, token *domain.Token, d time.Duration) (string, error) {
+	refreshToken := *token
+	refreshToken.Claims = copyClaims(token.Claims)
+	refreshToken.Claims[domain.PairTokenKey] = token.TokenString
+	refreshToken.Claims[domain.TokenTypeKey] = domain.TokenTypeRefresh
+
+	refreshKey, err := repo.SetToken(ctx, tokenPrefix(token.TokenString), refreshToken, d)
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.CreateAccountHash(ctx, strconv.FormatInt(token.AccountID, 10), refreshKey, token.TokenString, d)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshKey, nil
+}
+
+func newTokenString(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func tokenPrefix(tokenString string) string {
+	if len(tokenString) <= tokenRandomLength {
+		return ""
+	}
+	return tokenString[:len(tokenString)-tokenRandomLength]
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyClaims(claims map[string]string) map[string]string {
+	result := make(map[string]string, len(claims))
+	for k, v := range claims {
+		result[k] = v
+	}
+	return result
+}
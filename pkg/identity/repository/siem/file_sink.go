@@ -0,0 +1,56 @@
+package siem
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+)
+
+// FileSink 把紀錄一行一筆附加到檔案, 每一批寫完都會 sync, 給 SIEM 的 agent (例如 filebeat) 收集
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		file: file,
+	}, nil
+}
+
+func (sink *FileSink) Write(ctx context.Context, messages [][]byte) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	writer := bufio.NewWriter(sink.file)
+	for _, message := range messages {
+		_, err := writer.Write(message)
+		if err != nil {
+			return err
+		}
+		err = writer.WriteByte('\n')
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return sink.file.Sync()
+}
+
+func (sink *FileSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	return sink.file.Close()
+}
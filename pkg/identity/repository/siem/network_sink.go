@@ -0,0 +1,146 @@
+package siem
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"identity/pkg/domain"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type NetworkSinkOptions struct {
+	// Network 是 domain.SIEMSinkTCP, domain.SIEMSinkUDP 或 domain.SIEMSinkTLS
+	Network string
+	Address string
+	// TLSConfig 只有 domain.SIEMSinkTLS 使用
+	TLSConfig *tls.Config
+	// OctetCounting 在 TCP 與 TLS 上使用 RFC 6587 的 octet counting 分隔每一筆紀錄, 否則使用換行
+	// RFC 5424 syslog 使用 octet counting, 紀錄內容可以包含換行
+	OctetCounting bool
+	Timeout       time.Duration
+}
+
+// NetworkSink 透過 TCP, UDP 或 TLS 送出紀錄, 連線在第一次 Write 時建立, 送出失敗時關閉連線, 下一次 Write 重新連線
+// UDP 每一筆紀錄是一個 datagram, 不保證 SIEM 有收到
+type NetworkSink struct {
+	mu   sync.Mutex
+	opts NetworkSinkOptions
+	conn net.Conn
+}
+
+func NewNetworkSink(opts NetworkSinkOptions) (*NetworkSink, error) {
+	switch opts.Network {
+	case domain.SIEMSinkTCP, domain.SIEMSinkUDP, domain.SIEMSinkTLS:
+	default:
+		return nil, fmt.Errorf("siem: network %q is not supported. %w", opts.Network, domain.ErrInvalidInput)
+	}
+
+	if opts.Address == "" {
+		return nil, fmt.Errorf("siem: address is required. %w", domain.ErrInvalidInput)
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	return &NetworkSink{
+		opts: opts,
+	}, nil
+}
+
+func (sink *NetworkSink) Write(ctx context.Context, messages [][]byte) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	err := sink.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(sink.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = sink.conn.SetWriteDeadline(deadline)
+
+	if sink.opts.Network == domain.SIEMSinkUDP {
+		for _, message := range messages {
+			_, err = sink.conn.Write(message)
+			if err != nil {
+				sink.reset()
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	for _, message := range messages {
+		if sink.opts.OctetCounting {
+			buf.WriteString(strconv.Itoa(len(message)))
+			buf.WriteByte(' ')
+			buf.Write(message)
+			continue
+		}
+		buf.Write(message)
+		buf.WriteByte('\n')
+	}
+
+	_, err = sink.conn.Write(buf.Bytes())
+	if err != nil {
+		sink.reset()
+		return err
+	}
+
+	return nil
+}
+
+func (sink *NetworkSink) connect(ctx context.Context) error {
+	if sink.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: sink.opts.Timeout}
+
+	var err error
+	switch sink.opts.Network {
+	case domain.SIEMSinkTLS:
+		sink.conn, err = tls.DialWithDialer(dialer, "tcp", sink.opts.Address, sink.opts.TLSConfig)
+	default:
+		sink.conn, err = dialer.DialContext(ctx, sink.opts.Network, sink.opts.Address)
+	}
+	if err != nil {
+		sink.conn = nil
+		return fmt.Errorf("siem: dial %s %s failed: %w", sink.opts.Network, sink.opts.Address, err)
+	}
+
+	return nil
+}
+
+func (sink *NetworkSink) reset() {
+	if sink.conn != nil {
+		_ = sink.conn.Close()
+		sink.conn = nil
+	}
+}
+
+func (sink *NetworkSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.conn == nil {
+		return nil
+	}
+
+	err := sink.conn.Close()
+	sink.conn = nil
+	return err
+}
@@ -8,11 +8,13 @@ import (
 	identityMysql "identity/pkg/identity/repository/mysql"
 	"log"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/oschwald/geoip2-golang"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -66,7 +68,7 @@ func TestAccountTestSuite(t *testing.T) {
 		panic(err)
 	}
 
-	usecase := NewAccountUsecase(accountRepo, eventLogRepo, loginRepo, ipDB)
+	usecase := NewAccountUsecase(accountRepo, roleRepo, eventLogRepo, loginRepo, ipDB)
 
 	accountTestSuite := AccountTestSuite{
 		id:          uuid.NewString(),
@@ -90,13 +92,13 @@ func (suite *AccountTestSuite) SetupTest() {
 
 	err := suite.db.Set("gorm:table_options", "AUTO_INCREMENT=100000").AutoMigrate(domain.Account{})
 	suite.Require().NoError(err)
-	err = suite.db.AutoMigrate(domain.EventLog{}, domain.AccountRole{}, domain.Role{}, domain.Permission{}, domain.LoginLog{})
+	err = suite.db.AutoMigrate(domain.EventLog{}, domain.AccountRole{}, domain.Role{}, domain.Permission{}, domain.LoginLog{}, domain.OTPRecoveryCode{})
 	suite.Require().NoError(err)
 
 }
 
 func (suite *AccountTestSuite) TearDownTest() {
-	err := suite.db.Migrator().DropTable(domain.EventLog{}, domain.Account{}, domain.AccountRole{}, domain.Role{}, domain.Permission{}, domain.LoginLog{})
+	err := suite.db.Migrator().DropTable(domain.EventLog{}, domain.Account{}, domain.AccountRole{}, domain.Role{}, domain.Permission{}, domain.LoginLog{}, domain.OTPRecoveryCode{})
 	suite.Require().NoError(err)
 }
 
@@ -185,6 +187,43 @@ func (suite *AccountTestSuite) TestCRUDAccount() {
 
 }
 
+func (suite *AccountTestSuite) TestFindAccountsByEmailContains() {
+	ctx := context.Background()
+
+	for _, email := range []string{"100%_off@no.com", "100abcoff@no.com", `100\off@no.com`} {
+		account := domain.Account{
+			Namespace: suite.namespace,
+			Email: sql.NullString{
+				String: email,
+				Valid:  true,
+			},
+			PasswordEncrypt: "123456",
+			CreatorID:       1,
+			CreatorName:     "admin",
+			State:           domain.AccountStatusNormal,
+		}
+
+		err := suite.usecase.CreateAccount(ctx, &account)
+		suite.Require().NoError(err)
+	}
+
+	accounts, err := suite.usecase.Accounts(ctx, domain.FindAccountOptions{
+		Namespace:     suite.namespace,
+		EmailContains: "100%_",
+	})
+	suite.Require().NoError(err)
+	suite.Require().Len(accounts, 1)
+	suite.Assert().Equal("100%_off@no.com", accounts[0].Email.String)
+
+	accounts, err = suite.usecase.Accounts(ctx, domain.FindAccountOptions{
+		Namespace:     suite.namespace,
+		EmailContains: `\off`,
+	})
+	suite.Require().NoError(err)
+	suite.Require().Len(accounts, 1)
+	suite.Assert().Equal(`100\off@no.com`, accounts[0].Email.String)
+}
+
 func (suite *AccountTestSuite) TestLogin() {
 	ctx := context.Background()
 
@@ -276,6 +315,12 @@ func (suite *AccountTestSuite) TestLogin() {
 		suite.Assert().Equal(account1.Username, newAccount.Username)
 		suite.Assert().True(now.Before(newAccount.LastLoginAt))
 		suite.Assert().Equal(int32(0), newAccount.FailedPasswordAttempt)
+
+		loginLog := domain.LoginLog{}
+		err = suite.db.Where("target_id = ?", strconv.FormatUint(newAccount.ID, 10)).Order("id DESC").First(&loginLog).Error
+		suite.Require().NoError(err)
+		suite.Assert().Equal(domain.LoginLogSuccess, loginLog.State)
+		suite.Assert().Equal(login.ClientIP, loginLog.ClientIP)
 	})
 
 	suite.Run("mobile login", func() {
@@ -521,3 +566,87 @@ func (suite *AccountTestSuite) TestAddRoleToAccount() {
 	suite.Assert().Equal("finance1", roles[0].Name)
 	suite.Assert().Equal("finance2", roles[1].Name)
 }
+
+func (suite *AccountTestSuite) TestOTPRecoveryCodes() {
+	ctx := context.Background()
+
+	account := domain.Account{
+		Namespace: suite.namespace,
+		Username: sql.NullString{
+			String: "halo",
+			Valid:  true,
+		},
+		PasswordEncrypt: "123456",
+		State:           domain.AccountStatusNormal,
+		CreatorID:       1,
+		CreatorName:     "admin",
+	}
+
+	err := suite.usecase.CreateAccount(ctx, &account)
+	suite.Require().NoError(err)
+
+	verifyRequest := domain.VerifyOTPRequest{
+		Namespace: suite.namespace,
+		OTPCode:   "123456",
+	}
+	err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+	suite.Require().ErrorIs(err, domain.ErrOTPNotEnabled)
+
+	otpAuth, err := suite.usecase.ResetOTPSecret(ctx, domain.ResetOTPSecretRequest{
+		Namespace:   suite.namespace,
+		AccountID:   account.ID,
+		UpdaterID:   1,
+		UpdaterName: "admin",
+	})
+	suite.Require().NoError(err)
+	suite.Require().Len(otpAuth.RecoveryCodes, 10)
+
+	suite.Run("verify totp code", func() {
+		code, err := totp.GenerateCode(otpAuth.Secret, time.Now())
+		suite.Require().NoError(err)
+
+		verifyRequest.OTPCode = code
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().NoError(err)
+	})
+
+	suite.Run("recovery code can only be used once", func() {
+		verifyRequest.OTPCode = otpAuth.RecoveryCodes[0]
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().NoError(err)
+
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().ErrorIs(err, domain.ErrOTPCodeIncorrect)
+	})
+
+	suite.Run("regenerate recovery codes", func() {
+		codes, err := suite.usecase.GenerateOTPRecoveryCodes(ctx, domain.GenerateOTPRecoveryCodesRequest{
+			Namespace:   suite.namespace,
+			AccountID:   account.ID,
+			UpdaterID:   1,
+			UpdaterName: "admin",
+		})
+		suite.Require().NoError(err)
+
+		verifyRequest.OTPCode = otpAuth.RecoveryCodes[1]
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().ErrorIs(err, domain.ErrOTPCodeIncorrect)
+
+		verifyRequest.OTPCode = codes[0]
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().NoError(err)
+	})
+
+	suite.Run("clear otp", func() {
+		err := suite.usecase.ClearOTP(ctx, domain.ClearOTPRequest{
+			Namespace:   suite.namespace,
+			AccountID:   account.ID,
+			UpdaterID:   1,
+			UpdaterName: "admin",
+		})
+		suite.Require().NoError(err)
+
+		err = suite.usecase.VerifyOTP(ctx, account.UUID, verifyRequest)
+		suite.Require().ErrorIs(err, domain.ErrOTPNotEnabled)
+	})
+}
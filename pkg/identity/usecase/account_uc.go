@@ -2,35 +2,41 @@ package usecase
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"identity/internal/pkg/database"
 	"identity/pkg/domain"
+	"math/big"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nite-coder/blackbear/pkg/log"
 	"github.com/oschwald/geoip2-golang"
+	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/datatypes"
-	"gorm.io/gorm"
 )
 
 type AccountUsecase struct {
 	accountRepo  domain.AccountRepository
+	roleRepo     domain.RoleRepository
 	eventLogRepo domain.EventLogRepository
 	loginRepo    domain.LoginLogRepository
-	ipDB         geoip2.Reader
+	ipDB         *geoip2.Reader
 }
 
-func NewAccountUsecase(accountRepo domain.AccountRepository, eventLogRepo domain.EventLogRepository, loginRepo domain.LoginLogRepository, ipDB *geoip2.Reader) *AccountUsecase {
+func NewAccountUsecase(accountRepo domain.AccountRepository, roleRepo domain.RoleRepository, eventLogRepo domain.EventLogRepository, loginRepo domain.LoginLogRepository, ipDB *geoip2.Reader) *AccountUsecase {
 	return &AccountUsecase{
 		accountRepo:  accountRepo,
+		roleRepo:     roleRepo,
 		eventLogRepo: eventLogRepo,
 		loginRepo:    loginRepo,
-		ipDB:         *ipDB,
+		ipDB:         ipDB,
 	}
 }
 func (uc *AccountUsecase) Account(ctx context.Context, namespace string, accountID uint64) (*domain.Account, error) {
@@ -84,30 +90,26 @@ func (uc *AccountUsecase) CreateAccount(ctx context.Context, account *domain.Acc
 		return err
 	}
 
-	db := database.FromContext(ctx)
-	return db.Transaction(func(tx *gorm.DB) error {
-		ctx = database.ToContext(ctx, tx)
-
-		err = uc.accountRepo.CreateAccount(ctx, account)
-		if err != nil {
-			return err
-		}
-
-		newStatus, err := json.Marshal(&account)
+	entry := &auditEntry{
+		Namespace: "identity.account",
+		Action:    "create",
+		Message:   "account is created",
+		Actor:     account.CreatorName,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			if account.ID == 0 {
+				return nil, nil
+			}
+			return uc.accountRepo.Account(ctx, account.Namespace, account.ID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		err := uc.accountRepo.CreateAccount(ctx, account)
 		if err != nil {
 			return err
 		}
 
-		return uc.eventLogRepo.CreateEventLog(ctx, &domain.EventLog{
-			Namespace: "identity.account",
-			Action:    "create",
-			TargetID:  strconv.FormatUint(account.ID, 10),
-			Message:   "account is created",
-			OldStatus: datatypes.JSON([]byte("{}")),
-			NewStatus: newStatus,
-			State:     domain.EventLogSuccess,
-			Actor:     account.CreatorName,
-		})
+		entry.TargetID = strconv.FormatUint(account.ID, 10)
+		return nil
 	})
 }
 
@@ -121,39 +123,24 @@ func (uc *AccountUsecase) UpdateAccount(ctx context.Context, request *domain.Acc
 		return domain.ErrStale
 	}
 
-	db := database.FromContext(ctx)
-	return db.Transaction(func(tx *gorm.DB) error {
-		ctx = database.ToContext(ctx, tx)
-
-		oldStatus, err := json.Marshal(&account)
-		if err != nil {
-			return err
-		}
-
-		err = uc.accountRepo.UpdateAccount(ctx, request)
-		if err != nil {
-			return err
-		}
-
-		newStatus, err := json.Marshal(&account)
-		if err != nil {
-			return err
-		}
-
-		return uc.eventLogRepo.CreateEventLog(ctx, &domain.EventLog{
-			Namespace: "identity.account",
-			Action:    "update",
-			TargetID:  strconv.FormatUint(account.ID, 10),
-			Message:   "update account",
-			OldStatus: oldStatus,
-			NewStatus: newStatus,
-			State:     domain.EventLogSuccess,
-			Actor:     request.UpdaterName,
-		})
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "update",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "update account",
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.accountSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		return uc.accountRepo.UpdateAccount(ctx, request)
 	})
 }
 
 func (uc *AccountUsecase) UpdateAccountPassword(ctx context.Context, request domain.UpdateAccountPasswordRequest) error {
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return err
+	}
+
 	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
 	if err != nil {
 		return err
@@ -170,15 +157,30 @@ func (uc *AccountUsecase) UpdateAccountPassword(ctx context.Context, request dom
 	if err != nil {
 		return err
 	}
-	account.PasswordEncrypt = newPassword
-	account.UpdaterID = request.UpdaterID
-	account.UpdaterName = request.UpdaterName
-	account.UpdatedAt = time.Now().UTC()
 
-	return uc.accountRepo.UpdateAccountPassword(ctx, account)
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "update_password",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "password is updated",
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.accountSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		account.PasswordEncrypt = newPassword
+		account.UpdaterID = request.UpdaterID
+		account.UpdaterName = request.UpdaterName
+		account.UpdatedAt = time.Now().UTC()
+
+		return uc.accountRepo.UpdateAccountPassword(ctx, account)
+	})
 }
 
 func (uc *AccountUsecase) ForceUpdateAccountPassword(ctx context.Context, request domain.ForceUpdateAccountPasswordRequest) error {
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return err
+	}
+
 	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
 	if err != nil {
 		return err
@@ -190,12 +192,21 @@ func (uc *AccountUsecase) ForceUpdateAccountPassword(ctx context.Context, reques
 		return err
 	}
 
-	account.PasswordEncrypt = newPassword
-	account.UpdaterID = request.UpdaterID
-	account.UpdaterName = request.UpdaterName
-	account.UpdatedAt = time.Now().UTC()
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "force_update_password",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "password is updated without the old password",
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.accountSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		account.PasswordEncrypt = newPassword
+		account.UpdaterID = request.UpdaterID
+		account.UpdaterName = request.UpdaterName
+		account.UpdatedAt = time.Now().UTC()
 
-	return uc.accountRepo.UpdateAccountPassword(ctx, account)
+		return uc.accountRepo.UpdateAccountPassword(ctx, account)
+	})
 }
 
 func (uc *AccountUsecase) ChangeState(ctx context.Context, request domain.ChangeStateRequest) error {
@@ -208,39 +219,19 @@ func (uc *AccountUsecase) ChangeState(ctx context.Context, request domain.Change
 		return nil
 	}
 
-	db := database.FromContext(ctx)
-	return db.Transaction(func(tx *gorm.DB) error {
-		ctx = database.ToContext(ctx, tx)
-
-		oldStatus, err := json.Marshal(&account)
-		if err != nil {
-			return err
-		}
-
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "change_state",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   fmt.Sprintf("change state from %s to %s", account.State.String(), request.State.String()),
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.accountSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
 		account.State = request.State
-		account.UpdaterID = request.AccountID
+		account.UpdaterID = request.UpdaterID
 		account.UpdaterName = request.UpdaterName
 
-		err = uc.accountRepo.UpdateState(ctx, account)
-		if err != nil {
-			return err
-		}
-
-		newStatus, err := json.Marshal(&account)
-		if err != nil {
-			return err
-		}
-
-		return uc.eventLogRepo.CreateEventLog(ctx, &domain.EventLog{
-			Namespace: "identity.account",
-			Action:    "change_state",
-			TargetID:  strconv.FormatUint(account.ID, 10),
-			Message:   fmt.Sprintf("change state from %s to %s", account.State.String(), request.State.String()),
-			OldStatus: oldStatus,
-			NewStatus: newStatus,
-			State:     domain.EventLogSuccess,
-			Actor:     account.UpdaterName,
-		})
+		return uc.accountRepo.UpdateState(ctx, account)
 	})
 }
 
@@ -294,42 +285,33 @@ func (uc *AccountUsecase) Login(ctx context.Context, request domain.LoginInfo) (
 		return nil, domain.ErrAccountDisabled
 	}
 
-	db := database.FromContext(ctx)
+	// service account 只能使用 API key
+	if account.Type == domain.AccountTypeService {
+		return nil, domain.ErrServiceAccountLogin
+	}
+
 	//compare password
 	err = isPasswordValid(account.PasswordEncrypt, request.Password)
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 
 			//帳密錯誤更新錯誤次數
-			err = db.Transaction(func(tx *gorm.DB) error {
+			entry := &auditEntry{
+				Namespace: "identity.account",
+				Action:    "login_failed",
+				TargetID:  strconv.FormatUint(account.ID, 10),
+				Message:   "password is incorrect",
+				Actor:     otpAccountName(&account),
+				Snapshot:  uc.loginSnapshot(account.Namespace, account.ID),
+			}
+			err = audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
 				account.FailedPasswordAttempt = account.FailedPasswordAttempt + 1
 				err := uc.accountRepo.UpdateAccount(ctx, &account)
 				if err != nil {
 					return err
 				}
 
-				var contryCode, cityName string
-				if len(request.ClientIP) > 0 {
-					ip := net.ParseIP(request.ClientIP)
-					record, err := uc.ipDB.City(ip)
-					if err != nil {
-						return err
-					}
-
-					if len(record.Subdivisions) > 0 {
-						cityName = record.Subdivisions[0].Names["zh-CN"]
-					}
-					contryCode = record.Country.IsoCode
-				}
-
-				return uc.loginRepo.CreateLoginLog(ctx, &domain.LoginLog{
-					Namespace:   request.Namespace,
-					TargetID:    strconv.FormatUint(account.ID, 10),
-					CountryCode: contryCode,
-					CityName:    cityName,
-					DeviceType:  request.DeviceType,
-					State:       domain.LoginLogFail,
-				})
+				return uc.createLoginLog(ctx, request, account.ID, domain.LoginLogFail)
 			})
 
 			if err != nil {
@@ -343,55 +325,365 @@ func (uc *AccountUsecase) Login(ctx context.Context, request domain.LoginInfo) (
 	}
 
 	//登入成功，清除登入失敗次數
-	err = db.Transaction(func(tx *gorm.DB) error {
-		account.FailedPasswordAttempt = 0
+	entry := &auditEntry{
+		Namespace: "identity.account",
+		Action:    "login",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "login succeeded",
+		Actor:     otpAccountName(&account),
+		Snapshot:  uc.loginSnapshot(account.Namespace, account.ID),
+	}
+	err = audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		// 有啟用 OTP 的帳號要等 OTP 驗證通過才清除失敗次數, 否則重新登入就能重置 OTP 的嘗試次數
+		if account.OTPEnable == 0 {
+			account.FailedPasswordAttempt = 0
+		}
 		account.LastLoginAt = time.Now().UTC()
 		err = uc.accountRepo.UpdateAccount(ctx, &account)
 		if err != nil {
 			return err
 		}
 
-		var contryCode, cityName string
-		if len(request.ClientIP) > 0 {
-			ip := net.ParseIP(request.ClientIP)
-			record, err := uc.ipDB.City(ip)
-			if err != nil {
-				return err
-			}
+		return uc.createLoginLog(ctx, request, account.ID, domain.LoginLogSuccess)
+	})
 
-			if len(record.Subdivisions) > 0 {
-				cityName = record.Subdivisions[0].Names["zh-CN"]
-			}
-			contryCode = record.Country.IsoCode
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		return uc.loginRepo.CreateLoginLog(ctx, &domain.LoginLog{
-			Namespace:   request.Namespace,
-			TargetID:    strconv.FormatUint(account.ID, 10),
-			CountryCode: contryCode,
-			CityName:    cityName,
-			DeviceType:  request.DeviceType,
-			State:       domain.LoginLogSuccess,
-		})
+	return &account, nil
+}
+
+// ResetOTPSecret 產生新的 OTP secret 並啟用 OTP, 舊的恢復碼會一併失效
+func (uc *AccountUsecase) ResetOTPSecret(ctx context.Context, request domain.ResetOTPSecretRequest) (*domain.OTPAuth, error) {
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      account.Namespace,
+		AccountName: otpAccountName(account),
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	codes, codeHashes, err := generateOTPRecoveryCodes()
 	if err != nil {
 		return nil, err
 	}
 
-	return &account, nil
+	err = audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "reset_otp",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   fmt.Sprintf("otp secret is reset and %d recovery codes are generated", len(codes)),
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.otpSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		account.OTPEnable = 1
+		account.OTPSecret = key.Secret()
+		account.OTPLastResetAt = time.Now().UTC()
+		account.UpdaterID = request.UpdaterID
+		account.UpdaterName = request.UpdaterName
+
+		_, err := uc.accountRepo.UpdateOTPSecret(ctx, account)
+		if err != nil {
+			return err
+		}
+
+		return uc.accountRepo.CreateOTPRecoveryCodes(ctx, account.ID, codeHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.OTPAuth{
+		Secret:        key.Secret(),
+		URL:           key.URL(),
+		RecoveryCodes: codes,
+	}, nil
 }
 
-func (uc *AccountUsecase) ResetOTPSecret(ctx context.Context, request domain.ResetOTPSecretRequest) (string, error) {
-	panic("not implemented")
+// ClearOTP 關閉 OTP 並移除所有恢復碼
+func (uc *AccountUsecase) ClearOTP(ctx context.Context, request domain.ClearOTPRequest) error {
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return err
+	}
+
+	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return err
+	}
+
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "clear_otp",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "otp is cleared",
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.otpSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		account.OTPEnable = 0
+		account.OTPSecret = ""
+		account.OTPLastResetAt = time.Now().UTC()
+		account.UpdaterID = request.UpdaterID
+		account.UpdaterName = request.UpdaterName
+
+		_, err := uc.accountRepo.UpdateOTPSecret(ctx, account)
+		if err != nil {
+			return err
+		}
+
+		return uc.accountRepo.DeleteOTPRecoveryCodes(ctx, account.ID)
+	})
 }
 
+// VerifyOTP 驗證 OTP code, 驗證失敗時會把 code 當成恢復碼再驗證一次, 恢復碼使用後即失效
 func (uc *AccountUsecase) VerifyOTP(ctx context.Context, accountUUID string, request domain.VerifyOTPRequest) error {
-	panic("not implemented")
+	var account *domain.Account
+	var err error
+
+	if accountUUID != "" {
+		account, err = uc.accountRepo.AccountByUUID(ctx, request.Namespace, accountUUID)
+	} else {
+		account, err = uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if account.OTPEnable == 0 || account.OTPSecret == "" {
+		return domain.ErrOTPNotEnabled
+	}
+
+	switch account.State {
+	case domain.AccountStatusLocked:
+		return domain.ErrAccountLocked
+	case domain.AccountStatusDisabled:
+		return domain.ErrAccountDisabled
+	}
+
+	if totp.Validate(request.OTPCode, account.OTPSecret) {
+		return uc.clearFailedAttempts(ctx, account)
+	}
+
+	codeHash := hashOTPRecoveryCode(request.OTPCode)
+
+	entry := &auditEntry{
+		Namespace: "identity.account",
+		Action:    "use_otp_recovery_code",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Actor:     otpAccountName(account),
+		Snapshot:  uc.otpSnapshot(account.Namespace, account.ID),
+	}
+	err = audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		err := uc.accountRepo.UseOTPRecoveryCode(ctx, account.ID, codeHash)
+		if err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return domain.ErrOTPCodeIncorrect
+			}
+			return err
+		}
+
+		remaining, err := uc.accountRepo.CountOTPRecoveryCodes(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+
+		entry.Message = fmt.Sprintf("otp recovery code is used, %d codes remaining", remaining)
+		return nil
+	})
+	if errors.Is(err, domain.ErrOTPCodeIncorrect) {
+		return uc.recordOTPFailure(ctx, account)
+	}
+	if err != nil {
+		return err
+	}
+
+	return uc.clearFailedAttempts(ctx, account)
+}
+
+// recordOTPFailure OTP 驗證失敗沿用登入失敗的次數, 達到 maxFailedOTPAttempts 時鎖定帳號, 必須由 admin 解鎖
+func (uc *AccountUsecase) recordOTPFailure(ctx context.Context, account *domain.Account) error {
+	entry := &auditEntry{
+		Namespace: "identity.account",
+		Action:    "verify_otp_failed",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "otp code is incorrect",
+		Actor:     otpAccountName(account),
+		Snapshot:  uc.loginSnapshot(account.Namespace, account.ID),
+	}
+	err := audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		account.FailedPasswordAttempt = account.FailedPasswordAttempt + 1
+		if account.FailedPasswordAttempt >= maxFailedOTPAttempts {
+			account.State = domain.AccountStatusLocked
+			entry.Message = "otp code is incorrect too many times, account is locked"
+		}
+		return uc.accountRepo.UpdateAccount(ctx, account)
+	})
+	if err != nil {
+		return err
+	}
+
+	return domain.ErrOTPCodeIncorrect
+}
+
+// clearFailedAttempts OTP 驗證通過才算完成登入, 這時才清除登入失敗次數
+func (uc *AccountUsecase) clearFailedAttempts(ctx context.Context, account *domain.Account) error {
+	if account.FailedPasswordAttempt == 0 {
+		return nil
+	}
+
+	entry := &auditEntry{
+		Namespace: "identity.account",
+		Action:    "verify_otp",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   "otp is verified and failed attempts are cleared",
+		Actor:     otpAccountName(account),
+		Snapshot:  uc.loginSnapshot(account.Namespace, account.ID),
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		account.FailedPasswordAttempt = 0
+		return uc.accountRepo.UpdateAccount(ctx, account)
+	})
+}
+
+// GenerateOTPRecoveryCodes 重新產生恢復碼, 舊的恢復碼會全部失效
+func (uc *AccountUsecase) GenerateOTPRecoveryCodes(ctx context.Context, request domain.GenerateOTPRecoveryCodesRequest) ([]string, error) {
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.OTPEnable == 0 {
+		return nil, domain.ErrOTPNotEnabled
+	}
+
+	codes, codeHashes, err := generateOTPRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "generate_otp_recovery_codes",
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   fmt.Sprintf("%d otp recovery codes are generated", len(codes)),
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.otpSnapshot(account.Namespace, account.ID),
+	}, func(ctx context.Context) error {
+		return uc.accountRepo.CreateOTPRecoveryCodes(ctx, account.ID, codeHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
 }
 
 func (uc *AccountUsecase) AddRolesToAccount(ctx context.Context, request domain.AddRolesToAccountRequest) error {
-	return uc.accountRepo.AddRolesToAccount(ctx, request)
+	return audit(ctx, uc.eventLogRepo, &auditEntry{
+		Namespace: "identity.account",
+		Action:    "add_roles",
+		TargetID:  strconv.FormatUint(request.AccountID, 10),
+		Message:   fmt.Sprintf("%d roles are assigned to account", len(request.RoleIDs)),
+		Actor:     request.UpdaterName,
+		Snapshot:  uc.roleSnapshot(request.Namespace, request.AccountID),
+	}, func(ctx context.Context) error {
+		return uc.accountRepo.AddRolesToAccount(ctx, request)
+	})
+}
+
+// accountSnapshot 讀取帳號目前的資料做為 event log 的狀態, 密碼與 OTP secret 在寫入前會被遮蔽
+func (uc *AccountUsecase) accountSnapshot(namespace string, accountID uint64) func(ctx context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		return uc.accountRepo.Account(ctx, namespace, accountID)
+	}
+}
+
+// loginSnapshot 只記錄登入相關的欄位, 登入失敗次數與最後登入時間
+func (uc *AccountUsecase) loginSnapshot(namespace string, accountID uint64) func(ctx context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		account, err := uc.accountRepo.Account(ctx, namespace, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"state":                   account.State,
+			"failed_password_attempt": account.FailedPasswordAttempt,
+			"last_login_at":           account.LastLoginAt,
+		}, nil
+	}
+}
+
+// otpSnapshot 只記錄 OTP 的狀態與剩下的恢復碼數量
+func (uc *AccountUsecase) otpSnapshot(namespace string, accountID uint64) func(ctx context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		account, err := uc.accountRepo.Account(ctx, namespace, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCodes, err := uc.accountRepo.CountOTPRecoveryCodes(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"otp_enable":         account.OTPEnable,
+			"otp_last_reset_at":  account.OTPLastResetAt,
+			"otp_recovery_codes": recoveryCodes,
+		}, nil
+	}
+}
+
+// roleSnapshot 記錄帳號目前的角色, 依照 role id 排序
+func (uc *AccountUsecase) roleSnapshot(namespace string, accountID uint64) func(ctx context.Context) (interface{}, error) {
+	return func(ctx context.Context) (interface{}, error) {
+		roles, err := uc.roleRepo.RolesByAccountID(ctx, namespace, accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(roles, func(i, j int) bool {
+			return roles[i].ID < roles[j].ID
+		})
+
+		roleIDs := make([]uint64, 0, len(roles))
+		for _, role := range roles {
+			roleIDs = append(roleIDs, role.ID)
+		}
+
+		return &accountRolesStatus{
+			Namespace: namespace,
+			AccountID: accountID,
+			RoleIDs:   roleIDs,
+			Roles:     roles,
+		}, nil
+	}
+}
+
+// accountRolesStatus 是帳號的角色記錄在 event log 的狀態, 角色的格式與 roleStatus 相同,
+// domainEventsFromEventLog 依照前後的角色發佈 role.assigned 與 role.unassigned
+type accountRolesStatus struct {
+	Namespace string        `json:"namespace"`
+	AccountID uint64        `json:"account_id"`
+	RoleIDs   []uint64      `json:"role_ids"`
+	Roles     []domain.Role `json:"roles"`
 }
 
 func encryptPassword(password string) (string, error) {
@@ -402,6 +694,103 @@ func encryptPassword(password string) (string, error) {
 	return string(newEncrypt), nil
 }
 
+const (
+	// maxFailedOTPAttempts 登入與 OTP 驗證連續失敗的次數上限, 達到時鎖定帳號
+	maxFailedOTPAttempts = 5
+
+	otpRecoveryCodeCount    = 10
+	otpRecoveryCodeLength   = 10
+	otpRecoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// generateOTPRecoveryCodes 產生恢復碼, 回傳給使用者的明碼以及要儲存的 hash
+func generateOTPRecoveryCodes() ([]string, []*domain.OTPRecoveryCode, error) {
+	codes := make([]string, 0, otpRecoveryCodeCount)
+	recoveryCodes := make([]*domain.OTPRecoveryCode, 0, otpRecoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(otpRecoveryCodeAlphabet)))
+
+	for i := 0; i < otpRecoveryCodeCount; i++ {
+		// 用 rand.Int 取得均勻分布的索引, 直接對 byte 取餘數會讓字母表前面的字元比較常出現
+		buf := make([]byte, otpRecoveryCodeLength)
+		for j := range buf {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			buf[j] = otpRecoveryCodeAlphabet[n.Int64()]
+		}
+
+		code := string(buf[:otpRecoveryCodeLength/2]) + "-" + string(buf[otpRecoveryCodeLength/2:])
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, &domain.OTPRecoveryCode{
+			CodeHash: hashOTPRecoveryCode(code),
+		})
+	}
+
+	return codes, recoveryCodes, nil
+}
+
+// hashOTPRecoveryCode 忽略大小寫、空白與連字號, 讓使用者輸入時比較寬鬆
+func hashOTPRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func otpAccountName(account *domain.Account) string {
+	switch {
+	case account.Username.Valid:
+		return account.Username.String
+	case account.Email.Valid:
+		return account.Email.String
+	case account.Mobile.Valid:
+		return account.MobileCountryCode.String + account.Mobile.String
+	default:
+		return account.UUID
+	}
+}
+
+// createLoginLog 紀錄登入結果, 查不到 IP 的位置時仍然寫入 login log, 不影響登入
+func (uc *AccountUsecase) createLoginLog(ctx context.Context, request domain.LoginInfo, accountID uint64, state domain.LoginLogState) error {
+	logger := log.FromContext(ctx)
+
+	countryCode, cityName, err := lookupLocation(uc.ipDB, request.ClientIP)
+	if err != nil {
+		logger.Err(err).Str("client_ip", request.ClientIP).Warn("usecase: lookup login location failed")
+	}
+
+	return uc.loginRepo.CreateLoginLog(ctx, &domain.LoginLog{
+		Namespace:   request.Namespace,
+		TargetID:    strconv.FormatUint(accountID, 10),
+		CountryCode: countryCode,
+		CityName:    cityName,
+		DeviceType:  request.DeviceType,
+		State:       state,
+		ClientIP:    request.ClientIP,
+	})
+}
+
+// lookupLocation 用 GeoIP 查詢 IP 所在的國家與城市, clientIP 是空的時候直接回傳空字串
+func lookupLocation(ipDB *geoip2.Reader, clientIP string) (string, string, error) {
+	if ipDB == nil || len(clientIP) == 0 {
+		return "", "", nil
+	}
+
+	ip := net.ParseIP(clientIP)
+	record, err := ipDB.City(ip)
+	if err != nil {
+		return "", "", err
+	}
+
+	var cityName string
+	if len(record.Subdivisions) > 0 {
+		cityName = record.Subdivisions[0].Names["zh-CN"]
+	}
+
+	return record.Country.IsoCode, cityName, nil
+}
+
 // isPasswordValid 比對password是否正確
 func isPasswordValid(encryptPassword, oldPassword string) error {
 	// compare password
@@ -0,0 +1,243 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"identity/pkg/domain"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+)
+
+type APIKeyTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	apiKeys     *fakeAPIKeyRepo
+	accounts    *fakeAccountRepo
+	eventLogs   *fakeEventLogRepo
+	tokenSvc    domain.TokenUsecase
+	usecase     *APIKeyUsecase
+	namespace   string
+}
+
+func TestAPIKeyTestSuite(t *testing.T) {
+	suite.Run(t, &APIKeyTestSuite{namespace: "test.identity"})
+}
+
+func (suite *APIKeyTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	suite.accounts = &fakeAccountRepo{accounts: []*domain.Account{
+		{ID: 1, Namespace: suite.namespace, Username: sql.NullString{String: "angela", Valid: true}, State: domain.AccountStatusNormal},
+		{ID: 2, Namespace: suite.namespace, Username: sql.NullString{String: "ci-bot", Valid: true}, Type: domain.AccountTypeService, State: domain.AccountStatusNormal},
+	}}
+	roles := &fakeRoleRepo{roles: map[uint64][]domain.Role{
+		2: {
+			{Name: "deployer", State: domain.RoleStatusNormal},
+			{Name: "admin", State: domain.RoleStatusDisabled},
+		},
+	}}
+
+	suite.apiKeys = &fakeAPIKeyRepo{}
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.usecase = NewAPIKeyUsecase(suite.apiKeys, suite.accounts, roles, suite.eventLogs)
+	suite.tokenSvc = NewTokenUsecase(identityRedis.NewTokenRepo(client), identityRedis.NewSessionRepo(client), &fakeEventLogRepo{}, nil, TokenOptions{
+		APIKeySvc: suite.usecase,
+	})
+}
+
+func (suite *APIKeyTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+type fakeAPIKeyRepo struct {
+	keys []domain.APIKey
+}
+
+func (repo *fakeAPIKeyRepo) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	key.ID = uint64(len(repo.keys) + 1)
+	key.CreatedAt = time.Now().UTC()
+	repo.keys = append(repo.keys, *key)
+	return nil
+}
+
+func (repo *fakeAPIKeyRepo) APIKey(ctx context.Context, keyID string) (*domain.APIKey, error) {
+	for i := range repo.keys {
+		if repo.keys[i].KeyID == keyID {
+			key := repo.keys[i]
+			return &key, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeAPIKeyRepo) APIKeysByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.APIKey, error) {
+	result := []domain.APIKey{}
+	for _, key := range repo.keys {
+		if key.Namespace == namespace && key.AccountID == accountID {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeAPIKeyRepo) DeleteAPIKey(ctx context.Context, keyID string) error {
+	for i := range repo.keys {
+		if repo.keys[i].KeyID == keyID {
+			repo.keys = append(repo.keys[:i], repo.keys[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+func (repo *fakeAPIKeyRepo) TouchAPIKey(ctx context.Context, keyID string, lastUsedAt time.Time) error {
+	for i := range repo.keys {
+		if repo.keys[i].KeyID == keyID {
+			repo.keys[i].LastUsedAt = lastUsedAt
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+// fakeRoleRepo 只實作 RolesByAccountID
+type fakeRoleRepo struct {
+	domain.RoleRepository
+	roles map[uint64][]domain.Role
+}
+
+func (repo *fakeRoleRepo) RolesByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.Role, error) {
+	return repo.roles[accountID], nil
+}
+
+func (suite *APIKeyTestSuite) TestCreateAndResolveAPIKey() {
+	ctx := context.Background()
+
+	key, apiKey, err := suite.usecase.CreateAPIKey(ctx, domain.CreateAPIKeyRequest{
+		Namespace: suite.namespace,
+		AccountID: 2,
+		Name:      "github actions",
+		Scopes:    "deploy  read",
+	})
+	suite.Require().NoError(err)
+	suite.True(strings.HasPrefix(apiKey, key.KeyID+"_"))
+	suite.True(domain.IsAPIKey(apiKey))
+	suite.Equal("deploy read", key.Scopes)
+	suite.NotContains(key.SecretHash, strings.TrimPrefix(apiKey, key.KeyID+"_"))
+
+	token, err := suite.tokenSvc.Token(ctx, apiKey)
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), token.AccountID)
+	suite.Equal("ci-bot", token.Username)
+	suite.Equal(int32(domain.AccountTypeService), token.AccountType)
+	suite.Equal(int64(0), token.ExpiresIn)
+	suite.Equal("deployer", token.Claims[domain.ClaimRole])
+	suite.Equal("deploy read", token.Claims[domain.ClaimScope])
+	suite.Equal(key.KeyID, token.Claims[domain.ClaimAPIKeyID])
+	suite.True(token.HasAMR(domain.AMRAPIKey))
+
+	keys, err := suite.usecase.APIKeys(ctx, suite.namespace, 2)
+	suite.Require().NoError(err)
+	suite.Require().Len(keys, 1)
+	suite.False(keys[0].LastUsedAt.IsZero())
+
+	_, err = suite.tokenSvc.Token(ctx, key.KeyID+"_"+strings.Repeat("0", 64))
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+	_, err = suite.tokenSvc.Token(ctx, domain.APIKeyPrefix+"unknown_secret")
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	suite.Equal("identity.api_key", suite.eventLogs.eventLogs[0].Namespace)
+	suite.Equal("create", suite.eventLogs.eventLogs[0].Action)
+	suite.NotContains(string(suite.eventLogs.eventLogs[0].NewStatus), apiKey)
+}
+
+func (suite *APIKeyTestSuite) TestExpiredAPIKey() {
+	ctx := context.Background()
+
+	key, apiKey, err := suite.usecase.CreateAPIKey(ctx, domain.CreateAPIKeyRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		Name:      "laptop",
+		ExpiresIn: time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	token, err := suite.usecase.ResolveAPIKey(ctx, apiKey)
+	suite.Require().NoError(err)
+	suite.InDelta(int64(time.Hour/time.Second), token.ExpiresIn, 2)
+
+	suite.apiKeys.keys[0].ExpiresAt = time.Now().Add(-time.Second)
+	_, err = suite.usecase.ResolveAPIKey(ctx, apiKey)
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+
+	// 過期的 key 還是會出現在列表, 直到被撤銷
+	keys, err := suite.usecase.APIKeys(ctx, suite.namespace, 1)
+	suite.Require().NoError(err)
+	suite.Len(keys, 1)
+	suite.Equal(key.KeyID, keys[0].KeyID)
+}
+
+func (suite *APIKeyTestSuite) TestRevokeAPIKey() {
+	ctx := context.Background()
+
+	key, apiKey, err := suite.usecase.CreateAPIKey(ctx, domain.CreateAPIKeyRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		Name:      "laptop",
+	})
+	suite.Require().NoError(err)
+
+	// 不能撤銷其他帳號的 key
+	err = suite.usecase.RevokeAPIKey(ctx, domain.RevokeAPIKeyRequest{Namespace: suite.namespace, AccountID: 2, KeyID: key.KeyID})
+	suite.ErrorIs(err, domain.ErrNotFound)
+
+	err = suite.usecase.RevokeAPIKey(ctx, domain.RevokeAPIKeyRequest{Namespace: suite.namespace, AccountID: 1, KeyID: key.KeyID})
+	suite.Require().NoError(err)
+
+	_, err = suite.tokenSvc.Token(ctx, apiKey)
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	suite.Equal("revoke", suite.eventLogs.eventLogs[1].Action)
+	suite.Equal("angela", suite.eventLogs.eventLogs[1].Actor)
+}
+
+func (suite *APIKeyTestSuite) TestDisabledAccount() {
+	ctx := context.Background()
+
+	_, apiKey, err := suite.usecase.CreateAPIKey(ctx, domain.CreateAPIKeyRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		Name:      "laptop",
+	})
+	suite.Require().NoError(err)
+
+	suite.accounts.accounts[0].State = domain.AccountStatusDisabled
+	_, err = suite.usecase.ResolveAPIKey(ctx, apiKey)
+	suite.ErrorIs(err, domain.ErrAccountDisabled)
+}
+
+func (suite *APIKeyTestSuite) TestServiceAccountLogin() {
+	accountSvc := &AccountUsecase{accountRepo: suite.accounts}
+
+	_, err := accountSvc.Login(context.Background(), domain.LoginInfo{
+		Namespace: suite.namespace,
+		LoginType: domain.LoginTypeUsername,
+		Username:  "ci-bot",
+		Password:  "password",
+	})
+	suite.ErrorIs(err, domain.ErrServiceAccountLogin)
+}
@@ -0,0 +1,251 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/datatypes"
+)
+
+// apiKeyTouchInterval 避免每次使用 API key 都更新 last_used_at
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyUsecase struct {
+	apiKeyRepo   domain.APIKeyRepository
+	accountRepo  domain.AccountRepository
+	roleRepo     domain.RoleRepository
+	eventLogRepo domain.EventLogRepository
+}
+
+func NewAPIKeyUsecase(apiKeyRepo domain.APIKeyRepository, accountRepo domain.AccountRepository, roleRepo domain.RoleRepository, eventLogRepo domain.EventLogRepository) *APIKeyUsecase {
+	return &APIKeyUsecase{
+		apiKeyRepo:   apiKeyRepo,
+		accountRepo:  accountRepo,
+		roleRepo:     roleRepo,
+		eventLogRepo: eventLogRepo,
+	}
+}
+
+func (uc *APIKeyUsecase) CreateAPIKey(ctx context.Context, request domain.CreateAPIKeyRequest) (*domain.APIKey, string, error) {
+	if request.Name == "" || len(request.Name) > 64 || request.ExpiresIn < 0 {
+		return nil, "", domain.ErrInvalidInput
+	}
+
+	// 模擬登入時不能替使用者建立長效的憑證
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	account, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if account.State != domain.AccountStatusNormal {
+		return nil, "", domain.ErrAccountDisabled
+	}
+
+	keyID, err := randomString(8)
+	if err != nil {
+		return nil, "", err
+	}
+	keyID = domain.APIKeyPrefix + keyID
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := domain.APIKey{
+		Namespace:  account.Namespace,
+		AccountID:  account.ID,
+		Name:       request.Name,
+		KeyID:      keyID,
+		SecretHash: hashAPIKeySecret(secret),
+		Scopes:     strings.Join(strings.Fields(request.Scopes), " "),
+		ExpiresAt:  time.Unix(0, 0).UTC(),
+	}
+	if request.ExpiresIn > 0 {
+		key.ExpiresAt = time.Now().Add(request.ExpiresIn).UTC()
+	}
+
+	err = uc.apiKeyRepo.CreateAPIKey(ctx, &key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uc.recordAPIKey(ctx, "create", account, &key)
+
+	return &key, keyID + "_" + secret, nil
+}
+
+func (uc *APIKeyUsecase) APIKeys(ctx context.Context, namespace string, accountID uint64) ([]domain.APIKey, error) {
+	return uc.apiKeyRepo.APIKeysByAccountID(ctx, namespace, accountID)
+}
+
+// RevokeAPIKey 帳號只能撤銷自己的 key
+func (uc *APIKeyUsecase) RevokeAPIKey(ctx context.Context, request domain.RevokeAPIKeyRequest) error {
+	key, err := uc.apiKeyRepo.APIKey(ctx, request.KeyID)
+	if err != nil {
+		return err
+	}
+
+	if key.Namespace != request.Namespace || key.AccountID != request.AccountID {
+		return fmt.Errorf("api key %s was not found. %w", request.KeyID, domain.ErrNotFound)
+	}
+
+	account, err := uc.accountRepo.Account(ctx, key.Namespace, key.AccountID)
+	if err != nil {
+		return err
+	}
+
+	err = uc.apiKeyRepo.DeleteAPIKey(ctx, key.KeyID)
+	if err != nil {
+		return err
+	}
+
+	uc.recordAPIKey(ctx, "revoke", account, key)
+
+	return nil
+}
+
+func (uc *APIKeyUsecase) ResolveAPIKey(ctx context.Context, apiKey string) (*domain.Token, error) {
+	logger := log.FromContext(ctx)
+
+	keyID, secret, ok := splitAPIKey(apiKey)
+	if !ok {
+		return nil, domain.ErrInvalidToken
+	}
+
+	key, err := uc.apiKeyRepo.APIKey(ctx, keyID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, domain.ErrInvalidToken
+	}
+
+	now := time.Now()
+	if key.IsExpired(now) {
+		return nil, domain.ErrInvalidToken
+	}
+
+	account, err := uc.accountRepo.Account(ctx, key.Namespace, key.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch account.State {
+	case domain.AccountStatusLocked:
+		return nil, domain.ErrAccountLocked
+	case domain.AccountStatusDisabled:
+		return nil, domain.ErrAccountDisabled
+	}
+
+	roles, err := uc.roleRepo.RolesByAccountID(ctx, account.Namespace, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role.State == domain.RoleStatusNormal {
+			roleNames = append(roleNames, role.Name)
+		}
+	}
+
+	if now.Sub(key.LastUsedAt) > apiKeyTouchInterval {
+		err = uc.apiKeyRepo.TouchAPIKey(ctx, key.KeyID, now.UTC())
+		if err != nil {
+			logger.Err(err).Str("key_id", key.KeyID).Warn("usecase: touch api key failed")
+		}
+	}
+
+	var expiresIn int64
+	if key.ExpiresAt.Unix() > 0 {
+		expiresIn = int64(key.ExpiresAt.Sub(now) / time.Second)
+		if expiresIn < 1 {
+			expiresIn = 1
+		}
+	}
+
+	return &domain.Token{
+		AccountID:   int64(account.ID),
+		Namespace:   account.Namespace,
+		ExpiresIn:   expiresIn,
+		TokenString: key.KeyID,
+		Username:    account.Username.String,
+		AccountType: int32(account.Type),
+		Claims: map[string]string{
+			domain.ClaimAPIKeyID: key.KeyID,
+			domain.ClaimScope:    key.Scopes,
+			domain.ClaimRole:     strings.Join(roleNames, " "),
+			domain.ClaimAMR:      domain.AMRAPIKey,
+		},
+	}, nil
+}
+
+// recordAPIKey 把 API key 的建立與撤銷記錄到 event log
+func (uc *APIKeyUsecase) recordAPIKey(ctx context.Context, action string, account *domain.Account, key *domain.APIKey) {
+	logger := log.FromContext(ctx)
+
+	newStatus, err := json.Marshal(map[string]interface{}{
+		"namespace":  key.Namespace,
+		"key_id":     key.KeyID,
+		"name":       key.Name,
+		"scopes":     key.Scopes,
+		"expires_at": key.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		logger.Err(err).Error("usecase: marshal api key event failed")
+		return
+	}
+
+	err = createAuditLog(ctx, uc.eventLogRepo, &domain.EventLog{
+		Namespace: "identity.api_key",
+		Action:    action,
+		TargetID:  strconv.FormatUint(account.ID, 10),
+		Message:   fmt.Sprintf("api key %s is %sd", key.KeyID, action),
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     domain.EventLogSuccess,
+		Actor:     otpAccountName(account),
+	})
+	if err != nil {
+		logger.Err(err).Str("key_id", key.KeyID).Error("usecase: create api key event log failed")
+	}
+}
+
+// splitAPIKey 完整的 key 為 KeyID + "_" + secret, secret 是 hex 字串不會有底線
+func splitAPIKey(apiKey string) (string, string, bool) {
+	if !domain.IsAPIKey(apiKey) {
+		return "", "", false
+	}
+
+	idx := strings.LastIndex(apiKey, "_")
+	if idx <= len(domain.APIKeyPrefix) || idx == len(apiKey)-1 {
+		return "", "", false
+	}
+	return apiKey[:idx], apiKey[idx+1:], true
+}
+
+// hashAPIKeySecret secret 是 32 bytes 的亂數, 用 sha256 就足夠, 每次呼叫都要驗證所以不使用 bcrypt
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
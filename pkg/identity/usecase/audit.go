@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+
+	"gorm.io/datatypes"
+)
+
+// auditEntry 描述一次異動要寫入的 event log, TargetID 與 Message 可以在 mutate 裡面補上
+type auditEntry struct {
+	Namespace string
+	Action    string
+	TargetID  string
+	Message   string
+	// Actor 是 request 帶入的操作者, context 裡面有呼叫端的身分時以 context 為準
+	Actor string
+	// Snapshot 讀取目前的狀態, 在異動前後各呼叫一次, 回傳 nil 代表沒有狀態
+	Snapshot func(ctx context.Context) (interface{}, error)
+}
+
+// audit 在同一個 transaction 裡面取得異動前的 snapshot, 執行 mutate 之後再取得異動後的 snapshot, 寫成一筆 event log
+// mutate 或寫入 event log 失敗時整個 transaction 會 rollback
+func audit(ctx context.Context, eventLogRepo domain.EventLogRepository, entry *auditEntry, mutate func(ctx context.Context) error) error {
+	return database.Transaction(ctx, func(ctx context.Context) error {
+		oldStatus, err := entry.snapshot(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = mutate(ctx)
+		if err != nil {
+			return err
+		}
+
+		newStatus, err := entry.snapshot(ctx)
+		if err != nil {
+			return err
+		}
+
+		actor := domain.AuditFromContext(ctx).Actor
+		if actor == "" {
+			actor = entry.Actor
+		}
+
+		return createAuditLog(ctx, eventLogRepo, &domain.EventLog{
+			Namespace: entry.Namespace,
+			Action:    entry.Action,
+			TargetID:  entry.TargetID,
+			Message:   entry.Message,
+			OldStatus: oldStatus,
+			NewStatus: newStatus,
+			State:     domain.EventLogSuccess,
+			Actor:     actor,
+		})
+	})
+}
+
+func (entry *auditEntry) snapshot(ctx context.Context) (datatypes.JSON, error) {
+	if entry.Snapshot == nil {
+		return datatypes.JSON([]byte("{}")), nil
+	}
+
+	status, err := entry.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return datatypes.JSON([]byte("{}")), nil
+	}
+
+	return json.Marshal(status)
+}
+
+// createAuditLog 是所有 event log 寫入的入口, 沒有指定 ClientIP 與 Actor 時使用 context 裡面的呼叫端資訊,
+// OldStatus 與 NewStatus 的敏感欄位在寫入前就會被遮蔽
+func createAuditLog(ctx context.Context, eventLogRepo domain.EventLogRepository, eventLog *domain.EventLog) error {
+	caller := domain.AuditFromContext(ctx)
+	if eventLog.ClientIP == "" {
+		eventLog.ClientIP = caller.ClientIP
+	}
+	if eventLog.Actor == "" {
+		eventLog.Actor = caller.Actor
+	}
+
+	if len(eventLog.OldStatus) == 0 {
+		eventLog.OldStatus = datatypes.JSON([]byte("{}"))
+	}
+	if len(eventLog.NewStatus) == 0 {
+		eventLog.NewStatus = datatypes.JSON([]byte("{}"))
+	}
+	eventLog.OldStatus, eventLog.NewStatus = domain.RedactStatusChange(eventLog.OldStatus, eventLog.NewStatus)
+
+	return eventLogRepo.CreateEventLog(ctx, eventLog)
+}
@@ -0,0 +1,429 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+	accounts  *fakeAuditAccountRepo
+	roles     *fakeSCIMRoleRepo
+	eventLogs *fakeEventLogRepo
+	usecase   *AccountUsecase
+	namespace string
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, &AuditTestSuite{namespace: "test.identity"})
+}
+
+func (suite *AuditTestSuite) SetupTest() {
+	database.SetMockMode(true)
+
+	passwordEncrypt, err := encryptPassword("password")
+	suite.Require().NoError(err)
+
+	suite.roles = &fakeSCIMRoleRepo{
+		roles: []*domain.Role{
+			{ID: 1, Namespace: suite.namespace, Name: "finance"},
+			{ID: 2, Namespace: suite.namespace, Name: "support"},
+		},
+		members: map[uint64][]uint64{1: {1}},
+	}
+	suite.accounts = &fakeAuditAccountRepo{
+		fakeAccountRepo: fakeAccountRepo{accounts: []*domain.Account{
+			{ID: 1, Namespace: suite.namespace, Username: sql.NullString{String: "angela", Valid: true}, FirstName: "angela", PasswordEncrypt: passwordEncrypt, OTPSecret: "JBSWY3DPEHPK3PXP", State: domain.AccountStatusNormal, Version: 1},
+		}},
+		roles: suite.roles,
+	}
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.usecase = &AccountUsecase{accountRepo: suite.accounts, roleRepo: suite.roles, eventLogRepo: suite.eventLogs, loginRepo: &fakeLoginLogRepo{}}
+}
+
+func (suite *AuditTestSuite) TearDownTest() {
+	database.SetMockMode(false)
+}
+
+func (suite *AuditTestSuite) changes(eventLog *domain.EventLog) map[string][2]string {
+	result := map[string][2]string{}
+	for _, change := range domain.DiffStatus(eventLog.OldStatus, eventLog.NewStatus) {
+		result[change.Field] = [2]string{string(change.OldValue), string(change.NewValue)}
+	}
+	return result
+}
+
+func (suite *AuditTestSuite) TestUpdateAccount() {
+	ctx := domain.NewAuditContext(context.Background(), domain.Audit{Actor: "admin", ClientIP: "182.48.113.104"})
+
+	request := *suite.accounts.accounts[0]
+	request.FirstName = "Angela"
+	request.UpdaterName = "spoofed"
+	suite.Require().NoError(suite.usecase.UpdateAccount(ctx, &request))
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	eventLog := suite.eventLogs.eventLogs[0]
+	suite.Equal("update", eventLog.Action)
+	suite.Equal("1", eventLog.TargetID)
+	// 操作者與 IP 來自 context, 不是 request 帶入的名稱
+	suite.Equal("admin", eventLog.Actor)
+	suite.Equal("182.48.113.104", eventLog.ClientIP)
+
+	// newStatus 是更新之後的資料
+	changes := suite.changes(eventLog)
+	suite.Equal([2]string{`"angela"`, `"Angela"`}, changes["FirstName"])
+	suite.Equal([2]string{"1", "2"}, changes["Version"])
+	suite.NotContains(changes, "PasswordEncrypt")
+	suite.NotContains(changes, "OTPSecret")
+
+	// 寫入的狀態已經遮蔽密碼與 OTP secret
+	suite.NotContains(string(eventLog.OldStatus), suite.accounts.accounts[0].PasswordEncrypt)
+	suite.NotContains(string(eventLog.NewStatus), "JBSWY3DPEHPK3PXP")
+}
+
+func (suite *AuditTestSuite) TestUpdateAccountPassword() {
+	ctx := context.Background()
+	oldHash := suite.accounts.accounts[0].PasswordEncrypt
+
+	err := suite.usecase.UpdateAccountPassword(ctx, domain.UpdateAccountPasswordRequest{
+		Namespace:   suite.namespace,
+		AccountID:   1,
+		OldPassword: "wrong",
+		NewPassword: "new password",
+		UpdaterName: "angela",
+	})
+	suite.ErrorIs(err, domain.ErrUsernameOrPasswordIncorrect)
+	suite.Empty(suite.eventLogs.eventLogs)
+
+	err = suite.usecase.UpdateAccountPassword(ctx, domain.UpdateAccountPasswordRequest{
+		Namespace:   suite.namespace,
+		AccountID:   1,
+		OldPassword: "password",
+		NewPassword: "new password",
+		UpdaterID:   1,
+		UpdaterName: "angela",
+	})
+	suite.Require().NoError(err)
+	err = suite.usecase.ForceUpdateAccountPassword(ctx, domain.ForceUpdateAccountPasswordRequest{
+		Namespace:   suite.namespace,
+		AccountID:   1,
+		NewPassword: "reset password",
+		UpdaterID:   2,
+		UpdaterName: "admin",
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	actions := []string{}
+	for _, eventLog := range suite.eventLogs.eventLogs {
+		actions = append(actions, eventLog.Action)
+
+		// 密碼有異動但是不會留下 hash
+		suite.Equal([2]string{domain.RedactedValue, domain.RedactedValue}, suite.changes(eventLog)["PasswordEncrypt"])
+		suite.NotContains(string(eventLog.OldStatus), oldHash)
+		suite.NotContains(string(eventLog.NewStatus), suite.accounts.accounts[0].PasswordEncrypt)
+	}
+	suite.Equal([]string{"update_password", "force_update_password"}, actions)
+	// context 沒有呼叫端身分時使用 request 帶入的操作者
+	suite.Equal("angela", suite.eventLogs.eventLogs[0].Actor)
+	suite.Equal("admin", suite.eventLogs.eventLogs[1].Actor)
+}
+
+func (suite *AuditTestSuite) TestChangeState() {
+	ctx := context.Background()
+
+	err := suite.usecase.ChangeState(ctx, domain.ChangeStateRequest{
+		Namespace:   suite.namespace,
+		AccountID:   1,
+		State:       domain.AccountStatusLocked,
+		UpdaterID:   domain.SystemID,
+		UpdaterName: domain.SystemName,
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	eventLog := suite.eventLogs.eventLogs[0]
+	suite.Equal("change state from normal to locked", eventLog.Message)
+	suite.Equal(domain.SystemName, eventLog.Actor)
+	suite.Equal([2]string{"1", "3"}, suite.changes(eventLog)["State"])
+}
+
+func (suite *AuditTestSuite) TestAddRolesToAccount() {
+	ctx := domain.NewAuditContext(context.Background(), domain.Audit{Actor: "admin", ClientIP: "10.0.0.1"})
+
+	err := suite.usecase.AddRolesToAccount(ctx, domain.AddRolesToAccountRequest{
+		Namespace:   suite.namespace,
+		AccountID:   1,
+		RoleIDs:     []uint64{2, 1},
+		UpdaterName: "admin",
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	eventLog := suite.eventLogs.eventLogs[0]
+	suite.Equal("add_roles", eventLog.Action)
+	suite.Equal("10.0.0.1", eventLog.ClientIP)
+	suite.Equal([2]string{"[1]", "[1,2]"}, suite.changes(eventLog)["role_ids"])
+
+	// 沒有經過 SCIM 的角色異動也要發佈 role.assigned
+	suite.Equal([]string{"role.assigned:1:support"}, suite.events(eventLog))
+
+	err = suite.usecase.AddRolesToAccount(ctx, domain.AddRolesToAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		RoleIDs:   []uint64{2},
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	suite.Equal([]string{"role.unassigned:1:finance"}, suite.events(suite.eventLogs.eventLogs[1]))
+}
+
+// events 回傳 event log 轉出來的領域事件, 格式為 type:target_id:role_name
+func (suite *AuditTestSuite) events(eventLog *domain.EventLog) []string {
+	result := []string{}
+	for _, event := range domainEventsFromEventLog(eventLog) {
+		suite.Equal(suite.namespace, event.Namespace)
+
+		data := map[string]interface{}{}
+		suite.Require().NoError(json.Unmarshal(event.Data, &data))
+		roleName, _ := data["role_name"].(string)
+		if roleName == "" {
+			roleName, _ = data["name"].(string)
+		}
+		result = append(result, event.Type+":"+event.TargetID+":"+roleName)
+	}
+	return result
+}
+
+func (suite *AuditTestSuite) TestLogin() {
+	ctx := context.Background()
+	request := domain.LoginInfo{
+		Namespace: suite.namespace,
+		LoginType: domain.LoginTypeUsername,
+		Username:  "angela",
+		Password:  "wrong",
+	}
+
+	_, err := suite.usecase.Login(ctx, request)
+	suite.ErrorIs(err, domain.ErrUsernameOrPasswordIncorrect)
+
+	request.Password = "password"
+	_, err = suite.usecase.Login(ctx, request)
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	failed := suite.eventLogs.eventLogs[0]
+	suite.Equal("login_failed", failed.Action)
+	suite.Equal("angela", failed.Actor)
+	suite.Equal(map[string][2]string{
+		"failed_password_attempt": {"0", "1"},
+	}, suite.changes(failed))
+
+	login := suite.eventLogs.eventLogs[1]
+	suite.Equal("login", login.Action)
+	changes := suite.changes(login)
+	suite.Equal([2]string{"1", "0"}, changes["failed_password_attempt"])
+	suite.Contains(changes, "last_login_at")
+}
+
+func (suite *AuditTestSuite) TestVerifyOTPLockout() {
+	ctx := context.Background()
+	account := suite.accounts.accounts[0]
+	account.OTPEnable = 1
+
+	request := domain.VerifyOTPRequest{Namespace: suite.namespace, AccountID: account.ID, OTPCode: "000000"}
+	for i := 0; i < maxFailedOTPAttempts; i++ {
+		err := suite.usecase.VerifyOTP(ctx, "", request)
+		suite.Require().ErrorIs(err, domain.ErrOTPCodeIncorrect)
+	}
+	suite.Equal(int32(maxFailedOTPAttempts), account.FailedPasswordAttempt)
+	suite.Equal(domain.AccountStatusLocked, account.State)
+
+	// 鎖定之後即使是正確的 code 也不能通過
+	code, err := totp.GenerateCode(account.OTPSecret, time.Now())
+	suite.Require().NoError(err)
+	request.OTPCode = code
+	suite.ErrorIs(suite.usecase.VerifyOTP(ctx, "", request), domain.ErrAccountLocked)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, maxFailedOTPAttempts)
+	eventLog := suite.eventLogs.eventLogs[maxFailedOTPAttempts-1]
+	suite.Equal("verify_otp_failed", eventLog.Action)
+	suite.Equal("angela", eventLog.Actor)
+	suite.Equal(map[string][2]string{
+		"failed_password_attempt": {"4", "5"},
+		"state":                   {"1", "3"},
+	}, suite.changes(eventLog))
+}
+
+func (suite *AuditTestSuite) TestVerifyOTPClearsFailedAttempts() {
+	ctx := context.Background()
+	account := suite.accounts.accounts[0]
+	account.OTPEnable = 1
+
+	request := domain.VerifyOTPRequest{Namespace: suite.namespace, AccountID: account.ID, OTPCode: "000000"}
+	suite.Require().ErrorIs(suite.usecase.VerifyOTP(ctx, "", request), domain.ErrOTPCodeIncorrect)
+
+	// 密碼正確不會清除 OTP 的失敗次數
+	_, err := suite.usecase.Login(ctx, domain.LoginInfo{
+		Namespace: suite.namespace,
+		LoginType: domain.LoginTypeUsername,
+		Username:  "angela",
+		Password:  "password",
+	})
+	suite.Require().NoError(err)
+	suite.Equal(int32(1), account.FailedPasswordAttempt)
+
+	request.OTPCode, err = totp.GenerateCode(account.OTPSecret, time.Now())
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.usecase.VerifyOTP(ctx, "", request))
+	suite.Equal(int32(0), account.FailedPasswordAttempt)
+	suite.Equal("verify_otp", suite.eventLogs.eventLogs[len(suite.eventLogs.eventLogs)-1].Action)
+}
+
+func (suite *AuditTestSuite) TestAddAccountsToRole() {
+	ctx := domain.NewAuditContext(context.Background(), domain.Audit{Actor: "admin"})
+	roleSvc := NewRoleUsecase(suite.roles, suite.eventLogs)
+
+	err := roleSvc.AddAccountsToRole(ctx, suite.namespace, []uint64{3, 2}, 1)
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	eventLog := suite.eventLogs.eventLogs[0]
+	suite.Equal("identity.role", eventLog.Namespace)
+	suite.Equal("update_members", eventLog.Action)
+	suite.Equal("1", eventLog.TargetID)
+	suite.Equal("admin", eventLog.Actor)
+	suite.Equal(map[string][2]string{
+		"member_ids": {"[1]", "[2,3]"},
+	}, suite.changes(eventLog))
+	suite.Equal([]string{"role.assigned:2:finance", "role.assigned:3:finance", "role.unassigned:1:finance"}, suite.events(eventLog))
+
+	err = roleSvc.AddAccountsToRole(ctx, "other.namespace", []uint64{1}, 1)
+	suite.Require().ErrorIs(err, domain.ErrNotFound)
+}
+
+func (suite *AuditTestSuite) TestCreateAndUpdateRole() {
+	ctx := domain.NewAuditContext(context.Background(), domain.Audit{Actor: "admin"})
+	roleSvc := NewRoleUsecase(suite.roles, suite.eventLogs)
+
+	role := domain.Role{Namespace: suite.namespace, Name: "engineering", State: domain.RoleStatusNormal}
+	suite.Require().NoError(roleSvc.CreateRole(ctx, &role))
+
+	role.Desc = "engineers"
+	suite.Require().NoError(roleSvc.UpdateRole(ctx, &role))
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	suite.Equal([]string{"role.created:3:engineering"}, suite.events(suite.eventLogs.eventLogs[0]))
+	suite.Equal([]string{"role.updated:3:engineering"}, suite.events(suite.eventLogs.eventLogs[1]))
+}
+
+func (suite *AuditTestSuite) TestAuditRollback() {
+	ctx := context.Background()
+	failed := errors.New("update failed")
+
+	err := audit(ctx, suite.eventLogs, &auditEntry{Namespace: "identity.account", Action: "update"}, func(ctx context.Context) error {
+		return failed
+	})
+	suite.ErrorIs(err, failed)
+	suite.Empty(suite.eventLogs.eventLogs)
+}
+
+func (suite *AuditTestSuite) TestCreateAuditLogRedactsNestedSecrets() {
+	ctx := domain.NewAuditContext(context.Background(), domain.Audit{Actor: "admin", ClientIP: "10.0.0.1"})
+	oldStatus, _ := json.Marshal(map[string]interface{}{"webhook": map[string]interface{}{"url": "https://a", "secret": "old"}})
+	newStatus, _ := json.Marshal(map[string]interface{}{"webhook": map[string]interface{}{"url": "https://b", "secret": "new"}, "otp_secret": "added"})
+
+	eventLog := &domain.EventLog{Namespace: "identity.webhook", Action: "update", Actor: "okta", OldStatus: oldStatus, NewStatus: datatypes.JSON(newStatus)}
+	suite.Require().NoError(createAuditLog(ctx, suite.eventLogs, eventLog))
+
+	// 明確指定的操作者不會被 context 取代
+	suite.Equal("okta", eventLog.Actor)
+	suite.Equal("10.0.0.1", eventLog.ClientIP)
+	suite.NotContains(string(eventLog.OldStatus), "old")
+	suite.NotContains(string(eventLog.NewStatus), "new")
+	suite.NotContains(string(eventLog.NewStatus), "added")
+	suite.Equal(map[string][2]string{
+		"otp_secret":     {domain.RedactedValue, domain.RedactedValue},
+		"webhook.secret": {domain.RedactedValue, domain.RedactedValue},
+		"webhook.url":    {`"https://a"`, `"https://b"`},
+	}, suite.changes(eventLog))
+}
+
+// fakeAuditAccountRepo 在 fakeAccountRepo 之外實作會異動帳號的方法, 角色來自 fakeSCIMRoleRepo
+type fakeAuditAccountRepo struct {
+	fakeAccountRepo
+	roles *fakeSCIMRoleRepo
+}
+
+func (repo *fakeAuditAccountRepo) UpdateAccount(ctx context.Context, account *domain.Account) error {
+	existing, err := repo.Account(ctx, account.Namespace, account.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Version != account.Version {
+		return domain.ErrStale
+	}
+
+	passwordEncrypt := existing.PasswordEncrypt
+	*existing = *account
+	existing.PasswordEncrypt = passwordEncrypt
+	existing.Version++
+	return nil
+}
+
+func (repo *fakeAuditAccountRepo) UpdateAccountPassword(ctx context.Context, account *domain.Account) error {
+	existing, err := repo.Account(ctx, account.Namespace, account.ID)
+	if err != nil {
+		return err
+	}
+
+	existing.PasswordEncrypt = account.PasswordEncrypt
+	existing.Version++
+	return nil
+}
+
+func (repo *fakeAuditAccountRepo) UpdateState(ctx context.Context, account *domain.Account) error {
+	existing, err := repo.Account(ctx, account.Namespace, account.ID)
+	if err != nil {
+		return err
+	}
+
+	existing.State = account.State
+	existing.Version++
+	return nil
+}
+
+func (repo *fakeAuditAccountRepo) UseOTPRecoveryCode(ctx context.Context, accountID uint64, codeHash string) error {
+	return domain.ErrNotFound
+}
+
+func (repo *fakeAuditAccountRepo) CountOTPRecoveryCodes(ctx context.Context, accountID uint64) (int64, error) {
+	return 0, nil
+}
+
+func (repo *fakeAuditAccountRepo) AddRolesToAccount(ctx context.Context, request domain.AddRolesToAccountRequest) error {
+	for roleID, members := range repo.roles.members {
+		kept := []uint64{}
+		for _, accountID := range members {
+			if accountID != request.AccountID {
+				kept = append(kept, accountID)
+			}
+		}
+		repo.roles.members[roleID] = kept
+	}
+	for _, roleID := range request.RoleIDs {
+		repo.roles.members[roleID] = append(repo.roles.members[roleID], request.AccountID)
+	}
+	return nil
+}
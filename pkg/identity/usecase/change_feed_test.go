@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"identity/pkg/domain"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ChangeFeedTestSuite struct {
+	suite.Suite
+	eventLogRepo *fakeEventLogRepo
+	usecase      *ChangeFeedUsecase
+	namespace    string
+	// onPoll 在每次查詢 event log 之前呼叫, 用來模擬其他 transaction 在 Watch 期間 commit
+	onPoll func(poll int)
+	polls  int
+}
+
+func TestChangeFeedTestSuite(t *testing.T) {
+	suite.Run(t, &ChangeFeedTestSuite{namespace: "test.identity"})
+}
+
+func (suite *ChangeFeedTestSuite) SetupTest() {
+	suite.eventLogRepo = &fakeEventLogRepo{}
+	suite.onPoll = nil
+	suite.polls = 0
+	suite.usecase = NewChangeFeedUsecase(&pollHookEventLogRepo{fakeEventLogRepo: suite.eventLogRepo, suite: suite}, ChangeFeedOptions{
+		PollInterval: time.Millisecond,
+		GapTimeout:   time.Hour,
+	})
+}
+
+type pollHookEventLogRepo struct {
+	*fakeEventLogRepo
+	suite *ChangeFeedTestSuite
+}
+
+func (repo *pollHookEventLogRepo) EventLogsAfter(ctx context.Context, afterID uint64, limit int) ([]domain.EventLog, error) {
+	repo.suite.polls++
+	if repo.suite.onPoll != nil {
+		repo.suite.onPoll(repo.suite.polls)
+	}
+	return repo.fakeEventLogRepo.EventLogsAfter(ctx, afterID, limit)
+}
+
+func (suite *ChangeFeedTestSuite) createAccountLog(namespace string, accountID uint64, action string) {
+	newStatus, _ := json.Marshal(domain.Account{ID: accountID, Namespace: namespace, PasswordEncrypt: "hashed", State: domain.AccountStatusNormal})
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{
+		Namespace: "identity.account",
+		Action:    action,
+		TargetID:  strconv.FormatUint(accountID, 10),
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// watch 在背景執行 Watch, 收到 count 個事件之後結束
+func (suite *ChangeFeedTestSuite) watch(request domain.WatchRequest, count int) []domain.ChangeEvent {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	events := []domain.ChangeEvent{}
+	err := suite.usecase.Watch(ctx, request, func(event *domain.ChangeEvent) error {
+		events = append(events, *event)
+		if len(events) == count {
+			cancel()
+		}
+		return nil
+	})
+	suite.ErrorIs(err, context.Canceled)
+	return events
+}
+
+func (suite *ChangeFeedTestSuite) TestWatchFromCursor() {
+	suite.createAccountLog(suite.namespace, 1, "create")
+	suite.createAccountLog("other.identity", 2, "create")
+	suite.createAccountLog(suite.namespace, 1, "update")
+
+	events := suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 1}, 1)
+	suite.Require().Len(events, 1)
+	suite.Equal(domain.WebhookEventAccountUpdated, events[0].Type)
+	suite.Equal(uint64(3), events[0].Cursor)
+	suite.Equal("1", events[0].TargetID)
+	suite.NotContains(string(events[0].Data), "hashed")
+
+	// 只有其他 namespace 的事件時送出 heartbeat 推進 cursor
+	suite.createAccountLog("other.identity", 2, "update")
+	events = suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 3}, 1)
+	suite.Require().Len(events, 1)
+	suite.Equal(domain.ChangeEventHeartbeat, events[0].Type)
+	suite.Equal(uint64(4), events[0].Cursor)
+}
+
+func (suite *ChangeFeedTestSuite) TestWatchFromLatest() {
+	suite.createAccountLog(suite.namespace, 1, "create")
+
+	suite.onPoll = func(poll int) {
+		if poll == 3 {
+			suite.createAccountLog(suite.namespace, 3, "create")
+		}
+	}
+
+	events := suite.watch(domain.WatchRequest{Namespace: suite.namespace, Events: []string{"account.created"}}, 2)
+	suite.Require().Len(events, 2)
+	suite.Equal(domain.ChangeEventHeartbeat, events[0].Type)
+	suite.Equal(uint64(1), events[0].Cursor)
+	suite.Equal(domain.WebhookEventAccountCreated, events[1].Type)
+	suite.Equal("3", events[1].TargetID)
+}
+
+func (suite *ChangeFeedTestSuite) TestWatchRoleMembers() {
+	oldStatus, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Namespace: suite.namespace}, MemberIDs: []uint64{1}})
+	newStatus, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Namespace: suite.namespace}, MemberIDs: []uint64{2}})
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{
+		Namespace: "identity.role",
+		Action:    "update",
+		TargetID:  "7",
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+	})
+
+	events := suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 0, Events: []string{"role.*"}}, 1)
+	suite.Require().Len(events, 1)
+	suite.Equal(uint64(1), events[0].Cursor)
+
+	// 同一筆 event log 的事件, 只有最後一個帶這筆 event log 的 ID
+	suite.eventLogRepo.eventLogs = nil
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{Namespace: "identity.webhook", State: domain.EventLogSuccess})
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{
+		Namespace: "identity.role",
+		Action:    "update",
+		TargetID:  "7",
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+	})
+
+	events = suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 1}, 3)
+	suite.Require().Len(events, 3)
+	suite.Equal(domain.WebhookEventRoleUpdated, events[0].Type)
+	suite.Equal(uint64(1), events[0].Cursor)
+	suite.Equal(domain.WebhookEventRoleAssigned, events[1].Type)
+	suite.Equal(uint64(1), events[1].Cursor)
+	suite.Equal(domain.WebhookEventRoleUnassigned, events[2].Type)
+	suite.Equal(uint64(2), events[2].Cursor)
+}
+
+func (suite *ChangeFeedTestSuite) TestWatchWaitsForGap() {
+	suite.createAccountLog(suite.namespace, 1, "create")
+	suite.createAccountLog(suite.namespace, 2, "create")
+	suite.createAccountLog(suite.namespace, 3, "create")
+
+	// event log 2 還沒 commit
+	committed := suite.eventLogRepo.eventLogs[1]
+	suite.eventLogRepo.eventLogs = append(suite.eventLogRepo.eventLogs[:1], suite.eventLogRepo.eventLogs[2:]...)
+	suite.onPoll = func(poll int) {
+		if poll == 3 {
+			suite.eventLogRepo.eventLogs = append(suite.eventLogRepo.eventLogs[:1], committed, suite.eventLogRepo.eventLogs[1])
+		}
+	}
+
+	events := suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 1}, 2)
+	suite.Require().Len(events, 2)
+	suite.Equal("2", events[0].TargetID)
+	suite.Equal("3", events[1].TargetID)
+
+	// 超過 GapTimeout 的缺口視為 rollback
+	suite.onPoll = nil
+	suite.usecase.opts.GapTimeout = time.Millisecond
+	suite.eventLogRepo.eventLogs = append(suite.eventLogRepo.eventLogs[:1], suite.eventLogRepo.eventLogs[2:]...)
+	suite.eventLogRepo.eventLogs[1].CreatedAt = time.Now().Add(-time.Second)
+
+	events = suite.watch(domain.WatchRequest{Namespace: suite.namespace, Cursor: 1}, 1)
+	suite.Require().Len(events, 1)
+	suite.Equal("3", events[0].TargetID)
+}
+
+func (suite *ChangeFeedTestSuite) TestWatchInvalidRequest() {
+	send := func(event *domain.ChangeEvent) error { return nil }
+
+	err := suite.usecase.Watch(context.Background(), domain.WatchRequest{}, send)
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+
+	err = suite.usecase.Watch(context.Background(), domain.WatchRequest{Namespace: suite.namespace, Events: []string{"account.deleted"}}, send)
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	"time"
+)
+
+// changeFeedEvents 是 Watch 可以回傳的事件類型
+var changeFeedEvents = []string{"account.*", "role.*"}
+
+type ChangeFeedOptions struct {
+	// PollInterval 沒有新的 event log 時, 下一次查詢前等待的時間
+	PollInterval time.Duration
+	// BatchSize 每次查詢最多讀取的 event log 數量
+	BatchSize int
+	// GapTimeout event log 的 ID 不連續時, 等待前面的 transaction commit 的時間, 超過之後視為 rollback 跳過
+	GapTimeout time.Duration
+}
+
+func (opts *ChangeFeedOptions) setDefaults() {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = 5 * time.Second
+	}
+}
+
+// ChangeFeedUsecase 輪詢 event log 產生帳號與角色的 change feed
+// event log 的 ID 是在 insert 時取得, transaction 的 commit 順序不一定與 ID 相同,
+// 所以遇到不連續的 ID 時先停在缺口, 避免較晚 commit 的 event log 被 cursor 跳過
+type ChangeFeedUsecase struct {
+	eventLogRepo domain.EventLogRepository
+	opts         ChangeFeedOptions
+	now          func() time.Time
+}
+
+func NewChangeFeedUsecase(eventLogRepo domain.EventLogRepository, opts ChangeFeedOptions) *ChangeFeedUsecase {
+	opts.setDefaults()
+
+	return &ChangeFeedUsecase{
+		eventLogRepo: eventLogRepo,
+		opts:         opts,
+		now:          time.Now,
+	}
+}
+
+func (uc *ChangeFeedUsecase) Watch(ctx context.Context, request domain.WatchRequest, send func(event *domain.ChangeEvent) error) error {
+	if request.Namespace == "" {
+		return fmt.Errorf("namespace is required. %w", domain.ErrInvalidInput)
+	}
+
+	filters := request.Events
+	if len(filters) == 0 {
+		filters = changeFeedEvents
+	}
+	for _, filter := range filters {
+		if !isWebhookEventFilter(filter) {
+			return fmt.Errorf("event filter %q is invalid. %w", filter, domain.ErrInvalidInput)
+		}
+	}
+
+	cursor := request.Cursor
+	if cursor == 0 {
+		latestID, err := uc.eventLogRepo.LatestEventLogID(ctx)
+		if err != nil {
+			return err
+		}
+		cursor = latestID
+
+		// 先送出目前的位置, client 在收到任何事件之前斷線也可以用這個 cursor 接續
+		err = send(&domain.ChangeEvent{Cursor: cursor, Type: domain.ChangeEventHeartbeat, Namespace: request.Namespace, OccurredAt: uc.now().UTC()})
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(uc.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		eventLogs, err := uc.eventLogRepo.EventLogsAfter(ctx, cursor, uc.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		start := cursor
+		sent := false
+		blocked := false
+		for i := range eventLogs {
+			eventLog := &eventLogs[i]
+			if eventLog.ID != cursor+1 && uc.now().Sub(eventLog.CreatedAt) < uc.opts.GapTimeout {
+				blocked = true
+				break
+			}
+
+			events := uc.changeEvents(eventLog, request.Namespace, filters)
+			for j := range events {
+				events[j].Cursor = cursor
+				if j == len(events)-1 {
+					events[j].Cursor = eventLog.ID
+				}
+
+				err = send(&events[j])
+				if err != nil {
+					return err
+				}
+				sent = true
+			}
+			cursor = eventLog.ID
+		}
+
+		if !sent && cursor != start {
+			err = send(&domain.ChangeEvent{Cursor: cursor, Type: domain.ChangeEventHeartbeat, Namespace: request.Namespace, OccurredAt: uc.now().UTC()})
+			if err != nil {
+				return err
+			}
+		}
+
+		// 讀滿一批時代表可能還有資料, 直接讀下一批
+		if len(eventLogs) == uc.opts.BatchSize && !blocked {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// changeEvents 只回傳這個 namespace 的帳號與角色事件
+func (uc *ChangeFeedUsecase) changeEvents(eventLog *domain.EventLog, namespace string, filters []string) []domain.ChangeEvent {
+	events := []domain.ChangeEvent{}
+	for _, event := range domainEventsFromEventLog(eventLog) {
+		if event.Namespace != namespace || !domain.MatchEventType(changeFeedEvents, event.Type) || !domain.MatchEventType(filters, event.Type) {
+			continue
+		}
+
+		events = append(events, domain.ChangeEvent{
+			Type:       event.Type,
+			Namespace:  event.Namespace,
+			TargetID:   event.TargetID,
+			Actor:      event.Actor,
+			Data:       event.Data,
+			OccurredAt: event.OccurredAt,
+		})
+	}
+	return events
+}
@@ -0,0 +1,264 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ed25519"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type EventLogChainTestSuite struct {
+	suite.Suite
+	eventLogRepo *fakeEventLogRepo
+	chainRepo    *fakeEventLogChainRepo
+	repo         *HashChainEventLogRepo
+	usecase      *EventLogChainUsecase
+	key          ed25519.PrivateKey
+	now          time.Time
+}
+
+func TestEventLogChainTestSuite(t *testing.T) {
+	suite.Run(t, &EventLogChainTestSuite{})
+}
+
+func (suite *EventLogChainTestSuite) SetupTest() {
+	database.SetMockMode(true)
+
+	_, key, err := ed25519.GenerateKey(nil)
+	suite.Require().NoError(err)
+	suite.key = key
+
+	suite.now = time.Date(2022, 8, 1, 0, 0, 0, 500, time.UTC)
+	suite.eventLogRepo = &fakeEventLogRepo{}
+	suite.chainRepo = &fakeEventLogChainRepo{eventLogRepo: suite.eventLogRepo, chains: map[string]*domain.EventLogChain{}}
+	suite.repo = NewHashChainEventLogRepo(suite.eventLogRepo, suite.chainRepo)
+	suite.repo.now = func() time.Time { return suite.now }
+	suite.usecase = NewEventLogChainUsecase(suite.chainRepo, EventLogChainOptions{CheckpointKey: key, BatchSize: 2})
+}
+
+func (suite *EventLogChainTestSuite) createEventLog(namespace string, targetID string) *domain.EventLog {
+	eventLog := &domain.EventLog{
+		Namespace: namespace,
+		Action:    "update",
+		TargetID:  targetID,
+		OldStatus: datatypes.JSON(`{"FirstName": "angela", "State": 1}`),
+		NewStatus: datatypes.JSON(`{"FirstName": "Angela", "State": 1}`),
+		State:     domain.EventLogSuccess,
+		Actor:     "admin",
+	}
+	err := suite.repo.CreateEventLog(context.Background(), eventLog)
+	suite.Require().NoError(err)
+	return eventLog
+}
+
+func (suite *EventLogChainTestSuite) verify(namespace string) domain.EventLogChainVerification {
+	verifications, err := suite.usecase.VerifyEventLogChains(context.Background(), namespace)
+	suite.Require().NoError(err)
+	suite.Require().Len(verifications, 1)
+	return verifications[0]
+}
+
+func (suite *EventLogChainTestSuite) TestVerify() {
+	// hash chain 開始之前的 event log
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{Namespace: "identity.account", TargetID: "0"})
+
+	first := suite.createEventLog("identity.account", "1")
+	suite.createEventLog("identity.role", "7")
+	second := suite.createEventLog("identity.account", "2")
+	third := suite.createEventLog("identity.account", "3")
+
+	suite.Empty(first.PrevHash)
+	suite.Equal(first.Hash, second.PrevHash)
+	suite.Equal(second.Hash, third.PrevHash)
+	suite.Equal(suite.now.Truncate(time.Second), first.CreatedAt)
+
+	verifications, err := suite.usecase.VerifyEventLogChains(context.Background(), "")
+	suite.Require().NoError(err)
+	suite.Require().Len(verifications, 2)
+	suite.Equal("identity.account", verifications[0].Namespace)
+	suite.Nil(verifications[0].Break)
+	suite.Equal(uint64(3), verifications[0].ChainedCount)
+	suite.Equal(uint64(1), verifications[0].UnchainedCount)
+	suite.Equal(third.ID, verifications[0].LastEventLogID)
+	suite.Equal("identity.role", verifications[1].Namespace)
+	suite.Nil(verifications[1].Break)
+
+	// 資料庫的 JSON 欄位重新排列 key 與空白不影響 Hash
+	second.NewStatus = datatypes.JSON(`{"State":1,"FirstName":"Angela"}`)
+	suite.Nil(suite.verify("identity.account").Break)
+
+	// 還沒有 hash chain 的 namespace
+	result := suite.verify("identity.webhook")
+	suite.Equal("identity.webhook", result.Namespace)
+	suite.Nil(result.Break)
+	suite.Zero(result.ChainedCount)
+}
+
+func (suite *EventLogChainTestSuite) TestVerifyTampered() {
+	suite.createEventLog("identity.account", "1")
+	second := suite.createEventLog("identity.account", "2")
+	third := suite.createEventLog("identity.account", "3")
+
+	// 修改內容
+	second.Actor = "mallory"
+	result := suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainHashMismatch, result.Break.Reason)
+	suite.Equal(second.ID, result.Break.EventLogID)
+	suite.Equal(uint64(1), result.ChainedCount)
+	second.Actor = "admin"
+
+	// 刪除中間的 event log
+	suite.eventLogRepo.eventLogs = []*domain.EventLog{suite.eventLogRepo.eventLogs[0], third}
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainPrevHashMismatch, result.Break.Reason)
+	suite.Equal(third.ID, result.Break.EventLogID)
+
+	// 刪除最後的 event log
+	suite.eventLogRepo.eventLogs = []*domain.EventLog{suite.eventLogRepo.eventLogs[0], second}
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainHeadMismatch, result.Break.Reason)
+	suite.Equal(third.ID, result.Break.EventLogID)
+
+	// hash chain 開始之後出現沒有 Hash 的 event log
+	suite.eventLogRepo.eventLogs = append(suite.eventLogRepo.eventLogs, third)
+	hash, prevHash := second.Hash, second.PrevHash
+	second.Hash, second.PrevHash = "", ""
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainUnchained, result.Break.Reason)
+	second.Hash, second.PrevHash = hash, prevHash
+	suite.Nil(suite.verify("identity.account").Break)
+
+	// EventLogChain 被刪除
+	suite.chainRepo.chains = map[string]*domain.EventLogChain{}
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal("identity.account", result.Namespace)
+	suite.Equal(domain.EventLogChainHeadMismatch, result.Break.Reason)
+}
+
+func (suite *EventLogChainTestSuite) TestCheckpoints() {
+	ctx := context.Background()
+	suite.createEventLog("identity.account", "1")
+	second := suite.createEventLog("identity.account", "2")
+
+	suite.Require().NoError(suite.usecase.CreateEventLogCheckpoints(ctx))
+	// 沒有新的 event log 時不重複建立
+	suite.Require().NoError(suite.usecase.CreateEventLogCheckpoints(ctx))
+	suite.Require().Len(suite.chainRepo.checkpoints, 1)
+	suite.Equal(second.ID, suite.chainRepo.checkpoints[0].EventLogID)
+
+	third := suite.createEventLog("identity.account", "3")
+	suite.Require().NoError(suite.usecase.CreateEventLogCheckpoints(ctx))
+	suite.Require().Len(suite.chainRepo.checkpoints, 2)
+
+	result := suite.verify("identity.account")
+	suite.Nil(result.Break)
+	suite.Equal(uint64(2), result.CheckpointCount)
+
+	// 修改內容之後重新計算整段 hash chain, checkpoint 的簽章仍然可以發現
+	second.Actor = "mallory"
+	prevHash := suite.eventLogRepo.eventLogs[0].Hash
+	for _, eventLog := range suite.eventLogRepo.eventLogs[1:] {
+		eventLog.PrevHash = prevHash
+		eventLog.Hash, _ = domain.EventLogHash(eventLog)
+		prevHash = eventLog.Hash
+	}
+	suite.chainRepo.chains["identity.account"].LastHash = third.Hash
+
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainCheckpointMismatch, result.Break.Reason)
+	suite.Equal(second.ID, result.Break.EventLogID)
+
+	// 連 checkpoint 的 Hash 一起修改, 簽章驗證失敗
+	suite.chainRepo.checkpoints[0].Hash = second.Hash
+	suite.chainRepo.checkpoints[1].Hash = third.Hash
+	result = suite.verify("identity.account")
+	suite.Require().NotNil(result.Break)
+	suite.Equal(domain.EventLogChainCheckpointSignatureInvalid, result.Break.Reason)
+
+	// 沒有設定金鑰時不建立 checkpoint
+	uc := NewEventLogChainUsecase(suite.chainRepo, EventLogChainOptions{})
+	suite.Require().NoError(uc.CreateEventLogCheckpoints(ctx))
+	suite.Len(suite.chainRepo.checkpoints, 2)
+}
+
+type fakeEventLogChainRepo struct {
+	eventLogRepo *fakeEventLogRepo
+	chains       map[string]*domain.EventLogChain
+	checkpoints  []*domain.EventLogCheckpoint
+}
+
+func (repo *fakeEventLogChainRepo) LockEventLogChain(ctx context.Context, namespace string) (*domain.EventLogChain, error) {
+	chain, ok := repo.chains[namespace]
+	if !ok {
+		chain = &domain.EventLogChain{Namespace: namespace}
+		repo.chains[namespace] = chain
+	}
+	result := *chain
+	return &result, nil
+}
+
+func (repo *fakeEventLogChainRepo) UpdateEventLogChain(ctx context.Context, chain *domain.EventLogChain) error {
+	result := *chain
+	repo.chains[chain.Namespace] = &result
+	return nil
+}
+
+func (repo *fakeEventLogChainRepo) EventLogChains(ctx context.Context) ([]domain.EventLogChain, error) {
+	result := []domain.EventLogChain{}
+	for _, chain := range repo.chains {
+		result = append(result, *chain)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Namespace < result[j].Namespace })
+	return result, nil
+}
+
+func (repo *fakeEventLogChainRepo) ChainNamespaces(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, eventLog := range repo.eventLogRepo.eventLogs {
+		if eventLog.Hash != "" && !seen[eventLog.Namespace] {
+			seen[eventLog.Namespace] = true
+			result = append(result, eventLog.Namespace)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (repo *fakeEventLogChainRepo) ChainEventLogs(ctx context.Context, namespace string, afterID uint64, untilID uint64, limit int) ([]domain.EventLog, error) {
+	result := []domain.EventLog{}
+	for _, eventLog := range repo.eventLogRepo.eventLogs {
+		if eventLog.Namespace == namespace && eventLog.ID > afterID && eventLog.ID <= untilID && len(result) < limit {
+			result = append(result, *eventLog)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeEventLogChainRepo) CreateEventLogCheckpoint(ctx context.Context, checkpoint *domain.EventLogCheckpoint) error {
+	checkpoint.ID = uint64(len(repo.checkpoints) + 1)
+	repo.checkpoints = append(repo.checkpoints, checkpoint)
+	return nil
+}
+
+func (repo *fakeEventLogChainRepo) EventLogCheckpoints(ctx context.Context, namespace string) ([]domain.EventLogCheckpoint, error) {
+	result := []domain.EventLogCheckpoint{}
+	for _, checkpoint := range repo.checkpoints {
+		if checkpoint.Namespace == namespace {
+			result = append(result, *checkpoint)
+		}
+	}
+	return result, nil
+}
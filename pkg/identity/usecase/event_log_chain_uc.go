@@ -0,0 +1,306 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"math"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+// HashChainEventLogRepo 寫入 event log 時, 在同一個 transaction 把 event log 串到所屬 namespace 的 hash chain 後面
+// 鎖住 EventLogChain 直到 transaction 結束, 所以同一個 namespace 的 event log 依照 commit 的順序串接
+type HashChainEventLogRepo struct {
+	domain.EventLogRepository
+	chainRepo domain.EventLogChainRepository
+	now       func() time.Time
+}
+
+func NewHashChainEventLogRepo(eventLogRepo domain.EventLogRepository, chainRepo domain.EventLogChainRepository) *HashChainEventLogRepo {
+	return &HashChainEventLogRepo{
+		EventLogRepository: eventLogRepo,
+		chainRepo:          chainRepo,
+		now:                time.Now,
+	}
+}
+
+func (repo *HashChainEventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.EventLog) error {
+	return database.Transaction(ctx, func(ctx context.Context) error {
+		chain, err := repo.chainRepo.LockEventLogChain(ctx, eventLog.Namespace)
+		if err != nil {
+			return err
+		}
+
+		// datetime 欄位只保留到秒, 先捨去才能讓之後讀出來的 event log 算出相同的 Hash
+		eventLog.CreatedAt = repo.now().UTC().Truncate(time.Second)
+		eventLog.PrevHash = chain.LastHash
+		eventLog.Hash, err = domain.EventLogHash(eventLog)
+		if err != nil {
+			return err
+		}
+
+		err = repo.EventLogRepository.CreateEventLog(ctx, eventLog)
+		if err != nil {
+			return err
+		}
+
+		chain.LastEventLogID = eventLog.ID
+		chain.LastHash = eventLog.Hash
+		return repo.chainRepo.UpdateEventLogChain(ctx, chain)
+	})
+}
+
+type EventLogChainOptions struct {
+	// CheckpointKey 用來簽署 checkpoint, 沒有設定時不建立也不驗證 checkpoint 的簽章
+	CheckpointKey ed25519.PrivateKey
+	// BatchSize 驗證時每次讀取的 event log 數量
+	BatchSize int
+}
+
+type EventLogChainUsecase struct {
+	chainRepo     domain.EventLogChainRepository
+	checkpointKey ed25519.PrivateKey
+	keyID         string
+	batchSize     int
+	now           func() time.Time
+}
+
+func NewEventLogChainUsecase(chainRepo domain.EventLogChainRepository, opts EventLogChainOptions) *EventLogChainUsecase {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	uc := &EventLogChainUsecase{
+		chainRepo:     chainRepo,
+		checkpointKey: opts.CheckpointKey,
+		batchSize:     opts.BatchSize,
+		now:           time.Now,
+	}
+
+	if opts.CheckpointKey != nil {
+		// key id 是公鑰的 SHA-256 前 8 bytes, 換了金鑰之後舊的 checkpoint 不會被誤判為簽章錯誤
+		sum := sha256.Sum256(opts.CheckpointKey.Public().(ed25519.PublicKey))
+		uc.keyID = hex.EncodeToString(sum[:8])
+	}
+
+	return uc
+}
+
+func (uc *EventLogChainUsecase) VerifyEventLogChains(ctx context.Context, namespace string) ([]domain.EventLogChainVerification, error) {
+	chains, err := uc.chainRepo.EventLogChains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := uc.chainRepo.ChainNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]bool{}
+	for _, chain := range chains {
+		existing[chain.Namespace] = true
+	}
+
+	result := []domain.EventLogChainVerification{}
+	for i := range chains {
+		if namespace != "" && chains[i].Namespace != namespace {
+			continue
+		}
+
+		verification, err := uc.verifyEventLogChain(ctx, &chains[i], false)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *verification)
+	}
+
+	for _, ns := range namespaces {
+		if existing[ns] || (namespace != "" && ns != namespace) {
+			continue
+		}
+
+		verification, err := uc.verifyEventLogChain(ctx, &domain.EventLogChain{Namespace: ns, LastEventLogID: math.MaxInt64}, true)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *verification)
+	}
+
+	if namespace != "" && len(result) == 0 {
+		// 還沒有任何 event log 串上 hash chain
+		result = append(result, domain.EventLogChainVerification{Namespace: namespace, VerifiedAt: uc.now().UTC()})
+	}
+
+	return result, nil
+}
+
+// verifyEventLogChain 依照 ID 的順序重新計算每一筆 event log 的 Hash, 回傳第一個不符的位置
+// 只驗證到讀取 EventLogChain 當下的最後一筆, 驗證期間新寫入的 event log 留到下一次
+// missing 代表這個 namespace 有串上 hash chain 的 event log, 但是 EventLogChain 已經不存在
+func (uc *EventLogChainUsecase) verifyEventLogChain(ctx context.Context, chain *domain.EventLogChain, missing bool) (*domain.EventLogChainVerification, error) {
+	verification := domain.EventLogChainVerification{
+		Namespace:  chain.Namespace,
+		VerifiedAt: uc.now().UTC(),
+	}
+
+	checkpoints, err := uc.chainRepo.EventLogCheckpoints(ctx, chain.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	pending := map[uint64][]domain.EventLogCheckpoint{}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.EventLogID <= chain.LastEventLogID {
+			pending[checkpoint.EventLogID] = append(pending[checkpoint.EventLogID], checkpoint)
+		}
+	}
+
+	var afterID uint64
+	prevHash := ""
+	started := false
+	for {
+		eventLogs, err := uc.chainRepo.ChainEventLogs(ctx, chain.Namespace, afterID, chain.LastEventLogID, uc.batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range eventLogs {
+			eventLog := &eventLogs[i]
+			afterID = eventLog.ID
+
+			if eventLog.Hash == "" {
+				if !started {
+					verification.UnchainedCount++
+					continue
+				}
+				verification.Break = &domain.EventLogChainBreak{EventLogID: eventLog.ID, Reason: domain.EventLogChainUnchained}
+				return &verification, nil
+			}
+			started = true
+
+			if eventLog.PrevHash != prevHash {
+				verification.Break = &domain.EventLogChainBreak{EventLogID: eventLog.ID, Reason: domain.EventLogChainPrevHashMismatch, Expected: prevHash, Actual: eventLog.PrevHash}
+				return &verification, nil
+			}
+
+			hash, err := domain.EventLogHash(eventLog)
+			if err != nil {
+				return nil, err
+			}
+			if hash != eventLog.Hash {
+				verification.Break = &domain.EventLogChainBreak{EventLogID: eventLog.ID, Reason: domain.EventLogChainHashMismatch, Expected: hash, Actual: eventLog.Hash}
+				return &verification, nil
+			}
+
+			for _, checkpoint := range pending[eventLog.ID] {
+				if checkpoint.Hash != eventLog.Hash {
+					verification.Break = &domain.EventLogChainBreak{EventLogID: eventLog.ID, Reason: domain.EventLogChainCheckpointMismatch, Expected: checkpoint.Hash, Actual: eventLog.Hash}
+					return &verification, nil
+				}
+				if !uc.verifyCheckpointSignature(&checkpoint) {
+					verification.Break = &domain.EventLogChainBreak{EventLogID: eventLog.ID, Reason: domain.EventLogChainCheckpointSignatureInvalid}
+					return &verification, nil
+				}
+				if checkpoint.KeyID == uc.keyID {
+					verification.CheckpointCount++
+				}
+			}
+			delete(pending, eventLog.ID)
+
+			prevHash = eventLog.Hash
+			verification.ChainedCount++
+			verification.LastEventLogID = eventLog.ID
+			verification.LastHash = eventLog.Hash
+		}
+
+		if len(eventLogs) < uc.batchSize {
+			break
+		}
+	}
+
+	if missing {
+		verification.Break = &domain.EventLogChainBreak{EventLogID: verification.LastEventLogID, Reason: domain.EventLogChainHeadMismatch, Actual: verification.LastHash}
+		return &verification, nil
+	}
+
+	if verification.LastEventLogID != chain.LastEventLogID || verification.LastHash != chain.LastHash {
+		verification.Break = &domain.EventLogChainBreak{EventLogID: chain.LastEventLogID, Reason: domain.EventLogChainHeadMismatch, Expected: chain.LastHash, Actual: verification.LastHash}
+		return &verification, nil
+	}
+
+	// checkpoint 簽章過的 event log 已經不存在
+	for _, checkpoint := range checkpoints {
+		if _, ok := pending[checkpoint.EventLogID]; ok {
+			verification.Break = &domain.EventLogChainBreak{EventLogID: checkpoint.EventLogID, Reason: domain.EventLogChainCheckpointMismatch, Expected: checkpoint.Hash}
+			return &verification, nil
+		}
+	}
+
+	return &verification, nil
+}
+
+// verifyCheckpointSignature 只驗證目前金鑰簽的 checkpoint, 其他金鑰簽的 checkpoint 只比對 Hash
+func (uc *EventLogChainUsecase) verifyCheckpointSignature(checkpoint *domain.EventLogCheckpoint) bool {
+	if uc.checkpointKey == nil || checkpoint.KeyID != uc.keyID {
+		return true
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(uc.checkpointKey.Public().(ed25519.PublicKey), checkpoint.SigningPayload(), signature)
+}
+
+// CreateEventLogCheckpoints 對上次 checkpoint 之後有新的 event log 的 namespace 簽署目前 hash chain 的最後位置
+func (uc *EventLogChainUsecase) CreateEventLogCheckpoints(ctx context.Context) error {
+	if uc.checkpointKey == nil {
+		return nil
+	}
+
+	logger := log.FromContext(ctx)
+
+	chains, err := uc.chainRepo.EventLogChains(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, chain := range chains {
+		if chain.LastEventLogID == 0 {
+			continue
+		}
+
+		checkpoints, err := uc.chainRepo.EventLogCheckpoints(ctx, chain.Namespace)
+		if err != nil {
+			return err
+		}
+		if len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].EventLogID >= chain.LastEventLogID {
+			continue
+		}
+
+		checkpoint := domain.EventLogCheckpoint{
+			Namespace:  chain.Namespace,
+			EventLogID: chain.LastEventLogID,
+			Hash:       chain.LastHash,
+			KeyID:      uc.keyID,
+			CreatedAt:  uc.now().UTC().Truncate(time.Second),
+		}
+		checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(uc.checkpointKey, checkpoint.SigningPayload()))
+
+		err = uc.chainRepo.CreateEventLogCheckpoint(ctx, &checkpoint)
+		if err != nil {
+			return err
+		}
+
+		logger.Str("namespace", chain.Namespace).Uint64("event_log_id", chain.LastEventLogID).Debug("usecase: eventLog checkpoint created")
+	}
+
+	return nil
+}
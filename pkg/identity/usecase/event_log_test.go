@@ -0,0 +1,149 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"identity/pkg/domain"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type EventLogTestSuite struct {
+	suite.Suite
+	eventLogRepo *fakeEventLogRepo
+	usecase      *EventLogUsecase
+	now          time.Time
+}
+
+func TestEventLogTestSuite(t *testing.T) {
+	suite.Run(t, &EventLogTestSuite{})
+}
+
+func (suite *EventLogTestSuite) SetupTest() {
+	suite.eventLogRepo = &fakeEventLogRepo{}
+	suite.usecase = NewEventLogUsecase(suite.eventLogRepo)
+	suite.now = time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (suite *EventLogTestSuite) createEventLog(eventLog domain.EventLog) {
+	if eventLog.OldStatus == nil {
+		eventLog.OldStatus = datatypes.JSON([]byte("{}"))
+	}
+	if eventLog.NewStatus == nil {
+		eventLog.NewStatus = datatypes.JSON([]byte("{}"))
+	}
+	if eventLog.State == 0 {
+		eventLog.State = domain.EventLogSuccess
+	}
+	if eventLog.CreatedAt.IsZero() {
+		eventLog.CreatedAt = suite.now.Add(time.Duration(len(suite.eventLogRepo.eventLogs)) * time.Minute)
+	}
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), &eventLog)
+}
+
+func (suite *EventLogTestSuite) TestEventLogsPagination() {
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		suite.createEventLog(domain.EventLog{Namespace: "identity.account", Action: "update", TargetID: strconv.Itoa(i), Actor: "admin"})
+	}
+	suite.createEventLog(domain.EventLog{Namespace: "identity.role", Action: "create", TargetID: "7", Actor: "scim"})
+
+	opts := domain.FindEventLogOptions{Namespace: "identity.account", Limit: 2}
+	ids := []uint64{}
+	for page := 0; page < 5; page++ {
+		eventLogs, nextCursor, err := suite.usecase.EventLogs(ctx, opts)
+		suite.Require().NoError(err)
+		for _, eventLog := range eventLogs {
+			ids = append(ids, eventLog.ID)
+		}
+		if nextCursor == 0 {
+			break
+		}
+		opts.Cursor = nextCursor
+	}
+
+	// 由新到舊, 每一頁接續上一頁, 沒有重複與遺漏
+	suite.Equal([]uint64{5, 4, 3, 2, 1}, ids)
+
+	total, err := suite.usecase.CountEventLogs(ctx, domain.FindEventLogOptions{Namespace: "identity.account"})
+	suite.Require().NoError(err)
+	suite.Equal(int64(5), total)
+}
+
+func (suite *EventLogTestSuite) TestEventLogsFilters() {
+	ctx := context.Background()
+	suite.createEventLog(domain.EventLog{Namespace: "identity.account", Action: "create", TargetID: "1", Actor: "admin"})
+	suite.createEventLog(domain.EventLog{Namespace: "identity.account", Action: "change_state", TargetID: "1", Actor: "bob", State: domain.EventLogFail})
+	suite.createEventLog(domain.EventLog{Namespace: "identity.account", Action: "change_state", TargetID: "2", Actor: "admin"})
+
+	eventLogs, _, err := suite.usecase.EventLogs(ctx, domain.FindEventLogOptions{TargetID: "1", Actor: "bob"})
+	suite.Require().NoError(err)
+	suite.Require().Len(eventLogs, 1)
+	suite.Equal(uint64(2), eventLogs[0].ID)
+
+	eventLogs, _, err = suite.usecase.EventLogs(ctx, domain.FindEventLogOptions{Action: "change_state", State: domain.EventLogSuccess})
+	suite.Require().NoError(err)
+	suite.Require().Len(eventLogs, 1)
+	suite.Equal("2", eventLogs[0].TargetID)
+
+	total, err := suite.usecase.CountEventLogs(ctx, domain.FindEventLogOptions{
+		CreatedTimeStart: suite.now.Add(time.Minute),
+		CreatedTimeEnd:   suite.now.Add(2 * time.Minute),
+	})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), total)
+
+	_, _, err = suite.usecase.EventLogs(ctx, domain.FindEventLogOptions{
+		CreatedTimeStart: suite.now,
+		CreatedTimeEnd:   suite.now.Add(-time.Minute),
+	})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
+
+func (suite *EventLogTestSuite) TestEventLogsDiff() {
+	ctx := context.Background()
+
+	oldAccount := domain.Account{ID: 1, Namespace: "test.identity", FirstName: "angela", PasswordEncrypt: "old hash", OTPSecret: "otp", State: domain.AccountStatusNormal}
+	newAccount := oldAccount
+	newAccount.FirstName = "Angela"
+	newAccount.PasswordEncrypt = "new hash"
+	newAccount.State = domain.AccountStatusLocked
+	oldStatus, _ := json.Marshal(oldAccount)
+	newStatus, _ := json.Marshal(newAccount)
+	suite.createEventLog(domain.EventLog{Namespace: "identity.account", Action: "update", TargetID: "1", OldStatus: oldStatus, NewStatus: newStatus})
+
+	oldRole, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Name: "dev"}, MemberIDs: []uint64{1}})
+	newRole, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Name: "engineering"}, MemberIDs: []uint64{1, 2}})
+	suite.createEventLog(domain.EventLog{Namespace: "identity.role", Action: "update", TargetID: "7", OldStatus: oldRole, NewStatus: newRole})
+
+	eventLogs, _, err := suite.usecase.EventLogs(ctx, domain.FindEventLogOptions{Namespace: "identity.account"})
+	suite.Require().NoError(err)
+	suite.Require().Len(eventLogs, 1)
+
+	changes := map[string][2]string{}
+	for _, change := range eventLogs[0].Changes {
+		changes[change.Field] = [2]string{string(change.OldValue), string(change.NewValue)}
+	}
+	suite.Equal(map[string][2]string{
+		"FirstName":       {`"angela"`, `"Angela"`},
+		"PasswordEncrypt": {domain.RedactedValue, domain.RedactedValue},
+		"State":           {"1", "3"},
+	}, changes)
+
+	// 查詢結果不能包含密碼與 OTP secret
+	suite.NotContains(string(eventLogs[0].OldStatus), "old hash")
+	suite.NotContains(string(eventLogs[0].NewStatus), "new hash")
+	suite.NotContains(string(eventLogs[0].NewStatus), `"otp"`)
+
+	eventLogs, _, err = suite.usecase.EventLogs(ctx, domain.FindEventLogOptions{Namespace: "identity.role"})
+	suite.Require().NoError(err)
+	suite.Require().Len(eventLogs, 1)
+	suite.Equal([]domain.FieldChange{
+		{Field: "member_ids", OldValue: json.RawMessage("[1]"), NewValue: json.RawMessage("[1,2]")},
+		{Field: "role.Name", OldValue: json.RawMessage(`"dev"`), NewValue: json.RawMessage(`"engineering"`)},
+	}, eventLogs[0].Changes)
+}
@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+)
+
+const (
+	eventLogDefaultLimit = 50
+	eventLogMaxLimit     = 500
+)
+
+type EventLogUsecase struct {
+	eventLogRepo domain.EventLogRepository
+}
+
+func NewEventLogUsecase(eventLogRepo domain.EventLogRepository) *EventLogUsecase {
+	return &EventLogUsecase{
+		eventLogRepo: eventLogRepo,
+	}
+}
+
+func (uc *EventLogUsecase) EventLogs(ctx context.Context, opts domain.FindEventLogOptions) ([]domain.EventLog, uint64, error) {
+	err := validateFindEventLogOptions(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = eventLogDefaultLimit
+	}
+	if limit > eventLogMaxLimit {
+		limit = eventLogMaxLimit
+	}
+
+	// 多讀一筆判斷是否還有下一頁
+	opts.Limit = limit + 1
+	eventLogs, err := uc.eventLogRepo.EventLogs(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var nextCursor uint64
+	if len(eventLogs) > limit {
+		eventLogs = eventLogs[:limit]
+		nextCursor = eventLogs[limit-1].ID
+	}
+
+	for i := range eventLogs {
+		eventLog := &eventLogs[i]
+		eventLog.Changes = domain.DiffStatus(eventLog.OldStatus, eventLog.NewStatus)
+		eventLog.OldStatus = domain.RedactStatus(eventLog.OldStatus)
+		eventLog.NewStatus = domain.RedactStatus(eventLog.NewStatus)
+	}
+
+	return eventLogs, nextCursor, nil
+}
+
+func (uc *EventLogUsecase) CountEventLogs(ctx context.Context, opts domain.FindEventLogOptions) (int64, error) {
+	err := validateFindEventLogOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	return uc.eventLogRepo.CountEventLogs(ctx, opts)
+}
+
+func validateFindEventLogOptions(opts domain.FindEventLogOptions) error {
+	if !opts.CreatedTimeStart.IsZero() && !opts.CreatedTimeEnd.IsZero() && !opts.CreatedTimeEnd.After(opts.CreatedTimeStart) {
+		return fmt.Errorf("created time end must be after start. %w", domain.ErrInvalidInput)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"context"
+	"identity/pkg/domain"
+)
+
+type fakeEventLogRepo struct {
+	eventLogs []*domain.EventLog
+}
+
+func (repo *fakeEventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.EventLog) error {
+	eventLog.ID = uint64(len(repo.eventLogs) + 1)
+	repo.eventLogs = append(repo.eventLogs, eventLog)
+	return nil
+}
+
+func (repo *fakeEventLogRepo) EventLogsAfter(ctx context.Context, afterID uint64, limit int) ([]domain.EventLog, error) {
+	result := []domain.EventLog{}
+	for _, eventLog := range repo.eventLogs {
+		if eventLog.ID > afterID && len(result) < limit {
+			result = append(result, *eventLog)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeEventLogRepo) LatestEventLogID(ctx context.Context) (uint64, error) {
+	return uint64(len(repo.eventLogs)), nil
+}
+
+func (repo *fakeEventLogRepo) EventLogs(ctx context.Context, opts domain.FindEventLogOptions) ([]domain.EventLog, error) {
+	result := []domain.EventLog{}
+	for i := len(repo.eventLogs) - 1; i >= 0; i-- {
+		eventLog := repo.eventLogs[i]
+		if !matchEventLog(eventLog, opts) || (opts.Cursor > 0 && eventLog.ID >= opts.Cursor) {
+			continue
+		}
+		if opts.Limit > 0 && len(result) == opts.Limit {
+			break
+		}
+		result = append(result, *eventLog)
+	}
+	return result, nil
+}
+
+func (repo *fakeEventLogRepo) CountEventLogs(ctx context.Context, opts domain.FindEventLogOptions) (int64, error) {
+	var total int64
+	for _, eventLog := range repo.eventLogs {
+		if matchEventLog(eventLog, opts) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func matchEventLog(eventLog *domain.EventLog, opts domain.FindEventLogOptions) bool {
+	switch {
+	case opts.Namespace != "" && eventLog.Namespace != opts.Namespace,
+		opts.Action != "" && eventLog.Action != opts.Action,
+		opts.TargetID != "" && eventLog.TargetID != opts.TargetID,
+		opts.Actor != "" && eventLog.Actor != opts.Actor,
+		opts.State > 0 && eventLog.State != opts.State,
+		!opts.CreatedTimeStart.IsZero() && eventLog.CreatedAt.Before(opts.CreatedTimeStart),
+		!opts.CreatedTimeEnd.IsZero() && !eventLog.CreatedAt.Before(opts.CreatedTimeEnd):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"identity/pkg/domain"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+)
+
+type ImpersonationTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenSvc    domain.TokenUsecase
+	sessionSvc  domain.SessionUsecase
+	eventLogs   *fakeEventLogRepo
+	permissions *fakePermissionRepo
+	usecase     *ImpersonationUsecase
+	namespace   string
+}
+
+func TestImpersonationTestSuite(t *testing.T) {
+	suite.Run(t, &ImpersonationTestSuite{namespace: "test.identity"})
+}
+
+func (suite *ImpersonationTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	accounts := &fakeAccountRepo{accounts: []*domain.Account{
+		{ID: 1, Namespace: suite.namespace, Username: sql.NullString{String: "admin", Valid: true}, IsAdmin: 1, State: domain.AccountStatusNormal},
+		{ID: 2, Namespace: suite.namespace, Username: sql.NullString{String: "support", Valid: true}, State: domain.AccountStatusNormal},
+		{ID: 3, Namespace: suite.namespace, Username: sql.NullString{String: "angela", Valid: true}, State: domain.AccountStatusNormal},
+		{ID: 4, Namespace: suite.namespace, Username: sql.NullString{String: "root", Valid: true}, IsAdmin: 1, State: domain.AccountStatusNormal},
+	}}
+
+	sessionRepo := identityRedis.NewSessionRepo(client)
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.permissions = &fakePermissionRepo{}
+	suite.tokenSvc = NewTokenUsecase(identityRedis.NewTokenRepo(client), sessionRepo, &fakeEventLogRepo{}, nil, TokenOptions{})
+	suite.sessionSvc = NewSessionUsecase(sessionRepo)
+	suite.usecase = NewImpersonationUsecase(accounts, suite.permissions, suite.eventLogs, suite.tokenSvc)
+}
+
+func (suite *ImpersonationTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+// fakeAccountRepo 只實作 Account 與用 username 查詢的 Accounts
+type fakeAccountRepo struct {
+	domain.AccountRepository
+	accounts []*domain.Account
+}
+
+func (repo *fakeAccountRepo) Account(ctx context.Context, namespace string, accountID uint64) (*domain.Account, error) {
+	for _, account := range repo.accounts {
+		if account.Namespace == namespace && account.ID == accountID {
+			return account, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeAccountRepo) Accounts(ctx context.Context, opts domain.FindAccountOptions) ([]domain.Account, error) {
+	result := []domain.Account{}
+	for _, account := range repo.accounts {
+		if account.Namespace == opts.Namespace && account.Username.String == opts.Username {
+			result = append(result, *account)
+		}
+	}
+	return result, nil
+}
+
+type fakePermissionRepo struct {
+	permissions []domain.Permission
+}
+
+func (repo *fakePermissionRepo) PermissionsByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.Permission, error) {
+	result := []domain.Permission{}
+	for _, permission := range repo.permissions {
+		if permission.Namespace == namespace && permission.AccountID == accountID {
+			result = append(result, permission)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakePermissionRepo) DeletePermissionsByAccountID(ctx context.Context, namespace string, accountID uint64) error {
+	return nil
+}
+
+func (repo *fakePermissionRepo) CreatePermissions(ctx context.Context, permissions []*domain.Permission) error {
+	for _, permission := range permissions {
+		repo.permissions = append(repo.permissions, *permission)
+	}
+	return nil
+}
+
+// callerContext 模擬 AuthInterceptor 驗證過 token 之後放進 context 的 claims
+func (suite *ImpersonationTestSuite) callerContext(accountID uint64) context.Context {
+	return domain.NewContext(context.Background(), domain.Claims{
+		domain.ClaimAccountID: int64(accountID),
+		domain.ClaimNamespace: suite.namespace,
+	})
+}
+
+func (suite *ImpersonationTestSuite) impersonate(impersonatorID, accountID uint64) (*domain.Impersonation, error) {
+	return suite.usecase.ImpersonateAccount(suite.callerContext(impersonatorID), domain.ImpersonateAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: accountID,
+		Reason:    "ticket #1024",
+		ClientIP:  "10.0.0.1",
+	})
+}
+
+func (suite *ImpersonationTestSuite) TestImpersonateAccount() {
+	ctx := context.Background()
+
+	impersonation, err := suite.impersonate(1, 3)
+	suite.Require().NoError(err)
+	suite.Equal(int64(defaultImpersonationDuration/time.Second), impersonation.ExpiresIn)
+
+	token, err := suite.tokenSvc.Token(ctx, impersonation.AccessToken)
+	suite.Require().NoError(err)
+	suite.Equal(int64(3), token.AccountID)
+	suite.Equal("admin", token.Claims[domain.ClaimImpersonatorName])
+
+	impersonatorID, ok := token.Impersonator()
+	suite.True(ok)
+	suite.Equal(uint64(1), impersonatorID)
+	suite.Equal(domain.ErrImpersonationRestricted, domain.RequireNotImpersonated(token))
+
+	// 模擬登入不會佔用使用者的 session
+	sessions, err := suite.sessionSvc.Sessions(ctx, suite.namespace, 3)
+	suite.Require().NoError(err)
+	suite.Len(sessions, 0)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	eventLog := suite.eventLogs.eventLogs[0]
+	suite.Equal("identity.impersonation", eventLog.Namespace)
+	suite.Equal("start", eventLog.Action)
+	suite.Equal("3", eventLog.TargetID)
+	suite.Equal("admin", eventLog.Actor)
+	suite.Equal(domain.EventLogSuccess, eventLog.State)
+	suite.Contains(string(eventLog.NewStatus), `"impersonator_id":1`)
+
+	suite.redisServer.FastForward(defaultImpersonationDuration + time.Second)
+	_, err = suite.tokenSvc.Token(ctx, impersonation.AccessToken)
+	suite.ErrorIs(err, domain.ErrKeyNotFound)
+}
+
+func (suite *ImpersonationTestSuite) TestImpersonatePermission() {
+	_, err := suite.impersonate(2, 3)
+	suite.ErrorIs(err, domain.ErrImpersonationForbidden)
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	suite.Equal(domain.EventLogFail, suite.eventLogs.eventLogs[0].State)
+	suite.Equal("support", suite.eventLogs.eventLogs[0].Actor)
+
+	err = suite.permissions.CreatePermissions(context.Background(), []*domain.Permission{
+		{Namespace: suite.namespace, Code: domain.PermissionImpersonate, AccountID: 2},
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.impersonate(2, 3)
+	suite.NoError(err)
+
+	// 不能模擬 admin 或自己
+	_, err = suite.impersonate(2, 4)
+	suite.ErrorIs(err, domain.ErrImpersonationForbidden)
+	_, err = suite.impersonate(1, 1)
+	suite.ErrorIs(err, domain.ErrImpersonationForbidden)
+}
+
+func (suite *ImpersonationTestSuite) TestImpersonateDuration() {
+	_, err := suite.usecase.ImpersonateAccount(suite.callerContext(1), domain.ImpersonateAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: 3,
+		Reason:    "ticket #1024",
+		Duration:  maxImpersonationDuration + time.Minute,
+	})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+
+	_, err = suite.usecase.ImpersonateAccount(suite.callerContext(1), domain.ImpersonateAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: 3,
+	})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
+
+func (suite *ImpersonationTestSuite) TestImpersonateRequiresCaller() {
+	request := domain.ImpersonateAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: 3,
+		Reason:    "ticket #1024",
+	}
+
+	_, err := suite.usecase.ImpersonateAccount(context.Background(), request)
+	suite.ErrorIs(err, domain.ErrUnauthorized)
+
+	// 其他 namespace 的 token 不能模擬這個 namespace 的帳號
+	ctx := domain.NewContext(context.Background(), domain.Claims{
+		domain.ClaimAccountID: int64(1),
+		domain.ClaimNamespace: "other",
+	})
+	_, err = suite.usecase.ImpersonateAccount(ctx, request)
+	suite.ErrorIs(err, domain.ErrImpersonationForbidden)
+	suite.Len(suite.eventLogs.eventLogs, 0)
+}
+
+func (suite *ImpersonationTestSuite) TestEndImpersonation() {
+	ctx := context.Background()
+
+	impersonation, err := suite.impersonate(1, 3)
+	suite.Require().NoError(err)
+
+	err = suite.usecase.EndImpersonation(ctx, domain.EndImpersonationRequest{
+		Namespace:   suite.namespace,
+		AccessToken: impersonation.AccessToken,
+	})
+	suite.ErrorIs(err, domain.ErrUnauthorized)
+
+	err = suite.usecase.EndImpersonation(suite.callerContext(4), domain.EndImpersonationRequest{
+		Namespace:   suite.namespace,
+		AccessToken: impersonation.AccessToken,
+	})
+	suite.ErrorIs(err, domain.ErrImpersonationForbidden)
+
+	err = suite.usecase.EndImpersonation(suite.callerContext(1), domain.EndImpersonationRequest{
+		Namespace:   suite.namespace,
+		AccessToken: impersonation.AccessToken,
+		ClientIP:    "10.0.0.1",
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.tokenSvc.Token(ctx, impersonation.AccessToken)
+	suite.ErrorIs(err, domain.ErrKeyNotFound)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 2)
+	eventLog := suite.eventLogs.eventLogs[1]
+	suite.Equal("end", eventLog.Action)
+	suite.Equal("3", eventLog.TargetID)
+	suite.Equal("admin", eventLog.Actor)
+}
+
+func (suite *ImpersonationTestSuite) TestSensitiveOperations() {
+	ctx := context.Background()
+
+	impersonation, err := suite.impersonate(1, 3)
+	suite.Require().NoError(err)
+
+	token, err := suite.tokenSvc.Token(ctx, impersonation.AccessToken)
+	suite.Require().NoError(err)
+
+	_, err = suite.tokenSvc.ElevateToken(ctx, domain.ElevateTokenRequest{
+		TokenKey:  impersonation.AccessToken,
+		AccountID: 3,
+		Method:    domain.AMROTP,
+	})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+
+	claims := domain.Claims{}
+	for k, v := range token.Claims {
+		claims[k] = v
+	}
+	ctx = domain.NewContext(ctx, claims)
+
+	accountSvc := &AccountUsecase{}
+	err = accountSvc.UpdateAccountPassword(ctx, domain.UpdateAccountPasswordRequest{Namespace: suite.namespace, AccountID: 3})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+	_, err = accountSvc.ResetOTPSecret(ctx, domain.ResetOTPSecretRequest{Namespace: suite.namespace, AccountID: 3})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+	err = accountSvc.ClearOTP(ctx, domain.ClearOTPRequest{Namespace: suite.namespace, AccountID: 3})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+
+	// 模擬登入中不能再模擬其他帳號
+	_, err = suite.usecase.ImpersonateAccount(ctx, domain.ImpersonateAccountRequest{
+		Namespace: suite.namespace,
+		AccountID: 2,
+		Reason:    "ticket #1025",
+	})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+}
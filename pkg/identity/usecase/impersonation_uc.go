@@ -0,0 +1,247 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/datatypes"
+)
+
+const (
+	defaultImpersonationDuration = 15 * time.Minute
+	maxImpersonationDuration     = time.Hour
+)
+
+// ImpersonationUsecase admin 以其他帳號的身分登入, 開始與結束都會記錄到 event log
+type ImpersonationUsecase struct {
+	accountRepo    domain.AccountRepository
+	permissionRepo domain.PermissionRepository
+	eventLogRepo   domain.EventLogRepository
+	tokenSvc       domain.TokenUsecase
+}
+
+func NewImpersonationUsecase(accountRepo domain.AccountRepository, permissionRepo domain.PermissionRepository, eventLogRepo domain.EventLogRepository, tokenSvc domain.TokenUsecase) *ImpersonationUsecase {
+	return &ImpersonationUsecase{
+		accountRepo:    accountRepo,
+		permissionRepo: permissionRepo,
+		eventLogRepo:   eventLogRepo,
+		tokenSvc:       tokenSvc,
+	}
+}
+
+// ImpersonateAccount 簽發一個短效的 accessToken 給 admin 使用, token 不會有 refreshToken 也不會建立 session,
+// 所以不會佔用使用者的 session 上限, 也不會出現在使用者的裝置列表
+func (uc *ImpersonationUsecase) ImpersonateAccount(ctx context.Context, request domain.ImpersonateAccountRequest) (*domain.Impersonation, error) {
+	if request.AccountID == 0 || request.Reason == "" {
+		return nil, fmt.Errorf("account and reason are required. %w", domain.ErrInvalidInput)
+	}
+
+	duration := request.Duration
+	if duration <= 0 {
+		duration = defaultImpersonationDuration
+	}
+	if duration > maxImpersonationDuration {
+		return nil, fmt.Errorf("impersonation can not be longer than %s. %w", maxImpersonationDuration, domain.ErrInvalidInput)
+	}
+
+	// 模擬登入的 token 不能再發起模擬登入
+	err := rejectImpersonation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	impersonatorID, err := callerAccountID(ctx, request.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	impersonator, err := uc.accountRepo.Account(ctx, request.Namespace, impersonatorID)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := uc.accountRepo.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.checkImpersonation(ctx, impersonator, target)
+	if err != nil {
+		uc.recordImpersonation(ctx, "start", impersonator.ID, impersonatorName(impersonator), target.ID, map[string]interface{}{
+			"reason": request.Reason,
+			"error":  err.Error(),
+		}, request.ClientIP, domain.EventLogFail)
+		return nil, err
+	}
+
+	expiresIn := int64(duration / time.Second)
+	accessToken, err := uc.tokenSvc.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID:   int64(target.ID),
+			Namespace:   target.Namespace,
+			Username:    target.Username.String,
+			AccountType: int32(target.Type),
+			ExpiresIn:   expiresIn,
+		},
+		ClientIP: request.ClientIP,
+		ServerClaims: map[string]string{
+			domain.ClaimImpersonatorID:   strconv.FormatUint(impersonator.ID, 10),
+			domain.ClaimImpersonatorName: impersonatorName(impersonator),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uc.recordImpersonation(ctx, "start", impersonator.ID, impersonatorName(impersonator), target.ID, map[string]interface{}{
+		"reason":     request.Reason,
+		"expires_at": time.Now().Add(duration).UTC().Unix(),
+	}, request.ClientIP, domain.EventLogSuccess)
+
+	return &domain.Impersonation{
+		AccessToken:    accessToken,
+		ExpiresIn:      expiresIn,
+		AccountID:      target.ID,
+		ImpersonatorID: impersonator.ID,
+	}, nil
+}
+
+// EndImpersonation 撤銷模擬登入的 token, token 自然過期時不會有結束的紀錄, 可以從開始紀錄的 expires_at 得知
+func (uc *ImpersonationUsecase) EndImpersonation(ctx context.Context, request domain.EndImpersonationRequest) error {
+	callerID, err := callerAccountID(ctx, request.Namespace)
+	if err != nil {
+		return err
+	}
+
+	token, err := uc.tokenSvc.Token(ctx, request.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	impersonatorID, ok := token.Impersonator()
+	if !ok || token.Namespace != request.Namespace {
+		return fmt.Errorf("token is not an impersonation token. %w", domain.ErrInvalidInput)
+	}
+
+	if impersonatorID != callerID {
+		return domain.ErrImpersonationForbidden
+	}
+
+	err = uc.tokenSvc.RevokeToken(ctx, request.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	uc.recordImpersonation(ctx, "end", impersonatorID, token.Claims[domain.ClaimImpersonatorName], uint64(token.AccountID), map[string]interface{}{}, request.ClientIP, domain.EventLogSuccess)
+
+	return nil
+}
+
+// callerAccountID 從 context 裡的 claims 取得呼叫端的帳號, 沒有經過驗證的呼叫一律拒絕,
+// 不能讓呼叫端自己指定要用哪個 admin 的身分操作
+func callerAccountID(ctx context.Context, namespace string) (uint64, error) {
+	claims, ok := domain.FromContext(ctx)
+	if !ok {
+		return 0, domain.ErrUnauthorized
+	}
+
+	accountID, _ := claims[domain.ClaimAccountID].(int64)
+	if accountID <= 0 {
+		return 0, domain.ErrUnauthorized
+	}
+
+	if ns, _ := claims[domain.ClaimNamespace].(string); ns != namespace {
+		return 0, domain.ErrImpersonationForbidden
+	}
+
+	return uint64(accountID), nil
+}
+
+// checkImpersonation 只有 admin 或是擁有 PermissionImpersonate 的帳號可以模擬登入,
+// 並且不能模擬自己或其他 admin, 避免權限提升
+func (uc *ImpersonationUsecase) checkImpersonation(ctx context.Context, impersonator, target *domain.Account) error {
+	if impersonator.State != domain.AccountStatusNormal {
+		return domain.ErrAccountDisabled
+	}
+
+	if impersonator.ID == target.ID || target.IsAdmin == 1 {
+		return domain.ErrImpersonationForbidden
+	}
+
+	if target.State == domain.AccountStatusDisabled {
+		return domain.ErrAccountDisabled
+	}
+
+	if impersonator.IsAdmin == 1 {
+		return nil
+	}
+
+	permissions, err := uc.permissionRepo.PermissionsByAccountID(ctx, impersonator.Namespace, impersonator.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		if permission.Code == domain.PermissionImpersonate {
+			return nil
+		}
+	}
+
+	return domain.ErrImpersonationForbidden
+}
+
+// recordImpersonation 把模擬登入記錄到 event log, Actor 為 admin, TargetID 為被模擬的帳號
+func (uc *ImpersonationUsecase) recordImpersonation(ctx context.Context, action string, impersonatorID uint64, actor string, accountID uint64, detail map[string]interface{}, clientIP string, state domain.EventLogState) {
+	logger := log.FromContext(ctx)
+
+	detail["impersonator_id"] = impersonatorID
+	detail["account_id"] = accountID
+	newStatus, err := json.Marshal(detail)
+	if err != nil {
+		logger.Err(err).Error("usecase: marshal impersonation event failed")
+		return
+	}
+
+	message := fmt.Sprintf("%s started impersonating the account", actor)
+	switch {
+	case state != domain.EventLogSuccess:
+		message = fmt.Sprintf("%s was rejected to impersonate the account", actor)
+	case action == "end":
+		message = fmt.Sprintf("%s stopped impersonating the account", actor)
+	}
+
+	err = createAuditLog(ctx, uc.eventLogRepo, &domain.EventLog{
+		Namespace: "identity.impersonation",
+		Action:    action,
+		TargetID:  strconv.FormatUint(accountID, 10),
+		Message:   message,
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     state,
+		ClientIP:  clientIP,
+		Actor:     actor,
+	})
+	if err != nil {
+		logger.Err(err).Uint64("account_id", accountID).Error("usecase: create impersonation event log failed")
+	}
+}
+
+// rejectImpersonation 敏感操作 (修改密碼, OTP) 不允許用模擬登入的 token 進行
+func rejectImpersonation(ctx context.Context) error {
+	if domain.IsImpersonating(ctx) {
+		return domain.ErrImpersonationRestricted
+	}
+	return nil
+}
+
+func impersonatorName(account *domain.Account) string {
+	if account.Username.Valid && account.Username.String != "" {
+		return account.Username.String
+	}
+	return strconv.FormatUint(account.ID, 10)
+}
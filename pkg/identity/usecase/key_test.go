@@ -0,0 +1,224 @@
+package usecase
+
+import (
+	"context"
+	"identity/pkg/domain"
+	identityFile "identity/pkg/identity/repository/file"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type KeyTestSuite struct {
+	suite.Suite
+	dir     string
+	keyRepo domain.KeyRepository
+}
+
+func TestKeyTestSuite(t *testing.T) {
+	suite.Run(t, new(KeyTestSuite))
+}
+
+func (suite *KeyTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "identity-keystore")
+	suite.Require().NoError(err)
+	suite.dir = dir
+
+	suite.keyRepo, err = identityFile.NewKeyRepo(dir)
+	suite.Require().NoError(err)
+}
+
+func (suite *KeyTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *KeyTestSuite) TestSignAndParseToken() {
+	ctx := context.Background()
+
+	for _, algorithm := range []string{"RS256", "ES256", "EdDSA"} {
+		keySvc, err := NewKeyUsecase(suite.keyRepo, KeyOptions{
+			Algorithm: algorithm,
+			Issuer:    "http://identity.test",
+		})
+		suite.Require().NoError(err)
+
+		err = keySvc.RotateKeys(ctx)
+		suite.Require().NoError(err)
+
+		jwt, err := keySvc.SignToken(ctx, domain.Token{
+			AccountID:   1,
+			Namespace:   "test.identity",
+			AccountType: 2,
+			ExpiresIn:   60,
+			TokenString: "access_key",
+			Claims: map[string]string{
+				"role":              "admin",
+				domain.SessionKey:   "session_id",
+				domain.PairTokenKey: "refresh_key",
+				domain.ClaimActor:   `{"sub":"orders","client_id":"orders"}`,
+			},
+		})
+		suite.Require().NoError(err, algorithm)
+
+		token, err := keySvc.ParseToken(ctx, jwt)
+		suite.Require().NoError(err, algorithm)
+		suite.Assert().Equal(int64(1), token.AccountID)
+		suite.Assert().Equal("test.identity", token.Namespace)
+		suite.Assert().Equal(int32(2), token.AccountType)
+		suite.Assert().Equal("access_key", token.TokenString)
+		suite.Assert().Equal("admin", token.Claims["role"])
+		suite.Assert().Equal("session_id", token.Claims[domain.SessionKey])
+		suite.Assert().Empty(token.Claims[domain.PairTokenKey])
+		suite.Assert().Equal(&domain.Actor{Subject: "orders", ClientID: "orders"}, token.Actor())
+
+		_, err = keySvc.ParseToken(ctx, jwt[:len(jwt)-2]+"xx")
+		suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+		keys, err := suite.keyRepo.Keys(ctx)
+		suite.Require().NoError(err)
+		for _, key := range keys {
+			suite.Require().NoError(suite.keyRepo.DeleteKey(ctx, key.ID))
+		}
+	}
+}
+
+func (suite *KeyTestSuite) TestRotateKeys() {
+	ctx := context.Background()
+
+	keySvc, err := NewKeyUsecase(suite.keyRepo, KeyOptions{
+		Algorithm:        "ES256",
+		RotationInterval: 24 * time.Hour,
+		PublishAhead:     time.Hour,
+		Retention:        2 * time.Hour,
+	})
+	suite.Require().NoError(err)
+
+	// 目前的金鑰還沒有快到期, 不會建立新的金鑰
+	now := time.Now().UTC()
+	current, err := generateSigningKey("ES256", now.Add(-12*time.Hour))
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.keyRepo.CreateKey(ctx, current))
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	keys, err := keySvc.PublicKeys(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(keys, 1)
+
+	oldToken, err := keySvc.SignToken(ctx, domain.Token{AccountID: 1, ExpiresIn: 3600})
+	suite.Require().NoError(err)
+
+	// 快到期時先發佈下一把金鑰, 但是還是用目前的金鑰簽章
+	suite.Require().NoError(suite.keyRepo.DeleteKey(ctx, current.ID))
+	current.ActivatesAt = now.Add(-23*time.Hour - 30*time.Minute)
+	suite.Require().NoError(suite.keyRepo.CreateKey(ctx, current))
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	keys, err = keySvc.PublicKeys(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(keys, 2)
+	suite.Assert().Equal(current.ID, keys[0].ID)
+	suite.Assert().True(keys[1].ActivatesAt.After(now))
+
+	signingKey, err := keySvc.signingKey(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(current.ID, signingKey.ID)
+
+	// 新的金鑰生效後, 舊的金鑰在保留期間內仍然可以驗證
+	next := keys[1]
+	suite.Require().NoError(suite.keyRepo.DeleteKey(ctx, next.ID))
+	next.ActivatesAt = now.Add(-time.Hour)
+	suite.Require().NoError(suite.keyRepo.CreateKey(ctx, &next))
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	signingKey, err = keySvc.signingKey(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(next.ID, signingKey.ID)
+
+	_, err = keySvc.ParseToken(ctx, oldToken)
+	suite.Require().NoError(err)
+
+	// 超過保留期間後, 舊的金鑰會被移除
+	suite.Require().NoError(suite.keyRepo.DeleteKey(ctx, next.ID))
+	next.ActivatesAt = now.Add(-3 * time.Hour)
+	suite.Require().NoError(suite.keyRepo.CreateKey(ctx, &next))
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	keys, err = keySvc.PublicKeys(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(keys, 1)
+	suite.Assert().Equal(next.ID, keys[0].ID)
+}
+
+func (suite *KeyTestSuite) TestCachedKeysExpire() {
+	ctx := context.Background()
+
+	keySvc, err := NewKeyUsecase(suite.keyRepo, KeyOptions{Algorithm: "ES256"})
+	suite.Require().NoError(err)
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	current, err := keySvc.signingKey(ctx)
+	suite.Require().NoError(err)
+
+	// 其他 instance 建立並啟用了新的金鑰, 快取過期之前仍然使用舊的金鑰
+	next, err := generateSigningKey("ES256", time.Now().UTC())
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.keyRepo.CreateKey(ctx, next))
+
+	signingKey, err := keySvc.signingKey(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(current.ID, signingKey.ID)
+
+	keySvc.mu.Lock()
+	keySvc.loadedAt = keySvc.loadedAt.Add(-keyCacheTTL)
+	keySvc.mu.Unlock()
+
+	signingKey, err = keySvc.signingKey(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(next.ID, signingKey.ID)
+
+	keys, err := keySvc.PublicKeys(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Len(keys, 2)
+}
+
+func (suite *KeyTestSuite) TestRotateKeysLocked() {
+	ctx := context.Background()
+
+	keySvc, err := NewKeyUsecase(suite.keyRepo, KeyOptions{Algorithm: "ES256"})
+	suite.Require().NoError(err)
+
+	// 其他 instance 正在輪替金鑰時需要等待 lock
+	unlock, err := suite.keyRepo.LockKeys(ctx)
+	suite.Require().NoError(err)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	err = keySvc.RotateKeys(timeoutCtx)
+	suite.Require().ErrorIs(err, context.DeadlineExceeded)
+
+	keys, err := suite.keyRepo.Keys(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(keys)
+
+	unlock()
+
+	err = keySvc.RotateKeys(ctx)
+	suite.Require().NoError(err)
+
+	keys, err = suite.keyRepo.Keys(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Len(keys, 1)
+}
@@ -0,0 +1,445 @@
+package usecase
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/nite-coder/blackbear/pkg/log"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	defaultKeyAlgorithm        = "RS256"
+	defaultKeyRotationInterval = 30 * 24 * time.Hour
+	defaultKeyPublishAhead     = 24 * time.Hour
+	defaultKeyRetention        = 24 * time.Hour
+
+	// keyReloadInterval 遇到不認得的 kid 時重新讀取 keystore 的最短間隔
+	keyReloadInterval = 10 * time.Second
+	// keyCacheTTL 快取的金鑰超過這個時間就重新讀取 keystore, 讓其他 instance 輪替的金鑰可以被用來簽章並發佈到 JWKS
+	keyCacheTTL = time.Minute
+)
+
+// KeyOptions 是 JWT 簽章金鑰的設定
+type KeyOptions struct {
+	// Algorithm 簽章演算法, 支援 RS256, ES256 與 EdDSA
+	Algorithm string
+	Issuer    string
+	// RotationInterval 每把金鑰用來簽章的時間
+	RotationInterval time.Duration
+	// PublishAhead 新的金鑰在開始簽章之前先發佈到 JWKS 的時間
+	PublishAhead time.Duration
+	// Retention 金鑰被取代之後仍然保留在 JWKS 的時間, 需要大於 accessToken 的存活時間
+	Retention time.Duration
+}
+
+// accessTokenClaims 是 JWT 模式下 accessToken 的內容
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	AccountID   int64             `json:"account_id"`
+	Namespace   string            `json:"namespace"`
+	AccountType int32             `json:"account_type"`
+	Username    string            `json:"username,omitempty"`
+	SessionID   string            `json:"sid,omitempty"`
+	Actor       *domain.Actor     `json:"act,omitempty"`
+	Claims      map[string]string `json:"claims,omitempty"`
+}
+
+// idTokenClaims 是 OpenID Connect ID token 的內容
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string   `json:"nonce,omitempty"`
+	AuthTime          int64    `json:"auth_time,omitempty"`
+	AMR               []string `json:"amr,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Nickname          string   `json:"nickname,omitempty"`
+	GivenName         string   `json:"given_name,omitempty"`
+	FamilyName        string   `json:"family_name,omitempty"`
+	Picture           string   `json:"picture,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	PhoneNumber       string   `json:"phone_number,omitempty"`
+}
+
+type KeyUsecase struct {
+	keyRepo domain.KeyRepository
+	options KeyOptions
+
+	mu       sync.RWMutex
+	keys     []domain.SigningKey
+	loadedAt time.Time
+}
+
+func NewKeyUsecase(keyRepo domain.KeyRepository, options KeyOptions) (*KeyUsecase, error) {
+	if options.Algorithm == "" {
+		options.Algorithm = defaultKeyAlgorithm
+	}
+
+	if jwt.GetSigningMethod(options.Algorithm) == nil {
+		return nil, fmt.Errorf("usecase: key algorithm %s is not supported. %w", options.Algorithm, domain.ErrInvalidInput)
+	}
+
+	if options.RotationInterval <= 0 {
+		options.RotationInterval = defaultKeyRotationInterval
+	}
+
+	if options.PublishAhead <= 0 {
+		options.PublishAhead = defaultKeyPublishAhead
+	}
+
+	if options.PublishAhead >= options.RotationInterval {
+		options.PublishAhead = options.RotationInterval / 2
+	}
+
+	if options.Retention <= 0 {
+		options.Retention = defaultKeyRetention
+	}
+
+	return &KeyUsecase{
+		keyRepo: keyRepo,
+		options: options,
+	}, nil
+}
+
+// RotateKeys 最新的金鑰快要到期時, 建立下一把金鑰並提前發佈; 被取代超過 Retention 的金鑰會被刪除
+// 多個 instance 共用 keystore 時透過 LockKeys 互斥, 取得 lock 之後才讀取目前的金鑰, 避免重複建立
+func (uc *KeyUsecase) RotateKeys(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	unlock, err := uc.keyRepo.LockKeys(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := uc.keyRepo.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	var next time.Time
+	if len(keys) == 0 {
+		next = now
+	} else {
+		latest := keys[len(keys)-1]
+		if !now.Before(latest.ActivatesAt.Add(uc.options.RotationInterval - uc.options.PublishAhead)) {
+			next = latest.ActivatesAt.Add(uc.options.RotationInterval)
+			if next.Before(now) {
+				next = now
+			}
+		}
+	}
+
+	if !next.IsZero() {
+		key, err := generateSigningKey(uc.options.Algorithm, next)
+		if err != nil {
+			return err
+		}
+
+		err = uc.keyRepo.CreateKey(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		logger.Str("kid", key.ID).Str("activates_at", key.ActivatesAt.Format(time.RFC3339)).Info("usecase: signing key is created")
+		keys = append(keys, *key)
+	}
+
+	for i := 0; i < len(keys)-1; i++ {
+		if now.Before(keys[i+1].ActivatesAt.Add(uc.options.Retention)) {
+			continue
+		}
+
+		err = uc.keyRepo.DeleteKey(ctx, keys[i].ID)
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+
+		logger.Str("kid", keys[i].ID).Info("usecase: signing key is retired")
+	}
+
+	_, err = uc.reloadKeys(ctx)
+	return err
+}
+
+func (uc *KeyUsecase) PublicKeys(ctx context.Context) ([]domain.SigningKey, error) {
+	return uc.cachedKeys(ctx)
+}
+
+// SignToken 把 token 簽成 JWT, jti 為 token 在 redis 中的 key
+func (uc *KeyUsecase) SignToken(ctx context.Context, token domain.Token) (string, error) {
+	key, err := uc.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	claims := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    uc.options.Issuer,
+			Subject:   strconv.FormatInt(token.AccountID, 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(token.ExpiresIn) * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        token.TokenString,
+		},
+		AccountID:   token.AccountID,
+		Namespace:   token.Namespace,
+		AccountType: token.AccountType,
+		Username:    token.Username,
+		SessionID:   token.Claims[domain.SessionKey],
+		Actor:       token.Actor(),
+		Claims:      publicClaims(token.Claims),
+	}
+
+	jwtToken := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	jwtToken.Header["kid"] = key.ID
+
+	return jwtToken.SignedString(key.PrivateKey)
+}
+
+func (uc *KeyUsecase) SignIDToken(ctx context.Context, idToken domain.IDToken) (string, error) {
+	key, err := uc.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	claims := idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    uc.options.Issuer,
+			Subject:   idToken.Subject,
+			Audience:  jwt.ClaimStrings{idToken.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(idToken.ExpiresIn) * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce:             idToken.Nonce,
+		AMR:               idToken.AMR,
+		PreferredUsername: idToken.PreferredUsername,
+		Nickname:          idToken.Nickname,
+		GivenName:         idToken.GivenName,
+		FamilyName:        idToken.FamilyName,
+		Picture:           idToken.Picture,
+		Email:             idToken.Email,
+		PhoneNumber:       idToken.PhoneNumber,
+	}
+
+	if !idToken.AuthTime.IsZero() {
+		claims.AuthTime = idToken.AuthTime.Unix()
+	}
+
+	jwtToken := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	jwtToken.Header["kid"] = key.ID
+
+	return jwtToken.SignedString(key.PrivateKey)
+}
+
+// ParseToken 驗證 JWT 的簽章與期限, 回傳的 token 的 TokenString 為 redis 中的 key
+func (uc *KeyUsecase) ParseToken(ctx context.Context, tokenString string) (*domain.Token, error) {
+	var claims accessTokenClaims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := uc.verificationKey(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+
+		return key.PublicKey(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("usecase: parse jwt failed: %v. %w", err, domain.ErrInvalidToken)
+	}
+
+	if uc.options.Issuer != "" && !claims.VerifyIssuer(uc.options.Issuer, true) {
+		return nil, fmt.Errorf("usecase: jwt issuer is invalid. %w", domain.ErrInvalidToken)
+	}
+
+	token := domain.Token{
+		AccountID:   claims.AccountID,
+		Namespace:   claims.Namespace,
+		AccountType: claims.AccountType,
+		Username:    claims.Username,
+		TokenString: claims.ID,
+		Claims:      copyClaims(claims.Claims),
+	}
+
+	if claims.SessionID != "" {
+		token.Claims[domain.SessionKey] = claims.SessionID
+	}
+
+	if claims.Actor != nil {
+		actor, err := json.Marshal(claims.Actor)
+		if err != nil {
+			return nil, err
+		}
+		token.Claims[domain.ClaimActor] = string(actor)
+	}
+
+	if claims.ExpiresAt != nil {
+		token.ExpiresIn = int64(time.Until(claims.ExpiresAt.Time) / time.Second)
+	}
+
+	return &token, nil
+}
+
+// signingKey 回傳目前用來簽章的金鑰, 也就是已經生效的金鑰中最新的一把
+func (uc *KeyUsecase) signingKey(ctx context.Context) (*domain.SigningKey, error) {
+	keys, err := uc.cachedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].ActivatesAt.After(now) {
+			return &keys[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("usecase: no active signing key. %w", domain.ErrNotFound)
+}
+
+func (uc *KeyUsecase) verificationKey(ctx context.Context, kid string) (*domain.SigningKey, error) {
+	keys, err := uc.cachedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := findKey(keys, kid)
+	if key != nil {
+		return key, nil
+	}
+
+	// 其他 instance 可能已經建立了新的金鑰
+	uc.mu.RLock()
+	loadedAt := uc.loadedAt
+	uc.mu.RUnlock()
+	if time.Since(loadedAt) < keyReloadInterval {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+
+	keys, err = uc.reloadKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key = findKey(keys, kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown kid %s", kid)
+	}
+
+	return key, nil
+}
+
+func (uc *KeyUsecase) cachedKeys(ctx context.Context) ([]domain.SigningKey, error) {
+	uc.mu.RLock()
+	keys := uc.keys
+	loadedAt := uc.loadedAt
+	uc.mu.RUnlock()
+
+	if keys != nil && time.Since(loadedAt) < keyCacheTTL {
+		return keys, nil
+	}
+
+	reloaded, err := uc.reloadKeys(ctx)
+	if err != nil {
+		if keys == nil {
+			return nil, err
+		}
+
+		// keystore 暫時讀不到時繼續使用快取的金鑰
+		log.FromContext(ctx).Err(err).Warn("usecase: reload signing keys failed, use cached keys")
+		return keys, nil
+	}
+
+	return reloaded, nil
+}
+
+func (uc *KeyUsecase) reloadKeys(ctx context.Context) ([]domain.SigningKey, error) {
+	keys, err := uc.keyRepo.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uc.mu.Lock()
+	uc.keys = keys
+	uc.loadedAt = time.Now()
+	uc.mu.Unlock()
+
+	return keys, nil
+}
+
+func findKey(keys []domain.SigningKey, kid string) *domain.SigningKey {
+	for i := range keys {
+		if keys[i].ID == kid {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// publicClaims 移除只在 identity 內部使用的 claims, 例如配對的 refreshToken
+func publicClaims(claims map[string]string) map[string]string {
+	result := copyClaims(claims)
+	delete(result, domain.PairTokenKey)
+	delete(result, domain.BindHashKey)
+	delete(result, domain.SessionKey)
+	delete(result, domain.TokenTypeKey)
+	delete(result, domain.ClaimActor)
+	return result
+}
+
+func generateSigningKey(algorithm string, activatesAt time.Time) (*domain.SigningKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("usecase: key algorithm %s is not supported. %w", algorithm, domain.ErrInvalidInput)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// kid 使用 RFC 7638 的 JWK thumbprint
+	jwk := jose.JSONWebKey{Key: privateKey.Public()}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.SigningKey{
+		ID:          base64.RawURLEncoding.EncodeToString(thumbprint),
+		Algorithm:   algorithm,
+		PrivateKey:  privateKey,
+		CreatedAt:   time.Now().UTC(),
+		ActivatesAt: activatesAt,
+	}, nil
+}
@@ -0,0 +1,187 @@
+package usecase
+
+import (
+	"context"
+	"identity/pkg/domain"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LoginLogTestSuite struct {
+	suite.Suite
+	loginLogRepo *fakeLoginLogRepo
+	usecase      *LoginLogUsecase
+	namespace    string
+	now          time.Time
+}
+
+func TestLoginLogTestSuite(t *testing.T) {
+	suite.Run(t, &LoginLogTestSuite{namespace: "test.identity"})
+}
+
+func (suite *LoginLogTestSuite) SetupTest() {
+	suite.now = time.Date(2022, 7, 31, 0, 0, 0, 0, time.UTC)
+	suite.loginLogRepo = &fakeLoginLogRepo{}
+	suite.usecase = NewLoginLogUsecase(suite.loginLogRepo)
+	suite.usecase.now = func() time.Time { return suite.now }
+}
+
+func (suite *LoginLogTestSuite) createLoginLog(targetID string, state domain.LoginLogState, countryCode string, createdAt time.Time) {
+	_ = suite.loginLogRepo.CreateLoginLog(context.Background(), &domain.LoginLog{
+		Namespace:   suite.namespace,
+		TargetID:    targetID,
+		CountryCode: countryCode,
+		DeviceType:  domain.DeviceTypeWeb,
+		State:       state,
+		ClientIP:    "182.48.113.104",
+		CreatedAt:   createdAt,
+	})
+}
+
+func (suite *LoginLogTestSuite) TestLoginLogs() {
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		suite.createLoginLog("1", domain.LoginLogSuccess, "TW", suite.now.Add(time.Duration(i)*time.Hour))
+	}
+	suite.createLoginLog("2", domain.LoginLogFail, "JP", suite.now)
+	_ = suite.loginLogRepo.CreateLoginLog(ctx, &domain.LoginLog{Namespace: suite.namespace, TargetID: "1", DeviceType: domain.DeviceTypeIOS, State: domain.LoginLogFail, CreatedAt: suite.now})
+
+	opts := domain.FindLoginLogOptions{Namespace: suite.namespace, TargetID: "1", State: domain.LoginLogSuccess, Limit: 2}
+	ids := []uint64{}
+	for page := 0; page < 5; page++ {
+		loginLogs, nextCursor, err := suite.usecase.LoginLogs(ctx, opts)
+		suite.Require().NoError(err)
+		for _, loginLog := range loginLogs {
+			ids = append(ids, loginLog.ID)
+		}
+		if nextCursor == 0 {
+			break
+		}
+		opts.Cursor = nextCursor
+	}
+	suite.Equal([]uint64{5, 4, 3, 2, 1}, ids)
+
+	loginLogs, _, err := suite.usecase.LoginLogs(ctx, domain.FindLoginLogOptions{DeviceType: domain.DeviceTypeIOS})
+	suite.Require().NoError(err)
+	suite.Require().Len(loginLogs, 1)
+	suite.Equal(uint64(7), loginLogs[0].ID)
+
+	total, err := suite.usecase.CountLoginLogs(ctx, domain.FindLoginLogOptions{CountryCode: "JP"})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), total)
+
+	_, err = suite.usecase.CountLoginLogs(ctx, domain.FindLoginLogOptions{CreatedTimeStart: suite.now, CreatedTimeEnd: suite.now})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
+
+func (suite *LoginLogTestSuite) TestLoginSummary() {
+	ctx := context.Background()
+	suite.createLoginLog("1", domain.LoginLogSuccess, "TW", suite.now.Add(-60*24*time.Hour))
+	suite.createLoginLog("1", domain.LoginLogFail, "US", suite.now.Add(-40*24*time.Hour))
+	for i := 0; i < 12; i++ {
+		suite.createLoginLog("1", domain.LoginLogFail, "JP", suite.now.Add(-time.Duration(12-i)*time.Hour))
+	}
+	suite.createLoginLog("1", domain.LoginLogFail, "", suite.now.Add(-time.Minute))
+	suite.createLoginLog("2", domain.LoginLogSuccess, "DE", suite.now.Add(-time.Minute))
+
+	summary, err := suite.usecase.LoginSummary(ctx, domain.LoginSummaryRequest{Namespace: suite.namespace, AccountID: 1})
+	suite.Require().NoError(err)
+
+	// 最後一次成功登入不受統計期間限制
+	suite.Require().NotNil(summary.LastSuccessLogin)
+	suite.Equal(uint64(1), summary.LastSuccessLogin.ID)
+	suite.Equal(suite.now.Add(-30*24*time.Hour), summary.Since)
+	suite.Equal(int64(13), summary.FailureCount)
+	suite.Len(summary.RecentFailures, loginSummaryRecentFailures)
+	suite.Equal(uint64(15), summary.RecentFailures[0].ID)
+	suite.Equal([]string{"JP"}, summary.CountryCodes)
+
+	summary, err = suite.usecase.LoginSummary(ctx, domain.LoginSummaryRequest{Namespace: suite.namespace, AccountID: 1, Since: suite.now.Add(-90 * 24 * time.Hour)})
+	suite.Require().NoError(err)
+	suite.Equal(int64(14), summary.FailureCount)
+	suite.Equal([]string{"JP", "TW", "US"}, summary.CountryCodes)
+
+	summary, err = suite.usecase.LoginSummary(ctx, domain.LoginSummaryRequest{Namespace: suite.namespace, AccountID: 3})
+	suite.Require().NoError(err)
+	suite.Nil(summary.LastSuccessLogin)
+	suite.Empty(summary.RecentFailures)
+	suite.Empty(summary.CountryCodes)
+
+	_, err = suite.usecase.LoginSummary(ctx, domain.LoginSummaryRequest{Namespace: suite.namespace})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
+
+type fakeLoginLogRepo struct {
+	loginLogs []*domain.LoginLog
+}
+
+func (repo *fakeLoginLogRepo) CreateLoginLog(ctx context.Context, loginLog *domain.LoginLog) error {
+	loginLog.ID = uint64(len(repo.loginLogs) + 1)
+	repo.loginLogs = append(repo.loginLogs, loginLog)
+	return nil
+}
+
+func (repo *fakeLoginLogRepo) LoginLogsAfter(ctx context.Context, afterID uint64, limit int) ([]domain.LoginLog, error) {
+	result := []domain.LoginLog{}
+	for _, loginLog := range repo.loginLogs {
+		if loginLog.ID > afterID && len(result) < limit {
+			result = append(result, *loginLog)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeLoginLogRepo) LoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) ([]domain.LoginLog, error) {
+	result := []domain.LoginLog{}
+	for i := len(repo.loginLogs) - 1; i >= 0; i-- {
+		loginLog := repo.loginLogs[i]
+		if !matchLoginLog(loginLog, opts) || (opts.Cursor > 0 && loginLog.ID >= opts.Cursor) {
+			continue
+		}
+		if opts.Limit > 0 && len(result) == opts.Limit {
+			break
+		}
+		result = append(result, *loginLog)
+	}
+	return result, nil
+}
+
+func (repo *fakeLoginLogRepo) CountLoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) (int64, error) {
+	var total int64
+	for _, loginLog := range repo.loginLogs {
+		if matchLoginLog(loginLog, opts) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (repo *fakeLoginLogRepo) LoginCountries(ctx context.Context, opts domain.FindLoginLogOptions) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, loginLog := range repo.loginLogs {
+		if matchLoginLog(loginLog, opts) && loginLog.CountryCode != "" && !seen[loginLog.CountryCode] {
+			seen[loginLog.CountryCode] = true
+			result = append(result, loginLog.CountryCode)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func matchLoginLog(loginLog *domain.LoginLog, opts domain.FindLoginLogOptions) bool {
+	switch {
+	case opts.Namespace != "" && loginLog.Namespace != opts.Namespace,
+		opts.TargetID != "" && loginLog.TargetID != opts.TargetID,
+		opts.State > 0 && loginLog.State != opts.State,
+		opts.DeviceType > 0 && loginLog.DeviceType != opts.DeviceType,
+		opts.CountryCode != "" && loginLog.CountryCode != opts.CountryCode,
+		!opts.CreatedTimeStart.IsZero() && loginLog.CreatedAt.Before(opts.CreatedTimeStart),
+		!opts.CreatedTimeEnd.IsZero() && !loginLog.CreatedAt.Before(opts.CreatedTimeEnd):
+		return false
+	}
+	return true
+}
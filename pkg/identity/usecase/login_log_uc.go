@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"time"
+)
+
+const (
+	loginLogDefaultLimit = 50
+	loginLogMaxLimit     = 500
+	// loginSummaryPeriod 是沒有指定 Since 時, 登入摘要統計的期間
+	loginSummaryPeriod = 30 * 24 * time.Hour
+	// loginSummaryRecentFailures 是登入摘要最多回傳的登入失敗紀錄
+	loginSummaryRecentFailures = 10
+)
+
+type LoginLogUsecase struct {
+	loginLogRepo domain.LoginLogRepository
+	now          func() time.Time
+}
+
+func NewLoginLogUsecase(loginLogRepo domain.LoginLogRepository) *LoginLogUsecase {
+	return &LoginLogUsecase{
+		loginLogRepo: loginLogRepo,
+		now:          time.Now,
+	}
+}
+
+func (uc *LoginLogUsecase) LoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) ([]domain.LoginLog, uint64, error) {
+	err := validateFindLoginLogOptions(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = loginLogDefaultLimit
+	}
+	if limit > loginLogMaxLimit {
+		limit = loginLogMaxLimit
+	}
+
+	// 多讀一筆判斷是否還有下一頁
+	opts.Limit = limit + 1
+	loginLogs, err := uc.loginLogRepo.LoginLogs(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var nextCursor uint64
+	if len(loginLogs) > limit {
+		loginLogs = loginLogs[:limit]
+		nextCursor = loginLogs[limit-1].ID
+	}
+
+	return loginLogs, nextCursor, nil
+}
+
+func (uc *LoginLogUsecase) CountLoginLogs(ctx context.Context, opts domain.FindLoginLogOptions) (int64, error) {
+	err := validateFindLoginLogOptions(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	return uc.loginLogRepo.CountLoginLogs(ctx, opts)
+}
+
+func (uc *LoginLogUsecase) LoginSummary(ctx context.Context, request domain.LoginSummaryRequest) (*domain.LoginSummary, error) {
+	if request.Namespace == "" || request.AccountID == 0 {
+		return nil, fmt.Errorf("namespace and account id are required. %w", domain.ErrInvalidInput)
+	}
+
+	since := request.Since
+	if since.IsZero() {
+		since = uc.now().UTC().Add(-loginSummaryPeriod)
+	}
+
+	summary := domain.LoginSummary{
+		Namespace:      request.Namespace,
+		AccountID:      request.AccountID,
+		Since:          since,
+		RecentFailures: []domain.LoginLog{},
+	}
+
+	opts := domain.FindLoginLogOptions{
+		Namespace: request.Namespace,
+		TargetID:  strconv.FormatUint(request.AccountID, 10),
+		State:     domain.LoginLogSuccess,
+		Limit:     1,
+	}
+	loginLogs, err := uc.loginLogRepo.LoginLogs(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(loginLogs) > 0 {
+		summary.LastSuccessLogin = &loginLogs[0]
+	}
+
+	opts.State = domain.LoginLogFail
+	opts.CreatedTimeStart = since
+	opts.Limit = loginSummaryRecentFailures
+	summary.RecentFailures, err = uc.loginLogRepo.LoginLogs(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Limit = 0
+	summary.FailureCount, err = uc.loginLogRepo.CountLoginLogs(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.State = domain.LoginLogDefault
+	summary.CountryCodes, err = uc.loginLogRepo.LoginCountries(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+func validateFindLoginLogOptions(opts domain.FindLoginLogOptions) error {
+	if !opts.CreatedTimeStart.IsZero() && !opts.CreatedTimeEnd.IsZero() && !opts.CreatedTimeEnd.After(opts.CreatedTimeStart) {
+		return fmt.Errorf("created time end must be after start. %w", domain.ErrInvalidInput)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"identity/pkg/domain"
+	identityHTTP "identity/pkg/identity/delivery/http"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func (suite *OAuthTestSuite) postForm(server *httptest.Server, path string, form url.Values) (int, map[string]interface{}) {
+	resp, err := http.PostForm(server.URL+path, form)
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+
+	body := map[string]interface{}{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&body))
+	}
+	return resp.StatusCode, body
+}
+
+func (suite *OAuthTestSuite) TestDeviceAuthorizationGrant() {
+	ctx := context.Background()
+
+	server := httptest.NewServer(identityHTTP.NewIdentityHandler(nil, suite.usecase, nil, nil, nil).Routes())
+	defer server.Close()
+
+	client, _, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "cli",
+		GrantTypes: []string{domain.GrantTypeDeviceCode, domain.GrantTypeRefreshToken},
+		Scopes:     []string{"profile"},
+		Public:     true,
+	})
+	suite.Require().NoError(err)
+
+	status, body := suite.postForm(server, "/device_authorization", url.Values{"client_id": {client.ClientID}})
+	suite.Require().Equal(http.StatusOK, status, body)
+	deviceCode := body["device_code"].(string)
+	userCode := body["user_code"].(string)
+	suite.Assert().Regexp(`^[B-Z]{4}-[B-Z]{4}$`, userCode)
+	suite.Assert().Equal(float64(5), body["interval"])
+
+	tokenForm := url.Values{
+		"grant_type":  {domain.GrantTypeDeviceCode},
+		"client_id":   {client.ClientID},
+		"device_code": {deviceCode},
+	}
+
+	status, body = suite.postForm(server, "/token", tokenForm)
+	suite.Assert().Equal(http.StatusBadRequest, status)
+	suite.Assert().Equal("authorization_pending", body["error"])
+
+	// interval 之內再次 polling
+	status, body = suite.postForm(server, "/token", tokenForm)
+	suite.Assert().Equal(http.StatusBadRequest, status)
+	suite.Assert().Equal("slow_down", body["error"])
+
+	resp, err := http.Get(server.URL + "/device?user_code=" + url.QueryEscape(userCode))
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Assert().Equal(http.StatusOK, resp.StatusCode)
+
+	verifyForm := url.Values{
+		"user_code": {strings.ToLower(strings.Replace(userCode, "-", "", 1))},
+		"username":  {"angela"},
+		"password":  {"wrong"},
+		"action":    {"approve"},
+	}
+	resp, err = http.PostForm(server.URL+"/device", verifyForm)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Assert().Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	verifyForm.Set("password", "password")
+	resp, err = http.PostForm(server.URL+"/device", verifyForm)
+	suite.Require().NoError(err)
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Assert().Equal(http.StatusOK, resp.StatusCode)
+	suite.Assert().Contains(string(page), "Device connected to cli")
+
+	suite.redisServer.FastForward(5 * time.Second)
+
+	status, body = suite.postForm(server, "/token", tokenForm)
+	suite.Require().Equal(http.StatusOK, status, body)
+	suite.Assert().NotEmpty(body["refresh_token"])
+
+	token, err := suite.tokenSvc.Token(ctx, body["access_token"].(string))
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), token.AccountID)
+	suite.Assert().Equal(client.ClientID, token.Claims[domain.ClaimClientID])
+
+	// device code 只能使用一次
+	suite.redisServer.FastForward(5 * time.Second)
+	status, body = suite.postForm(server, "/token", tokenForm)
+	suite.Assert().Equal(http.StatusBadRequest, status)
+	suite.Assert().Equal("invalid_grant", body["error"])
+}
+
+func (suite *OAuthTestSuite) TestDeviceAuthorizationDeniedAndExpired() {
+	ctx := context.Background()
+
+	client, _, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "tv",
+		GrantTypes: []string{domain.GrantTypeDeviceCode},
+		Public:     true,
+	})
+	suite.Require().NoError(err)
+
+	authorization, err := suite.usecase.DeviceAuthorization(ctx, domain.DeviceAuthorizationRequest{ClientID: client.ClientID})
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.ApproveDevice(ctx, domain.ApproveDeviceRequest{
+		Namespace: "other.namespace",
+		AccountID: 1,
+		UserCode:  authorization.UserCode,
+		Approved:  true,
+	})
+	suite.Require().ErrorIs(err, domain.ErrNotFound)
+
+	_, err = suite.usecase.ApproveDevice(ctx, domain.ApproveDeviceRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		UserCode:  authorization.UserCode,
+		Approved:  false,
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:  domain.GrantTypeDeviceCode,
+		ClientID:   client.ClientID,
+		DeviceCode: authorization.DeviceCode,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthAccessDenied)
+
+	// 超過期限之後 user code 與 device code 都不能再使用
+	authorization, err = suite.usecase.DeviceAuthorization(ctx, domain.DeviceAuthorizationRequest{ClientID: client.ClientID})
+	suite.Require().NoError(err)
+
+	record, err := suite.usecase.deviceRepo.DeviceAuthorization(ctx, authorization.DeviceCode)
+	suite.Require().NoError(err)
+	record.ExpiresAt = time.Now().Add(-time.Second)
+	suite.Require().NoError(suite.usecase.deviceRepo.UpdateDeviceAuthorization(ctx, record))
+
+	_, err = suite.usecase.ApproveDevice(ctx, domain.ApproveDeviceRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		UserCode:  authorization.UserCode,
+		Approved:  true,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthExpiredToken)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:  domain.GrantTypeDeviceCode,
+		ClientID:   client.ClientID,
+		DeviceCode: authorization.DeviceCode,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthExpiredToken)
+}
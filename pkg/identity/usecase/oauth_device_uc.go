@@ -0,0 +1,273 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDeviceCodeLifetime = 10 * time.Minute
+	defaultDeviceCodeInterval = 5 * time.Second
+
+	// userCodeCharset 只使用不容易看錯的子音 (RFC 8628 6.1)
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+func (uc *OAuthUsecase) DeviceAuthorization(ctx context.Context, request domain.DeviceAuthorizationRequest) (*domain.DeviceAuthorizationResponse, error) {
+	client, err := uc.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowGrantType(domain.GrantTypeDeviceCode) {
+		return nil, domain.ErrOAuthUnauthorizedClient
+	}
+
+	scope := request.Scope
+	if scope == "" {
+		scope = client.Scopes
+	}
+
+	if !client.AllowScope(scope) {
+		return nil, domain.ErrOAuthInvalidScope
+	}
+
+	if domain.HasScope(scope, domain.ScopeOpenID) && uc.options.KeySvc == nil {
+		return nil, fmt.Errorf("openid connect is not enabled. %w", domain.ErrOAuthInvalidScope)
+	}
+
+	deviceCode, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization := domain.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		ClientID:   client.ClientID,
+		Namespace:  client.Namespace,
+		Scope:      scope,
+		Status:     domain.DeviceAuthorizationPending,
+		ExpiresAt:  time.Now().UTC().Add(uc.options.DeviceCodeLifetime),
+	}
+
+	// user code 只有 8 碼, 重複時重新產生
+	for i := 0; i < 3; i++ {
+		authorization.UserCode, err = newUserCode()
+		if err != nil {
+			return nil, err
+		}
+
+		// 過期之後多保留一段時間, 用來區分過期與無效的 device code
+		err = uc.deviceRepo.CreateDeviceAuthorization(ctx, &authorization, 2*uc.options.DeviceCodeLifetime)
+		if !errors.Is(err, domain.ErrAlreadyExists) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userCode := formatUserCode(authorization.UserCode)
+	verificationURI := uc.options.Issuer + "/device"
+
+	return &domain.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int64(uc.options.DeviceCodeLifetime / time.Second),
+		Interval:                int64(uc.options.DeviceCodeInterval / time.Second),
+	}, nil
+}
+
+// ApproveDevice 給已經登入的帳號使用, 例如內部 portal 取得 accessToken 之後呼叫
+func (uc *OAuthUsecase) ApproveDevice(ctx context.Context, request domain.ApproveDeviceRequest) (*domain.OAuthClient, error) {
+	authorization, client, err := uc.pendingDeviceAuthorization(ctx, request.UserCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Namespace != request.Namespace {
+		return nil, fmt.Errorf("user code is invalid. %w", domain.ErrNotFound)
+	}
+
+	if !request.Approved {
+		authorization.Status = domain.DeviceAuthorizationDenied
+		return client, uc.deviceRepo.UpdateDeviceAuthorization(ctx, authorization)
+	}
+
+	account, err := uc.accountSvc.Account(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.State != domain.AccountStatusNormal {
+		return nil, fmt.Errorf("account state is %s. %w", account.State.String(), domain.ErrOAuthAccessDenied)
+	}
+
+	amr := request.AMR
+	if amr == "" {
+		amr = "pwd"
+	}
+
+	authorization.Status = domain.DeviceAuthorizationApproved
+	authorization.AccountID = account.ID
+	authorization.AMR = amr
+	authorization.AuthTime = time.Now().UTC()
+
+	err = uc.deviceRepo.UpdateDeviceAuthorization(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// VerifyDevice 給 verification page 使用, 同意或拒絕之前都必須先驗證帳號密碼
+func (uc *OAuthUsecase) VerifyDevice(ctx context.Context, request domain.VerifyDeviceRequest) (*domain.OAuthClient, error) {
+	_, client, err := uc.pendingDeviceAuthorization(ctx, request.UserCode)
+	if err != nil {
+		return nil, err
+	}
+
+	request.LoginInfo.Namespace = client.Namespace
+	account, amr, err := uc.login(ctx, request.LoginInfo, request.OTPCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.ApproveDevice(ctx, domain.ApproveDeviceRequest{
+		Namespace: account.Namespace,
+		AccountID: account.ID,
+		UserCode:  request.UserCode,
+		Approved:  request.Approved,
+		AMR:       amr,
+	})
+}
+
+func (uc *OAuthUsecase) pendingDeviceAuthorization(ctx context.Context, userCode string) (*domain.DeviceAuthorization, *domain.OAuthClient, error) {
+	userCode = normalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return nil, nil, fmt.Errorf("user code is invalid. %w", domain.ErrNotFound)
+	}
+
+	authorization, err := uc.deviceRepo.DeviceAuthorizationByUserCode(ctx, userCode)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return nil, nil, fmt.Errorf("user code is invalid. %w", domain.ErrNotFound)
+		}
+		return nil, nil, err
+	}
+
+	if time.Now().After(authorization.ExpiresAt) {
+		return nil, nil, domain.ErrOAuthExpiredToken
+	}
+
+	if authorization.Status != domain.DeviceAuthorizationPending {
+		return nil, nil, fmt.Errorf("user code was already used. %w", domain.ErrNotFound)
+	}
+
+	client, err := uc.clientRepo.OAuthClient(ctx, authorization.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authorization, client, nil
+}
+
+// exchangeDeviceCode 裝置用 device code polling /token, 使用者同意之後才會簽發 token (RFC 8628 3.4)
+func (uc *OAuthUsecase) exchangeDeviceCode(ctx context.Context, client *domain.OAuthClient, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if request.DeviceCode == "" {
+		return nil, fmt.Errorf("device_code is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	authorization, err := uc.deviceRepo.DeviceAuthorization(ctx, request.DeviceCode)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return nil, fmt.Errorf("device_code is invalid. %w", domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	if authorization.ClientID != client.ClientID {
+		return nil, fmt.Errorf("device_code was issued to another client. %w", domain.ErrOAuthInvalidGrant)
+	}
+
+	if time.Now().After(authorization.ExpiresAt) {
+		return nil, domain.ErrOAuthExpiredToken
+	}
+
+	ok, err := uc.deviceRepo.PollDeviceAuthorization(ctx, request.DeviceCode, uc.options.DeviceCodeInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, domain.ErrOAuthSlowDown
+	}
+
+	switch authorization.Status {
+	case domain.DeviceAuthorizationPending:
+		return nil, domain.ErrOAuthAuthorizationPending
+	case domain.DeviceAuthorizationDenied:
+		err = uc.deviceRepo.DeleteDeviceAuthorization(ctx, authorization)
+		if err != nil && !errors.Is(err, domain.ErrKeyNotFound) {
+			return nil, err
+		}
+		return nil, domain.ErrOAuthAccessDenied
+	}
+
+	// device code 只能換一次 token
+	err = uc.deviceRepo.DeleteDeviceAuthorization(ctx, authorization)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return nil, fmt.Errorf("device_code was already used. %w", domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	return uc.issueTokens(ctx, client, accountGrant{
+		Namespace:  authorization.Namespace,
+		AccountID:  authorization.AccountID,
+		Scope:      authorization.Scope,
+		AMR:        authorization.AMR,
+		AuthTime:   authorization.AuthTime,
+		DeviceType: domain.DeviceTypeDefault,
+	}, request)
+}
+
+func newUserCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(userCodeCharset)))
+	for i := 0; i < userCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(userCodeCharset[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// formatUserCode 顯示給使用者時分成兩段, 例如 WDJB-MJHT
+func formatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode 使用者輸入的 user code 不分大小寫, 並且忽略分隔符號
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, userCode)
+}
@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"context"
+	"identity/pkg/domain"
+)
+
+func (suite *OAuthTestSuite) TestTokenExchange() {
+	ctx := context.Background()
+
+	app, appSecret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "app",
+		RedirectURIs: []string{"https://app.test/callback"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken},
+		Scopes:       []string{"profile", "orders.read", "orders.write"},
+	})
+	suite.Require().NoError(err)
+
+	orders, ordersSecret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "orders",
+		GrantTypes: []string{domain.GrantTypeTokenExchange, domain.GrantTypeClientCredentials},
+		Scopes:     []string{"orders.read", "payments.read"},
+	})
+	suite.Require().NoError(err)
+
+	request := suite.authorizeRequest(app, "")
+	request.Scope = "orders.read orders.write"
+	code, err := suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+
+	subject, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     app.ClientID,
+		ClientSecret: appSecret,
+		Code:         code,
+	})
+	suite.Require().NoError(err)
+
+	exchange := domain.OAuthTokenRequest{
+		GrantType:        domain.GrantTypeTokenExchange,
+		ClientID:         orders.ClientID,
+		ClientSecret:     ordersSecret,
+		SubjectToken:     subject.AccessToken,
+		SubjectTokenType: domain.TokenTypeAccessToken,
+		ClientIP:         "10.0.0.1",
+	}
+
+	// 不能要求 subject token 沒有的 scope
+	exchange.Scope = "payments.read"
+	_, err = suite.usecase.Token(ctx, exchange)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidScope)
+
+	exchange.Scope = "orders.read"
+	resp, err := suite.usecase.Token(ctx, exchange)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(domain.TokenTypeAccessToken, resp.IssuedTokenType)
+	suite.Assert().Equal("orders.read", resp.Scope)
+	suite.Assert().Empty(resp.RefreshToken)
+	suite.Assert().LessOrEqual(resp.ExpiresIn, defaultAccessTokenExpiresIn)
+
+	token, err := suite.tokenSvc.Token(ctx, resp.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), token.AccountID)
+	suite.Assert().Equal(orders.ClientID, token.Claims[domain.ClaimClientID])
+	suite.Assert().Equal(&domain.Actor{Subject: orders.ClientID, ClientID: orders.ClientID}, token.Actor())
+
+	// 再 exchange 一次時 act 會是巢狀的, actor token 決定 act 的 sub
+	actorToken, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeClientCredentials,
+		ClientID:     orders.ClientID,
+		ClientSecret: ordersSecret,
+	})
+	suite.Require().NoError(err)
+
+	resp, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:        domain.GrantTypeTokenExchange,
+		ClientID:         orders.ClientID,
+		ClientSecret:     ordersSecret,
+		SubjectToken:     resp.AccessToken,
+		SubjectTokenType: domain.TokenTypeAccessToken,
+		ActorToken:       actorToken.AccessToken,
+		ActorTokenType:   domain.TokenTypeAccessToken,
+	})
+	suite.Require().NoError(err)
+
+	token, err = suite.tokenSvc.Token(ctx, resp.AccessToken)
+	suite.Require().NoError(err)
+	actor := token.Actor()
+	suite.Require().NotNil(actor)
+	suite.Assert().Equal(orders.ClientID, actor.Subject)
+	suite.Require().NotNil(actor.Actor)
+	suite.Assert().Equal(orders.ClientID, actor.Actor.ClientID)
+
+	// refreshToken 不能拿來 exchange
+	exchange.SubjectToken = subject.RefreshToken
+	_, err = suite.usecase.Token(ctx, exchange)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+
+	exchange.SubjectToken = subject.AccessToken
+	exchange.SubjectTokenType = "urn:ietf:params:oauth:token-type:saml2"
+	_, err = suite.usecase.Token(ctx, exchange)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidRequest)
+
+	// app 沒有 token exchange 的權限
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:        domain.GrantTypeTokenExchange,
+		ClientID:         app.ClientID,
+		ClientSecret:     appSecret,
+		SubjectToken:     subject.AccessToken,
+		SubjectTokenType: domain.TokenTypeAccessToken,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthUnauthorizedClient)
+
+	states := []domain.EventLogState{}
+	for _, eventLog := range suite.eventLogs.eventLogs {
+		suite.Assert().Equal("token_exchange", eventLog.Action)
+		suite.Assert().Equal(orders.ClientID, eventLog.Actor)
+		suite.Assert().Equal("1", eventLog.TargetID)
+		states = append(states, eventLog.State)
+	}
+	suite.Assert().Equal([]domain.EventLogState{domain.EventLogFail, domain.EventLogSuccess, domain.EventLogSuccess}, states)
+	suite.Assert().Equal("10.0.0.1", suite.eventLogs.eventLogs[1].ClientIP)
+}
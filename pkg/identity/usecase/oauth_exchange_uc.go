@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/datatypes"
+)
+
+// exchangeToken 讓 service 用帳號的 accessToken 換一個 scope 更小的 accessToken 去呼叫其他 service (RFC 8693)
+// 新的 token 會帶 act claim 記錄是哪個 service 代替帳號呼叫, 不會簽發 refreshToken
+func (uc *OAuthUsecase) exchangeToken(ctx context.Context, client *domain.OAuthClient, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if client.IsPublic() {
+		return nil, domain.ErrOAuthUnauthorizedClient
+	}
+
+	if request.SubjectToken == "" || !isExchangeableTokenType(request.SubjectTokenType) {
+		return nil, fmt.Errorf("subject_token or subject_token_type is invalid. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	if request.ActorToken != "" && !isExchangeableTokenType(request.ActorTokenType) {
+		return nil, fmt.Errorf("actor_token_type is invalid. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	if request.RequestedTokenType != "" && !isExchangeableTokenType(request.RequestedTokenType) {
+		return nil, fmt.Errorf("requested_token_type is not supported. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	// 沒有啟用 JWT 時 accessToken 是 opaque token
+	if strings.EqualFold(request.RequestedTokenType, domain.TokenTypeJWT) && uc.options.KeySvc == nil {
+		return nil, fmt.Errorf("jwt access token is not enabled. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	subject, err := uc.exchangeableToken(ctx, client, request.SubjectToken)
+	if err != nil {
+		return nil, fmt.Errorf("subject_token is invalid: %v. %w", err, domain.ErrOAuthInvalidGrant)
+	}
+
+	actor := &domain.Actor{
+		Subject:  client.ClientID,
+		ClientID: client.ClientID,
+		Actor:    subject.Actor(),
+	}
+
+	if request.ActorToken != "" {
+		actorToken, err := uc.exchangeableToken(ctx, client, request.ActorToken)
+		if err != nil {
+			return nil, fmt.Errorf("actor_token is invalid: %v. %w", err, domain.ErrOAuthInvalidGrant)
+		}
+		actor.Subject = tokenSubject(actorToken)
+	}
+
+	// subject token 有 scope 時只能縮小, 並且不能超過 client 註冊的 scope
+	scope := request.Scope
+	subjectScope, hasScope := subject.Claims[domain.ClaimScope]
+	if scope == "" {
+		scope = client.Scopes
+		if hasScope {
+			scope = subjectScope
+		}
+	}
+
+	if !client.AllowScope(scope) || (hasScope && !(&domain.OAuthClient{Scopes: subjectScope}).AllowScope(scope)) {
+		uc.recordTokenExchange(ctx, client, subject, actor, scope, request.ClientIP, domain.EventLogFail)
+		return nil, domain.ErrOAuthInvalidScope
+	}
+
+	expiresIn := defaultAccessTokenExpiresIn
+	expiresAt, err := uc.tokenSvc.TokenExpiresAt(ctx, request.SubjectToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// 新的 token 不能比 subject token 活得更久
+	if !expiresAt.IsZero() {
+		remaining := int64(time.Until(expiresAt) / time.Second)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("subject_token is expired. %w", domain.ErrOAuthInvalidGrant)
+		}
+		if remaining < expiresIn {
+			expiresIn = remaining
+		}
+	}
+
+	actorJSON, err := json.Marshal(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	serverClaims := map[string]string{
+		domain.ClaimActor: string(actorJSON),
+	}
+	for _, key := range []string{domain.ClaimAMR, domain.ClaimAuthTime} {
+		if val, ok := subject.Claims[key]; ok {
+			serverClaims[key] = val
+		}
+	}
+
+	accessToken, err := uc.tokenSvc.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID:   subject.AccountID,
+			Namespace:   subject.Namespace,
+			Username:    subject.Username,
+			AccountType: subject.AccountType,
+			ExpiresIn:   expiresIn,
+			Claims: map[string]string{
+				domain.ClaimClientID: client.ClientID,
+				domain.ClaimScope:    scope,
+			},
+		},
+		ServerClaims: serverClaims,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uc.recordTokenExchange(ctx, client, subject, actor, scope, request.ClientIP, domain.EventLogSuccess)
+
+	issuedTokenType := domain.TokenTypeAccessToken
+	if strings.EqualFold(request.RequestedTokenType, domain.TokenTypeJWT) {
+		issuedTokenType = domain.TokenTypeJWT
+	}
+
+	return &domain.OAuthTokenResponse{
+		AccessToken:     accessToken,
+		TokenType:       oauthTokenType,
+		ExpiresIn:       expiresIn,
+		Scope:           scope,
+		IssuedTokenType: issuedTokenType,
+	}, nil
+}
+
+// exchangeableToken subject token 與 actor token 都必須是同一個 namespace 的 accessToken
+func (uc *OAuthUsecase) exchangeableToken(ctx context.Context, client *domain.OAuthClient, tokenString string) (*domain.Token, error) {
+	token, err := uc.tokenSvc.Token(ctx, tokenString)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			return nil, errors.New("token is invalid or expired")
+		}
+		return nil, err
+	}
+
+	if token.Claims[domain.TokenTypeKey] == domain.TokenTypeRefresh {
+		return nil, errors.New("refresh token can not be exchanged")
+	}
+
+	if token.Namespace != client.Namespace {
+		return nil, errors.New("token belongs to another namespace")
+	}
+
+	if _, ok := token.Claims[domain.ClaimAPIKeyID]; ok {
+		return nil, errors.New("api key can not be exchanged")
+	}
+
+	// 模擬登入的 token 換出來的 token 會失去 impersonator 的標記
+	if _, ok := token.Impersonator(); ok {
+		return nil, errors.New("impersonation token can not be exchanged")
+	}
+
+	return token, nil
+}
+
+// recordTokenExchange 把 token exchange 記錄到 event log, Actor 為呼叫的 client
+func (uc *OAuthUsecase) recordTokenExchange(ctx context.Context, client *domain.OAuthClient, subject *domain.Token, actor *domain.Actor, scope string, clientIP string, state domain.EventLogState) {
+	logger := log.FromContext(ctx)
+
+	newStatus, err := json.Marshal(map[string]interface{}{
+		"namespace":         subject.Namespace,
+		"account_id":        subject.AccountID,
+		"subject_client_id": subject.Claims[domain.ClaimClientID],
+		"scope":             scope,
+		"act":               actor,
+	})
+	if err != nil {
+		logger.Err(err).Error("usecase: marshal token exchange event failed")
+		return
+	}
+
+	message := "token is exchanged"
+	if state != domain.EventLogSuccess {
+		message = "token exchange is rejected"
+	}
+
+	err = createAuditLog(ctx, uc.eventLogRepo, &domain.EventLog{
+		Namespace: "identity.oauth",
+		Action:    "token_exchange",
+		TargetID:  tokenSubject(subject),
+		Message:   message,
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     state,
+		ClientIP:  clientIP,
+		Actor:     client.ClientID,
+	})
+	if err != nil {
+		logger.Err(err).Str("client_id", client.ClientID).Error("usecase: create token exchange event log failed")
+	}
+}
+
+// tokenSubject 帳號的 token 為 account id, client_credentials 簽發的 token 為 client_id
+func tokenSubject(token *domain.Token) string {
+	if token.AccountID == 0 {
+		return token.Claims[domain.ClaimClientID]
+	}
+	return strconv.FormatInt(token.AccountID, 10)
+}
+
+func isExchangeableTokenType(tokenType string) bool {
+	return strings.EqualFold(tokenType, domain.TokenTypeAccessToken) || strings.EqualFold(tokenType, domain.TokenTypeJWT)
+}
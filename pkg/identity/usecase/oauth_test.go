@@ -0,0 +1,634 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"identity/pkg/domain"
+	identityFile "identity/pkg/identity/repository/file"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+)
+
+type OAuthTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenSvc    domain.TokenUsecase
+	eventLogs   *fakeEventLogRepo
+	usecase     *OAuthUsecase
+	namespace   string
+	account     *domain.Account
+}
+
+func TestOAuthTestSuite(t *testing.T) {
+	suite.Run(t, &OAuthTestSuite{namespace: "test.identity"})
+}
+
+func (suite *OAuthTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	passwordEncrypt, err := encryptPassword("password")
+	suite.Require().NoError(err)
+
+	suite.account = &domain.Account{
+		ID:                1,
+		UUID:              "7b0e1a8c-8d0f-4a35-9d43-4f0e3d0d2b51",
+		Namespace:         suite.namespace,
+		Username:          sql.NullString{String: "angela", Valid: true},
+		FirstName:         "Angela",
+		LastName:          "Chen",
+		Avatar:            "https://cdn.test/angela.png",
+		Email:             sql.NullString{String: "angela@test.identity", Valid: true},
+		MobileCountryCode: sql.NullString{String: "886", Valid: true},
+		Mobile:            sql.NullString{String: "912345678", Valid: true},
+		PasswordEncrypt:   passwordEncrypt,
+		State:             domain.AccountStatusNormal,
+	}
+
+	sessionRepo := identityRedis.NewSessionRepo(client)
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.tokenSvc = NewTokenUsecase(identityRedis.NewTokenRepo(client), sessionRepo, &fakeEventLogRepo{}, nil, TokenOptions{})
+	suite.usecase = NewOAuthUsecase(&fakeOAuthClientRepo{}, identityRedis.NewAuthorizationCodeRepo(client), identityRedis.NewDeviceAuthorizationRepo(client), suite.eventLogs, &fakeAccountUsecase{account: suite.account}, suite.tokenSvc, OAuthOptions{})
+}
+
+func (suite *OAuthTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+type fakeOAuthClientRepo struct {
+	clients []domain.OAuthClient
+}
+
+func (repo *fakeOAuthClientRepo) CreateOAuthClient(ctx context.Context, client *domain.OAuthClient) error {
+	repo.clients = append(repo.clients, *client)
+	return nil
+}
+
+func (repo *fakeOAuthClientRepo) OAuthClient(ctx context.Context, clientID string) (*domain.OAuthClient, error) {
+	for i := range repo.clients {
+		if repo.clients[i].ClientID == clientID {
+			client := repo.clients[i]
+			return &client, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeOAuthClientRepo) OAuthClients(ctx context.Context, namespace string) ([]domain.OAuthClient, error) {
+	result := []domain.OAuthClient{}
+	for _, client := range repo.clients {
+		if client.Namespace == namespace {
+			result = append(result, client)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeOAuthClientRepo) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	for i := range repo.clients {
+		if repo.clients[i].ClientID == clientID {
+			repo.clients = append(repo.clients[:i], repo.clients[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+// fakeAccountUsecase 只實作 OAuth 會用到的方法, 只有一個帳號
+type fakeAccountUsecase struct {
+	domain.AccountUsecase
+	account *domain.Account
+}
+
+func (uc *fakeAccountUsecase) Account(ctx context.Context, namespace string, accountID uint64) (*domain.Account, error) {
+	if namespace != uc.account.Namespace || accountID != uc.account.ID {
+		return nil, domain.ErrNotFound
+	}
+	return uc.account, nil
+}
+
+func (uc *fakeAccountUsecase) Login(ctx context.Context, loginInfo domain.LoginInfo) (*domain.Account, error) {
+	if loginInfo.Namespace != uc.account.Namespace || loginInfo.Username != uc.account.Username.String {
+		return nil, domain.ErrUsernameOrPasswordIncorrect
+	}
+
+	if isPasswordValid(uc.account.PasswordEncrypt, loginInfo.Password) != nil {
+		return nil, domain.ErrUsernameOrPasswordIncorrect
+	}
+	return uc.account, nil
+}
+
+func (uc *fakeAccountUsecase) VerifyOTP(ctx context.Context, accountUUID string, request domain.VerifyOTPRequest) error {
+	if request.OTPCode != "123456" {
+		return domain.ErrOTPCodeIncorrect
+	}
+	return nil
+}
+
+func (suite *OAuthTestSuite) authorizeRequest(client *domain.OAuthClient, challenge string) domain.AuthorizeRequest {
+	return domain.AuthorizeRequest{
+		ClientID:            client.ClientID,
+		ResponseType:        "code",
+		Scope:               "profile",
+		State:               "xyz",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: domain.CodeChallengeMethodS256,
+		LoginInfo: domain.LoginInfo{
+			LoginType: domain.LoginTypeUsername,
+			Username:  "angela",
+			Password:  "password",
+		},
+	}
+}
+
+func (suite *OAuthTestSuite) TestAuthorizationCodeWithPKCE() {
+	ctx := context.Background()
+
+	client, secret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "spa",
+		RedirectURIs: []string{"https://app.test/callback"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken},
+		Scopes:       []string{"profile", "email"},
+		Public:       true,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Empty(secret)
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	// public client 一定要使用 PKCE
+	_, err = suite.usecase.Authorize(ctx, suite.authorizeRequest(client, ""))
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidRequest)
+
+	request := suite.authorizeRequest(client, challenge)
+	request.LoginInfo.Password = "wrong"
+	_, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().ErrorIs(err, domain.ErrUsernameOrPasswordIncorrect)
+
+	request = suite.authorizeRequest(client, challenge)
+	request.Scope = "admin"
+	_, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidScope)
+
+	code, err := suite.usecase.Authorize(ctx, suite.authorizeRequest(client, challenge))
+	suite.Require().NoError(err)
+
+	tokenRequest := domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		Code:         code,
+		RedirectURI:  "https://app.test/callback",
+		CodeVerifier: strings.Repeat("x", 43),
+	}
+
+	// code_verifier 錯誤時 code 也會被消耗掉
+	_, err = suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+
+	code, err = suite.usecase.Authorize(ctx, suite.authorizeRequest(client, challenge))
+	suite.Require().NoError(err)
+
+	tokenRequest.Code = code
+	tokenRequest.CodeVerifier = verifier
+	resp, err := suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("Bearer", resp.TokenType)
+	suite.Assert().Equal("profile", resp.Scope)
+	suite.Assert().NotEmpty(resp.RefreshToken)
+
+	token, err := suite.tokenSvc.Token(ctx, resp.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int64(1), token.AccountID)
+	suite.Assert().Equal(client.ClientID, token.Claims[domain.ClaimClientID])
+	suite.Assert().Equal("profile", token.Claims[domain.ClaimScope])
+
+	// 同一個 code 不能使用兩次
+	_, err = suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+
+	// refresh 時 scope 只能縮小
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		RefreshToken: resp.RefreshToken,
+		Scope:        "email",
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidScope)
+
+	// accessToken 不能拿來 refresh
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		RefreshToken: resp.AccessToken,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+
+	refreshed, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		RefreshToken: resp.RefreshToken,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().NotEqual(resp.RefreshToken, refreshed.RefreshToken)
+	suite.Assert().Equal("profile", refreshed.Scope)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		RefreshToken: resp.RefreshToken,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+}
+
+func (suite *OAuthTestSuite) TestAuthorizationCodeRedirectURI() {
+	ctx := context.Background()
+
+	client, secret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "web",
+		RedirectURIs: []string{"https://app.test/callback"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode},
+		Scopes:       []string{"profile"},
+	})
+	suite.Require().NoError(err)
+
+	tokenRequest := domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+	}
+
+	// /authorize 有帶 redirect_uri 時, /token 一定要帶一樣的值
+	request := suite.authorizeRequest(client, "")
+	request.RedirectURI = "https://app.test/callback"
+	tokenRequest.Code, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+	_, err = suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidGrant)
+
+	tokenRequest.Code, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+	tokenRequest.RedirectURI = "https://app.test/callback"
+	_, err = suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().NoError(err)
+
+	// 沒有帶 redirect_uri 時使用唯一註冊的 redirect_uri, /token 可以不帶
+	tokenRequest.Code, err = suite.usecase.Authorize(ctx, suite.authorizeRequest(client, ""))
+	suite.Require().NoError(err)
+	tokenRequest.RedirectURI = ""
+	_, err = suite.usecase.Token(ctx, tokenRequest)
+	suite.Require().NoError(err)
+}
+
+func (suite *OAuthTestSuite) TestAuthorizeWithOTP() {
+	ctx := context.Background()
+
+	client, _, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "web",
+		RedirectURIs: []string{"https://app.test/callback"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode},
+		Scopes:       []string{"profile"},
+	})
+	suite.Require().NoError(err)
+
+	suite.account.OTPEnable = 1
+	defer func() { suite.account.OTPEnable = 0 }()
+
+	request := suite.authorizeRequest(client, "")
+	_, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().ErrorIs(err, domain.ErrOTPRequired)
+
+	request.OTPCode = "000000"
+	_, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().ErrorIs(err, domain.ErrOTPCodeIncorrect)
+
+	request.OTPCode = "123456"
+	_, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.ValidateAuthorizeRequest(ctx, &domain.AuthorizeRequest{
+		ClientID:    client.ClientID,
+		RedirectURI: "https://evil.test/callback",
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidRequest)
+}
+
+func (suite *OAuthTestSuite) TestClientCredentials() {
+	ctx := context.Background()
+
+	client, secret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "billing",
+		GrantTypes: []string{domain.GrantTypeClientCredentials},
+		Scopes:     []string{"accounts.read"},
+	})
+	suite.Require().NoError(err)
+	suite.Require().NotEmpty(secret)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeClientCredentials,
+		ClientID:     client.ClientID,
+		ClientSecret: "wrong",
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidClient)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthUnauthorizedClient)
+
+	resp, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeClientCredentials,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Empty(resp.RefreshToken)
+	suite.Assert().Equal("accounts.read", resp.Scope)
+
+	token, err := suite.tokenSvc.Token(ctx, resp.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(client.ClientID, token.Claims[domain.ClaimClientID])
+
+	_, _, err = suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "public",
+		GrantTypes: []string{domain.GrantTypeClientCredentials},
+		Public:     true,
+	})
+	suite.Require().ErrorIs(err, domain.ErrInvalidInput)
+
+	clients, err := suite.usecase.Clients(ctx, suite.namespace)
+	suite.Require().NoError(err)
+	suite.Assert().Len(clients, 1)
+
+	err = suite.usecase.DeleteClient(ctx, "other.namespace", client.ClientID)
+	suite.Require().ErrorIs(err, domain.ErrNotFound)
+
+	err = suite.usecase.DeleteClient(ctx, suite.namespace, client.ClientID)
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeClientCredentials,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidClient)
+}
+
+func (suite *OAuthTestSuite) TestOpenIDConnect() {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "identity-keystore")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	keyRepo, err := identityFile.NewKeyRepo(dir)
+	suite.Require().NoError(err)
+
+	keySvc, err := NewKeyUsecase(keyRepo, KeyOptions{Algorithm: "ES256", Issuer: "http://identity.test"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(keySvc.RotateKeys(ctx))
+
+	// 沒有啟用 JWT 時不支援 openid scope
+	_, err = suite.usecase.OpenIDConfiguration(ctx)
+	suite.Require().ErrorIs(err, domain.ErrNotFound)
+
+	suite.usecase = NewOAuthUsecase(suite.usecase.clientRepo, suite.usecase.codeRepo, suite.usecase.deviceRepo, suite.usecase.eventLogRepo, suite.usecase.accountSvc, suite.tokenSvc, OAuthOptions{
+		Issuer: "http://identity.test/",
+		KeySvc: keySvc,
+	})
+
+	configuration, err := suite.usecase.OpenIDConfiguration(ctx)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("http://identity.test", configuration.Issuer)
+	suite.Assert().Equal("http://identity.test/userinfo", configuration.UserInfoEndpoint)
+	suite.Assert().Equal([]string{"ES256"}, configuration.IDTokenSigningAlgValuesSupported)
+
+	client, secret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "grafana",
+		RedirectURIs: []string{"https://grafana.test/login/generic_oauth"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken},
+		Scopes:       []string{"openid", "profile", "email"},
+	})
+	suite.Require().NoError(err)
+
+	request := suite.authorizeRequest(client, "")
+	request.Scope = "openid email"
+	request.Nonce = "n-0S6_WzA2Mj"
+	code, err := suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+
+	resp, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Code:         code,
+	})
+	suite.Require().NoError(err)
+	suite.Require().NotEmpty(resp.IDToken)
+
+	claims := suite.parseIDToken(keySvc, resp.IDToken)
+	suite.Assert().Equal(suite.account.UUID, claims.Subject)
+	suite.Assert().Equal("http://identity.test", claims.Issuer)
+	suite.Assert().True(claims.VerifyAudience(client.ClientID, true))
+	suite.Assert().Equal("n-0S6_WzA2Mj", claims.Nonce)
+	suite.Assert().Equal("angela@test.identity", claims.Email)
+	suite.Assert().Empty(claims.GivenName)
+	suite.Assert().NotZero(claims.AuthTime)
+
+	userInfo, err := suite.usecase.UserInfo(ctx, resp.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(domain.UserInfo{Subject: suite.account.UUID, Email: "angela@test.identity"}, *userInfo)
+
+	// refresh 時重新簽發的 ID token 不帶 nonce
+	refreshed, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		RefreshToken: resp.RefreshToken,
+	})
+	suite.Require().NoError(err)
+	claims = suite.parseIDToken(keySvc, refreshed.IDToken)
+	suite.Assert().Equal(suite.account.UUID, claims.Subject)
+	suite.Assert().Empty(claims.Nonce)
+
+	_, err = suite.usecase.UserInfo(ctx, refreshed.RefreshToken)
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	// 縮小 scope 只影響新的 accessToken, refreshToken 維持原本授權的 scope
+	narrowed, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		RefreshToken: refreshed.RefreshToken,
+		Scope:        "email",
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Equal("email", narrowed.Scope)
+	suite.Assert().Empty(narrowed.IDToken)
+
+	token, err := suite.tokenSvc.Token(ctx, narrowed.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("email", token.Claims[domain.ClaimScope])
+
+	_, err = suite.usecase.UserInfo(ctx, narrowed.AccessToken)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInsufficientScope)
+
+	refreshed, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeRefreshToken,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		RefreshToken: narrowed.RefreshToken,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Equal("openid email", refreshed.Scope)
+
+	_, err = suite.usecase.UserInfo(ctx, "unknown")
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	// 沒有 openid scope 的 accessToken 不能取得 userinfo
+	request = suite.authorizeRequest(client, "")
+	request.Scope = "profile"
+	code, err = suite.usecase.Authorize(ctx, request)
+	suite.Require().NoError(err)
+
+	resp, err = suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     client.ClientID,
+		ClientSecret: secret,
+		Code:         code,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Empty(resp.IDToken)
+
+	_, err = suite.usecase.UserInfo(ctx, resp.AccessToken)
+	suite.Require().ErrorIs(err, domain.ErrOAuthInsufficientScope)
+}
+
+func (suite *OAuthTestSuite) parseIDToken(keySvc *KeyUsecase, idToken string) *idTokenClaims {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		key, err := keySvc.verificationKey(context.Background(), t.Header["kid"].(string))
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey(), nil
+	})
+	suite.Require().NoError(err)
+	return &claims
+}
+
+func (suite *OAuthTestSuite) TestIntrospectAndRevokeToken() {
+	ctx := context.Background()
+
+	gateway, gatewaySecret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:  suite.namespace,
+		Name:       "gateway",
+		GrantTypes: []string{domain.GrantTypeClientCredentials},
+	})
+	suite.Require().NoError(err)
+
+	app, appSecret, err := suite.usecase.CreateClient(ctx, domain.CreateOAuthClientRequest{
+		Namespace:    suite.namespace,
+		Name:         "app",
+		RedirectURIs: []string{"https://app.test/callback"},
+		GrantTypes:   []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken},
+		Scopes:       []string{"profile"},
+	})
+	suite.Require().NoError(err)
+
+	code, err := suite.usecase.Authorize(ctx, suite.authorizeRequest(app, ""))
+	suite.Require().NoError(err)
+
+	resp, err := suite.usecase.Token(ctx, domain.OAuthTokenRequest{
+		GrantType:    domain.GrantTypeAuthorizationCode,
+		ClientID:     app.ClientID,
+		ClientSecret: appSecret,
+		Code:         code,
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.IntrospectToken(ctx, domain.IntrospectTokenRequest{
+		ClientID:     gateway.ClientID,
+		ClientSecret: "wrong",
+		Token:        resp.AccessToken,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthInvalidClient)
+
+	introspection, err := suite.usecase.IntrospectToken(ctx, domain.IntrospectTokenRequest{
+		ClientID:     gateway.ClientID,
+		ClientSecret: gatewaySecret,
+		Token:        resp.AccessToken,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().True(introspection.Active)
+	suite.Assert().Equal("1", introspection.Subject)
+	suite.Assert().Equal("profile", introspection.Scope)
+	suite.Assert().Equal(app.ClientID, introspection.ClientID)
+	suite.Assert().Equal(suite.namespace, introspection.Namespace)
+	suite.Assert().Equal("Bearer", introspection.TokenType)
+	suite.Assert().InDelta(time.Now().Unix()+defaultAccessTokenExpiresIn, introspection.ExpiresAt, 5)
+
+	introspection, err = suite.usecase.IntrospectToken(ctx, domain.IntrospectTokenRequest{
+		ClientID:     gateway.ClientID,
+		ClientSecret: gatewaySecret,
+		Token:        "unknown",
+	})
+	suite.Require().NoError(err)
+	suite.Assert().Equal(domain.TokenIntrospection{Active: false}, *introspection)
+
+	// 不能撤銷簽發給其他 client 的 token
+	err = suite.usecase.RevokeToken(ctx, domain.RevokeTokenRequest{
+		ClientID:     gateway.ClientID,
+		ClientSecret: gatewaySecret,
+		Token:        resp.RefreshToken,
+	})
+	suite.Require().ErrorIs(err, domain.ErrOAuthUnauthorizedClient)
+
+	// 撤銷 refreshToken 時, 同一個 session 的 accessToken 也會被撤銷
+	err = suite.usecase.RevokeToken(ctx, domain.RevokeTokenRequest{
+		ClientID:     app.ClientID,
+		ClientSecret: appSecret,
+		Token:        resp.RefreshToken,
+	})
+	suite.Require().NoError(err)
+
+	introspection, err = suite.usecase.IntrospectToken(ctx, domain.IntrospectTokenRequest{
+		ClientID:     gateway.ClientID,
+		ClientSecret: gatewaySecret,
+		Token:        resp.AccessToken,
+	})
+	suite.Require().NoError(err)
+	suite.Assert().False(introspection.Active)
+
+	err = suite.usecase.RevokeToken(ctx, domain.RevokeTokenRequest{
+		ClientID:     app.ClientID,
+		ClientSecret: appSecret,
+		Token:        resp.RefreshToken,
+	})
+	suite.Require().NoError(err)
+}
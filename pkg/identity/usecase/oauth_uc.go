@@ -0,0 +1,793 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationCodeLifetime = time.Minute
+	oauthTokenType            = "Bearer"
+)
+
+// OAuthOptions 是 OAuth 2.0 與 OpenID Connect 的設定
+type OAuthOptions struct {
+	// Issuer 是對外的 base url, 用來產生 discovery document
+	Issuer string
+	// KeySvc 用來簽發 ID token, 為 nil 時不支援 OpenID Connect
+	KeySvc domain.KeyUsecase
+	// DeviceCodeLifetime device code 的存活時間
+	DeviceCodeLifetime time.Duration
+	// DeviceCodeInterval 裝置 polling /token 的最短間隔
+	DeviceCodeInterval time.Duration
+}
+
+type OAuthUsecase struct {
+	clientRepo   domain.OAuthClientRepository
+	codeRepo     domain.AuthorizationCodeRepository
+	deviceRepo   domain.DeviceAuthorizationRepository
+	eventLogRepo domain.EventLogRepository
+	accountSvc   domain.AccountUsecase
+	tokenSvc     domain.TokenUsecase
+	options      OAuthOptions
+}
+
+func NewOAuthUsecase(clientRepo domain.OAuthClientRepository, codeRepo domain.AuthorizationCodeRepository, deviceRepo domain.DeviceAuthorizationRepository, eventLogRepo domain.EventLogRepository, accountSvc domain.AccountUsecase, tokenSvc domain.TokenUsecase, options OAuthOptions) *OAuthUsecase {
+	options.Issuer = strings.TrimSuffix(options.Issuer, "/")
+
+	if options.DeviceCodeLifetime <= 0 {
+		options.DeviceCodeLifetime = defaultDeviceCodeLifetime
+	}
+
+	if options.DeviceCodeInterval <= 0 {
+		options.DeviceCodeInterval = defaultDeviceCodeInterval
+	}
+
+	return &OAuthUsecase{
+		clientRepo:   clientRepo,
+		codeRepo:     codeRepo,
+		deviceRepo:   deviceRepo,
+		eventLogRepo: eventLogRepo,
+		accountSvc:   accountSvc,
+		tokenSvc:     tokenSvc,
+		options:      options,
+	}
+}
+
+func (uc *OAuthUsecase) CreateClient(ctx context.Context, request domain.CreateOAuthClientRequest) (*domain.OAuthClient, string, error) {
+	if request.Namespace == "" || request.Name == "" || len(request.GrantTypes) == 0 {
+		return nil, "", domain.ErrInvalidInput
+	}
+
+	for _, grantType := range request.GrantTypes {
+		switch grantType {
+		case domain.GrantTypeAuthorizationCode, domain.GrantTypeRefreshToken, domain.GrantTypeDeviceCode:
+		case domain.GrantTypeClientCredentials, domain.GrantTypeTokenExchange:
+			if request.Public {
+				return nil, "", fmt.Errorf("public client can not use %s. %w", grantType, domain.ErrInvalidInput)
+			}
+		default:
+			return nil, "", fmt.Errorf("grant type %s is not supported. %w", grantType, domain.ErrInvalidInput)
+		}
+	}
+
+	for _, redirectURI := range request.RedirectURIs {
+		if !isValidRedirectURI(redirectURI) {
+			return nil, "", fmt.Errorf("redirect uri %s is invalid. %w", redirectURI, domain.ErrInvalidInput)
+		}
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := domain.OAuthClient{
+		Namespace:    request.Namespace,
+		ClientID:     clientID,
+		Name:         request.Name,
+		RedirectURIs: strings.Join(request.RedirectURIs, " "),
+		GrantTypes:   strings.Join(request.GrantTypes, " "),
+		Scopes:       strings.Join(request.Scopes, " "),
+		CreatorID:    request.CreatorID,
+		CreatorName:  request.CreatorName,
+	}
+
+	var secret string
+	if request.Public {
+		client.Public = 1
+	} else {
+		secret, err = randomString(32)
+		if err != nil {
+			return nil, "", err
+		}
+
+		client.SecretHash, err = encryptPassword(secret)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = uc.clientRepo.CreateOAuthClient(ctx, &client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &client, secret, nil
+}
+
+func (uc *OAuthUsecase) Client(ctx context.Context, namespace, clientID string) (*domain.OAuthClient, error) {
+	client, err := uc.clientRepo.OAuthClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Namespace != namespace {
+		return nil, fmt.Errorf("oauth client %s was not found. %w", clientID, domain.ErrNotFound)
+	}
+
+	return client, nil
+}
+
+func (uc *OAuthUsecase) Clients(ctx context.Context, namespace string) ([]domain.OAuthClient, error) {
+	return uc.clientRepo.OAuthClients(ctx, namespace)
+}
+
+func (uc *OAuthUsecase) DeleteClient(ctx context.Context, namespace, clientID string) error {
+	_, err := uc.Client(ctx, namespace, clientID)
+	if err != nil {
+		return err
+	}
+
+	return uc.clientRepo.DeleteOAuthClient(ctx, clientID)
+}
+
+// ValidateAuthorizeRequest 沒有帶 redirect_uri 時, 如果 client 只有註冊一個 redirect_uri 就使用那一個
+func (uc *OAuthUsecase) ValidateAuthorizeRequest(ctx context.Context, request *domain.AuthorizeRequest) (*domain.OAuthClient, error) {
+	if request.ClientID == "" {
+		return nil, fmt.Errorf("client_id is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	client, err := uc.clientRepo.OAuthClient(ctx, request.ClientID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("client %s was not found. %w", request.ClientID, domain.ErrOAuthInvalidClient)
+		}
+		return nil, err
+	}
+
+	if request.RedirectURI == "" {
+		redirectURIs := strings.Fields(client.RedirectURIs)
+		if len(redirectURIs) != 1 {
+			return nil, fmt.Errorf("redirect_uri is required. %w", domain.ErrOAuthInvalidRequest)
+		}
+		request.RedirectURI = redirectURIs[0]
+		request.RedirectURIDefaulted = true
+	}
+
+	if !client.AllowRedirectURI(request.RedirectURI) {
+		return nil, fmt.Errorf("redirect_uri is not registered. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	request.LoginInfo.Namespace = client.Namespace
+	return client, nil
+}
+
+func (uc *OAuthUsecase) Authorize(ctx context.Context, request domain.AuthorizeRequest) (string, error) {
+	client, err := uc.ValidateAuthorizeRequest(ctx, &request)
+	if err != nil {
+		return "", err
+	}
+
+	if request.ResponseType != "code" {
+		return "", domain.ErrOAuthUnsupportedResponseType
+	}
+
+	if !client.AllowGrantType(domain.GrantTypeAuthorizationCode) {
+		return "", domain.ErrOAuthUnauthorizedClient
+	}
+
+	if request.Scope == "" {
+		request.Scope = client.Scopes
+	}
+
+	if !client.AllowScope(request.Scope) {
+		return "", domain.ErrOAuthInvalidScope
+	}
+
+	if domain.HasScope(request.Scope, domain.ScopeOpenID) && uc.options.KeySvc == nil {
+		return "", fmt.Errorf("openid connect is not enabled. %w", domain.ErrOAuthInvalidScope)
+	}
+
+	if request.CodeChallenge != "" {
+		if request.CodeChallengeMethod == "" {
+			request.CodeChallengeMethod = domain.CodeChallengeMethodPlain
+		}
+
+		if request.CodeChallengeMethod != domain.CodeChallengeMethodPlain && request.CodeChallengeMethod != domain.CodeChallengeMethodS256 {
+			return "", fmt.Errorf("code_challenge_method is not supported. %w", domain.ErrOAuthInvalidRequest)
+		}
+
+		if len(request.CodeChallenge) < 43 || len(request.CodeChallenge) > 128 {
+			return "", fmt.Errorf("code_challenge is invalid. %w", domain.ErrOAuthInvalidRequest)
+		}
+	} else if client.IsPublic() {
+		return "", fmt.Errorf("public client must use pkce. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	account, amr, err := uc.login(ctx, request.LoginInfo, request.OTPCode)
+	if err != nil {
+		return "", err
+	}
+
+	return uc.codeRepo.CreateAuthorizationCode(ctx, &domain.AuthorizationCode{
+		ClientID:            client.ClientID,
+		Namespace:           client.Namespace,
+		AccountID:           account.ID,
+		RedirectURI:         request.RedirectURI,
+		RedirectURIProvided: !request.RedirectURIDefaulted,
+		Scope:               request.Scope,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		Nonce:               request.Nonce,
+		AMR:                 amr,
+		AuthTime:            time.Now().UTC(),
+	}, authorizationCodeLifetime)
+}
+
+func (uc *OAuthUsecase) Token(ctx context.Context, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if request.GrantType == "" {
+		return nil, fmt.Errorf("grant_type is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	client, err := uc.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch request.GrantType {
+	case domain.GrantTypeAuthorizationCode, domain.GrantTypeClientCredentials, domain.GrantTypeRefreshToken, domain.GrantTypeDeviceCode, domain.GrantTypeTokenExchange:
+	default:
+		return nil, domain.ErrOAuthUnsupportedGrantType
+	}
+
+	if !client.AllowGrantType(request.GrantType) {
+		return nil, domain.ErrOAuthUnauthorizedClient
+	}
+
+	switch request.GrantType {
+	case domain.GrantTypeAuthorizationCode:
+		return uc.exchangeAuthorizationCode(ctx, client, request)
+	case domain.GrantTypeClientCredentials:
+		return uc.clientCredentials(ctx, client, request)
+	case domain.GrantTypeDeviceCode:
+		return uc.exchangeDeviceCode(ctx, client, request)
+	case domain.GrantTypeTokenExchange:
+		return uc.exchangeToken(ctx, client, request)
+	default:
+		return uc.refreshToken(ctx, client, request)
+	}
+}
+
+func (uc *OAuthUsecase) exchangeAuthorizationCode(ctx context.Context, client *domain.OAuthClient, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if request.Code == "" {
+		return nil, fmt.Errorf("code is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	code, err := uc.codeRepo.ConsumeAuthorizationCode(ctx, request.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return nil, fmt.Errorf("code is invalid or expired. %w", domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	if code.ClientID != client.ClientID {
+		return nil, fmt.Errorf("code was issued to another client. %w", domain.ErrOAuthInvalidGrant)
+	}
+
+	// /authorize 有帶 redirect_uri 時, /token 一定要帶一樣的值 (RFC 6749 4.1.3)
+	if (code.RedirectURIProvided || request.RedirectURI != "") && request.RedirectURI != code.RedirectURI {
+		return nil, fmt.Errorf("redirect_uri does not match. %w", domain.ErrOAuthInvalidGrant)
+	}
+
+	if code.CodeChallenge != "" && !verifyCodeChallenge(code.CodeChallenge, code.CodeChallengeMethod, request.CodeVerifier) {
+		return nil, fmt.Errorf("code_verifier is invalid. %w", domain.ErrOAuthInvalidGrant)
+	}
+
+	return uc.issueTokens(ctx, client, accountGrant{
+		Namespace:  code.Namespace,
+		AccountID:  code.AccountID,
+		Scope:      code.Scope,
+		Nonce:      code.Nonce,
+		AMR:        code.AMR,
+		AuthTime:   code.AuthTime,
+		DeviceType: domain.DeviceTypeWeb,
+	}, request)
+}
+
+// accountGrant 是帳號授權給 client 的內容, 來自 authorization code 或 device authorization
+type accountGrant struct {
+	Namespace  string
+	AccountID  uint64
+	Scope      string
+	Nonce      string
+	AMR        string
+	AuthTime   time.Time
+	DeviceType domain.DeviceType
+}
+
+// issueTokens 依照帳號的授權簽發 accessToken, 有 refresh grant 時簽發 refreshToken, 有 openid scope 時簽發 ID token
+func (uc *OAuthUsecase) issueTokens(ctx context.Context, client *domain.OAuthClient, grant accountGrant, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	account, err := uc.accountSvc.Account(ctx, grant.Namespace, grant.AccountID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("account was not found. %w", domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	if account.State != domain.AccountStatusNormal {
+		return nil, fmt.Errorf("account state is %s. %w", account.State.String(), domain.ErrOAuthInvalidGrant)
+	}
+
+	session, err := uc.tokenSvc.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID:   int64(account.ID),
+			Namespace:   account.Namespace,
+			Username:    account.Username.String,
+			AccountType: int32(account.Type),
+			ExpiresIn:   defaultAccessTokenExpiresIn,
+			Claims: map[string]string{
+				domain.ClaimClientID: client.ClientID,
+				domain.ClaimScope:    grant.Scope,
+			},
+		},
+		DeviceType: grant.DeviceType,
+		ClientIP:   request.ClientIP,
+		UserAgent:  request.UserAgent,
+		ServerClaims: map[string]string{
+			domain.ClaimAMR:      grant.AMR,
+			domain.ClaimAuthTime: strconv.FormatInt(grant.AuthTime.Unix(), 10),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := domain.OAuthTokenResponse{
+		AccessToken: session.AccessToken,
+		TokenType:   oauthTokenType,
+		ExpiresIn:   defaultAccessTokenExpiresIn,
+		Scope:       grant.Scope,
+	}
+
+	if client.AllowGrantType(domain.GrantTypeRefreshToken) {
+		resp.RefreshToken = session.RefreshKey
+	}
+
+	if domain.HasScope(grant.Scope, domain.ScopeOpenID) {
+		resp.IDToken, err = uc.signIDToken(ctx, client, account, grant.Scope, grant.Nonce, grant.AuthTime, strings.Fields(grant.AMR))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resp, nil
+}
+
+// clientCredentials 給 service 之間呼叫使用, 只會簽發 accessToken
+func (uc *OAuthUsecase) clientCredentials(ctx context.Context, client *domain.OAuthClient, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if client.IsPublic() {
+		return nil, domain.ErrOAuthUnauthorizedClient
+	}
+
+	scope := request.Scope
+	if scope == "" {
+		scope = client.Scopes
+	}
+
+	if !client.AllowScope(scope) {
+		return nil, domain.ErrOAuthInvalidScope
+	}
+
+	accessToken, err := uc.tokenSvc.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			Namespace: client.Namespace,
+			Username:  client.ClientID,
+			ExpiresIn: defaultAccessTokenExpiresIn,
+			Claims: map[string]string{
+				domain.ClaimClientID: client.ClientID,
+				domain.ClaimScope:    scope,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   oauthTokenType,
+		ExpiresIn:   defaultAccessTokenExpiresIn,
+		Scope:       scope,
+	}, nil
+}
+
+func (uc *OAuthUsecase) refreshToken(ctx context.Context, client *domain.OAuthClient, request domain.OAuthTokenRequest) (*domain.OAuthTokenResponse, error) {
+	if request.RefreshToken == "" {
+		return nil, fmt.Errorf("refresh_token is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	token, err := uc.tokenSvc.LookupToken(ctx, request.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			return nil, fmt.Errorf("refresh_token is invalid or expired. %w", domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	if token.Claims[domain.TokenTypeKey] != domain.TokenTypeRefresh || token.Claims[domain.ClaimClientID] != client.ClientID {
+		return nil, fmt.Errorf("refresh_token was not issued to the client. %w", domain.ErrOAuthInvalidGrant)
+	}
+
+	// 只能縮小 scope, 不能要求原本沒有授權的 scope, 新的 refreshToken 維持原本的 scope (RFC 6749 6)
+	scope := token.Claims[domain.ClaimScope]
+	var claims map[string]string
+	if request.Scope != "" {
+		original := domain.OAuthClient{Scopes: scope}
+		if !original.AllowScope(request.Scope) {
+			return nil, domain.ErrOAuthInvalidScope
+		}
+
+		scope = strings.Join(strings.Fields(request.Scope), " ")
+		claims = map[string]string{domain.ClaimScope: scope}
+	}
+
+	accessToken, refreshToken, err := uc.tokenSvc.RefreshTokenWithClaims(ctx, request.RefreshToken, claims)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrRefreshTokenReused) || errors.Is(err, domain.ErrRefreshTokenExpired) {
+			return nil, fmt.Errorf("refresh_token is invalid or expired: %v. %w", err, domain.ErrOAuthInvalidGrant)
+		}
+		return nil, err
+	}
+
+	resp := domain.OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    oauthTokenType,
+		ExpiresIn:    token.ExpiresIn,
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+
+	// refresh 時重新簽發的 ID token 不帶 nonce (OpenID Connect Core 12.2)
+	if domain.HasScope(scope, domain.ScopeOpenID) {
+		account, err := uc.accountSvc.Account(ctx, token.Namespace, uint64(token.AccountID))
+		if err != nil {
+			return nil, err
+		}
+
+		authTime := time.Time{}
+		if unix, err := strconv.ParseInt(token.Claims[domain.ClaimAuthTime], 10, 64); err == nil {
+			authTime = time.Unix(unix, 0)
+		}
+
+		resp.IDToken, err = uc.signIDToken(ctx, client, account, scope, "", authTime, strings.Fields(token.Claims[domain.ClaimAMR]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resp, nil
+}
+
+func (uc *OAuthUsecase) IntrospectToken(ctx context.Context, request domain.IntrospectTokenRequest) (*domain.TokenIntrospection, error) {
+	client, err := uc.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	// public client 沒有 secret, 不能用來查詢 token
+	if client.IsPublic() {
+		return nil, domain.ErrOAuthUnauthorizedClient
+	}
+
+	if request.Token == "" {
+		return nil, fmt.Errorf("token is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	inactive := &domain.TokenIntrospection{Active: false}
+
+	token, err := uc.tokenSvc.LookupToken(ctx, request.Token)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	if token.Namespace != client.Namespace {
+		return inactive, nil
+	}
+
+	expiresAt, err := uc.tokenSvc.TokenExpiresAt(ctx, request.Token)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	introspection := domain.TokenIntrospection{
+		Active:    true,
+		Scope:     token.Claims[domain.ClaimScope],
+		ClientID:  token.Claims[domain.ClaimClientID],
+		Username:  token.Username,
+		TokenType: oauthTokenType,
+		Subject:   tokenSubject(token),
+		Namespace: token.Namespace,
+		SessionID: token.Claims[domain.SessionKey],
+	}
+
+	if token.Claims[domain.TokenTypeKey] == domain.TokenTypeRefresh {
+		introspection.TokenType = domain.GrantTypeRefreshToken
+	}
+
+	if !expiresAt.IsZero() {
+		introspection.ExpiresAt = expiresAt.Unix()
+	}
+
+	return &introspection, nil
+}
+
+// RevokeToken 只能撤銷簽發給自己的 token, 不是透過 OAuth 簽發的 token 則必須在同一個 namespace
+func (uc *OAuthUsecase) RevokeToken(ctx context.Context, request domain.RevokeTokenRequest) error {
+	client, err := uc.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	if request.Token == "" {
+		return fmt.Errorf("token is required. %w", domain.ErrOAuthInvalidRequest)
+	}
+
+	token, err := uc.tokenSvc.LookupToken(ctx, request.Token)
+	if err != nil {
+		// 無效的 token 視為已經撤銷 (RFC 7009 2.2)
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			return nil
+		}
+		return err
+	}
+
+	tokenClientID := token.Claims[domain.ClaimClientID]
+	if tokenClientID != client.ClientID && (tokenClientID != "" || token.Namespace != client.Namespace) {
+		return fmt.Errorf("token was not issued to the client. %w", domain.ErrOAuthUnauthorizedClient)
+	}
+
+	err = uc.tokenSvc.RevokeToken(ctx, request.Token)
+	if err != nil && !errors.Is(err, domain.ErrKeyNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (uc *OAuthUsecase) OpenIDConfiguration(ctx context.Context) (*domain.OpenIDConfiguration, error) {
+	if uc.options.KeySvc == nil || uc.options.Issuer == "" {
+		return nil, fmt.Errorf("openid connect is not enabled. %w", domain.ErrNotFound)
+	}
+
+	keys, err := uc.options.KeySvc.PublicKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithms := []string{}
+	for _, key := range keys {
+		if !containsString(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	return &domain.OpenIDConfiguration{
+		Issuer:                            uc.options.Issuer,
+		AuthorizationEndpoint:             uc.options.Issuer + "/authorize",
+		TokenEndpoint:                     uc.options.Issuer + "/token",
+		UserInfoEndpoint:                  uc.options.Issuer + "/userinfo",
+		IntrospectionEndpoint:             uc.options.Issuer + "/introspect",
+		RevocationEndpoint:                uc.options.Issuer + "/revoke",
+		DeviceAuthorizationEndpoint:       uc.options.Issuer + "/device_authorization",
+		JWKSURI:                           uc.options.Issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{domain.GrantTypeAuthorizationCode, domain.GrantTypeClientCredentials, domain.GrantTypeRefreshToken, domain.GrantTypeDeviceCode, domain.GrantTypeTokenExchange},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		ScopesSupported:                   []string{domain.ScopeOpenID, domain.ScopeProfile, domain.ScopeEmail, domain.ScopePhone},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"preferred_username", "nickname", "given_name", "family_name", "picture", "email", "phone_number",
+		},
+		CodeChallengeMethodsSupported: []string{domain.CodeChallengeMethodS256, domain.CodeChallengeMethodPlain},
+	}, nil
+}
+
+func (uc *OAuthUsecase) UserInfo(ctx context.Context, accessToken string) (*domain.UserInfo, error) {
+	if accessToken == "" {
+		return nil, domain.ErrInvalidToken
+	}
+
+	token, err := uc.tokenSvc.Token(ctx, accessToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrInvalidToken) {
+			return nil, fmt.Errorf("access token is invalid or expired. %w", domain.ErrInvalidToken)
+		}
+		return nil, err
+	}
+
+	if token.Claims[domain.TokenTypeKey] == domain.TokenTypeRefresh || token.AccountID == 0 {
+		return nil, fmt.Errorf("token can not be used for userinfo. %w", domain.ErrInvalidToken)
+	}
+
+	scope := token.Claims[domain.ClaimScope]
+	if !domain.HasScope(scope, domain.ScopeOpenID) {
+		return nil, domain.ErrOAuthInsufficientScope
+	}
+
+	account, err := uc.accountSvc.Account(ctx, token.Namespace, uint64(token.AccountID))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("account was not found. %w", domain.ErrInvalidToken)
+		}
+		return nil, err
+	}
+
+	userInfo := toUserInfo(account, scope)
+	return &userInfo, nil
+}
+
+func (uc *OAuthUsecase) signIDToken(ctx context.Context, client *domain.OAuthClient, account *domain.Account, scope string, nonce string, authTime time.Time, amr []string) (string, error) {
+	if uc.options.KeySvc == nil {
+		return "", fmt.Errorf("openid connect is not enabled. %w", domain.ErrOAuthInvalidScope)
+	}
+
+	return uc.options.KeySvc.SignIDToken(ctx, domain.IDToken{
+		UserInfo:  toUserInfo(account, scope),
+		Audience:  client.ClientID,
+		Nonce:     nonce,
+		AuthTime:  authTime,
+		AMR:       amr,
+		ExpiresIn: defaultAccessTokenExpiresIn,
+	})
+}
+
+// toUserInfo 依照 scope 把 Account 對應到 OpenID Connect 的標準 claims, sub 使用不會變動的 UUID
+func toUserInfo(account *domain.Account, scope string) domain.UserInfo {
+	userInfo := domain.UserInfo{
+		Subject: account.UUID,
+	}
+
+	if domain.HasScope(scope, domain.ScopeProfile) {
+		userInfo.PreferredUsername = account.Username.String
+		userInfo.Nickname = account.NickName
+		userInfo.GivenName = account.FirstName
+		userInfo.FamilyName = account.LastName
+		userInfo.Picture = account.Avatar
+	}
+
+	if domain.HasScope(scope, domain.ScopeEmail) && account.Email.Valid {
+		userInfo.Email = account.Email.String
+	}
+
+	if domain.HasScope(scope, domain.ScopePhone) && account.Mobile.Valid {
+		userInfo.PhoneNumber = "+" + strings.TrimPrefix(account.MobileCountryCode.String, "+") + account.Mobile.String
+	}
+
+	return userInfo
+}
+
+// login 驗證帳號密碼, 有啟用 OTP 的帳號必須同時帶 OTP code, 回傳帳號與 amr
+func (uc *OAuthUsecase) login(ctx context.Context, loginInfo domain.LoginInfo, otpCode string) (*domain.Account, string, error) {
+	account, err := uc.accountSvc.Login(ctx, loginInfo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if account.OTPEnable != 1 {
+		return account, "pwd", nil
+	}
+
+	if otpCode == "" {
+		return nil, "", domain.ErrOTPRequired
+	}
+
+	err = uc.accountSvc.VerifyOTP(ctx, "", domain.VerifyOTPRequest{
+		Namespace: account.Namespace,
+		AccountID: account.ID,
+		OTPCode:   otpCode,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return account, "pwd " + domain.AMROTP, nil
+}
+
+// authenticateClient confidential client 必須帶正確的 secret, public client 只需要 client_id
+func (uc *OAuthUsecase) authenticateClient(ctx context.Context, clientID, clientSecret string) (*domain.OAuthClient, error) {
+	if clientID == "" {
+		return nil, fmt.Errorf("client_id is required. %w", domain.ErrOAuthInvalidClient)
+	}
+
+	client, err := uc.clientRepo.OAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("client %s was not found. %w", clientID, domain.ErrOAuthInvalidClient)
+		}
+		return nil, err
+	}
+
+	if client.IsPublic() {
+		return client, nil
+	}
+
+	if clientSecret == "" || isPasswordValid(client.SecretHash, clientSecret) != nil {
+		return nil, fmt.Errorf("client secret is incorrect. %w", domain.ErrOAuthInvalidClient)
+	}
+
+	return client, nil
+}
+
+// verifyCodeChallenge 依照 RFC 7636 4.6 驗證 code_verifier
+func verifyCodeChallenge(challenge, method, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	expected := verifier
+	if method == domain.CodeChallengeMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// isValidRedirectURI redirect_uri 必須是絕對路徑並且不能有 fragment (RFC 6749 3.1.2)
+func isValidRedirectURI(redirectURI string) bool {
+	if strings.Contains(redirectURI, "#") || strings.ContainsAny(redirectURI, " \t\n") {
+		return false
+	}
+
+	idx := strings.Index(redirectURI, "://")
+	return idx > 0 && len(redirectURI) > idx+3
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func containsString(values []string, val string) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
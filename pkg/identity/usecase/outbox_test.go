@@ -0,0 +1,247 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	identityNATS "identity/pkg/identity/repository/nats"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type OutboxTestSuite struct {
+	suite.Suite
+	natsServer   *server.Server
+	natsConn     *nats.Conn
+	storeDir     string
+	outboxRepo   *fakeOutboxRepo
+	eventLogRepo *OutboxEventLogRepo
+	publisher    *flakyPublisher
+	usecase      *OutboxUsecase
+	namespace    string
+}
+
+func TestOutboxTestSuite(t *testing.T) {
+	suite.Run(t, &OutboxTestSuite{namespace: "test.identity"})
+}
+
+func (suite *OutboxTestSuite) SetupTest() {
+	database.SetMockMode(true)
+
+	var err error
+	suite.storeDir, err = ioutil.TempDir("", "identity-outbox")
+	suite.Require().NoError(err)
+
+	suite.natsServer, err = server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  suite.storeDir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	suite.Require().NoError(err)
+	go suite.natsServer.Start()
+	suite.Require().True(suite.natsServer.ReadyForConnections(5 * time.Second))
+
+	suite.natsConn, err = nats.Connect(suite.natsServer.ClientURL())
+	suite.Require().NoError(err)
+
+	publisher, err := identityNATS.NewEventPublisher(suite.natsConn, "IDENTITY")
+	suite.Require().NoError(err)
+
+	suite.outboxRepo = &fakeOutboxRepo{}
+	suite.eventLogRepo = NewOutboxEventLogRepo(&fakeEventLogRepo{}, suite.outboxRepo)
+	suite.publisher = &flakyPublisher{publisher: publisher, failures: map[string]int{}}
+	suite.usecase = NewOutboxUsecase(suite.outboxRepo, suite.publisher, OutboxOptions{})
+}
+
+func (suite *OutboxTestSuite) TearDownTest() {
+	suite.natsConn.Close()
+	suite.natsServer.Shutdown()
+	suite.natsServer.WaitForShutdown()
+	_ = os.RemoveAll(suite.storeDir)
+	database.SetMockMode(false)
+}
+
+type fakeOutboxRepo struct {
+	events []*domain.OutboxEvent
+}
+
+func (repo *fakeOutboxRepo) CreateOutboxEvents(ctx context.Context, events []*domain.OutboxEvent) error {
+	for _, event := range events {
+		event.ID = uint64(len(repo.events) + 1)
+		created := *event
+		repo.events = append(repo.events, &created)
+	}
+	return nil
+}
+
+func (repo *fakeOutboxRepo) PendingOutboxEvents(ctx context.Context, limit int) ([]domain.OutboxEvent, error) {
+	result := []domain.OutboxEvent{}
+	for _, event := range repo.events {
+		if event.State == domain.OutboxStatePending && len(result) < limit {
+			result = append(result, *event)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeOutboxRepo) MarkOutboxEventsPublished(ctx context.Context, eventIDs []uint64, publishedAt time.Time) error {
+	for _, event := range repo.events {
+		if containsUint64(eventIDs, event.ID) {
+			event.State = domain.OutboxStatePublished
+			event.PublishedAt = publishedAt
+		}
+	}
+	return nil
+}
+
+func (repo *fakeOutboxRepo) WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return true, fn(ctx)
+}
+
+// flakyPublisher 讓指定的 message ID 發佈失敗指定的次數
+type flakyPublisher struct {
+	publisher domain.EventPublisher
+	failures  map[string]int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, subject string, msgID string, payload []byte) error {
+	if p.failures[msgID] > 0 {
+		p.failures[msgID]--
+		return errors.New("nats: no responders available for request")
+	}
+	return p.publisher.Publish(ctx, subject, msgID, payload)
+}
+
+func (suite *OutboxTestSuite) createAccountEventLog(accountID uint64, action string, state domain.AccountState) {
+	account := domain.Account{
+		ID:              accountID,
+		Namespace:       suite.namespace,
+		Username:        sql.NullString{String: "angela", Valid: true},
+		PasswordEncrypt: "hashed",
+		State:           state,
+	}
+	newStatus, err := json.Marshal(account)
+	suite.Require().NoError(err)
+
+	err = suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{
+		Namespace: "identity.account",
+		Action:    action,
+		TargetID:  strconv.FormatUint(accountID, 10),
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+		Actor:     "admin",
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *OutboxTestSuite) fetch(subject string, count int) []domain.WebhookEvent {
+	js, err := suite.natsConn.JetStream()
+	suite.Require().NoError(err)
+
+	sub, err := js.SubscribeSync(subject, nats.DeliverAll())
+	suite.Require().NoError(err)
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+
+	events := []domain.WebhookEvent{}
+	for i := 0; i < count; i++ {
+		msg, err := sub.NextMsg(2 * time.Second)
+		suite.Require().NoError(err)
+
+		event := domain.WebhookEvent{}
+		suite.Require().NoError(json.Unmarshal(msg.Data, &event))
+		events = append(events, event)
+	}
+
+	_, err = sub.NextMsg(100 * time.Millisecond)
+	suite.ErrorIs(err, nats.ErrTimeout)
+	return events
+}
+
+func (suite *OutboxTestSuite) TestWriteOutboxWithEventLog() {
+	suite.createAccountEventLog(1, "change_state", domain.AccountStatusLocked)
+
+	err := suite.eventLogRepo.CreateEventLog(context.Background(), &domain.EventLog{
+		Namespace: "identity.account",
+		Action:    "create",
+		TargetID:  "2",
+		State:     domain.EventLogFail,
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.outboxRepo.events, 1)
+	event := suite.outboxRepo.events[0]
+	suite.Equal("account", event.AggregateType)
+	suite.Equal("1", event.AggregateID)
+	suite.Equal("identity.test.identity.account.locked", event.Subject)
+	suite.Equal(domain.OutboxStatePending, event.State)
+	suite.NotEmpty(event.EventID)
+	suite.NotContains(string(event.Payload), "hashed")
+}
+
+func (suite *OutboxTestSuite) TestRelayPublishesToJetStream() {
+	ctx := context.Background()
+	suite.createAccountEventLog(1, "create", domain.AccountStatusNormal)
+	suite.createAccountEventLog(1, "update", domain.AccountStatusNormal)
+	suite.createAccountEventLog(1, "change_state", domain.AccountStatusDisabled)
+
+	err := suite.usecase.Relay(ctx)
+	suite.Require().NoError(err)
+	for _, event := range suite.outboxRepo.events {
+		suite.Equal(domain.OutboxStatePublished, event.State)
+	}
+
+	events := suite.fetch("identity."+suite.namespace+".account.>", 3)
+	suite.Equal(domain.WebhookEventAccountCreated, events[0].Type)
+	suite.Equal(domain.WebhookEventAccountUpdated, events[1].Type)
+	suite.Equal(domain.WebhookEventAccountDisabled, events[2].Type)
+	suite.Equal(suite.outboxRepo.events[0].EventID, events[0].ID)
+
+	// relay 在標記之前掛掉時會重新發佈, 由 JetStream 用 message ID 去除重複
+	suite.outboxRepo.events[1].State = domain.OutboxStatePending
+	err = suite.usecase.Relay(ctx)
+	suite.Require().NoError(err)
+	suite.fetch("identity."+suite.namespace+".account.>", 3)
+}
+
+func (suite *OutboxTestSuite) TestRelayKeepsAggregateOrder() {
+	ctx := context.Background()
+	suite.createAccountEventLog(1, "create", domain.AccountStatusNormal)
+	suite.createAccountEventLog(2, "create", domain.AccountStatusNormal)
+	suite.createAccountEventLog(1, "change_state", domain.AccountStatusLocked)
+	suite.publisher.failures["identity-outbox-1"] = 1
+
+	err := suite.usecase.Relay(ctx)
+	suite.Error(err)
+
+	// 帳號 1 的第一個事件失敗, 後面的事件也不能先發佈, 帳號 2 不受影響
+	suite.Equal(domain.OutboxStatePending, suite.outboxRepo.events[0].State)
+	suite.Equal(domain.OutboxStatePublished, suite.outboxRepo.events[1].State)
+	suite.Equal(domain.OutboxStatePending, suite.outboxRepo.events[2].State)
+
+	err = suite.usecase.Relay(ctx)
+	suite.Require().NoError(err)
+
+	events := suite.fetch("identity."+suite.namespace+".account.>", 3)
+	suite.Equal("2", events[0].TargetID)
+	suite.Equal("1", events[1].TargetID)
+	suite.Equal(domain.WebhookEventAccountCreated, events[1].Type)
+	suite.Equal("1", events[2].TargetID)
+	suite.Equal(domain.WebhookEventAccountLocked, events[2].Type)
+}
@@ -0,0 +1,151 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+type OutboxOptions struct {
+	// BatchSize 每次 Relay 最多發佈的事件數量
+	BatchSize int
+	// PublishTimeout 每個事件發佈的 timeout
+	PublishTimeout time.Duration
+}
+
+func (opts *OutboxOptions) setDefaults() {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.PublishTimeout <= 0 {
+		opts.PublishTimeout = 5 * time.Second
+	}
+}
+
+// OutboxUsecase 把 outbox 的事件依照 ID 的順序發佈, 發佈成功之後才標記為已發佈, 所以是 at-least-once
+// 同一個 aggregate 的事件發佈失敗時, 這一批後面同一個 aggregate 的事件都不會發佈, 以保持順序
+type OutboxUsecase struct {
+	outboxRepo domain.OutboxRepository
+	publisher  domain.EventPublisher
+	opts       OutboxOptions
+	now        func() time.Time
+}
+
+func NewOutboxUsecase(outboxRepo domain.OutboxRepository, publisher domain.EventPublisher, opts OutboxOptions) *OutboxUsecase {
+	opts.setDefaults()
+
+	return &OutboxUsecase{
+		outboxRepo: outboxRepo,
+		publisher:  publisher,
+		opts:       opts,
+		now:        time.Now,
+	}
+}
+
+func (uc *OutboxUsecase) Relay(ctx context.Context) error {
+	var relayErr error
+
+	_, err := uc.outboxRepo.WithRelayLock(ctx, func(ctx context.Context) error {
+		events, err := uc.outboxRepo.PendingOutboxEvents(ctx, uc.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		published := make([]uint64, 0, len(events))
+		blocked := map[string]bool{}
+		for _, event := range events {
+			aggregate := event.AggregateType + "/" + event.AggregateID
+			if blocked[aggregate] {
+				continue
+			}
+
+			err = uc.publish(ctx, &event)
+			if err != nil {
+				log.FromContext(ctx).Err(err).Uint64("outbox_event_id", event.ID).Str("subject", event.Subject).Error("outbox: publish event failed")
+				blocked[aggregate] = true
+				if relayErr == nil {
+					relayErr = fmt.Errorf("outbox: publish event %d failed: %w", event.ID, err)
+				}
+				continue
+			}
+			published = append(published, event.ID)
+		}
+
+		return uc.outboxRepo.MarkOutboxEventsPublished(ctx, published, uc.now().UTC())
+	})
+	if err != nil {
+		return err
+	}
+
+	return relayErr
+}
+
+func (uc *OutboxUsecase) publish(ctx context.Context, event *domain.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, uc.opts.PublishTimeout)
+	defer cancel()
+
+	// 用 outbox 的 ID 作為 message ID, 重複發佈時由 broker 去除重複
+	return uc.publisher.Publish(ctx, event.Subject, fmt.Sprintf("identity-outbox-%d", event.ID), event.Payload)
+}
+
+// OutboxEventLogRepo 寫入 event log 時, 在同一個 transaction 把對應的領域事件寫入 outbox
+type OutboxEventLogRepo struct {
+	domain.EventLogRepository
+	outboxRepo domain.OutboxRepository
+}
+
+func NewOutboxEventLogRepo(eventLogRepo domain.EventLogRepository, outboxRepo domain.OutboxRepository) *OutboxEventLogRepo {
+	return &OutboxEventLogRepo{
+		EventLogRepository: eventLogRepo,
+		outboxRepo:         outboxRepo,
+	}
+}
+
+func (repo *OutboxEventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.EventLog) error {
+	return database.Transaction(ctx, func(ctx context.Context) error {
+		err := repo.EventLogRepository.CreateEventLog(ctx, eventLog)
+		if err != nil {
+			return err
+		}
+
+		events := domainEventsFromEventLog(eventLog)
+		if len(events) == 0 {
+			return nil
+		}
+
+		// 同一筆 event log 產生的事件屬於同一個 aggregate, 例如角色成員異動的事件都屬於這個角色
+		aggregateType := strings.TrimPrefix(eventLog.Namespace, "identity.")
+		outboxEvents := make([]*domain.OutboxEvent, 0, len(events))
+		for i := range events {
+			event := events[i]
+			if event.ID == "" {
+				event.ID = uuid.NewString()
+			}
+
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			outboxEvents = append(outboxEvents, &domain.OutboxEvent{
+				AggregateType: aggregateType,
+				AggregateID:   eventLog.TargetID,
+				Namespace:     event.Namespace,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Subject:       domain.OutboxSubject(event.Namespace, event.Type),
+				Payload:       payload,
+				State:         domain.OutboxStatePending,
+			})
+		}
+
+		return repo.outboxRepo.CreateOutboxEvents(ctx, outboxEvents)
+	})
+}
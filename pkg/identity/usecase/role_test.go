@@ -54,7 +54,7 @@ func TestRoleTestSuite(t *testing.T) {
 
 	roleRepo := identityMysql.NewRoleRepo()
 	accountRepo := identityMysql.NewAccountRepo()
-	usecase := NewRoleUsecase(roleRepo)
+	usecase := NewRoleUsecase(roleRepo, identityMysql.NewEventLogRepo())
 
 	roleTestSuite := RoleTestSuite{
 		db:          db,
@@ -166,7 +166,7 @@ func (suite *RoleTestSuite) TestAddAccountsToRole() {
 
 	accountIds := []uint64{account1.ID, account2.ID}
 
-	err = suite.usecase.AddAccountsToRole(ctx, accountIds, role.ID)
+	err = suite.usecase.AddAccountsToRole(ctx, suite.namespace, accountIds, role.ID)
 	suite.Require().NoError(err)
 
 	accounts, err := suite.accountRepo.AccountsByRoleID(ctx, suite.namespace, role.ID)
@@ -3,24 +3,59 @@ package usecase
 import (
 	"context"
 	"identity/pkg/domain"
+	"strconv"
 )
 
 type RoleUsecase struct {
-	roleRepo domain.RoleRepository
+	roleRepo     domain.RoleRepository
+	eventLogRepo domain.EventLogRepository
 }
 
-func NewRoleUsecase(repo domain.RoleRepository) *RoleUsecase {
+func NewRoleUsecase(repo domain.RoleRepository, eventLogRepo domain.EventLogRepository) *RoleUsecase {
 	return &RoleUsecase{
-		roleRepo: repo,
+		roleRepo:     repo,
+		eventLogRepo: eventLogRepo,
 	}
 }
 
 func (uc *RoleUsecase) CreateRole(ctx context.Context, role *domain.Role) error {
-	return uc.roleRepo.CreateRole(ctx, role)
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "create",
+		Message:   "role is created",
+		Actor:     role.CreatorName,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			if role.ID == 0 {
+				return nil, nil
+			}
+			return loadRoleStatus(ctx, uc.roleRepo, role.Namespace, role.ID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		err := uc.roleRepo.CreateRole(ctx, role)
+		if err != nil {
+			return err
+		}
+
+		entry.TargetID = strconv.FormatUint(role.ID, 10)
+		return nil
+	})
 }
 
 func (uc *RoleUsecase) UpdateRole(ctx context.Context, role *domain.Role) error {
-	return uc.roleRepo.UpdateRole(ctx, role)
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "update",
+		TargetID:  strconv.FormatUint(role.ID, 10),
+		Message:   "role is updated",
+		Actor:     role.UpdaterName,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			return loadRoleStatus(ctx, uc.roleRepo, role.Namespace, role.ID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		return uc.roleRepo.UpdateRole(ctx, role)
+	})
 }
 
 func (uc *RoleUsecase) Role(ctx context.Context, namespace string, id uint64) (*domain.Role, error) {
@@ -31,8 +66,41 @@ func (uc *RoleUsecase) Roles(ctx context.Context, opts domain.FindRoleOptions) (
 	return uc.roleRepo.Roles(ctx, opts)
 }
 
-func (uc *RoleUsecase) AddAccountsToRole(ctx context.Context, accountIDs []uint64, roleID uint64) error {
-	return uc.roleRepo.AddAccountsToRole(ctx, accountIDs, roleID)
+// AddAccountsToRole 會取代角色原本的成員, event log 記錄異動前後的成員
+func (uc *RoleUsecase) AddAccountsToRole(ctx context.Context, namespace string, accountIDs []uint64, roleID uint64) error {
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "update_members",
+		TargetID:  strconv.FormatUint(roleID, 10),
+		Message:   "role members are replaced",
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			return loadRoleStatus(ctx, uc.roleRepo, namespace, roleID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		return uc.roleRepo.AddAccountsToRole(ctx, accountIDs, roleID)
+	})
+}
+
+// roleStatus 是角色記錄在 event log 的狀態, 成員只記錄帳號 ID;
+// RoleUsecase 與 SCIM 都使用這個格式, domainEventsFromEventLog 依照前後的成員發佈 role.assigned 與 role.unassigned
+type roleStatus struct {
+	Role      domain.Role `json:"role"`
+	MemberIDs []uint64    `json:"member_ids"`
+}
+
+func loadRoleStatus(ctx context.Context, roleRepo domain.RoleRepository, namespace string, roleID uint64) (*roleStatus, error) {
+	role, err := roleRepo.Role(ctx, namespace, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberIDs, err := roleRepo.AccountIDsByRoleID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &roleStatus{Role: *role, MemberIDs: memberIDs}, nil
 }
 
 func (uc *RoleUsecase) RolesByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.Role, error) {
@@ -0,0 +1,257 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"identity/internal/pkg/database"
+	"identity/pkg/domain"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SCIMTestSuite struct {
+	suite.Suite
+	accounts    *fakeSCIMAccountRepo
+	roles       *fakeSCIMRoleRepo
+	permissions *fakePermissionRepo
+	eventLogs   *fakeEventLogRepo
+	usecase     *SCIMUsecase
+	namespace   string
+}
+
+func TestSCIMTestSuite(t *testing.T) {
+	suite.Run(t, &SCIMTestSuite{namespace: "test.identity"})
+}
+
+func (suite *SCIMTestSuite) SetupTest() {
+	database.SetMockMode(true)
+
+	suite.roles = &fakeSCIMRoleRepo{members: map[uint64][]uint64{}}
+	suite.accounts = &fakeSCIMAccountRepo{
+		fakeAccountRepo: fakeAccountRepo{accounts: []*domain.Account{
+			{ID: 1, UUID: "uuid-okta", Namespace: suite.namespace, Username: sql.NullString{String: "okta", Valid: true}, State: domain.AccountStatusNormal},
+			{ID: 2, UUID: "uuid-angela", Namespace: suite.namespace, Username: sql.NullString{String: "angela", Valid: true}, State: domain.AccountStatusNormal},
+			{ID: 3, UUID: "uuid-jason", Namespace: suite.namespace, Username: sql.NullString{String: "jason", Valid: true}, State: domain.AccountStatusNormal},
+			{ID: 4, UUID: "uuid-admin", Namespace: suite.namespace, Username: sql.NullString{String: "admin", Valid: true}, IsAdmin: 1, State: domain.AccountStatusNormal},
+		}},
+		roles: suite.roles,
+	}
+	suite.permissions = &fakePermissionRepo{}
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.usecase = NewSCIMUsecase(nil, suite.accounts, suite.roles, suite.permissions, suite.eventLogs)
+}
+
+func (suite *SCIMTestSuite) TearDownTest() {
+	database.SetMockMode(false)
+}
+
+// fakeSCIMAccountRepo 角色的成員來自 fakeSCIMRoleRepo
+type fakeSCIMAccountRepo struct {
+	fakeAccountRepo
+	roles *fakeSCIMRoleRepo
+}
+
+func (repo *fakeSCIMAccountRepo) AccountByUUID(ctx context.Context, namespace string, uuid string) (*domain.Account, error) {
+	for _, account := range repo.accounts {
+		if account.Namespace == namespace && account.UUID == uuid {
+			return account, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeSCIMAccountRepo) CountAccounts(ctx context.Context, opts domain.FindAccountOptions) (int64, error) {
+	accounts, err := repo.Accounts(ctx, opts)
+	return int64(len(accounts)), err
+}
+
+func (repo *fakeSCIMAccountRepo) AccountsByRoleID(ctx context.Context, namespace string, roleID uint64) ([]domain.Account, error) {
+	result := []domain.Account{}
+	for _, accountID := range repo.roles.members[roleID] {
+		account, err := repo.Account(ctx, namespace, accountID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *account)
+	}
+	return result, nil
+}
+
+type fakeSCIMRoleRepo struct {
+	domain.RoleRepository
+	roles   []*domain.Role
+	members map[uint64][]uint64
+}
+
+func (repo *fakeSCIMRoleRepo) Role(ctx context.Context, namespace string, id uint64) (*domain.Role, error) {
+	for _, role := range repo.roles {
+		if role.Namespace == namespace && role.ID == id {
+			result := *role
+			return &result, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeSCIMRoleRepo) Roles(ctx context.Context, opts domain.FindRoleOptions) ([]domain.Role, error) {
+	result := []domain.Role{}
+	for _, role := range repo.roles {
+		if role.Namespace == opts.Namespace && (opts.Name == "" || role.Name == opts.Name) {
+			result = append(result, *role)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeSCIMRoleRepo) RolesByAccountID(ctx context.Context, namespace string, accountID uint64) ([]domain.Role, error) {
+	result := []domain.Role{}
+	for _, role := range repo.roles {
+		for _, memberID := range repo.members[role.ID] {
+			if memberID == accountID {
+				result = append(result, *role)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeSCIMRoleRepo) CreateRole(ctx context.Context, role *domain.Role) error {
+	role.ID = uint64(len(repo.roles) + 1)
+	role.Version = 1
+	created := *role
+	repo.roles = append(repo.roles, &created)
+	return nil
+}
+
+func (repo *fakeSCIMRoleRepo) UpdateRole(ctx context.Context, role *domain.Role) error {
+	for _, existing := range repo.roles {
+		if existing.ID == role.ID {
+			if existing.Version != role.Version {
+				return domain.ErrStale
+			}
+			*existing = *role
+			existing.Version++
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+func (repo *fakeSCIMRoleRepo) AddAccountsToRole(ctx context.Context, accountIDs []uint64, roleID uint64) error {
+	repo.members[roleID] = accountIDs
+	return nil
+}
+
+func (repo *fakeSCIMRoleRepo) AccountIDsByRoleID(ctx context.Context, roleID uint64) ([]uint64, error) {
+	accountIDs := append([]uint64{}, repo.members[roleID]...)
+	sort.Slice(accountIDs, func(i, j int) bool {
+		return accountIDs[i] < accountIDs[j]
+	})
+	return accountIDs, nil
+}
+
+func (suite *SCIMTestSuite) TestAuthorize() {
+	ctx := context.Background()
+
+	err := suite.usecase.Authorize(ctx, &domain.Token{Namespace: suite.namespace, AccountID: 1})
+	suite.ErrorIs(err, domain.ErrSCIMForbidden)
+
+	suite.permissions.permissions = append(suite.permissions.permissions, domain.Permission{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		Code:      domain.PermissionSCIM,
+	})
+	err = suite.usecase.Authorize(ctx, &domain.Token{Namespace: suite.namespace, AccountID: 1})
+	suite.NoError(err)
+
+	err = suite.usecase.Authorize(ctx, &domain.Token{Namespace: suite.namespace, AccountID: 4})
+	suite.NoError(err)
+
+	// 模擬登入的 token 就算是 admin 也不能使用 SCIM
+	err = suite.usecase.Authorize(ctx, &domain.Token{Namespace: suite.namespace, AccountID: 4, Claims: map[string]string{domain.ClaimImpersonatorID: "1"}})
+	suite.ErrorIs(err, domain.ErrImpersonationRestricted)
+}
+
+func (suite *SCIMTestSuite) TestUsersCountIgnoresPagination() {
+	accounts, total, err := suite.usecase.Users(context.Background(), domain.FindAccountOptions{
+		Namespace: suite.namespace,
+		Username:  "angela",
+		Offset:    10,
+		Limit:     10,
+	})
+	suite.Require().NoError(err)
+	suite.Len(accounts, 1)
+	suite.Equal(int64(1), total)
+}
+
+func (suite *SCIMTestSuite) TestGroupLifecycle() {
+	ctx := context.Background()
+
+	group := &domain.SCIMGroup{
+		Role:    domain.Role{Namespace: suite.namespace, Name: "engineering", CreatorName: "okta", UpdaterName: "okta"},
+		Members: []domain.Account{{UUID: "uuid-angela"}},
+	}
+	err := suite.usecase.CreateGroup(ctx, group)
+	suite.Require().NoError(err)
+	suite.Equal(uint64(2), group.Members[0].ID)
+	suite.Equal([]uint64{2}, suite.roles.members[group.Role.ID])
+
+	err = suite.usecase.CreateGroup(ctx, &domain.SCIMGroup{Role: domain.Role{Namespace: suite.namespace, Name: "engineering"}})
+	suite.ErrorIs(err, domain.ErrAlreadyExists)
+
+	found, err := suite.usecase.Group(ctx, suite.namespace, group.Role.ID)
+	suite.Require().NoError(err)
+	found.Members = []domain.Account{{UUID: "uuid-angela"}, {UUID: "uuid-jason"}}
+	err = suite.usecase.UpdateGroup(ctx, found)
+	suite.Require().NoError(err)
+	suite.Equal([]uint64{2, 3}, suite.roles.members[group.Role.ID])
+
+	// 用舊的版本更新時要回傳 ErrStale, 讓 SCIM 回傳 412
+	err = suite.usecase.UpdateGroup(ctx, group)
+	suite.ErrorIs(err, domain.ErrStale)
+
+	roles, err := suite.usecase.activeRoles(ctx, suite.namespace, 3)
+	suite.Require().NoError(err)
+	suite.Len(roles, 1)
+
+	err = suite.usecase.DeleteGroup(ctx, suite.namespace, group.Role.ID, "okta")
+	suite.Require().NoError(err)
+	suite.Empty(suite.roles.members[group.Role.ID])
+
+	_, err = suite.usecase.Group(ctx, suite.namespace, group.Role.ID)
+	suite.ErrorIs(err, domain.ErrNotFound)
+
+	groups, total, err := suite.usecase.Groups(ctx, domain.FindRoleOptions{Namespace: suite.namespace})
+	suite.Require().NoError(err)
+	suite.Empty(groups)
+	suite.Equal(int64(0), total)
+
+	// 重新建立同名的角色時會啟用原本被停用的角色
+	restored := &domain.SCIMGroup{Role: domain.Role{Namespace: suite.namespace, Name: "engineering", CreatorName: "okta"}}
+	err = suite.usecase.CreateGroup(ctx, restored)
+	suite.Require().NoError(err)
+	suite.Equal(group.Role.ID, restored.Role.ID)
+	suite.Equal(domain.RoleStatusNormal, restored.Role.State)
+
+	actions := []string{}
+	events := []string{}
+	for _, eventLog := range suite.eventLogs.eventLogs {
+		suite.Equal("identity.role", eventLog.Namespace)
+		suite.Equal(strconv.FormatUint(group.Role.ID, 10), eventLog.TargetID)
+		actions = append(actions, eventLog.Action)
+
+		for _, event := range domainEventsFromEventLog(eventLog) {
+			events = append(events, event.Type+":"+event.TargetID)
+		}
+	}
+	suite.Equal([]string{"create", "update", "delete", "create"}, actions)
+	suite.Equal([]string{
+		"role.created:1", "role.assigned:2",
+		"role.updated:1", "role.assigned:3",
+		"role.deleted:1", "role.unassigned:2", "role.unassigned:3",
+		"role.created:1",
+	}, events)
+}
@@ -0,0 +1,363 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+)
+
+// SCIMUsecase 讓 Okta 或 Azure AD 透過 SCIM 佈建帳號與角色,
+// 帳號的異動透過 AccountUsecase 處理, 與其他管道一樣會記錄 event log
+type SCIMUsecase struct {
+	accountSvc     domain.AccountUsecase
+	accountRepo    domain.AccountRepository
+	roleRepo       domain.RoleRepository
+	permissionRepo domain.PermissionRepository
+	eventLogRepo   domain.EventLogRepository
+}
+
+func NewSCIMUsecase(accountSvc domain.AccountUsecase, accountRepo domain.AccountRepository, roleRepo domain.RoleRepository, permissionRepo domain.PermissionRepository, eventLogRepo domain.EventLogRepository) *SCIMUsecase {
+	return &SCIMUsecase{
+		accountSvc:     accountSvc,
+		accountRepo:    accountRepo,
+		roleRepo:       roleRepo,
+		permissionRepo: permissionRepo,
+		eventLogRepo:   eventLogRepo,
+	}
+}
+
+// Authorize 模擬登入的 token 不能使用 SCIM
+func (uc *SCIMUsecase) Authorize(ctx context.Context, token *domain.Token) error {
+	err := domain.RequireNotImpersonated(token)
+	if err != nil {
+		return err
+	}
+
+	account, err := uc.accountRepo.Account(ctx, token.Namespace, uint64(token.AccountID))
+	if err != nil {
+		return err
+	}
+
+	if account.State != domain.AccountStatusNormal {
+		return domain.ErrAccountDisabled
+	}
+
+	if account.IsAdmin == 1 {
+		return nil
+	}
+
+	permissions, err := uc.permissionRepo.PermissionsByAccountID(ctx, account.Namespace, account.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		if permission.Code == domain.PermissionSCIM {
+			return nil
+		}
+	}
+
+	return domain.ErrSCIMForbidden
+}
+
+func (uc *SCIMUsecase) Users(ctx context.Context, opts domain.FindAccountOptions) ([]domain.Account, int64, error) {
+	accounts, err := uc.accountRepo.Accounts(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 總數不能套用分頁, 否則 offset 超過第一頁時會是 0
+	countOpts := opts
+	countOpts.Offset = 0
+	countOpts.Limit = 0
+	countOpts.Sort = ""
+
+	total, err := uc.accountRepo.CountAccounts(ctx, countOpts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return accounts, total, nil
+}
+
+func (uc *SCIMUsecase) User(ctx context.Context, namespace string, uuid string) (*domain.Account, []domain.Role, error) {
+	account, err := uc.accountRepo.AccountByUUID(ctx, namespace, uuid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roles, err := uc.activeRoles(ctx, namespace, account.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return account, roles, nil
+}
+
+// CreateUser SCIM 沒有提供密碼時產生一組隨機密碼, 使用者需要透過 SSO 或重設密碼登入
+func (uc *SCIMUsecase) CreateUser(ctx context.Context, account *domain.Account) error {
+	if account.PasswordEncrypt == "" {
+		password, err := randomString(32)
+		if err != nil {
+			return err
+		}
+		account.PasswordEncrypt = password
+	}
+
+	account.Type = domain.AccountTypeUser
+	if account.State == domain.AccountStatusDefault {
+		account.State = domain.AccountStatusNormal
+	}
+
+	return uc.accountSvc.CreateAccount(ctx, account)
+}
+
+// UpdateUser 被鎖定的帳號不會因為 active 為 true 而解鎖
+func (uc *SCIMUsecase) UpdateUser(ctx context.Context, account *domain.Account, active bool) error {
+	switch {
+	case !active:
+		account.State = domain.AccountStatusDisabled
+	case account.State == domain.AccountStatusDisabled || account.State == domain.AccountStatusDefault:
+		account.State = domain.AccountStatusNormal
+	}
+
+	err := uc.accountSvc.UpdateAccount(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	account.Version++
+	return nil
+}
+
+func (uc *SCIMUsecase) DeactivateUser(ctx context.Context, namespace string, uuid string, actor string) error {
+	account, err := uc.accountRepo.AccountByUUID(ctx, namespace, uuid)
+	if err != nil {
+		return err
+	}
+
+	return uc.accountSvc.ChangeState(ctx, domain.ChangeStateRequest{
+		Namespace:   namespace,
+		AccountID:   account.ID,
+		State:       domain.AccountStatusDisabled,
+		UpdaterName: actor,
+	})
+}
+
+// Groups 停用的角色視為已經被刪除, 角色的數量不多所以在記憶體分頁
+func (uc *SCIMUsecase) Groups(ctx context.Context, opts domain.FindRoleOptions) ([]domain.SCIMGroup, int64, error) {
+	offset, limit := opts.Offset, opts.Limit
+	opts.Offset, opts.Limit = 0, 0
+
+	roles, err := uc.roleRepo.Roles(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	active := make([]domain.Role, 0, len(roles))
+	for _, role := range roles {
+		if role.State != domain.RoleStatusDisabled {
+			active = append(active, role)
+		}
+	}
+
+	total := int64(len(active))
+	if offset > len(active) {
+		offset = len(active)
+	}
+	active = active[offset:]
+	if limit > 0 && limit < len(active) {
+		active = active[:limit]
+	}
+
+	groups := make([]domain.SCIMGroup, 0, len(active))
+	for _, role := range active {
+		members, err := uc.accountRepo.AccountsByRoleID(ctx, role.Namespace, role.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, domain.SCIMGroup{Role: role, Members: members})
+	}
+
+	return groups, total, nil
+}
+
+func (uc *SCIMUsecase) Group(ctx context.Context, namespace string, roleID uint64) (*domain.SCIMGroup, error) {
+	role, err := uc.roleRepo.Role(ctx, namespace, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.State == domain.RoleStatusDisabled {
+		return nil, fmt.Errorf("role %d was deleted by scim. %w", roleID, domain.ErrNotFound)
+	}
+
+	members, err := uc.accountRepo.AccountsByRoleID(ctx, namespace, role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.SCIMGroup{Role: *role, Members: members}, nil
+}
+
+// CreateGroup 同名的角色之前被 SCIM 刪除 (停用) 時, 會重新啟用原本的角色
+func (uc *SCIMUsecase) CreateGroup(ctx context.Context, group *domain.SCIMGroup) error {
+	role := &group.Role
+	actor := role.CreatorName
+	if role.Namespace == "" || role.Name == "" {
+		return fmt.Errorf("namespace and displayName are required. %w", domain.ErrInvalidInput)
+	}
+
+	existing, err := uc.roleRepo.Roles(ctx, domain.FindRoleOptions{Namespace: role.Namespace, Name: role.Name})
+	if err != nil {
+		return err
+	}
+
+	namespace := role.Namespace
+	roleID := uint64(0)
+	if len(existing) > 0 {
+		roleID = existing[0].ID
+	}
+
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "create",
+		TargetID:  strconv.FormatUint(roleID, 10),
+		Message:   fmt.Sprintf("create role %s by scim", role.Name),
+		Actor:     actor,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			if roleID == 0 {
+				return nil, nil
+			}
+			return loadRoleStatus(ctx, uc.roleRepo, namespace, roleID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		if len(existing) > 0 {
+			if existing[0].State != domain.RoleStatusDisabled {
+				return fmt.Errorf("role %s already exists. %w", role.Name, domain.ErrAlreadyExists)
+			}
+
+			restored := existing[0]
+			restored.Desc = role.Desc
+			restored.State = domain.RoleStatusNormal
+			restored.UpdaterName = actor
+
+			err = uc.roleRepo.UpdateRole(ctx, &restored)
+			if err != nil {
+				return err
+			}
+			restored.Version++
+			*role = restored
+		} else {
+			role.State = domain.RoleStatusNormal
+			err = uc.roleRepo.CreateRole(ctx, role)
+			if err != nil {
+				return err
+			}
+			roleID = role.ID
+			entry.TargetID = strconv.FormatUint(roleID, 10)
+		}
+
+		return uc.setMembers(ctx, group)
+	})
+}
+
+func (uc *SCIMUsecase) UpdateGroup(ctx context.Context, group *domain.SCIMGroup) error {
+	old, err := uc.Group(ctx, group.Role.Namespace, group.Role.ID)
+	if err != nil {
+		return err
+	}
+
+	if old.Role.Version != group.Role.Version {
+		return domain.ErrStale
+	}
+
+	namespace, roleID := group.Role.Namespace, group.Role.ID
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "update",
+		TargetID:  strconv.FormatUint(roleID, 10),
+		Message:   fmt.Sprintf("update role %s by scim", group.Role.Name),
+		Actor:     group.Role.UpdaterName,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			return loadRoleStatus(ctx, uc.roleRepo, namespace, roleID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		role := &group.Role
+		role.State = domain.RoleStatusNormal
+
+		err := uc.roleRepo.UpdateRole(ctx, role)
+		if err != nil {
+			return err
+		}
+		role.Version++
+
+		return uc.setMembers(ctx, group)
+	})
+}
+
+func (uc *SCIMUsecase) DeleteGroup(ctx context.Context, namespace string, roleID uint64, actor string) error {
+	old, err := uc.Group(ctx, namespace, roleID)
+	if err != nil {
+		return err
+	}
+
+	entry := &auditEntry{
+		Namespace: "identity.role",
+		Action:    "delete",
+		TargetID:  strconv.FormatUint(roleID, 10),
+		Message:   fmt.Sprintf("delete role %s by scim", old.Role.Name),
+		Actor:     actor,
+		Snapshot: func(ctx context.Context) (interface{}, error) {
+			return loadRoleStatus(ctx, uc.roleRepo, namespace, roleID)
+		},
+	}
+	return audit(ctx, uc.eventLogRepo, entry, func(ctx context.Context) error {
+		deleted := domain.SCIMGroup{Role: old.Role}
+		deleted.Role.State = domain.RoleStatusDisabled
+		deleted.Role.UpdaterName = actor
+
+		err := uc.roleRepo.UpdateRole(ctx, &deleted.Role)
+		if err != nil {
+			return err
+		}
+
+		return uc.roleRepo.AddAccountsToRole(ctx, nil, roleID)
+	})
+}
+
+// setMembers 把成員的 UUID 轉成帳號 ID, 然後取代角色原本的成員
+func (uc *SCIMUsecase) setMembers(ctx context.Context, group *domain.SCIMGroup) error {
+	accountIDs := make([]uint64, 0, len(group.Members))
+	for i := range group.Members {
+		member := &group.Members[i]
+		if member.ID == 0 {
+			account, err := uc.accountRepo.AccountByUUID(ctx, group.Role.Namespace, member.UUID)
+			if err != nil {
+				return fmt.Errorf("member %s: %w", member.UUID, err)
+			}
+			*member = *account
+		}
+		accountIDs = append(accountIDs, member.ID)
+	}
+
+	return uc.roleRepo.AddAccountsToRole(ctx, accountIDs, group.Role.ID)
+}
+
+func (uc *SCIMUsecase) activeRoles(ctx context.Context, namespace string, accountID uint64) ([]domain.Role, error) {
+	roles, err := uc.roleRepo.RolesByAccountID(ctx, namespace, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.Role, 0, len(roles))
+	for _, role := range roles {
+		if role.State != domain.RoleStatusDisabled {
+			result = append(result, role)
+		}
+	}
+	return result, nil
+}
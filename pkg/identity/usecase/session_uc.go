@@ -0,0 +1,82 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	"sort"
+)
+
+type SessionUsecase struct {
+	sessionRepo domain.SessionRepository
+}
+
+func NewSessionUsecase(sessionRepo domain.SessionRepository) *SessionUsecase {
+	return &SessionUsecase{
+		sessionRepo: sessionRepo,
+	}
+}
+
+func (uc *SessionUsecase) Session(ctx context.Context, namespace string, accountID int64, sessionID string) (*domain.Session, error) {
+	session, err := uc.sessionRepo.Session(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Namespace != namespace || session.AccountID != accountID {
+		return nil, fmt.Errorf("session does not belong to the account. %w", domain.ErrNotFound)
+	}
+
+	return session, nil
+}
+
+// Sessions 回傳 account 在 namespace 底下所有的 session, 最新登入的排在最前面
+func (uc *SessionUsecase) Sessions(ctx context.Context, namespace string, accountID int64) ([]domain.Session, error) {
+	sessions, err := uc.sessionRepo.SessionsByAccountID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Namespace != namespace {
+			continue
+		}
+		result = append(result, session)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+func (uc *SessionUsecase) RevokeSession(ctx context.Context, request domain.RevokeSessionRequest) error {
+	session, err := uc.Session(ctx, request.Namespace, request.AccountID, request.SessionID)
+	if err != nil {
+		return err
+	}
+
+	return uc.sessionRepo.DeleteSession(ctx, session.ID)
+}
+
+func (uc *SessionUsecase) RevokeOtherSessions(ctx context.Context, request domain.RevokeOtherSessionsRequest) error {
+	sessions, err := uc.Sessions(ctx, request.Namespace, request.AccountID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == request.CurrentSessionID {
+			continue
+		}
+
+		err = uc.sessionRepo.DeleteSession(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"identity/pkg/domain"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siemEnterpriseID 是 RFC 5424 structured data 的 SD-ID 使用的 private enterprise number
+const siemEnterpriseID = "identity@32473"
+
+// siemRecord 是匯出到 SIEM 的 event log 或 login log, 不包含帳號的原始狀態, 只有遮蔽過敏感欄位的差異
+type siemRecord struct {
+	Type        string       `json:"type"`
+	ID          uint64       `json:"id"`
+	Namespace   string       `json:"namespace"`
+	Action      string       `json:"action"`
+	TargetID    string       `json:"target_id"`
+	Actor       string       `json:"actor,omitempty"`
+	ClientIP    string       `json:"client_ip,omitempty"`
+	Outcome     string       `json:"outcome"`
+	Message     string       `json:"message,omitempty"`
+	Changes     []siemChange `json:"changes,omitempty"`
+	CountryCode string       `json:"country_code,omitempty"`
+	CityName    string       `json:"city_name,omitempty"`
+	DeviceType  string       `json:"device_type,omitempty"`
+	Hash        string       `json:"hash,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+type siemChange struct {
+	Field    string          `json:"field"`
+	OldValue json.RawMessage `json:"old_value"`
+	NewValue json.RawMessage `json:"new_value"`
+}
+
+func siemRecordFromEventLog(eventLog *domain.EventLog) *siemRecord {
+	record := &siemRecord{
+		Type:      domain.SIEMRecordEventLog,
+		ID:        eventLog.ID,
+		Namespace: eventLog.Namespace,
+		Action:    eventLog.Action,
+		TargetID:  eventLog.TargetID,
+		Actor:     eventLog.Actor,
+		ClientIP:  eventLog.ClientIP,
+		Outcome:   siemOutcome(eventLog.State == domain.EventLogSuccess, eventLog.State == domain.EventLogFail),
+		Message:   eventLog.Message,
+		Hash:      eventLog.Hash,
+		CreatedAt: eventLog.CreatedAt.UTC(),
+	}
+
+	for _, change := range domain.DiffStatus(eventLog.OldStatus, eventLog.NewStatus) {
+		record.Changes = append(record.Changes, siemChange{Field: change.Field, OldValue: change.OldValue, NewValue: change.NewValue})
+	}
+
+	return record
+}
+
+func siemRecordFromLoginLog(loginLog *domain.LoginLog) *siemRecord {
+	return &siemRecord{
+		Type:        domain.SIEMRecordLoginLog,
+		ID:          loginLog.ID,
+		Namespace:   loginLog.Namespace,
+		Action:      "login",
+		TargetID:    loginLog.TargetID,
+		ClientIP:    loginLog.ClientIP,
+		Outcome:     siemOutcome(loginLog.State == domain.LoginLogSuccess, loginLog.State == domain.LoginLogFail),
+		CountryCode: loginLog.CountryCode,
+		CityName:    loginLog.CityName,
+		DeviceType:  siemDeviceType(loginLog.DeviceType),
+		CreatedAt:   loginLog.CreatedAt.UTC(),
+	}
+}
+
+func siemOutcome(success bool, fail bool) string {
+	switch {
+	case success:
+		return "success"
+	case fail:
+		return "failure"
+	}
+	return "unknown"
+}
+
+func siemDeviceType(deviceType domain.DeviceType) string {
+	switch deviceType {
+	case domain.DeviceTypeWeb:
+		return "web"
+	case domain.DeviceTypeIOS:
+		return "ios"
+	case domain.DeviceTypeAndroid:
+		return "android"
+	}
+	return ""
+}
+
+// formatSIEMRecord 依照格式產生一筆紀錄, 結果不包含換行
+func formatSIEMRecord(format string, hostname string, record *siemRecord) ([]byte, error) {
+	switch format {
+	case domain.SIEMFormatJSON:
+		return json.Marshal(record)
+	case domain.SIEMFormatCEF:
+		return formatCEF(record)
+	case domain.SIEMFormatSyslog:
+		return formatSyslog(hostname, record)
+	}
+	return nil, fmt.Errorf("siem format %q is not supported. %w", format, domain.ErrInvalidInput)
+}
+
+// formatCEF 產生 ArcSight Common Event Format, 例如
+// CEF:0|Identity|identity|1.0|identity.account:update|identity.account update|3|rt=1656633600000 ...
+func formatCEF(record *siemRecord) ([]byte, error) {
+	signatureID := record.Namespace + ":" + record.Action
+	name := record.Message
+	if record.Type == domain.SIEMRecordLoginLog {
+		signatureID = "login:" + record.Outcome
+		name = "login " + record.Outcome
+	}
+	if name == "" {
+		name = record.Namespace + " " + record.Action
+	}
+
+	severity := 3
+	if record.Outcome == "failure" {
+		severity = 6
+	}
+
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(record.CreatedAt.UnixNano()/int64(time.Millisecond), 10)},
+		{"externalId", record.Type + "-" + strconv.FormatUint(record.ID, 10)},
+		{"act", record.Action},
+		{"outcome", record.Outcome},
+		{"suser", record.Actor},
+		{"duid", record.TargetID},
+		{"cs1Label", "namespace"},
+		{"cs1", record.Namespace},
+	}
+	if net.ParseIP(record.ClientIP) != nil {
+		extensions = append(extensions, [2]string{"src", record.ClientIP})
+	}
+	if record.Type == domain.SIEMRecordLoginLog {
+		extensions = append(extensions,
+			[2]string{"cs2Label", "countryCode"}, [2]string{"cs2", record.CountryCode},
+			[2]string{"cs3Label", "cityName"}, [2]string{"cs3", record.CityName},
+			[2]string{"cs4Label", "deviceType"}, [2]string{"cs4", record.DeviceType},
+		)
+	}
+	if record.Hash != "" {
+		extensions = append(extensions, [2]string{"cs5Label", "hash"}, [2]string{"cs5", record.Hash})
+	}
+	if len(record.Changes) > 0 {
+		changes, err := json.Marshal(record.Changes)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, [2]string{"msg", string(changes)})
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("CEF:0|Identity|identity|1.0|")
+	builder.WriteString(escapeCEFHeader(signatureID))
+	builder.WriteByte('|')
+	builder.WriteString(escapeCEFHeader(name))
+	builder.WriteByte('|')
+	builder.WriteString(strconv.Itoa(severity))
+	builder.WriteByte('|')
+	first := true
+	for _, extension := range extensions {
+		if extension[1] == "" {
+			continue
+		}
+		if !first {
+			builder.WriteByte(' ')
+		}
+		first = false
+		builder.WriteString(extension[0])
+		builder.WriteByte('=')
+		builder.WriteString(escapeCEFExtension(extension[1]))
+	}
+
+	return []byte(builder.String()), nil
+}
+
+var cefHeaderReplacer = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+var cefExtensionReplacer = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+func escapeCEFHeader(value string) string {
+	return cefHeaderReplacer.Replace(value)
+}
+
+func escapeCEFExtension(value string) string {
+	return cefExtensionReplacer.Replace(value)
+}
+
+// formatSyslog 產生 RFC 5424 syslog, facility 是 authpriv, 主要欄位放在 structured data, MSG 是 JSON 格式的完整紀錄
+func formatSyslog(hostname string, record *siemRecord) ([]byte, error) {
+	const facilityAuthPriv = 10
+	severity := 6 // informational
+	if record.Outcome == "failure" {
+		severity = 4 // warning
+	}
+
+	message, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	params := [][2]string{
+		{"id", strconv.FormatUint(record.ID, 10)},
+		{"namespace", record.Namespace},
+		{"action", record.Action},
+		{"target_id", record.TargetID},
+		{"actor", record.Actor},
+		{"client_ip", record.ClientIP},
+		{"outcome", record.Outcome},
+	}
+
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "<%d>1 %s %s identity - %s [%s",
+		facilityAuthPriv*8+severity,
+		record.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderValue(hostname, 255),
+		record.Type,
+		siemEnterpriseID,
+	)
+	for _, param := range params {
+		if param[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&builder, ` %s="%s"`, param[0], syslogParamReplacer.Replace(param[1]))
+	}
+	builder.WriteString("] ")
+	builder.Write(message)
+
+	return []byte(builder.String()), nil
+}
+
+var syslogParamReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderValue 只保留 RFC 5424 header 允許的可見 ASCII 字元, 空的時候回傳 NILVALUE
+func syslogHeaderValue(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,351 @@
+package usecase
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/pkg/domain"
+	identitySIEM "identity/pkg/identity/repository/siem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type SIEMTestSuite struct {
+	suite.Suite
+	siemRepo     *fakeSIEMRepo
+	eventLogRepo *fakeEventLogRepo
+	loginLogRepo *fakeLoginLogRepo
+	now          time.Time
+}
+
+func TestSIEMTestSuite(t *testing.T) {
+	suite.Run(t, &SIEMTestSuite{})
+}
+
+func (suite *SIEMTestSuite) SetupTest() {
+	suite.siemRepo = &fakeSIEMRepo{checkpoints: map[string]domain.SIEMCheckpoint{}}
+	suite.eventLogRepo = &fakeEventLogRepo{}
+	suite.loginLogRepo = &fakeLoginLogRepo{}
+	suite.now = time.Date(2022, 8, 1, 8, 0, 0, 0, time.UTC)
+}
+
+func (suite *SIEMTestSuite) newUsecase(sink domain.SIEMSink, format string) *SIEMUsecase {
+	uc, err := NewSIEMUsecase(suite.siemRepo, suite.eventLogRepo, suite.loginLogRepo, sink, SIEMOptions{
+		Format:     format,
+		BatchSize:  2,
+		GapTimeout: time.Minute,
+		Hostname:   "identity-1",
+	})
+	suite.Require().NoError(err)
+	uc.now = func() time.Time { return suite.now }
+	return uc
+}
+
+func (suite *SIEMTestSuite) createEventLog(action string, state domain.EventLogState) *domain.EventLog {
+	oldStatus, _ := json.Marshal(domain.Account{ID: 1, Namespace: "test.identity", FirstName: "angela", PasswordEncrypt: "old hash"})
+	newStatus, _ := json.Marshal(domain.Account{ID: 1, Namespace: "test.identity", FirstName: "Angela", PasswordEncrypt: "new hash"})
+	eventLog := &domain.EventLog{
+		Namespace: "identity.account",
+		Action:    action,
+		TargetID:  "1",
+		Message:   "update account",
+		OldStatus: datatypes.JSON(oldStatus),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     state,
+		ClientIP:  "182.48.113.104",
+		Actor:     "admin",
+		CreatedAt: suite.now.Add(-time.Hour),
+	}
+	_ = suite.eventLogRepo.CreateEventLog(context.Background(), eventLog)
+	return eventLog
+}
+
+func (suite *SIEMTestSuite) createLoginLog(state domain.LoginLogState) *domain.LoginLog {
+	loginLog := &domain.LoginLog{
+		Namespace:   "test.identity",
+		TargetID:    "1",
+		CountryCode: "TW",
+		DeviceType:  domain.DeviceTypeIOS,
+		State:       state,
+		ClientIP:    "182.48.113.104",
+		CreatedAt:   suite.now.Add(-time.Hour),
+	}
+	_ = suite.loginLogRepo.CreateLoginLog(context.Background(), loginLog)
+	return loginLog
+}
+
+// startSyslogServer 是本地的 syslog TCP listener, 依照 RFC 6587 octet counting 讀出每一筆紀錄
+func (suite *SIEMTestSuite) startSyslogServer() (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = listener.Close() })
+
+	messages := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					length, err := reader.ReadString(' ')
+					if err != nil {
+						return
+					}
+					size, err := strconv.Atoi(strings.TrimSpace(length))
+					if err != nil {
+						return
+					}
+					buf := make([]byte, size)
+					_, err = ioReadFull(reader, buf)
+					if err != nil {
+						return
+					}
+					messages <- string(buf)
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func ioReadFull(reader *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := reader.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (suite *SIEMTestSuite) receive(messages <-chan string, count int) []string {
+	result := []string{}
+	for len(result) < count {
+		select {
+		case message := <-messages:
+			result = append(result, message)
+		case <-time.After(2 * time.Second):
+			suite.FailNow("timeout waiting for syslog messages", "received %d of %d", len(result), count)
+		}
+	}
+
+	select {
+	case message := <-messages:
+		suite.FailNow("unexpected syslog message", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+	return result
+}
+
+func (suite *SIEMTestSuite) TestExportSyslogOverTCP() {
+	ctx := context.Background()
+	address, messages := suite.startSyslogServer()
+	sink, err := identitySIEM.NewNetworkSink(identitySIEM.NetworkSinkOptions{Network: domain.SIEMSinkTCP, Address: address, OctetCounting: true})
+	suite.Require().NoError(err)
+	defer sink.Close()
+
+	for i := 0; i < 3; i++ {
+		suite.createEventLog("update", domain.EventLogSuccess)
+	}
+	suite.createLoginLog(domain.LoginLogFail)
+
+	uc := suite.newUsecase(sink, domain.SIEMFormatSyslog)
+	suite.Require().NoError(uc.Export(ctx))
+
+	// BatchSize 是 2, 一次 Export 仍然會匯出全部
+	received := suite.receive(messages, 4)
+	header := regexp.MustCompile(`^<(\d+)>1 2022-08-01T07:00:00\.000000Z identity-1 identity - (event_log|login_log) \[identity@32473 id="(\d+)"`)
+	match := header.FindStringSubmatch(received[0])
+	suite.Require().NotNil(match, received[0])
+	suite.Equal("86", match[1])
+	suite.Equal("event_log", match[2])
+	suite.Contains(received[0], `actor="admin"`)
+	suite.NotContains(received[0], "old hash")
+	suite.NotContains(received[0], "new hash")
+
+	// 每一輪依序匯出 event log 與 login log 的一個批次
+	loginMessage := received[2]
+	match = header.FindStringSubmatch(loginMessage)
+	suite.Require().NotNil(match, loginMessage)
+	suite.Equal("84", match[1])
+	suite.Equal("login_log", match[2])
+
+	record := siemRecord{}
+	suite.Require().NoError(json.Unmarshal([]byte(loginMessage[strings.Index(loginMessage, "] ")+2:]), &record))
+	suite.Equal("failure", record.Outcome)
+	suite.Equal("ios", record.DeviceType)
+
+	checkpoint := suite.siemRepo.checkpoints["default"]
+	suite.Equal(uint64(3), checkpoint.LastEventLogID)
+	suite.Equal(uint64(1), checkpoint.LastLoginLogID)
+
+	// 重新啟動之後從 checkpoint 接續, 不重送也不遺漏
+	suite.createEventLog("change_state", domain.EventLogFail)
+	uc = suite.newUsecase(sink, domain.SIEMFormatSyslog)
+	suite.Require().NoError(uc.Export(ctx))
+	received = suite.receive(messages, 1)
+	suite.Contains(received[0], `id="4"`)
+	suite.Contains(received[0], `outcome="failure"`)
+
+	suite.Require().NoError(uc.Export(ctx))
+	suite.receive(messages, 0)
+}
+
+func (suite *SIEMTestSuite) TestExportCEFOverUDP() {
+	ctx := context.Background()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer conn.Close()
+
+	sink, err := identitySIEM.NewNetworkSink(identitySIEM.NetworkSinkOptions{Network: domain.SIEMSinkUDP, Address: conn.LocalAddr().String()})
+	suite.Require().NoError(err)
+	defer sink.Close()
+
+	eventLog := suite.createEventLog("update", domain.EventLogSuccess)
+	eventLog.Message = "a|b=c"
+	suite.createLoginLog(domain.LoginLogSuccess)
+
+	uc := suite.newUsecase(sink, domain.SIEMFormatCEF)
+	suite.Require().NoError(uc.Export(ctx))
+
+	datagrams := []string{}
+	buf := make([]byte, 65535)
+	for i := 0; i < 2; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		suite.Require().NoError(err)
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+
+	suite.True(strings.HasPrefix(datagrams[0], `CEF:0|Identity|identity|1.0|identity.account:update|a\|b=c|3|rt=1659337200000 externalId=event_log-1 act=update outcome=success suser=admin duid=1 cs1Label=namespace cs1=identity.account src=182.48.113.104 msg=`), datagrams[0])
+	suite.Contains(datagrams[0], `"field":"FirstName"`)
+	suite.NotContains(datagrams[0], "new hash")
+	suite.True(strings.HasPrefix(datagrams[1], "CEF:0|Identity|identity|1.0|login:success|login success|3|"), datagrams[1])
+	suite.Contains(datagrams[1], "cs2Label=countryCode cs2=TW")
+	suite.Contains(datagrams[1], "cs4Label=deviceType cs4=ios")
+}
+
+func (suite *SIEMTestSuite) TestExportJSONLinesToFile() {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "identity-siem")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "identity.log")
+	sink, err := identitySIEM.NewFileSink(path)
+	suite.Require().NoError(err)
+	defer sink.Close()
+
+	suite.createEventLog("update", domain.EventLogSuccess)
+	uc := suite.newUsecase(sink, domain.SIEMFormatJSON)
+	suite.Require().NoError(uc.Export(ctx))
+	suite.createLoginLog(domain.LoginLogSuccess)
+	suite.Require().NoError(uc.Export(ctx))
+
+	data, err := ioutil.ReadFile(path)
+	suite.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	suite.Require().Len(lines, 2)
+
+	record := siemRecord{}
+	suite.Require().NoError(json.Unmarshal([]byte(lines[0]), &record))
+	suite.Equal(domain.SIEMRecordEventLog, record.Type)
+	suite.Equal(uint64(1), record.ID)
+	suite.Contains(lines[0], `"field":"PasswordEncrypt","old_value":"[REDACTED]"`)
+	suite.Require().NoError(json.Unmarshal([]byte(lines[1]), &record))
+	suite.Equal(domain.SIEMRecordLoginLog, record.Type)
+}
+
+func (suite *SIEMTestSuite) TestExportRetryAndGap() {
+	ctx := context.Background()
+	sink := &failingSIEMSink{err: errors.New("connection refused")}
+	uc := suite.newUsecase(sink, domain.SIEMFormatJSON)
+
+	suite.createEventLog("update", domain.EventLogSuccess)
+	err := uc.Export(ctx)
+	suite.Error(err)
+	suite.Equal(uint64(0), suite.siemRepo.checkpoints["default"].LastEventLogID)
+
+	// 送出失敗不會更新 checkpoint, 下一次重送
+	sink.err = nil
+	suite.Require().NoError(uc.Export(ctx))
+	suite.Len(sink.messages, 1)
+	suite.Equal(uint64(1), suite.siemRepo.checkpoints["default"].LastEventLogID)
+
+	// event log 2 還沒 commit, 停在缺口等待
+	suite.createEventLog("update", domain.EventLogSuccess)
+	third := suite.createEventLog("update", domain.EventLogSuccess)
+	third.CreatedAt = suite.now
+	suite.eventLogRepo.eventLogs = append(suite.eventLogRepo.eventLogs[:1], third)
+	suite.Require().NoError(uc.Export(ctx))
+	suite.Len(sink.messages, 1)
+
+	// 超過 GapTimeout 視為 rollback
+	suite.now = suite.now.Add(2 * time.Minute)
+	suite.Require().NoError(uc.Export(ctx))
+	suite.Len(sink.messages, 2)
+	suite.Equal(uint64(3), suite.siemRepo.checkpoints["default"].LastEventLogID)
+}
+
+func (suite *SIEMTestSuite) TestInvalidFormat() {
+	_, err := NewSIEMUsecase(suite.siemRepo, suite.eventLogRepo, suite.loginLogRepo, &failingSIEMSink{}, SIEMOptions{Format: "xml"})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+}
+
+type fakeSIEMRepo struct {
+	checkpoints map[string]domain.SIEMCheckpoint
+}
+
+func (repo *fakeSIEMRepo) SIEMCheckpoint(ctx context.Context, name string) (*domain.SIEMCheckpoint, error) {
+	checkpoint, ok := repo.checkpoints[name]
+	if !ok {
+		checkpoint = domain.SIEMCheckpoint{Name: name}
+	}
+	return &checkpoint, nil
+}
+
+func (repo *fakeSIEMRepo) SaveSIEMCheckpoint(ctx context.Context, checkpoint *domain.SIEMCheckpoint) error {
+	repo.checkpoints[checkpoint.Name] = *checkpoint
+	return nil
+}
+
+func (repo *fakeSIEMRepo) WithExportLock(ctx context.Context, name string, fn func(ctx context.Context) error) (bool, error) {
+	return true, fn(ctx)
+}
+
+type failingSIEMSink struct {
+	err      error
+	messages []string
+}
+
+func (sink *failingSIEMSink) Write(ctx context.Context, messages [][]byte) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	for _, message := range messages {
+		sink.messages = append(sink.messages, string(message))
+	}
+	return nil
+}
+
+func (sink *failingSIEMSink) Close() error {
+	return nil
+}
@@ -0,0 +1,190 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"identity/pkg/domain"
+	"os"
+	"time"
+
+	"github.com/nite-coder/blackbear/pkg/log"
+)
+
+type SIEMOptions struct {
+	// Name 是 checkpoint 的名稱, 匯出到多個 SIEM 時每個 exporter 使用不同的名稱
+	Name string
+	// Format 是 domain.SIEMFormatCEF, domain.SIEMFormatJSON 或 domain.SIEMFormatSyslog
+	Format string
+	// BatchSize 每次讀取的 event log 與 login log 數量
+	BatchSize int
+	// GapTimeout ID 不連續時等待前面的 transaction commit 的時間, 與 ChangeFeedOptions.GapTimeout 相同
+	GapTimeout time.Duration
+	// Hostname 是 syslog header 的 HOSTNAME, 沒有設定時使用 os.Hostname
+	Hostname string
+}
+
+func (opts *SIEMOptions) setDefaults() {
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	if opts.Format == "" {
+		opts.Format = domain.SIEMFormatJSON
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = 5 * time.Second
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+}
+
+// SIEMUsecase 依照 ID 的順序把 event log 與 login log 匯出到 SIEM
+// 送出成功之後才更新 checkpoint, 所以重新啟動不會遺漏; 送出成功但是來不及更新 checkpoint 時會重送,
+// 每一筆紀錄都帶有 type 與 id, SIEM 可以用來去除重複
+type SIEMUsecase struct {
+	siemRepo     domain.SIEMRepository
+	eventLogRepo domain.EventLogRepository
+	loginLogRepo domain.LoginLogRepository
+	sink         domain.SIEMSink
+	opts         SIEMOptions
+	now          func() time.Time
+}
+
+func NewSIEMUsecase(siemRepo domain.SIEMRepository, eventLogRepo domain.EventLogRepository, loginLogRepo domain.LoginLogRepository, sink domain.SIEMSink, opts SIEMOptions) (*SIEMUsecase, error) {
+	opts.setDefaults()
+
+	switch opts.Format {
+	case domain.SIEMFormatCEF, domain.SIEMFormatJSON, domain.SIEMFormatSyslog:
+	default:
+		return nil, fmt.Errorf("siem format %q is not supported. %w", opts.Format, domain.ErrInvalidInput)
+	}
+
+	return &SIEMUsecase{
+		siemRepo:     siemRepo,
+		eventLogRepo: eventLogRepo,
+		loginLogRepo: loginLogRepo,
+		sink:         sink,
+		opts:         opts,
+		now:          time.Now,
+	}, nil
+}
+
+func (uc *SIEMUsecase) Export(ctx context.Context) error {
+	_, err := uc.siemRepo.WithExportLock(ctx, uc.opts.Name, func(ctx context.Context) error {
+		checkpoint, err := uc.siemRepo.SIEMCheckpoint(ctx, uc.opts.Name)
+		if err != nil {
+			return err
+		}
+
+		// 讀滿一批而且沒有停在缺口時代表可能還有資料, 繼續匯出直到追上
+		for {
+			moreEventLogs, err := uc.exportEventLogs(ctx, checkpoint)
+			if err != nil {
+				return err
+			}
+
+			moreLoginLogs, err := uc.exportLoginLogs(ctx, checkpoint)
+			if err != nil {
+				return err
+			}
+
+			if (!moreEventLogs && !moreLoginLogs) || ctx.Err() != nil {
+				return nil
+			}
+		}
+	})
+	return err
+}
+
+// exportEventLogs 回傳是否還有下一批 event log
+func (uc *SIEMUsecase) exportEventLogs(ctx context.Context, checkpoint *domain.SIEMCheckpoint) (bool, error) {
+	eventLogs, err := uc.eventLogRepo.EventLogsAfter(ctx, checkpoint.LastEventLogID, uc.opts.BatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	lastID := checkpoint.LastEventLogID
+	messages := make([][]byte, 0, len(eventLogs))
+	for i := range eventLogs {
+		eventLog := &eventLogs[i]
+		if uc.waitForGap(lastID, eventLog.ID, eventLog.CreatedAt) {
+			break
+		}
+
+		message, err := formatSIEMRecord(uc.opts.Format, uc.opts.Hostname, siemRecordFromEventLog(eventLog))
+		if err != nil {
+			return false, err
+		}
+		messages = append(messages, message)
+		lastID = eventLog.ID
+	}
+
+	if lastID == checkpoint.LastEventLogID {
+		return false, nil
+	}
+
+	err = uc.sink.Write(ctx, messages)
+	if err != nil {
+		return false, fmt.Errorf("siem: write event logs after %d failed: %w", checkpoint.LastEventLogID, err)
+	}
+
+	checkpoint.LastEventLogID = lastID
+	err = uc.siemRepo.SaveSIEMCheckpoint(ctx, checkpoint)
+	if err != nil {
+		return false, err
+	}
+
+	log.FromContext(ctx).Int("count", len(messages)).Uint64("last_event_log_id", lastID).Debug("siem: event logs exported")
+	return len(eventLogs) == uc.opts.BatchSize && lastID == eventLogs[len(eventLogs)-1].ID, nil
+}
+
+// exportLoginLogs 回傳是否還有下一批 login log
+func (uc *SIEMUsecase) exportLoginLogs(ctx context.Context, checkpoint *domain.SIEMCheckpoint) (bool, error) {
+	loginLogs, err := uc.loginLogRepo.LoginLogsAfter(ctx, checkpoint.LastLoginLogID, uc.opts.BatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	lastID := checkpoint.LastLoginLogID
+	messages := make([][]byte, 0, len(loginLogs))
+	for i := range loginLogs {
+		loginLog := &loginLogs[i]
+		if uc.waitForGap(lastID, loginLog.ID, loginLog.CreatedAt) {
+			break
+		}
+
+		message, err := formatSIEMRecord(uc.opts.Format, uc.opts.Hostname, siemRecordFromLoginLog(loginLog))
+		if err != nil {
+			return false, err
+		}
+		messages = append(messages, message)
+		lastID = loginLog.ID
+	}
+
+	if lastID == checkpoint.LastLoginLogID {
+		return false, nil
+	}
+
+	err = uc.sink.Write(ctx, messages)
+	if err != nil {
+		return false, fmt.Errorf("siem: write login logs after %d failed: %w", checkpoint.LastLoginLogID, err)
+	}
+
+	checkpoint.LastLoginLogID = lastID
+	err = uc.siemRepo.SaveSIEMCheckpoint(ctx, checkpoint)
+	if err != nil {
+		return false, err
+	}
+
+	log.FromContext(ctx).Int("count", len(messages)).Uint64("last_login_log_id", lastID).Debug("siem: login logs exported")
+	return len(loginLogs) == uc.opts.BatchSize && lastID == loginLogs[len(loginLogs)-1].ID, nil
+}
+
+// waitForGap ID 不連續而且還沒超過 GapTimeout 時停在缺口, 避免較晚 commit 的紀錄被 checkpoint 跳過
+// 第一次匯出 (lastID 為 0) 時從最舊的紀錄開始, 不需要等待
+func (uc *SIEMUsecase) waitForGap(lastID uint64, id uint64, createdAt time.Time) bool {
+	return lastID != 0 && id != lastID+1 && uc.now().Sub(createdAt) < uc.opts.GapTimeout
+}
@@ -0,0 +1,573 @@
+package usecase
+
+import (
+	"context"
+	"identity/pkg/domain"
+	identityFile "identity/pkg/identity/repository/file"
+	identityRedis "identity/pkg/identity/repository/redis"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+	redisServer *miniredis.Miniredis
+	tokenRepo   domain.TokenRepository
+	sessionRepo domain.SessionRepository
+	eventLogs   *fakeEventLogRepo
+	usecase     domain.TokenUsecase
+	sessionSvc  domain.SessionUsecase
+	namespace   string
+
+	limitedNamespace  string
+	rejectedNamespace string
+}
+
+func TestTokenTestSuite(t *testing.T) {
+	tokenTestSuite := TokenTestSuite{
+		namespace:         "test.identity",
+		limitedNamespace:  "test.limited",
+		rejectedNamespace: "test.rejected",
+	}
+
+	suite.Run(t, &tokenTestSuite)
+}
+
+func (suite *TokenTestSuite) SetupTest() {
+	suite.redisServer = miniredis.NewMiniRedis()
+	err := suite.redisServer.Start()
+	suite.Require().NoError(err)
+
+	client := redis.NewClient(&redis.Options{
+		Addr: suite.redisServer.Addr(),
+	})
+
+	suite.tokenRepo = identityRedis.NewTokenRepo(client)
+	suite.sessionRepo = identityRedis.NewSessionRepo(client)
+	suite.eventLogs = &fakeEventLogRepo{}
+	suite.usecase = NewTokenUsecase(suite.tokenRepo, suite.sessionRepo, suite.eventLogs, nil, TokenOptions{
+		SessionPolicies: []domain.SessionPolicy{
+			{
+				Namespace:   suite.limitedNamespace,
+				MaxSessions: 3,
+				Devices: []domain.DeviceSessionLimit{
+					{DeviceType: domain.DeviceTypeWeb, MaxSessions: 1},
+				},
+			},
+			{
+				Namespace:   suite.rejectedNamespace,
+				MaxSessions: 1,
+				OnLimit:     domain.SessionLimitReject,
+			},
+		},
+		RefreshTokenPolicy: domain.RefreshTokenPolicy{
+			AbsoluteLifetime: 24 * time.Hour,
+			IdleTimeout:      time.Hour,
+		},
+	})
+	suite.sessionSvc = NewSessionUsecase(suite.sessionRepo)
+}
+
+func (suite *TokenTestSuite) TearDownTest() {
+	suite.redisServer.Close()
+}
+
+func (suite *TokenTestSuite) TestCreateAndRefreshToken() {
+	ctx := context.Background()
+
+	token := domain.Token{
+		AccountID: 1,
+		Namespace: suite.namespace,
+		Username:  "halo",
+		Claims: map[string]string{
+			"role": "admin",
+		},
+	}
+
+	session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token:  token,
+		Prefix: "web_",
+	})
+	suite.Require().NoError(err)
+	accessKey, refreshKey := session.AccessKey, session.RefreshKey
+
+	accessToken, err := suite.usecase.Token(ctx, accessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("halo", accessToken.Username)
+	suite.Assert().Equal("admin", accessToken.Claims["role"])
+	suite.Assert().Equal(refreshKey, accessToken.Claims[domain.PairTokenKey])
+
+	// accessToken 不能當成 refreshToken 使用
+	_, _, err = suite.usecase.RefreshToken(ctx, accessKey)
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	// 呼叫端不能自己帶 TokenType 偽造 refreshToken
+	session, err = suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+			Claims: map[string]string{
+				domain.TokenTypeKey: domain.TokenTypeRefresh,
+				domain.SessionKey:   "other_session",
+				domain.BindHashKey:  "other_hash",
+			},
+		},
+		Prefix: "web_",
+	})
+	suite.Require().NoError(err)
+
+	forged, err := suite.usecase.Token(ctx, session.AccessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(forged.Claims[domain.TokenTypeKey])
+	suite.Assert().Empty(forged.Claims[domain.BindHashKey])
+	suite.Assert().Equal(session.ID, forged.Claims[domain.SessionKey])
+
+	_, _, err = suite.usecase.RefreshToken(ctx, session.AccessKey)
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	forgedKey, err := suite.usecase.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+			Claims:    map[string]string{domain.TokenTypeKey: domain.TokenTypeRefresh},
+		},
+		Prefix: "web_",
+	})
+	suite.Require().NoError(err)
+
+	_, _, err = suite.usecase.RefreshToken(ctx, forgedKey)
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	forgedRefreshKey, err := suite.usecase.CreateRefreshToken(ctx, &domain.Token{
+		AccountID:   1,
+		Namespace:   suite.namespace,
+		TokenString: forgedKey,
+		Claims:      map[string]string{domain.SessionKey: "other_session"},
+	})
+	suite.Require().NoError(err)
+
+	forged, err = suite.usecase.LookupToken(ctx, forgedRefreshKey)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(forged.Claims[domain.SessionKey])
+
+	// refreshToken 也不能當成 accessToken 使用
+	_, err = suite.usecase.Token(ctx, refreshKey)
+	suite.Require().ErrorIs(err, domain.ErrInvalidToken)
+
+	refreshToken, err := suite.usecase.LookupToken(ctx, refreshKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(domain.TokenTypeRefresh, refreshToken.Claims[domain.TokenTypeKey])
+
+	newAccessKey, newRefreshKey, err := suite.usecase.RefreshToken(ctx, refreshKey)
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, accessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	_, _, err = suite.usecase.RefreshToken(ctx, "web_unknown")
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	accessToken, err = suite.usecase.Token(ctx, newAccessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(newRefreshKey, accessToken.Claims[domain.PairTokenKey])
+	suite.Assert().Equal("admin", accessToken.Claims["role"])
+
+	err = suite.usecase.DeleteTokenByAccountID(ctx, 1)
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, newAccessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+}
+
+func (suite *TokenTestSuite) TestRefreshTokenDefaultExpiry() {
+	ctx := context.Background()
+	usecase := NewTokenUsecase(suite.tokenRepo, suite.sessionRepo, suite.eventLogs, nil, TokenOptions{})
+
+	accessToken := domain.Token{AccountID: 1, Namespace: suite.namespace, Username: "halo", ExpiresIn: 900}
+	accessKey, err := suite.tokenRepo.SetToken(ctx, "web_", accessToken, 15*time.Minute)
+	suite.Require().NoError(err)
+	accessToken.TokenString = accessKey
+	refreshKey, err := suite.tokenRepo.CreateRefreshToken(ctx, &accessToken, time.Hour)
+	suite.Require().NoError(err)
+
+	// refreshToken 沒有存活時間時使用預設值, 新的 refreshToken 不能永久有效
+	_, newRefreshKey, err := usecase.RefreshToken(ctx, refreshKey)
+	suite.Require().NoError(err)
+	ttl, err := suite.tokenRepo.TokenTTL(ctx, newRefreshKey)
+	suite.Require().NoError(err)
+	suite.Equal(time.Duration(defaultRefreshTokenExpiresIn)*time.Second, ttl)
+}
+
+func (suite *TokenTestSuite) TestElevateToken() {
+	ctx := context.Background()
+
+	session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+		},
+	})
+	suite.Require().NoError(err)
+	accessKey := session.AccessKey
+
+	token, err := suite.usecase.Token(ctx, accessKey)
+	suite.Require().NoError(err)
+	suite.Require().ErrorIs(domain.RequireStepUp(token, 0), domain.ErrStepUpRequired)
+
+	_, err = suite.usecase.ElevateToken(ctx, domain.ElevateTokenRequest{
+		TokenKey:  accessKey,
+		AccountID: 2,
+		Method:    domain.AMROTP,
+	})
+	suite.Require().ErrorIs(err, domain.ErrInvalidInput)
+
+	elevated, err := suite.usecase.ElevateToken(ctx, domain.ElevateTokenRequest{
+		TokenKey:  accessKey,
+		AccountID: 1,
+		Method:    domain.AMROTP,
+		Duration:  time.Minute,
+	})
+	suite.Require().NoError(err)
+	// opaque 模式下 token key 不變
+	suite.Equal(accessKey, elevated.AccessToken)
+
+	token, err = suite.usecase.Token(ctx, accessKey)
+	suite.Require().NoError(err)
+	suite.Assert().True(token.HasAMR(domain.AMROTP))
+	suite.Assert().NoError(domain.RequireStepUp(token, 5*time.Minute))
+
+	ttl := suite.redisServer.TTL("identity:token:" + accessKey)
+	suite.Assert().True(ttl > time.Hour)
+
+	token.Claims[domain.ClaimElevatedUntil] = "1"
+	suite.Assert().ErrorIs(domain.RequireStepUp(token, 0), domain.ErrStepUpRequired)
+}
+
+func (suite *TokenTestSuite) TestForgedStepUpClaims() {
+	ctx := context.Background()
+
+	// 呼叫端自己帶 amr, elevated_until 與 impersonator_id 時會被移除, 不能跳過 OTP
+	elevatedUntil := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	forged := map[string]string{
+		"role":                       "admin",
+		domain.ClaimAMR:              domain.AMROTP,
+		domain.ClaimAuthTime:         strconv.FormatInt(time.Now().Unix(), 10),
+		domain.ClaimElevatedUntil:    elevatedUntil,
+		domain.ClaimImpersonatorID:   "99",
+		domain.ClaimImpersonatorName: "admin",
+		domain.ClaimActor:            `{"sub":"orders"}`,
+	}
+
+	session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+			Claims:    forged,
+		},
+	})
+	suite.Require().NoError(err)
+
+	token, err := suite.usecase.Token(ctx, session.AccessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("admin", token.Claims["role"])
+	suite.Assert().Empty(token.Claims[domain.ClaimElevatedUntil])
+	suite.Assert().Empty(token.Claims[domain.ClaimImpersonatorID])
+	suite.Assert().Nil(token.Actor())
+	suite.Assert().ErrorIs(domain.RequireStepUp(token, 0), domain.ErrStepUpRequired)
+
+	accessKey, err := suite.usecase.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+			Claims:    forged,
+		},
+	})
+	suite.Require().NoError(err)
+
+	token, err = suite.usecase.Token(ctx, accessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(token.Claims[domain.ClaimElevatedUntil])
+	suite.Assert().ErrorIs(domain.RequireStepUp(token, 0), domain.ErrStepUpRequired)
+
+	// 內部流程透過 ServerClaims 設定
+	accessKey, err = suite.usecase.CreateAccessToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+		},
+		ServerClaims: map[string]string{
+			domain.ClaimAMR:           domain.AMROTP,
+			domain.ClaimElevatedUntil: elevatedUntil,
+		},
+	})
+	suite.Require().NoError(err)
+
+	token, err = suite.usecase.Token(ctx, accessKey)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(elevatedUntil, token.Claims[domain.ClaimElevatedUntil])
+}
+
+func (suite *TokenTestSuite) TestSessions() {
+	ctx := context.Background()
+
+	sessionIDs := []string{}
+	for i := 0; i < 3; i++ {
+		session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+			Token: domain.Token{
+				AccountID: 1,
+				Namespace: suite.namespace,
+			},
+			DeviceType: domain.DeviceTypeWeb,
+			ClientIP:   "127.0.0.1",
+			UserAgent:  "test",
+		})
+		suite.Require().NoError(err)
+		sessionIDs = append(sessionIDs, session.ID)
+	}
+
+	sessions, err := suite.sessionSvc.Sessions(ctx, suite.namespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 3)
+	suite.Assert().Equal("test", sessions[0].UserAgent)
+
+	_, err = suite.sessionSvc.Session(ctx, suite.namespace, 2, sessionIDs[0])
+	suite.Require().ErrorIs(err, domain.ErrNotFound)
+
+	// refresh token 之後 session 要指向新的 token
+	session, err := suite.sessionSvc.Session(ctx, suite.namespace, 1, sessionIDs[0])
+	suite.Require().NoError(err)
+	newAccessKey, newRefreshKey, err := suite.usecase.RefreshToken(ctx, session.RefreshKey)
+	suite.Require().NoError(err)
+
+	session, err = suite.sessionSvc.Session(ctx, suite.namespace, 1, sessionIDs[0])
+	suite.Require().NoError(err)
+	suite.Assert().Equal(newAccessKey, session.AccessKey)
+	suite.Assert().Equal(newRefreshKey, session.RefreshKey)
+
+	err = suite.sessionSvc.RevokeSession(ctx, domain.RevokeSessionRequest{
+		Namespace: suite.namespace,
+		AccountID: 1,
+		SessionID: sessionIDs[0],
+	})
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, newAccessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	err = suite.sessionSvc.RevokeOtherSessions(ctx, domain.RevokeOtherSessionsRequest{
+		Namespace:        suite.namespace,
+		AccountID:        1,
+		CurrentSessionID: sessionIDs[2],
+	})
+	suite.Require().NoError(err)
+
+	sessions, err = suite.sessionSvc.Sessions(ctx, suite.namespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 1)
+	suite.Assert().Equal(sessionIDs[2], sessions[0].ID)
+}
+
+func (suite *TokenTestSuite) TestSessionLimit() {
+	ctx := context.Background()
+
+	createToken := func(namespace string, deviceType domain.DeviceType) (*domain.Session, error) {
+		return suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+			Token: domain.Token{
+				AccountID: 1,
+				Namespace: namespace,
+			},
+			DeviceType: deviceType,
+		})
+	}
+
+	// web 只能有一個 session, 新的登入會踢掉舊的
+	web1, err := createToken(suite.limitedNamespace, domain.DeviceTypeWeb)
+	suite.Require().NoError(err)
+	web2, err := createToken(suite.limitedNamespace, domain.DeviceTypeWeb)
+	suite.Require().NoError(err)
+
+	_, err = suite.usecase.Token(ctx, web1.AccessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+	_, _, err = suite.usecase.RefreshToken(ctx, web1.RefreshKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	// 總數上限是 3, 超過時踢掉最舊的 session
+	ios1, err := createToken(suite.limitedNamespace, domain.DeviceTypeIOS)
+	suite.Require().NoError(err)
+	_, err = createToken(suite.limitedNamespace, domain.DeviceTypeAndroid)
+	suite.Require().NoError(err)
+	_, err = createToken(suite.limitedNamespace, domain.DeviceTypeAndroid)
+	suite.Require().NoError(err)
+
+	sessions, err := suite.sessionSvc.Sessions(ctx, suite.limitedNamespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 3)
+	for _, session := range sessions {
+		suite.Assert().NotEqual(web2.ID, session.ID)
+	}
+
+	_, err = suite.usecase.Token(ctx, ios1.AccessKey)
+	suite.Require().NoError(err)
+
+	// 其他 namespace 不受影響
+	sessions, err = suite.sessionSvc.Sessions(ctx, suite.namespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 0)
+
+	// reject 模式下拒絕新的登入, 並且不會留下 token
+	first, err := createToken(suite.rejectedNamespace, domain.DeviceTypeWeb)
+	suite.Require().NoError(err)
+	_, err = createToken(suite.rejectedNamespace, domain.DeviceTypeWeb)
+	suite.Require().ErrorIs(err, domain.ErrSessionLimitExceeded)
+
+	sessions, err = suite.sessionSvc.Sessions(ctx, suite.rejectedNamespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 1)
+	suite.Assert().Equal(first.ID, sessions[0].ID)
+
+	tokenCount := 0
+	for _, key := range suite.redisServer.Keys() {
+		if strings.HasPrefix(key, "identity:token:") {
+			tokenCount++
+		}
+	}
+	suite.Assert().Equal(8, tokenCount)
+}
+
+func (suite *TokenTestSuite) TestRefreshTokenReuse() {
+	ctx := context.Background()
+
+	session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+		},
+	})
+	suite.Require().NoError(err)
+
+	_, refreshKey, err := suite.usecase.RefreshToken(ctx, session.RefreshKey)
+	suite.Require().NoError(err)
+
+	// 新的 refreshToken 的存活時間是 idle timeout
+	ttl := suite.redisServer.TTL("identity:token:" + refreshKey)
+	suite.Assert().True(ttl > 0 && ttl <= time.Hour)
+
+	accessKey, refreshKey, err := suite.usecase.RefreshToken(ctx, refreshKey)
+	suite.Require().NoError(err)
+
+	// 重複使用已經輪替過的 refreshToken, 整個 family 都會被撤銷
+	_, _, err = suite.usecase.RefreshToken(ctx, session.RefreshKey)
+	suite.Require().ErrorIs(err, domain.ErrRefreshTokenReused)
+
+	_, err = suite.usecase.Token(ctx, accessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+	_, _, err = suite.usecase.RefreshToken(ctx, refreshKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	sessions, err := suite.sessionSvc.Sessions(ctx, suite.namespace, 1)
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 0)
+
+	suite.Require().Len(suite.eventLogs.eventLogs, 1)
+	suite.Assert().Equal("refresh_token_reused", suite.eventLogs.eventLogs[0].Action)
+	suite.Assert().Equal(session.ID, suite.eventLogs.eventLogs[0].TargetID)
+}
+
+func (suite *TokenTestSuite) TestRefreshTokenFamilyExpired() {
+	ctx := context.Background()
+
+	session, err := suite.usecase.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID:        1,
+			Namespace:        suite.namespace,
+			RefreshExpiresIn: int64(48 * time.Hour / time.Second),
+		},
+	})
+	suite.Require().NoError(err)
+
+	// 模擬 session 已經建立超過 absolute lifetime
+	suite.redisServer.HSet("identity:session:"+session.ID, "created_at", strconv.FormatInt(time.Now().Add(-25*time.Hour).Unix(), 10))
+
+	_, _, err = suite.usecase.RefreshToken(ctx, session.RefreshKey)
+	suite.Require().ErrorIs(err, domain.ErrRefreshTokenExpired)
+
+	_, err = suite.usecase.Token(ctx, session.AccessKey)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+}
+
+func (suite *TokenTestSuite) TestJWTAccessToken() {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "identity-keystore")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	keyRepo, err := identityFile.NewKeyRepo(dir)
+	suite.Require().NoError(err)
+	keySvc, err := NewKeyUsecase(keyRepo, KeyOptions{Algorithm: "ES256"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(keySvc.RotateKeys(ctx))
+
+	tokenSvc := NewTokenUsecase(suite.tokenRepo, suite.sessionRepo, suite.eventLogs, nil, TokenOptions{
+		AccessTokenFormat: domain.AccessTokenFormatJWT,
+		KeySvc:            keySvc,
+	})
+
+	session, err := tokenSvc.CreateToken(ctx, domain.CreateTokenRequest{
+		Token: domain.Token{
+			AccountID: 1,
+			Namespace: suite.namespace,
+			Claims: map[string]string{
+				"role": "admin",
+			},
+		},
+	})
+	suite.Require().NoError(err)
+	suite.Require().True(isJWT(session.AccessToken))
+
+	// 其他 service 可以離線驗證
+	claims, err := keySvc.ParseToken(ctx, session.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("admin", claims.Claims["role"])
+	suite.Assert().Equal(session.ID, claims.Claims[domain.SessionKey])
+
+	token, err := tokenSvc.Token(ctx, session.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(session.AccessKey, token.TokenString)
+
+	// step-up 之後重新簽發的 JWT 帶有 elevated claims, 離線驗證也看得到
+	elevated, err := tokenSvc.ElevateToken(ctx, domain.ElevateTokenRequest{
+		TokenKey:  session.AccessToken,
+		AccountID: 1,
+		Method:    domain.AMROTP,
+	})
+	suite.Require().NoError(err)
+	suite.Require().True(isJWT(elevated.AccessToken))
+	suite.Assert().NotEqual(session.AccessToken, elevated.AccessToken)
+
+	claims, err = keySvc.ParseToken(ctx, elevated.AccessToken)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(session.AccessKey, claims.TokenString)
+	suite.Assert().True(claims.HasAMR(domain.AMROTP))
+	suite.Assert().NoError(domain.RequireStepUp(claims, time.Minute))
+	suite.Assert().Equal(claims.Claims[domain.ClaimElevatedUntil], elevated.Claims[domain.ClaimElevatedUntil])
+
+	accessToken, _, err := tokenSvc.RefreshToken(ctx, session.RefreshKey)
+	suite.Require().NoError(err)
+	suite.Require().True(isJWT(accessToken))
+
+	// 舊的 accessToken 已經被撤銷, 即使 JWT 簽章還有效也查不到
+	_, err = tokenSvc.Token(ctx, session.AccessToken)
+	suite.Require().ErrorIs(err, domain.ErrKeyNotFound)
+
+	_, err = tokenSvc.Token(ctx, accessToken)
+	suite.Require().NoError(err)
+}
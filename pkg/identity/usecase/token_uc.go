@@ -0,0 +1,567 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nite-coder/blackbear/pkg/log"
+	"github.com/oschwald/geoip2-golang"
+	"gorm.io/datatypes"
+)
+
+const (
+	defaultAccessTokenExpiresIn  = int64(2 * time.Hour / time.Second)
+	defaultRefreshTokenExpiresIn = int64(7 * 24 * time.Hour / time.Second)
+	defaultElevatedDuration      = 5 * time.Minute
+
+	// sessionTouchInterval 避免每次查詢 token 都更新 session 的 last_seen_at
+	sessionTouchInterval = time.Minute
+)
+
+// TokenOptions 是 token 與 session 相關的設定
+type TokenOptions struct {
+	SessionPolicies    []domain.SessionPolicy
+	RefreshTokenPolicy domain.RefreshTokenPolicy
+	// AccessTokenFormat 為 domain.AccessTokenFormatJWT 時 accessToken 會用 KeySvc 簽成 JWT
+	AccessTokenFormat string
+	KeySvc            domain.KeyUsecase
+	// APIKeySvc 有設定時, Token 也可以用 API key 查詢
+	APIKeySvc domain.APIKeyUsecase
+}
+
+type TokenUsecase struct {
+	tokenRepo          domain.TokenRepository
+	sessionRepo        domain.SessionRepository
+	eventLogRepo       domain.EventLogRepository
+	ipDB               *geoip2.Reader
+	sessionPolicies    map[string]domain.SessionPolicy
+	refreshTokenPolicy domain.RefreshTokenPolicy
+	keySvc             domain.KeyUsecase
+	apiKeySvc          domain.APIKeyUsecase
+}
+
+func NewTokenUsecase(tokenRepo domain.TokenRepository, sessionRepo domain.SessionRepository, eventLogRepo domain.EventLogRepository, ipDB *geoip2.Reader, options TokenOptions) *TokenUsecase {
+	policies := make(map[string]domain.SessionPolicy, len(options.SessionPolicies))
+	for _, policy := range options.SessionPolicies {
+		policies[policy.Namespace] = policy
+	}
+
+	uc := &TokenUsecase{
+		tokenRepo:          tokenRepo,
+		sessionRepo:        sessionRepo,
+		eventLogRepo:       eventLogRepo,
+		ipDB:               ipDB,
+		sessionPolicies:    policies,
+		refreshTokenPolicy: options.RefreshTokenPolicy,
+		apiKeySvc:          options.APIKeySvc,
+	}
+
+	if options.AccessTokenFormat == domain.AccessTokenFormatJWT {
+		uc.keySvc = options.KeySvc
+	}
+
+	return uc
+}
+
+// CreateToken 建立一組 accessToken 與 refreshToken, 並記錄成一個 session
+func (uc *TokenUsecase) CreateToken(ctx context.Context, request domain.CreateTokenRequest) (*domain.Session, error) {
+	logger := log.FromContext(ctx)
+
+	accessToken := request.Token
+	if accessToken.ExpiresIn <= 0 {
+		accessToken.ExpiresIn = defaultAccessTokenExpiresIn
+	}
+
+	if accessToken.RefreshExpiresIn <= 0 {
+		accessToken.RefreshExpiresIn = defaultRefreshTokenExpiresIn
+	}
+
+	accessToken.Claims = callerClaims(accessToken.Claims)
+	for k, v := range request.ServerClaims {
+		accessToken.Claims[k] = v
+	}
+
+	session := domain.Session{
+		ID:         uuid.NewString(),
+		Namespace:  accessToken.Namespace,
+		AccountID:  accessToken.AccountID,
+		DeviceType: request.DeviceType,
+		ClientIP:   request.ClientIP,
+		UserAgent:  request.UserAgent,
+	}
+	accessToken.Claims[domain.SessionKey] = session.ID
+
+	countryCode, cityName, err := lookupLocation(uc.ipDB, request.ClientIP)
+	if err != nil {
+		logger.Err(err).Str("client_ip", request.ClientIP).Warn("usecase: lookup session location failed")
+	}
+	session.CountryCode = countryCode
+	session.CityName = cityName
+
+	accessKey, err := uc.tokenRepo.SetToken(ctx, request.Prefix, accessToken, time.Duration(accessToken.ExpiresIn)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	accessToken.TokenString = accessKey
+
+	refreshKey, err := uc.tokenRepo.CreateRefreshToken(ctx, &accessToken, time.Duration(accessToken.RefreshExpiresIn)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken.Claims[domain.PairTokenKey] = refreshKey
+	err = uc.tokenRepo.UpdateToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session.AccessKey = accessKey
+	session.RefreshKey = refreshKey
+	session.AccessToken, err = uc.issueAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	limit := uc.sessionPolicies[session.Namespace].Limit(session.DeviceType)
+	evicted, err := uc.sessionRepo.CreateSession(ctx, &session, time.Duration(accessToken.RefreshExpiresIn)*time.Second, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(evicted) > 0 {
+		logger.Int64("account_id", session.AccountID).Strs("evicted_sessions", evicted).Info("usecase: sessions were evicted by session limit")
+	}
+
+	return &session, nil
+}
+
+func (uc *TokenUsecase) CreateAccessToken(ctx context.Context, request domain.CreateTokenRequest) (string, error) {
+	accessToken := request.Token
+	if accessToken.ExpiresIn <= 0 {
+		accessToken.ExpiresIn = defaultAccessTokenExpiresIn
+	}
+	accessToken.Claims = callerClaims(accessToken.Claims)
+	for k, v := range request.ServerClaims {
+		accessToken.Claims[k] = v
+	}
+
+	accessKey, err := uc.tokenRepo.SetToken(ctx, request.Prefix, accessToken, time.Duration(accessToken.ExpiresIn)*time.Second)
+	if err != nil {
+		return "", err
+	}
+	accessToken.TokenString = accessKey
+
+	return uc.issueAccessToken(ctx, accessToken)
+}
+
+// Token 回傳 accessToken 的內容, refreshToken 不能當作 bearer token 使用, 會回傳 ErrInvalidToken
+func (uc *TokenUsecase) Token(ctx context.Context, tokenKey string) (*domain.Token, error) {
+	token, err := uc.LookupToken(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Claims[domain.TokenTypeKey] == domain.TokenTypeRefresh {
+		return nil, fmt.Errorf("usecase: refresh token can not be used as access token. %w", domain.ErrInvalidToken)
+	}
+
+	return token, nil
+}
+
+func (uc *TokenUsecase) LookupToken(ctx context.Context, tokenKey string) (*domain.Token, error) {
+	logger := log.FromContext(ctx)
+
+	if uc.apiKeySvc != nil && domain.IsAPIKey(tokenKey) {
+		return uc.apiKeySvc.ResolveAPIKey(ctx, tokenKey)
+	}
+
+	tokenKey, err := uc.resolveTokenKey(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := uc.tokenRepo.GetAuthToken(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := token.Claims[domain.SessionKey]
+	if sessionID != "" {
+		session, err := uc.sessionRepo.Session(ctx, sessionID)
+		if err == nil && time.Since(session.LastSeenAt) > sessionTouchInterval {
+			err = uc.sessionRepo.TouchSession(ctx, sessionID, time.Now())
+		}
+		if err != nil {
+			logger.Err(err).Str("session_id", sessionID).Warn("usecase: touch session failed")
+		}
+	}
+
+	return token, nil
+}
+
+// RefreshToken 輪替 refreshToken, 已經輪替過的 refreshToken 再次被使用時會撤銷整個 token family
+func (uc *TokenUsecase) RefreshToken(ctx context.Context, tokenKey string) (string, string, error) {
+	return uc.RefreshTokenWithClaims(ctx, tokenKey, nil)
+}
+
+func (uc *TokenUsecase) RefreshTokenWithClaims(ctx context.Context, tokenKey string, claims map[string]string) (string, string, error) {
+	refreshToken, err := uc.tokenRepo.GetToken(ctx, tokenKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return "", "", uc.revokeReusedTokenFamily(ctx, tokenKey, err)
+		}
+		return "", "", err
+	}
+
+	// 只有 refreshToken 可以換發新的 token, 不能拿 accessToken 延長存活時間
+	if refreshToken.Claims[domain.TokenTypeKey] != domain.TokenTypeRefresh {
+		return "", "", domain.ErrInvalidToken
+	}
+
+	d := uc.refreshTokenPolicy.IdleTimeout
+	if d <= 0 {
+		d = time.Duration(refreshToken.RefreshExpiresIn) * time.Second
+	}
+	if d <= 0 {
+		d = time.Duration(defaultRefreshTokenExpiresIn) * time.Second
+	}
+
+	sessionID := refreshToken.Claims[domain.SessionKey]
+	if sessionID != "" {
+		session, err := uc.sessionRepo.Session(ctx, sessionID)
+		if err != nil {
+			return "", "", err
+		}
+
+		if uc.refreshTokenPolicy.AbsoluteLifetime > 0 {
+			remaining := time.Until(session.CreatedAt.Add(uc.refreshTokenPolicy.AbsoluteLifetime))
+			if remaining <= 0 {
+				err = uc.sessionRepo.DeleteSession(ctx, sessionID)
+				if err != nil && !errors.Is(err, domain.ErrNotFound) {
+					return "", "", err
+				}
+				return "", "", domain.ErrRefreshTokenExpired
+			}
+
+			if remaining < d {
+				d = remaining
+			}
+		}
+	}
+
+	accessKey, refreshKey, err := uc.tokenRepo.RefreshToken(ctx, tokenKey, d)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) || errors.Is(err, domain.ErrRefreshTokenReused) {
+			return "", "", uc.revokeReusedTokenFamily(ctx, tokenKey, err)
+		}
+		return "", "", err
+	}
+
+	if sessionID != "" {
+		err = uc.sessionRepo.UpdateSessionTokens(ctx, sessionID, accessKey, refreshKey, d)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if len(claims) == 0 && uc.keySvc == nil {
+		return accessKey, refreshKey, nil
+	}
+
+	accessToken, err := uc.tokenRepo.GetToken(ctx, accessKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(claims) > 0 {
+		accessToken.Claims = copyClaims(accessToken.Claims)
+		for k, v := range claims {
+			accessToken.Claims[k] = v
+		}
+
+		err = uc.tokenRepo.UpdateToken(ctx, accessToken)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	accessKey, err = uc.issueAccessToken(ctx, accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessKey, refreshKey, nil
+}
+
+// revokeReusedTokenFamily refreshToken 不存在時, 檢查是否為已經輪替過的 refreshToken.
+// 是的話代表 refreshToken 可能已經外洩, 撤銷整個 token family 並記錄安全事件, 否則回傳原本的錯誤
+func (uc *TokenUsecase) revokeReusedTokenFamily(ctx context.Context, tokenKey string, cause error) error {
+	logger := log.FromContext(ctx)
+
+	family, err := uc.tokenRepo.RotatedTokenFamily(ctx, tokenKey)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyNotFound) {
+			return cause
+		}
+		return err
+	}
+
+	status := map[string]interface{}{
+		"session_id": family,
+	}
+
+	if family != "" {
+		session, err := uc.sessionRepo.Session(ctx, family)
+		if err == nil {
+			status["namespace"] = session.Namespace
+			status["account_id"] = session.AccountID
+			status["client_ip"] = session.ClientIP
+
+			err = uc.sessionRepo.DeleteSession(ctx, family)
+		}
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+	}
+
+	newStatus, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	err = createAuditLog(ctx, uc.eventLogRepo, &domain.EventLog{
+		Namespace: "identity.token",
+		Action:    "refresh_token_reused",
+		TargetID:  family,
+		Message:   "rotated refresh token was used again, token family is revoked",
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     domain.EventLogSuccess,
+		Actor:     "system",
+	})
+	if err != nil {
+		logger.Err(err).Str("session_id", family).Error("usecase: create refresh token reused event log failed")
+	}
+
+	logger.Str("session_id", family).Warn("usecase: refresh token reuse detected, token family is revoked")
+	return fmt.Errorf("token family %s is revoked. %w", family, domain.ErrRefreshTokenReused)
+}
+
+func (uc *TokenUsecase) BindHashToken(ctx context.Context, hashKey, accessTokenKey string) error {
+	accessTokenKey, err := uc.resolveTokenKey(ctx, accessTokenKey)
+	if err != nil {
+		return err
+	}
+	return uc.tokenRepo.BindHashToken(ctx, hashKey, accessTokenKey)
+}
+
+func (uc *TokenUsecase) DeleteHash(ctx context.Context, hashKey string) error {
+	return uc.tokenRepo.DeleteHash(ctx, hashKey)
+}
+
+func (uc *TokenUsecase) DeleteTokenByAccountID(ctx context.Context, accountID int64, prefixTokens ...string) error {
+	return uc.tokenRepo.DeleteTokenByAccountID(ctx, accountID, prefixTokens...)
+}
+
+// RenewToken 延長 token 的存活時間, duration 的單位是秒
+func (uc *TokenUsecase) RenewToken(ctx context.Context, tokenKey string, duration int64) error {
+	if duration <= 0 {
+		return fmt.Errorf("duration must be greater than zero. %w", domain.ErrInvalidInput)
+	}
+
+	tokenKey, err := uc.resolveTokenKey(ctx, tokenKey)
+	if err != nil {
+		return err
+	}
+	return uc.tokenRepo.RenewToken(ctx, tokenKey, time.Duration(duration)*time.Second)
+}
+
+func (uc *TokenUsecase) CreateRefreshToken(ctx context.Context, token *domain.Token) (string, error) {
+	if token.RefreshExpiresIn <= 0 {
+		token.RefreshExpiresIn = defaultRefreshTokenExpiresIn
+	}
+	token.Claims = callerClaims(token.Claims)
+	return uc.tokenRepo.CreateRefreshToken(ctx, token, time.Duration(token.RefreshExpiresIn)*time.Second)
+}
+
+// ElevateToken 在第二因子驗證通過後標記 token 的 amr, auth_time 與 elevated_until
+func (uc *TokenUsecase) ElevateToken(ctx context.Context, request domain.ElevateTokenRequest) (*domain.ElevatedToken, error) {
+	if request.TokenKey == "" || request.Method == "" {
+		return nil, domain.ErrInvalidInput
+	}
+
+	tokenKey, err := uc.resolveTokenKey(ctx, request.TokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := uc.tokenRepo.GetAuthToken(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccountID != request.AccountID {
+		return nil, fmt.Errorf("token does not belong to the account. %w", domain.ErrInvalidInput)
+	}
+
+	err = domain.RequireNotImpersonated(token)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := request.Duration
+	if duration <= 0 {
+		duration = defaultElevatedDuration
+	}
+
+	if token.Claims == nil {
+		token.Claims = map[string]string{}
+	}
+
+	if !token.HasAMR(request.Method) {
+		token.Claims[domain.ClaimAMR] = strings.TrimSpace(token.Claims[domain.ClaimAMR] + " " + request.Method)
+	}
+
+	now := time.Now().UTC()
+	token.Claims[domain.ClaimAuthTime] = strconv.FormatInt(now.Unix(), 10)
+	token.Claims[domain.ClaimElevatedUntil] = strconv.FormatInt(now.Add(duration).Unix(), 10)
+
+	err = uc.tokenRepo.UpdateToken(ctx, *token)
+	if err != nil {
+		return nil, err
+	}
+
+	// JWT 的 exp 不能超過 redis 裡 token 剩下的存活時間
+	ttl, err := uc.tokenRepo.TokenTTL(ctx, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		token.ExpiresIn = int64((ttl + time.Second - 1) / time.Second)
+	}
+	token.TokenString = tokenKey
+
+	accessToken, err := uc.issueAccessToken(ctx, *token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.ElevatedToken{
+		Token:       token,
+		AccessToken: accessToken,
+	}, nil
+}
+
+func (uc *TokenUsecase) TokenExpiresAt(ctx context.Context, tokenKey string) (time.Time, error) {
+	tokenKey, err := uc.resolveTokenKey(ctx, tokenKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ttl, err := uc.tokenRepo.TokenTTL(ctx, tokenKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if ttl == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Now().Add(ttl), nil
+}
+
+func (uc *TokenUsecase) RevokeToken(ctx context.Context, tokenKey string) error {
+	tokenKey, err := uc.resolveTokenKey(ctx, tokenKey)
+	if err != nil {
+		return err
+	}
+
+	token, err := uc.tokenRepo.GetToken(ctx, tokenKey)
+	if err != nil {
+		return err
+	}
+
+	sessionID := token.Claims[domain.SessionKey]
+	if sessionID != "" {
+		err = uc.sessionRepo.DeleteSession(ctx, sessionID)
+		if err == nil || !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+	}
+
+	pairKey := token.Claims[domain.PairTokenKey]
+	if pairKey != "" {
+		err = uc.tokenRepo.DeleteToken(ctx, pairKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return uc.tokenRepo.DeleteToken(ctx, tokenKey)
+}
+
+// issueAccessToken 回傳要給 client 的 accessToken, JWT 模式下會把 token 簽成 JWT
+func (uc *TokenUsecase) issueAccessToken(ctx context.Context, token domain.Token) (string, error) {
+	if uc.keySvc == nil {
+		return token.TokenString, nil
+	}
+	return uc.keySvc.SignToken(ctx, token)
+}
+
+// resolveTokenKey JWT 模式下 client 帶來的可能是 JWT, 驗證簽章之後取回 token 在 redis 中的 key (jti)
+func (uc *TokenUsecase) resolveTokenKey(ctx context.Context, tokenKey string) (string, error) {
+	if uc.keySvc == nil || !isJWT(tokenKey) {
+		return tokenKey, nil
+	}
+
+	token, err := uc.keySvc.ParseToken(ctx, tokenKey)
+	if err != nil {
+		return "", err
+	}
+
+	return token.TokenString, nil
+}
+
+// isJWT JWT 由三段 base64url 組成, 並且 header 一定是 `{"` 開頭
+func isJWT(tokenKey string) bool {
+	return strings.Count(tokenKey, ".") == 2 && strings.HasPrefix(tokenKey, "eyJ")
+}
+
+// serverOwnedClaims 由 identity 自己維護的 claims, 呼叫端帶入的值會被移除,
+// 否則可以偽造 refreshToken, 掛到別人的 session 上, 或是不經過 OTP 就通過 RequireStepUp;
+// 需要這些 claims 的內部流程透過 CreateTokenRequest.ServerClaims 設定
+var serverOwnedClaims = []string{
+	domain.TokenTypeKey,
+	domain.SessionKey,
+	domain.PairTokenKey,
+	domain.BindHashKey,
+	domain.ClaimAMR,
+	domain.ClaimAuthTime,
+	domain.ClaimElevatedUntil,
+	domain.ClaimImpersonatorID,
+	domain.ClaimImpersonatorName,
+	domain.ClaimActor,
+}
+
+// callerClaims 複製呼叫端帶入的 claims, 並移除 serverOwnedClaims
+func callerClaims(claims map[string]string) map[string]string {
+	result := copyClaims(claims)
+	for _, key := range serverOwnedClaims {
+		delete(result, key)
+	}
+	return result
+}
+
+func copyClaims(claims map[string]string) map[string]string {
+	result := make(map[string]string, len(claims))
+	for k, v := range claims {
+		result[k] = v
+	}
+	return result
+}
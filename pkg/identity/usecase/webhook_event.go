@@ -0,0 +1,273 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"identity/pkg/domain"
+	"strconv"
+)
+
+// WebhookEventLogRepo 寫入 event log 之後, 在同一個 transaction 把對應的事件發佈到 webhook
+type WebhookEventLogRepo struct {
+	domain.EventLogRepository
+	webhookSvc domain.WebhookUsecase
+}
+
+func NewWebhookEventLogRepo(eventLogRepo domain.EventLogRepository, webhookSvc domain.WebhookUsecase) *WebhookEventLogRepo {
+	return &WebhookEventLogRepo{
+		EventLogRepository: eventLogRepo,
+		webhookSvc:         webhookSvc,
+	}
+}
+
+func (repo *WebhookEventLogRepo) CreateEventLog(ctx context.Context, eventLog *domain.EventLog) error {
+	err := repo.EventLogRepository.CreateEventLog(ctx, eventLog)
+	if err != nil {
+		return err
+	}
+
+	events := domainEventsFromEventLog(eventLog)
+	for i := range events {
+		err = repo.webhookSvc.Publish(ctx, &events[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WebhookLoginLogRepo 寫入 login log 之後發佈 login.succeeded 或 login.failed
+type WebhookLoginLogRepo struct {
+	domain.LoginLogRepository
+	webhookSvc domain.WebhookUsecase
+}
+
+func NewWebhookLoginLogRepo(loginLogRepo domain.LoginLogRepository, webhookSvc domain.WebhookUsecase) *WebhookLoginLogRepo {
+	return &WebhookLoginLogRepo{
+		LoginLogRepository: loginLogRepo,
+		webhookSvc:         webhookSvc,
+	}
+}
+
+func (repo *WebhookLoginLogRepo) CreateLoginLog(ctx context.Context, loginLog *domain.LoginLog) error {
+	err := repo.LoginLogRepository.CreateLoginLog(ctx, loginLog)
+	if err != nil {
+		return err
+	}
+
+	eventType := domain.WebhookEventLoginSucceeded
+	if loginLog.State != domain.LoginLogSuccess {
+		eventType = domain.WebhookEventLoginFailed
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account_id":   loginLog.TargetID,
+		"country_code": loginLog.CountryCode,
+		"city_name":    loginLog.CityName,
+		"device_type":  loginLog.DeviceType,
+		"client_ip":    loginLog.ClientIP,
+	})
+	if err != nil {
+		return err
+	}
+
+	return repo.webhookSvc.Publish(ctx, &domain.WebhookEvent{
+		Type:       eventType,
+		Namespace:  loginLog.Namespace,
+		TargetID:   loginLog.TargetID,
+		Data:       data,
+		OccurredAt: loginLog.CreatedAt,
+	})
+}
+
+// domainEventsFromEventLog 把 event log 轉成領域事件, webhook 與 outbox 共用, 失敗的操作與沒有對應事件類型的 event log 不會發佈
+// 帳號的 event log 包含密碼與 OTP secret, 所以只發佈 webhookAccount 裡面的欄位
+func domainEventsFromEventLog(eventLog *domain.EventLog) []domain.WebhookEvent {
+	if eventLog.State != domain.EventLogSuccess {
+		return nil
+	}
+
+	newEvent := func(eventType string, namespace string, targetID string, data interface{}) domain.WebhookEvent {
+		raw, _ := json.Marshal(data)
+		return domain.WebhookEvent{
+			Type:       eventType,
+			Namespace:  namespace,
+			TargetID:   targetID,
+			Actor:      eventLog.Actor,
+			Data:       raw,
+			OccurredAt: eventLog.CreatedAt,
+		}
+	}
+
+	// 成員的異動拆成每個帳號一個事件, TargetID 為帳號 ID
+	assignment := func(eventType string, role *domain.Role, accountID uint64) domain.WebhookEvent {
+		return newEvent(eventType, role.Namespace, strconv.FormatUint(accountID, 10), map[string]interface{}{
+			"role_id":    role.ID,
+			"role_name":  role.Name,
+			"account_id": accountID,
+		})
+	}
+
+	switch eventLog.Namespace {
+	case "identity.account":
+		if eventLog.Action == "add_roles" {
+			var oldStatus, newStatus accountRolesStatus
+			_ = json.Unmarshal(eventLog.OldStatus, &oldStatus)
+			if json.Unmarshal(eventLog.NewStatus, &newStatus) != nil || newStatus.Namespace == "" {
+				return nil
+			}
+
+			events := []domain.WebhookEvent{}
+			for i := range newStatus.Roles {
+				if !containsUint64(oldStatus.RoleIDs, newStatus.Roles[i].ID) {
+					events = append(events, assignment(domain.WebhookEventRoleAssigned, &newStatus.Roles[i], newStatus.AccountID))
+				}
+			}
+			for i := range oldStatus.Roles {
+				if !containsUint64(newStatus.RoleIDs, oldStatus.Roles[i].ID) {
+					events = append(events, assignment(domain.WebhookEventRoleUnassigned, &oldStatus.Roles[i], newStatus.AccountID))
+				}
+			}
+			return events
+		}
+
+		account := domain.Account{}
+		if json.Unmarshal(eventLog.NewStatus, &account) != nil || account.Namespace == "" {
+			return nil
+		}
+
+		eventType := ""
+		switch eventLog.Action {
+		case "create":
+			eventType = domain.WebhookEventAccountCreated
+		case "update":
+			eventType = domain.WebhookEventAccountUpdated
+		case "change_state":
+			switch account.State {
+			case domain.AccountStatusLocked:
+				eventType = domain.WebhookEventAccountLocked
+			case domain.AccountStatusDisabled:
+				eventType = domain.WebhookEventAccountDisabled
+			case domain.AccountStatusNormal:
+				eventType = domain.WebhookEventAccountActivated
+			}
+		}
+		if eventType == "" {
+			return nil
+		}
+
+		return []domain.WebhookEvent{newEvent(eventType, account.Namespace, eventLog.TargetID, toWebhookAccount(&account))}
+	case "identity.role":
+		var oldStatus, newStatus roleStatus
+		_ = json.Unmarshal(eventLog.OldStatus, &oldStatus)
+		if json.Unmarshal(eventLog.NewStatus, &newStatus) != nil || newStatus.Role.Namespace == "" {
+			return nil
+		}
+
+		role := newStatus.Role
+		events := []domain.WebhookEvent{}
+		switch eventLog.Action {
+		case "create":
+			events = append(events, newEvent(domain.WebhookEventRoleCreated, role.Namespace, eventLog.TargetID, toWebhookRole(&role)))
+		case "update":
+			events = append(events, newEvent(domain.WebhookEventRoleUpdated, role.Namespace, eventLog.TargetID, toWebhookRole(&role)))
+		case "delete":
+			events = append(events, newEvent(domain.WebhookEventRoleDeleted, role.Namespace, eventLog.TargetID, toWebhookRole(&role)))
+		case "update_members":
+		default:
+			return nil
+		}
+
+		assign := func(eventType string, accountIDs []uint64, except []uint64) {
+			for _, accountID := range accountIDs {
+				if !containsUint64(except, accountID) {
+					events = append(events, assignment(eventType, &role, accountID))
+				}
+			}
+		}
+		assign(domain.WebhookEventRoleAssigned, newStatus.MemberIDs, oldStatus.MemberIDs)
+		assign(domain.WebhookEventRoleUnassigned, oldStatus.MemberIDs, newStatus.MemberIDs)
+		return events
+	case "identity.api_key", "identity.token":
+		status := map[string]interface{}{}
+		if json.Unmarshal(eventLog.NewStatus, &status) != nil {
+			return nil
+		}
+
+		namespace, _ := status["namespace"].(string)
+		if namespace == "" {
+			return nil
+		}
+
+		eventType := ""
+		switch eventLog.Namespace + "/" + eventLog.Action {
+		case "identity.api_key/create":
+			eventType = domain.WebhookEventAPIKeyCreated
+		case "identity.api_key/revoke":
+			eventType = domain.WebhookEventAPIKeyRevoked
+		case "identity.token/refresh_token_reused":
+			eventType = domain.WebhookEventTokenReused
+		default:
+			return nil
+		}
+
+		return []domain.WebhookEvent{newEvent(eventType, namespace, eventLog.TargetID, status)}
+	}
+
+	return nil
+}
+
+// webhookAccount 是發佈到 webhook 的帳號欄位
+type webhookAccount struct {
+	ID          uint64 `json:"id"`
+	UUID        string `json:"uuid"`
+	Namespace   string `json:"namespace"`
+	Type        int32  `json:"type"`
+	Username    string `json:"username,omitempty"`
+	Email       string `json:"email,omitempty"`
+	FirstName   string `json:"first_name,omitempty"`
+	LastName    string `json:"last_name,omitempty"`
+	ExternalID  string `json:"external_id,omitempty"`
+	State       string `json:"state"`
+	IsAdmin     bool   `json:"is_admin"`
+	Version     uint32 `json:"version"`
+	UpdaterName string `json:"updater_name,omitempty"`
+}
+
+func toWebhookAccount(account *domain.Account) webhookAccount {
+	return webhookAccount{
+		ID:          account.ID,
+		UUID:        account.UUID,
+		Namespace:   account.Namespace,
+		Type:        int32(account.Type),
+		Username:    account.Username.String,
+		Email:       account.Email.String,
+		FirstName:   account.FirstName,
+		LastName:    account.LastName,
+		ExternalID:  account.ExternalID,
+		State:       account.State.String(),
+		IsAdmin:     account.IsAdmin == 1,
+		Version:     account.Version,
+		UpdaterName: account.UpdaterName,
+	}
+}
+
+func toWebhookRole(role *domain.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        role.ID,
+		"namespace": role.Namespace,
+		"name":      role.Name,
+		"desc":      role.Desc,
+		"state":     role.State,
+	}
+}
+
+func containsUint64(values []uint64, val uint64) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
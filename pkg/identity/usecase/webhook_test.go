@@ -0,0 +1,357 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"identity/pkg/domain"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gorm.io/datatypes"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+	server    *httptest.Server
+	repo      *fakeWebhookRepo
+	usecase   *WebhookUsecase
+	namespace string
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, &WebhookTestSuite{namespace: "test.identity"})
+}
+
+func (suite *WebhookTestSuite) SetupTest() {
+	suite.status = http.StatusOK
+	suite.requests = nil
+	suite.bodies = nil
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.requests = append(suite.requests, r)
+		suite.bodies = append(suite.bodies, body)
+		w.WriteHeader(suite.status)
+	}))
+
+	suite.repo = &fakeWebhookRepo{}
+	suite.usecase = NewWebhookUsecase(suite.repo, &fakeEventLogRepo{}, WebhookOptions{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Timeout:        time.Second,
+	})
+}
+
+func (suite *WebhookTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *WebhookTestSuite) setStatus(status int) {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	suite.status = status
+}
+
+func (suite *WebhookTestSuite) createWebhook(events ...string) *domain.Webhook {
+	webhook, err := suite.usecase.CreateWebhook(context.Background(), domain.CreateWebhookRequest{
+		Namespace:   suite.namespace,
+		URL:         suite.server.URL,
+		Events:      events,
+		CreatorName: "admin",
+	})
+	suite.Require().NoError(err)
+	return webhook
+}
+
+type fakeWebhookRepo struct {
+	webhooks   []*domain.Webhook
+	deliveries []*domain.WebhookDelivery
+}
+
+func (repo *fakeWebhookRepo) CreateWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	webhook.ID = uint64(len(repo.webhooks) + 1)
+	created := *webhook
+	repo.webhooks = append(repo.webhooks, &created)
+	return nil
+}
+
+func (repo *fakeWebhookRepo) Webhook(ctx context.Context, namespace string, webhookID uint64) (*domain.Webhook, error) {
+	for _, webhook := range repo.webhooks {
+		if webhook.Namespace == namespace && webhook.ID == webhookID {
+			result := *webhook
+			return &result, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeWebhookRepo) Webhooks(ctx context.Context, namespace string) ([]domain.Webhook, error) {
+	result := []domain.Webhook{}
+	for _, webhook := range repo.webhooks {
+		if webhook.Namespace == namespace {
+			result = append(result, *webhook)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeWebhookRepo) DeleteWebhook(ctx context.Context, namespace string, webhookID uint64) error {
+	for i, webhook := range repo.webhooks {
+		if webhook.Namespace == namespace && webhook.ID == webhookID {
+			repo.webhooks = append(repo.webhooks[:i], repo.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+func (repo *fakeWebhookRepo) CreateWebhookDeliveries(ctx context.Context, deliveries []*domain.WebhookDelivery) error {
+	for _, delivery := range deliveries {
+		delivery.ID = uint64(len(repo.deliveries) + 1)
+		created := *delivery
+		repo.deliveries = append(repo.deliveries, &created)
+	}
+	return nil
+}
+
+func (repo *fakeWebhookRepo) WebhookDelivery(ctx context.Context, namespace string, deliveryID uint64) (*domain.WebhookDelivery, error) {
+	for _, delivery := range repo.deliveries {
+		if delivery.Namespace == namespace && delivery.ID == deliveryID {
+			result := *delivery
+			return &result, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (repo *fakeWebhookRepo) WebhookDeliveries(ctx context.Context, opts domain.FindWebhookDeliveryOptions) ([]domain.WebhookDelivery, error) {
+	result := []domain.WebhookDelivery{}
+	for _, delivery := range repo.deliveries {
+		if delivery.Namespace != opts.Namespace || (opts.State > 0 && delivery.State != opts.State) {
+			continue
+		}
+		if opts.WebhookID > 0 && delivery.WebhookID != opts.WebhookID {
+			continue
+		}
+		result = append(result, *delivery)
+	}
+	return result, nil
+}
+
+func (repo *fakeWebhookRepo) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	result := []domain.WebhookDelivery{}
+	for _, delivery := range repo.deliveries {
+		if delivery.State == domain.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			result = append(result, *delivery)
+		}
+	}
+	return result, nil
+}
+
+func (repo *fakeWebhookRepo) ClaimWebhookDelivery(ctx context.Context, deliveryID uint64, now time.Time, leaseUntil time.Time) (bool, error) {
+	for _, delivery := range repo.deliveries {
+		if delivery.ID == deliveryID && delivery.State == domain.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			delivery.NextAttemptAt = leaseUntil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (repo *fakeWebhookRepo) UpdateWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	for i, existing := range repo.deliveries {
+		if existing.ID == delivery.ID {
+			updated := *delivery
+			repo.deliveries[i] = &updated
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
+
+func (suite *WebhookTestSuite) TestCreateWebhook() {
+	ctx := context.Background()
+
+	_, err := suite.usecase.CreateWebhook(ctx, domain.CreateWebhookRequest{
+		Namespace: suite.namespace,
+		URL:       suite.server.URL,
+		Events:    []string{"account.deleted"},
+	})
+	suite.ErrorIs(err, domain.ErrWebhookEventInvalid)
+
+	_, err = suite.usecase.CreateWebhook(ctx, domain.CreateWebhookRequest{
+		Namespace: suite.namespace,
+		URL:       "ftp://example.com",
+		Events:    []string{"*"},
+	})
+	suite.ErrorIs(err, domain.ErrInvalidInput)
+
+	webhook := suite.createWebhook("account.*", domain.WebhookEventLoginFailed)
+	suite.True(strings.HasPrefix(webhook.Secret, webhookSecretPrefix))
+	suite.True(webhook.Subscribes(domain.WebhookEventAccountLocked))
+	suite.True(webhook.Subscribes(domain.WebhookEventLoginFailed))
+	suite.False(webhook.Subscribes(domain.WebhookEventLoginSucceeded))
+
+	webhooks, err := suite.usecase.Webhooks(ctx, suite.namespace)
+	suite.Require().NoError(err)
+	suite.Len(webhooks, 1)
+	suite.Empty(webhooks[0].Secret)
+}
+
+func (suite *WebhookTestSuite) TestPublishFromEventLogAndDispatch() {
+	ctx := context.Background()
+	webhook := suite.createWebhook("account.*")
+	suite.createWebhook(domain.WebhookEventLoginFailed)
+
+	account := domain.Account{
+		ID:              1,
+		UUID:            "uuid-angela",
+		Namespace:       suite.namespace,
+		Username:        sql.NullString{String: "angela", Valid: true},
+		PasswordEncrypt: "hashed",
+		OTPSecret:       "otp secret",
+		State:           domain.AccountStatusLocked,
+	}
+	newStatus, err := json.Marshal(account)
+	suite.Require().NoError(err)
+
+	eventLogRepo := NewWebhookEventLogRepo(&fakeEventLogRepo{}, suite.usecase)
+	err = eventLogRepo.CreateEventLog(ctx, &domain.EventLog{
+		Namespace: "identity.account",
+		Action:    "change_state",
+		TargetID:  "1",
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+		Actor:     "admin",
+	})
+	suite.Require().NoError(err)
+	suite.Require().Len(suite.repo.deliveries, 1)
+
+	err = suite.usecase.Dispatch(ctx)
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.requests, 1)
+	req, body := suite.requests[0], suite.bodies[0]
+	suite.Equal(domain.WebhookEventAccountLocked, req.Header.Get(domain.WebhookEventHeader))
+
+	signature := req.Header.Get(domain.WebhookSignatureHeader)
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+	suite.Require().NoError(err)
+	suite.Equal(domain.WebhookSignature(suite.repo.webhooks[0].Secret, timestamp, body), signature)
+
+	// 帳號的 secret 不能送到 webhook
+	suite.NotContains(string(body), "hashed")
+	suite.NotContains(string(body), "otp secret")
+
+	event := domain.WebhookEvent{}
+	suite.Require().NoError(json.Unmarshal(body, &event))
+	suite.Equal(suite.namespace, event.Namespace)
+	suite.Equal("admin", event.Actor)
+
+	delivery := suite.repo.deliveries[0]
+	suite.Equal(webhook.ID, delivery.WebhookID)
+	suite.Equal(domain.WebhookDeliverySucceeded, delivery.State)
+	suite.Equal(int32(1), delivery.Attempts)
+	suite.Equal(int32(http.StatusOK), delivery.ResponseStatus)
+}
+
+func (suite *WebhookTestSuite) TestDeadLetterAndRedeliver() {
+	ctx := context.Background()
+	suite.createWebhook(domain.WebhookEventLoginFailed)
+	suite.setStatus(http.StatusServiceUnavailable)
+
+	loginLogRepo := NewWebhookLoginLogRepo(&fakeLoginLogRepo{}, suite.usecase)
+	err := loginLogRepo.CreateLoginLog(ctx, &domain.LoginLog{
+		Namespace: suite.namespace,
+		TargetID:  "1",
+		State:     domain.LoginLogFail,
+	})
+	suite.Require().NoError(err)
+
+	err = suite.usecase.Dispatch(ctx)
+	suite.Require().NoError(err)
+	suite.Len(suite.requests, 3)
+
+	deadLetters, err := suite.usecase.DeadLetters(ctx, suite.namespace, 0)
+	suite.Require().NoError(err)
+	suite.Require().Len(deadLetters, 1)
+	suite.Equal(int32(3), deadLetters[0].Attempts)
+	suite.Equal(int32(http.StatusServiceUnavailable), deadLetters[0].ResponseStatus)
+	suite.Contains(deadLetters[0].LastError, "503")
+
+	suite.setStatus(http.StatusOK)
+	err = suite.usecase.Redeliver(ctx, suite.namespace, deadLetters[0].ID)
+	suite.Require().NoError(err)
+
+	err = suite.usecase.Dispatch(ctx)
+	suite.Require().NoError(err)
+	suite.Len(suite.requests, 4)
+	suite.Equal(domain.WebhookDeliverySucceeded, suite.repo.deliveries[0].State)
+
+	deadLetters, err = suite.usecase.DeadLetters(ctx, suite.namespace, 0)
+	suite.Require().NoError(err)
+	suite.Empty(deadLetters)
+}
+
+func (suite *WebhookTestSuite) TestClientErrorIsNotRetried() {
+	ctx := context.Background()
+	webhook := suite.createWebhook("*")
+	suite.setStatus(http.StatusGone)
+
+	err := suite.usecase.Publish(ctx, &domain.WebhookEvent{Type: domain.WebhookEventAccountCreated, Namespace: suite.namespace})
+	suite.Require().NoError(err)
+
+	err = suite.usecase.Dispatch(ctx)
+	suite.Require().NoError(err)
+	suite.Len(suite.requests, 1)
+	suite.Equal(domain.WebhookDeliveryDead, suite.repo.deliveries[0].State)
+
+	// webhook 被刪除之後, 重新發送會直接進入 dead letter
+	err = suite.usecase.DeleteWebhook(ctx, domain.DeleteWebhookRequest{Namespace: suite.namespace, WebhookID: webhook.ID})
+	suite.Require().NoError(err)
+	err = suite.usecase.Redeliver(ctx, suite.namespace, suite.repo.deliveries[0].ID)
+	suite.Require().NoError(err)
+	err = suite.usecase.Dispatch(ctx)
+	suite.Require().NoError(err)
+	suite.Len(suite.requests, 1)
+	suite.Equal("webhook was deleted", suite.repo.deliveries[0].LastError)
+}
+
+func (suite *WebhookTestSuite) TestRoleMembershipEvents() {
+	oldStatus, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Namespace: suite.namespace, Name: "engineering"}, MemberIDs: []uint64{1, 2}})
+	newStatus, _ := json.Marshal(roleStatus{Role: domain.Role{ID: 7, Namespace: suite.namespace, Name: "engineering"}, MemberIDs: []uint64{2, 3}})
+
+	events := domainEventsFromEventLog(&domain.EventLog{
+		Namespace: "identity.role",
+		Action:    "update",
+		TargetID:  "7",
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		State:     domain.EventLogSuccess,
+	})
+
+	types := []string{}
+	for _, event := range events {
+		suite.Equal(suite.namespace, event.Namespace)
+		types = append(types, event.Type+":"+event.TargetID)
+	}
+	suite.Equal([]string{"role.updated:7", "role.assigned:3", "role.unassigned:1"}, types)
+}
@@ -0,0 +1,405 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/google/uuid"
+	"github.com/nite-coder/blackbear/pkg/log"
+	"gorm.io/datatypes"
+)
+
+const (
+	webhookSecretPrefix   = "whsec_"
+	webhookDeadLetterSize = 100
+	webhookMaxErrorLength = 512
+)
+
+type WebhookOptions struct {
+	// MaxAttempts 每個 delivery 最多發送的次數, 用完之後進入 dead letter
+	MaxAttempts int
+	// InitialBackoff 與 MaxBackoff 是重試之間等待時間的範圍, 每次重試等待的時間以指數成長
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout 每次發送的 timeout
+	Timeout time.Duration
+	// Workers 同時發送的 delivery 數量
+	Workers int
+	// BatchSize 每次 Dispatch 最多處理的 delivery 數量
+	BatchSize int
+}
+
+func (opts *WebhookOptions) setDefaults() {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+}
+
+// WebhookUsecase 事件在寫入 event log 的同一個 transaction 建立 delivery,
+// 由背景的 worker 發送, 所以 transaction rollback 時不會送出事件
+type WebhookUsecase struct {
+	webhookRepo  domain.WebhookRepository
+	eventLogRepo domain.EventLogRepository
+	opts         WebhookOptions
+	client       *http.Client
+	now          func() time.Time
+}
+
+func NewWebhookUsecase(webhookRepo domain.WebhookRepository, eventLogRepo domain.EventLogRepository, opts WebhookOptions) *WebhookUsecase {
+	opts.setDefaults()
+
+	return &WebhookUsecase{
+		webhookRepo:  webhookRepo,
+		eventLogRepo: eventLogRepo,
+		opts:         opts,
+		client:       &http.Client{},
+		now:          time.Now,
+	}
+}
+
+func (uc *WebhookUsecase) CreateWebhook(ctx context.Context, request domain.CreateWebhookRequest) (*domain.Webhook, error) {
+	if request.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required. %w", domain.ErrInvalidInput)
+	}
+
+	endpoint, err := url.Parse(request.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("webhook url %q is invalid. %w", request.URL, domain.ErrInvalidInput)
+	}
+
+	if len(request.Events) == 0 {
+		return nil, fmt.Errorf("at least one event is required. %w", domain.ErrWebhookEventInvalid)
+	}
+
+	for _, event := range request.Events {
+		if !isWebhookEventFilter(event) {
+			return nil, fmt.Errorf("webhook event %q is not supported. %w", event, domain.ErrWebhookEventInvalid)
+		}
+	}
+
+	secret, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := domain.Webhook{
+		Namespace:   request.Namespace,
+		URL:         request.URL,
+		Secret:      webhookSecretPrefix + secret,
+		Events:      strings.Join(request.Events, " "),
+		State:       domain.WebhookStateEnabled,
+		CreatorName: request.CreatorName,
+	}
+
+	err = uc.webhookRepo.CreateWebhook(ctx, &webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	uc.recordWebhook(ctx, "create", &webhook, request.CreatorName)
+	return &webhook, nil
+}
+
+func (uc *WebhookUsecase) Webhooks(ctx context.Context, namespace string) ([]domain.Webhook, error) {
+	webhooks, err := uc.webhookRepo.Webhooks(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook 還沒有送出的 delivery 在發送時會因為找不到 webhook 而進入 dead letter
+func (uc *WebhookUsecase) DeleteWebhook(ctx context.Context, request domain.DeleteWebhookRequest) error {
+	webhook, err := uc.webhookRepo.Webhook(ctx, request.Namespace, request.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	err = uc.webhookRepo.DeleteWebhook(ctx, request.Namespace, request.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	uc.recordWebhook(ctx, "delete", webhook, request.UpdaterName)
+	return nil
+}
+
+func (uc *WebhookUsecase) Publish(ctx context.Context, event *domain.WebhookEvent) error {
+	webhooks, err := uc.webhookRepo.Webhooks(ctx, event.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*domain.WebhookDelivery
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if webhook.State != domain.WebhookStateEnabled || !webhook.Subscribes(event.Type) {
+			continue
+		}
+
+		if len(deliveries) == 0 {
+			if event.ID == "" {
+				event.ID = uuid.New().String()
+			}
+			if event.OccurredAt.IsZero() {
+				event.OccurredAt = uc.now().UTC()
+			}
+		}
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		deliveries = append(deliveries, &domain.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Namespace: event.Namespace,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   datatypes.JSON(payload),
+			State:     domain.WebhookDeliveryPending,
+		})
+	}
+
+	return uc.webhookRepo.CreateWebhookDeliveries(ctx, deliveries)
+}
+
+func (uc *WebhookUsecase) DeadLetters(ctx context.Context, namespace string, webhookID uint64) ([]domain.WebhookDelivery, error) {
+	return uc.webhookRepo.WebhookDeliveries(ctx, domain.FindWebhookDeliveryOptions{
+		Namespace: namespace,
+		WebhookID: webhookID,
+		State:     domain.WebhookDeliveryDead,
+		Limit:     webhookDeadLetterSize,
+	})
+}
+
+// Redeliver 重新發送時重試次數從頭開始計算, 已經成功的 delivery 也可以重新發送
+func (uc *WebhookUsecase) Redeliver(ctx context.Context, namespace string, deliveryID uint64) error {
+	delivery, err := uc.webhookRepo.WebhookDelivery(ctx, namespace, deliveryID)
+	if err != nil {
+		return err
+	}
+
+	if delivery.State == domain.WebhookDeliveryPending {
+		return nil
+	}
+
+	delivery.State = domain.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.ResponseStatus = 0
+	delivery.LastError = ""
+	delivery.NextAttemptAt = uc.now().UTC()
+	return uc.webhookRepo.UpdateWebhookDelivery(ctx, delivery)
+}
+
+// Dispatch 先取得 delivery 的租約再發送, 租約到期前沒有完成 (例如 instance 被關閉) 的 delivery 會被重新發送
+func (uc *WebhookUsecase) Dispatch(ctx context.Context) error {
+	now := uc.now().UTC()
+
+	deliveries, err := uc.webhookRepo.DueWebhookDeliveries(ctx, now, uc.opts.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, uc.opts.Workers)
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		claimed, err := uc.webhookRepo.ClaimWebhookDelivery(ctx, delivery.ID, now, now.Add(uc.lease()))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			uc.deliver(ctx, delivery)
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// lease 是一個 delivery 用完所有重試次數所需要的最長時間
+func (uc *WebhookUsecase) lease() time.Duration {
+	return time.Duration(uc.opts.MaxAttempts) * (uc.opts.Timeout + uc.opts.MaxBackoff)
+}
+
+func (uc *WebhookUsecase) deliver(ctx context.Context, delivery *domain.WebhookDelivery) {
+	logger := log.FromContext(ctx).Uint64("delivery_id", delivery.ID)
+
+	webhook, err := uc.webhookRepo.Webhook(ctx, delivery.Namespace, delivery.WebhookID)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		err = backoff.Permanent(errors.New("webhook was deleted"))
+	case err == nil && webhook.State != domain.WebhookStateEnabled:
+		err = backoff.Permanent(errors.New("webhook is disabled"))
+	case err != nil:
+		logger.Err(err).Error("usecase: get webhook for delivery failed")
+		return
+	default:
+		policy := backoff.NewExponentialBackOff()
+		policy.InitialInterval = uc.opts.InitialBackoff
+		policy.MaxInterval = uc.opts.MaxBackoff
+		policy.MaxElapsedTime = 0
+
+		err = backoff.Retry(func() error {
+			delivery.Attempts++
+			return uc.send(ctx, webhook, delivery)
+		}, backoff.WithContext(backoff.WithMaxRetries(policy, uint64(uc.opts.MaxAttempts-1)), ctx))
+	}
+
+	// 服務關閉時不更新狀態, 租約到期後會由其他 instance 重新發送
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := uc.now().UTC()
+	if err == nil {
+		delivery.State = domain.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = now
+	} else {
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			err = permanent.Err
+		}
+		delivery.State = domain.WebhookDeliveryDead
+		delivery.LastError = truncate(err.Error(), webhookMaxErrorLength)
+		logger.Err(err).Str("event_type", delivery.EventType).Warn("usecase: webhook delivery is moved to dead letter")
+	}
+	delivery.NextAttemptAt = now
+
+	err = uc.webhookRepo.UpdateWebhookDelivery(ctx, delivery)
+	if err != nil {
+		logger.Err(err).Error("usecase: update webhook delivery failed")
+	}
+}
+
+// send 回應 2xx 代表成功, 408, 429 與 5xx 會重試, 其他的 4xx 不會重試
+func (uc *WebhookUsecase) send(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, uc.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "identity-webhook/1.0")
+	req.Header.Set(domain.WebhookEventHeader, delivery.EventType)
+	req.Header.Set(domain.WebhookDeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(domain.WebhookSignatureHeader, domain.WebhookSignature(webhook.Secret, uc.now().Unix(), delivery.Payload))
+
+	resp, err := uc.client.Do(req)
+	if err != nil {
+		delivery.ResponseStatus = 0
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.ResponseStatus = int32(resp.StatusCode)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	default:
+		return backoff.Permanent(fmt.Errorf("webhook responded with status %d", resp.StatusCode))
+	}
+}
+
+// recordWebhook 把 webhook 的建立與刪除記錄到 event log, 不記錄 secret
+func (uc *WebhookUsecase) recordWebhook(ctx context.Context, action string, webhook *domain.Webhook, actor string) {
+	logger := log.FromContext(ctx)
+
+	newStatus, err := json.Marshal(map[string]interface{}{
+		"namespace": webhook.Namespace,
+		"url":       webhook.URL,
+		"events":    webhook.Events,
+	})
+	if err != nil {
+		logger.Err(err).Error("usecase: marshal webhook event failed")
+		return
+	}
+
+	err = createAuditLog(ctx, uc.eventLogRepo, &domain.EventLog{
+		Namespace: "identity.webhook",
+		Action:    action,
+		TargetID:  strconv.FormatUint(webhook.ID, 10),
+		Message:   fmt.Sprintf("webhook %s is %sd", webhook.URL, action),
+		OldStatus: datatypes.JSON([]byte("{}")),
+		NewStatus: datatypes.JSON(newStatus),
+		State:     domain.EventLogSuccess,
+		Actor:     actor,
+	})
+	if err != nil {
+		logger.Err(err).Uint64("webhook_id", webhook.ID).Error("usecase: create webhook event log failed")
+	}
+}
+
+// isWebhookEventFilter 支援完整的事件類型, "*" 以及 "account.*" 這種同一類的事件
+func isWebhookEventFilter(filter string) bool {
+	if filter == "*" {
+		return true
+	}
+
+	for _, eventType := range domain.WebhookEventTypes {
+		if filter == eventType {
+			return true
+		}
+		if strings.HasSuffix(filter, ".*") && strings.HasPrefix(eventType, filter[:len(filter)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,105 @@
+package identityclient
+
+import (
+	"container/list"
+	"identity/pkg/domain"
+	"sync"
+	"time"
+)
+
+// tokenCache 是 Token 查詢結果的 LRU 快取, 每一筆的期限不會超過 token 本身的 ExpiresIn
+type tokenCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type tokenCacheEntry struct {
+	key       string
+	token     domain.Token
+	expiresAt time.Time
+}
+
+func newTokenCache(maxEntries int, ttl time.Duration) *tokenCache {
+	return &tokenCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+func (c *tokenCache) get(key string) (*domain.Token, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*tokenCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	token := entry.token
+	return &token, true
+}
+
+func (c *tokenCache) add(key string, token domain.Token) {
+	ttl := c.ttl
+	if token.ExpiresIn > 0 && time.Duration(token.ExpiresIn)*time.Second < ttl {
+		ttl = time.Duration(token.ExpiresIn) * time.Second
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &tokenCacheEntry{key: key, token: token, expiresAt: c.now().Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *tokenCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *tokenCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*tokenCacheEntry).key)
+}
+
+// deleteByAccountID 清掉帳號的全部 token, 用在撤銷 token 或 API key 之後
+func (c *tokenCache) deleteByAccountID(accountID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*tokenCacheEntry).token.AccountID == accountID {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
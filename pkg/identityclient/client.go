@@ -0,0 +1,531 @@
+// Package identityclient 包裝 identityProto.IdentityServiceClient, 提供 domain 型別的方法,
+// 負責每次呼叫的 deadline, 冪等呼叫的重試, 以及把 grpc status 還原成 domain.AppError
+package identityclient
+
+import (
+	"context"
+	"errors"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"io"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultTimeout        = 5 * time.Second
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	defaultTokenCacheSize = 10000
+	defaultTokenCacheTTL  = 30 * time.Second
+)
+
+// Options 是 Client 的設定, 沒有設定的欄位使用預設值
+type Options struct {
+	// Timeout 每一次 RPC 的 deadline, 重試時每一次都會重新計算
+	Timeout time.Duration
+	// MaxRetries 冪等呼叫遇到 Unavailable 等連線問題時最多重試的次數, 小於 0 代表不重試
+	MaxRetries int
+	// InitialBackoff 與 MaxBackoff 是重試間隔的範圍, 間隔會以指數成長
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// TokenCacheSize Token 查詢結果快取的筆數上限, 小於 0 代表不快取
+	TokenCacheSize int
+	// TokenCacheTTL Token 查詢結果快取的時間, 不會超過 token 本身的 ExpiresIn
+	// token 被撤銷之後, 其他 process 最多還會快取 TokenCacheTTL 的時間
+	TokenCacheTTL time.Duration
+}
+
+// Client 是 identity 的 client, 可以同時給多個 goroutine 使用
+type Client struct {
+	client  identityProto.IdentityServiceClient
+	options Options
+	cache   *tokenCache
+}
+
+// NewClient 建立 identity 的 client, cc 通常是 grpc.Dial 回傳的 *grpc.ClientConn
+func NewClient(cc grpc.ClientConnInterface, options Options) *Client {
+	return newClient(identityProto.NewIdentityServiceClient(cc), options)
+}
+
+func newClient(client identityProto.IdentityServiceClient, options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+
+	if options.MaxRetries == 0 {
+		options.MaxRetries = defaultMaxRetries
+	}
+
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = defaultInitialBackoff
+	}
+
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultMaxBackoff
+	}
+
+	if options.TokenCacheSize == 0 {
+		options.TokenCacheSize = defaultTokenCacheSize
+	}
+
+	if options.TokenCacheTTL <= 0 {
+		options.TokenCacheTTL = defaultTokenCacheTTL
+	}
+
+	c := &Client{
+		client:  client,
+		options: options,
+	}
+
+	if options.TokenCacheSize > 0 {
+		c.cache = newTokenCache(options.TokenCacheSize, options.TokenCacheTTL)
+	}
+
+	return c
+}
+
+// invoke 呼叫 fn 並套用 deadline, idempotent 為 true 時遇到連線問題會用 backoff 重試
+func (c *Client) invoke(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	operation := func() error {
+		callCtx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
+
+		err := fn(callCtx)
+		if err != nil && !(idempotent && retryable(err)) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
+	var b backoff.BackOff = &backoff.StopBackOff{}
+	if idempotent && c.options.MaxRetries > 0 {
+		bo := backoff.NewExponentialBackOff()
+		bo.InitialInterval = c.options.InitialBackoff
+		bo.MaxInterval = c.options.MaxBackoff
+		bo.MaxElapsedTime = 0
+		b = backoff.WithMaxRetries(bo, uint64(c.options.MaxRetries))
+	}
+
+	return FromStatusError(backoff.Retry(operation, backoff.WithContext(b, ctx)))
+}
+
+// Token 查詢 token 的內容, 支援 opaque token, JWT 與 API key, 結果會快取在 process 內
+func (c *Client) Token(ctx context.Context, tokenKey string) (*domain.Token, error) {
+	if c.cache != nil {
+		if token, ok := c.cache.get(tokenKey); ok {
+			return token, nil
+		}
+	}
+
+	var resp *identityProto.TokenResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.Token(ctx, &identityProto.TokenRequest{TokenKey: tokenKey})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Token == nil {
+		return nil, domain.ErrKeyNotFound
+	}
+
+	token := fromTokenProto(resp.Token)
+	if c.cache != nil {
+		c.cache.add(tokenKey, token)
+	}
+
+	return &token, nil
+}
+
+// Validate 實作 middleware.Validator, token 不存在時回傳 domain.ErrInvalidToken
+func (c *Client) Validate(ctx context.Context, tokenString string) (*domain.Token, error) {
+	token, err := c.Token(ctx, tokenString)
+	if errors.Is(err, domain.ErrKeyNotFound) {
+		return nil, domain.ErrInvalidToken
+	}
+	return token, err
+}
+
+// CreateToken 建立 token 與 session, 回傳的 session 帶有 AccessToken 與 RefreshKey
+func (c *Client) CreateToken(ctx context.Context, request domain.CreateTokenRequest) (*domain.Session, error) {
+	var resp *identityProto.CreateTokenResponse
+	err := c.invoke(ctx, false, func(ctx context.Context) (err error) {
+		resp, err = c.client.CreateToken(ctx, &identityProto.CreateTokenRequest{
+			Token:      toTokenProto(&request.Token),
+			Namespace:  request.Prefix,
+			DeviceType: int32(request.DeviceType),
+			ClientIp:   request.ClientIP,
+			UserAgent:  request.UserAgent,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.Session{
+		ID:          resp.SessionId,
+		Namespace:   request.Token.Namespace,
+		AccountID:   request.Token.AccountID,
+		AccessKey:   resp.AccessKey,
+		RefreshKey:  resp.RefreshKey,
+		AccessToken: resp.Token,
+		DeviceType:  request.DeviceType,
+		ClientIP:    request.ClientIP,
+		UserAgent:   request.UserAgent,
+	}, nil
+}
+
+// RefreshToken 用 refreshToken 換新的 accessToken 與 refreshToken, 舊的 refreshToken 會失效, 所以不會重試
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (string, string, error) {
+	var resp *identityProto.RefreshTokenResponse
+	err := c.invoke(ctx, false, func(ctx context.Context) (err error) {
+		resp, err = c.client.RefreshToken(ctx, &identityProto.RefreshTokenRequest{RefreshToken: refreshToken})
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return resp.AuthToken, resp.RefreshToken, nil
+}
+
+// RenewToken 延長 token 的期限, duration 的單位為秒
+func (c *Client) RenewToken(ctx context.Context, tokenKey string, duration int64) error {
+	if c.cache != nil {
+		c.cache.delete(tokenKey)
+	}
+
+	return c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.RenewToken(ctx, &identityProto.RenewTokenRequest{TokenKey: tokenKey, Duration: duration})
+		return err
+	})
+}
+
+// DeleteTokenByAccountID 刪除帳號全部的 token, 同時清掉本地快取裡這個帳號的 token
+func (c *Client) DeleteTokenByAccountID(ctx context.Context, namespace string, accountID int64) error {
+	err := c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.DeleteTokenByAccountID(ctx, &identityProto.DeleteTokenByAccountIDRequest{AccountId: accountID, Namespace: namespace})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.cache != nil {
+		c.cache.deleteByAccountID(accountID)
+	}
+
+	return nil
+}
+
+func (c *Client) BindHashToken(ctx context.Context, hashKey, accessTokenKey string) error {
+	return c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.BindHashToken(ctx, &identityProto.BindHashTokenRequest{HashKey: hashKey, AccessTokenKey: accessTokenKey})
+		return err
+	})
+}
+
+func (c *Client) DeleteHash(ctx context.Context, hashKey string) error {
+	return c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.DeleteHash(ctx, &identityProto.DeleteHashRequest{HashKey: hashKey})
+		return err
+	})
+}
+
+func (c *Client) Session(ctx context.Context, namespace string, accountID int64, sessionID string) (*domain.Session, error) {
+	var resp *identityProto.SessionResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.Session(ctx, &identityProto.SessionRequest{Namespace: namespace, AccountId: accountID, SessionId: sessionID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Session == nil {
+		return nil, domain.ErrNotFound
+	}
+
+	session := fromSessionProto(resp.Session)
+	return &session, nil
+}
+
+func (c *Client) Sessions(ctx context.Context, namespace string, accountID int64) ([]domain.Session, error) {
+	var resp *identityProto.SessionsResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.Sessions(ctx, &identityProto.SessionsRequest{Namespace: namespace, AccountId: accountID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]domain.Session, 0, len(resp.Sessions))
+	for _, session := range resp.Sessions {
+		sessions = append(sessions, fromSessionProto(session))
+	}
+	return sessions, nil
+}
+
+func (c *Client) RevokeSession(ctx context.Context, request domain.RevokeSessionRequest) error {
+	return c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.RevokeSession(ctx, &identityProto.RevokeSessionRequest{
+			Namespace: request.Namespace,
+			AccountId: request.AccountID,
+			SessionId: request.SessionID,
+		})
+		return err
+	})
+}
+
+func (c *Client) RevokeOtherSessions(ctx context.Context, request domain.RevokeOtherSessionsRequest) error {
+	return c.invoke(ctx, true, func(ctx context.Context) error {
+		_, err := c.client.RevokeOtherSessions(ctx, &identityProto.RevokeOtherSessionsRequest{
+			Namespace:        request.Namespace,
+			AccountId:        request.AccountID,
+			CurrentSessionId: request.CurrentSessionID,
+		})
+		return err
+	})
+}
+
+// CreateOAuthClient 回傳的 secret 只會出現這一次, public client 沒有 secret
+func (c *Client) CreateOAuthClient(ctx context.Context, request domain.CreateOAuthClientRequest) (*domain.OAuthClient, string, error) {
+	var resp *identityProto.CreateOAuthClientResponse
+	err := c.invoke(ctx, false, func(ctx context.Context) (err error) {
+		resp, err = c.client.CreateOAuthClient(ctx, &identityProto.CreateOAuthClientRequest{
+			Namespace:    request.Namespace,
+			Name:         request.Name,
+			RedirectUris: request.RedirectURIs,
+			GrantTypes:   request.GrantTypes,
+			Scopes:       request.Scopes,
+			Public:       request.Public,
+			CreatorId:    int64(request.CreatorID),
+			CreatorName:  request.CreatorName,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := fromOAuthClientProto(resp.Client)
+	return &client, resp.ClientSecret, nil
+}
+
+func (c *Client) OAuthClient(ctx context.Context, namespace, clientID string) (*domain.OAuthClient, error) {
+	var resp *identityProto.OAuthClientResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.OAuthClient(ctx, &identityProto.OAuthClientRequest{Namespace: namespace, ClientId: clientID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := fromOAuthClientProto(resp.Client)
+	return &client, nil
+}
+
+func (c *Client) OAuthClients(ctx context.Context, namespace string) ([]domain.OAuthClient, error) {
+	var resp *identityProto.OAuthClientsResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.OAuthClients(ctx, &identityProto.OAuthClientsRequest{Namespace: namespace})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]domain.OAuthClient, 0, len(resp.Clients))
+	for _, client := range resp.Clients {
+		clients = append(clients, fromOAuthClientProto(client))
+	}
+	return clients, nil
+}
+
+func (c *Client) DeleteOAuthClient(ctx context.Context, namespace, clientID string) error {
+	return c.invoke(ctx, false, func(ctx context.Context) error {
+		_, err := c.client.DeleteOAuthClient(ctx, &identityProto.DeleteOAuthClientRequest{Namespace: namespace, ClientId: clientID})
+		return err
+	})
+}
+
+// ImpersonateAccount 回傳的 token 不能被 refresh, 期限到了需要重新申請
+func (c *Client) ImpersonateAccount(ctx context.Context, request domain.ImpersonateAccountRequest) (*domain.Impersonation, error) {
+	var resp *identityProto.ImpersonateAccountResponse
+	err := c.invoke(ctx, false, func(ctx context.Context) (err error) {
+		resp, err = c.client.ImpersonateAccount(ctx, &identityProto.ImpersonateAccountRequest{
+			Namespace: request.Namespace,
+			AccountId: int64(request.AccountID),
+			Reason:    request.Reason,
+			Duration:  int64(request.Duration / time.Second),
+			ClientIp:  request.ClientIP,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.Impersonation{
+		AccessToken:    resp.AccessToken,
+		ExpiresIn:      resp.ExpiresIn,
+		AccountID:      request.AccountID,
+		ImpersonatorID: uint64(resp.ImpersonatorId),
+	}, nil
+}
+
+func (c *Client) EndImpersonation(ctx context.Context, request domain.EndImpersonationRequest) error {
+	if c.cache != nil {
+		c.cache.delete(request.AccessToken)
+	}
+
+	return c.invoke(ctx, false, func(ctx context.Context) error {
+		_, err := c.client.EndImpersonation(ctx, &identityProto.EndImpersonationRequest{
+			Namespace:   request.Namespace,
+			AccessToken: request.AccessToken,
+			ClientIp:    request.ClientIP,
+		})
+		return err
+	})
+}
+
+// CreateAPIKey 回傳的 apiKey 只會出現這一次, identity 只保存 hash
+func (c *Client) CreateAPIKey(ctx context.Context, request domain.CreateAPIKeyRequest) (*domain.APIKey, string, error) {
+	var resp *identityProto.CreateAPIKeyResponse
+	err := c.invoke(ctx, false, func(ctx context.Context) (err error) {
+		resp, err = c.client.CreateAPIKey(ctx, &identityProto.CreateAPIKeyRequest{
+			Namespace: request.Namespace,
+			AccountId: int64(request.AccountID),
+			Name:      request.Name,
+			Scopes:    request.Scopes,
+			ExpiresIn: int64(request.ExpiresIn / time.Second),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := fromAPIKeyProto(resp.ApiKey)
+	return &key, resp.Key, nil
+}
+
+func (c *Client) APIKeys(ctx context.Context, namespace string, accountID uint64) ([]domain.APIKey, error) {
+	var resp *identityProto.APIKeysResponse
+	err := c.invoke(ctx, true, func(ctx context.Context) (err error) {
+		resp, err = c.client.APIKeys(ctx, &identityProto.APIKeysRequest{Namespace: namespace, AccountId: int64(accountID)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]domain.APIKey, 0, len(resp.ApiKeys))
+	for _, key := range resp.ApiKeys {
+		keys = append(keys, fromAPIKeyProto(key))
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey 撤銷之後, 本地快取裡用這把 key 查詢的結果會被清掉
+func (c *Client) RevokeAPIKey(ctx context.Context, request domain.RevokeAPIKeyRequest) error {
+	err := c.invoke(ctx, false, func(ctx context.Context) error {
+		_, err := c.client.RevokeAPIKey(ctx, &identityProto.RevokeAPIKeyRequest{
+			Namespace: request.Namespace,
+			AccountId: int64(request.AccountID),
+			KeyId:     request.KeyID,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.cache != nil {
+		c.cache.deleteByAccountID(int64(request.AccountID))
+	}
+
+	return nil
+}
+
+// Watch 持續接收帳號與角色的異動並交給 handler, 直到 ctx 結束或 handler 回傳錯誤
+// 連線中斷時會用 backoff 重新連線, 並從最後收到的 cursor 接續, 所以 handler 可能收到重複的事件, 但不會漏掉
+// request.Cursor 為 0 時從目前最新的位置開始
+func (c *Client) Watch(ctx context.Context, request domain.WatchRequest, handler func(event *domain.ChangeEvent) error) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = c.options.InitialBackoff
+	bo.MaxInterval = c.options.MaxBackoff
+	bo.MaxElapsedTime = 0
+
+	for {
+		err := c.watch(ctx, &request, func(event *domain.ChangeEvent) error {
+			bo.Reset()
+			return handler(event)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var handlerErr *watchHandlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if err != nil && !retryable(err) {
+			return FromStatusError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bo.NextBackOff()):
+		}
+	}
+}
+
+// watchHandlerError 區分 handler 的錯誤與 stream 的錯誤, handler 的錯誤不重新連線
+type watchHandlerError struct {
+	err error
+}
+
+func (e *watchHandlerError) Error() string {
+	return e.err.Error()
+}
+
+// watch 接收到 stream 結束為止, 每收到一個事件就更新 request.Cursor, server 正常結束 stream 時回傳 nil
+func (c *Client) watch(ctx context.Context, request *domain.WatchRequest, handler func(event *domain.ChangeEvent) error) error {
+	stream, err := c.client.Watch(ctx, &identityProto.WatchRequest{
+		Namespace: request.Namespace,
+		Cursor:    request.Cursor,
+		Events:    request.Events,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		event := fromWatchResponseProto(resp)
+		if event.Type != domain.ChangeEventHeartbeat {
+			err = handler(&event)
+			if err != nil {
+				return &watchHandlerError{err: err}
+			}
+		}
+		request.Cursor = event.Cursor
+	}
+}
@@ -0,0 +1,281 @@
+package identityclient
+
+import (
+	"context"
+	"errors"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+	fake   *fakeIdentityClient
+	client *Client
+}
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, &ClientTestSuite{})
+}
+
+func (suite *ClientTestSuite) SetupTest() {
+	suite.fake = &fakeIdentityClient{tokens: map[string]*identityProto.Token{
+		"alice-token": {AccountId: 1, Namespace: "test.identity", Username: "alice", ExpiresIn: 60},
+		"short-token": {AccountId: 2, Namespace: "test.identity", Username: "bob", ExpiresIn: 1},
+	}}
+	suite.client = newClient(suite.fake, Options{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		TokenCacheTTL:  time.Minute,
+	})
+}
+
+// fakeIdentityClient 只實作測試需要的 RPC, failures 是接下來依序回傳的錯誤
+type fakeIdentityClient struct {
+	identityProto.IdentityServiceClient
+	tokens   map[string]*identityProto.Token
+	failures []error
+	calls    int
+	// watchStreams 是依序回傳的 Watch stream, watchCursors 記錄每次呼叫 Watch 帶的 cursor
+	watchStreams []*fakeWatchStream
+	watchCursors []uint64
+}
+
+func (c *fakeIdentityClient) fail() error {
+	c.calls++
+	if len(c.failures) == 0 {
+		return nil
+	}
+
+	err := c.failures[0]
+	c.failures = c.failures[1:]
+	return err
+}
+
+func (c *fakeIdentityClient) Token(ctx context.Context, in *identityProto.TokenRequest, opts ...grpc.CallOption) (*identityProto.TokenResponse, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+
+	token, ok := c.tokens[in.TokenKey]
+	if !ok {
+		return nil, appStatusError(domain.ErrKeyNotFound)
+	}
+	return &identityProto.TokenResponse{Token: token}, nil
+}
+
+func (c *fakeIdentityClient) RefreshToken(ctx context.Context, in *identityProto.RefreshTokenRequest, opts ...grpc.CallOption) (*identityProto.RefreshTokenResponse, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return &identityProto.RefreshTokenResponse{AuthToken: "new-access", RefreshToken: "new-refresh"}, nil
+}
+
+func (c *fakeIdentityClient) DeleteTokenByAccountID(ctx context.Context, in *identityProto.DeleteTokenByAccountIDRequest, opts ...grpc.CallOption) (*identityProto.DeleteTokenByAccountIDResponse, error) {
+	if err := c.fail(); err != nil {
+		return nil, err
+	}
+	return &identityProto.DeleteTokenByAccountIDResponse{}, nil
+}
+
+// appStatusError 與 identity 的 toStatusError 相同
+func appStatusError(appErr *domain.AppError) error {
+	st, _ := status.New(appErr.Status, appErr.Message).WithDetails(&errdetails.ErrorInfo{
+		Reason: appErr.Code,
+		Domain: errorDomain,
+	})
+	return st.Err()
+}
+
+func (suite *ClientTestSuite) TestFromStatusError() {
+	err := FromStatusError(appStatusError(domain.ErrAccountLocked))
+	suite.ErrorIs(err, domain.ErrAccountLocked)
+
+	var appErr *domain.AppError
+	suite.Require().True(errors.As(err, &appErr))
+	suite.Equal(codes.Unauthenticated, appErr.Status)
+
+	err = FromStatusError(status.Error(codes.Internal, "boom"))
+	suite.Equal(codes.Internal, status.Code(err))
+	suite.False(errors.As(err, &appErr))
+
+	suite.NoError(FromStatusError(nil))
+}
+
+func (suite *ClientTestSuite) TestTokenCache() {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		token, err := suite.client.Token(ctx, "alice-token")
+		suite.Require().NoError(err)
+		suite.Equal("alice", token.Username)
+	}
+	suite.Equal(1, suite.fake.calls)
+
+	// 快取的期限不會超過 token 的 ExpiresIn
+	now := time.Now()
+	suite.client.cache.now = func() time.Time { return now }
+	_, err := suite.client.Token(ctx, "short-token")
+	suite.Require().NoError(err)
+
+	suite.client.cache.now = func() time.Time { return now.Add(2 * time.Second) }
+	_, err = suite.client.Token(ctx, "short-token")
+	suite.Require().NoError(err)
+	suite.Equal(3, suite.fake.calls)
+
+	err = suite.client.DeleteTokenByAccountID(ctx, "test.identity", 1)
+	suite.Require().NoError(err)
+	_, err = suite.client.Token(ctx, "alice-token")
+	suite.Require().NoError(err)
+	suite.Equal(5, suite.fake.calls)
+}
+
+func (suite *ClientTestSuite) TestTokenCacheEviction() {
+	cache := newTokenCache(2, time.Minute)
+	cache.add("a", domain.Token{AccountID: 1})
+	cache.add("b", domain.Token{AccountID: 2})
+
+	_, ok := cache.get("a")
+	suite.True(ok)
+
+	// b 最久沒有被使用, 會先被移除
+	cache.add("c", domain.Token{AccountID: 3})
+	_, ok = cache.get("b")
+	suite.False(ok)
+	_, ok = cache.get("a")
+	suite.True(ok)
+	_, ok = cache.get("c")
+	suite.True(ok)
+}
+
+func (suite *ClientTestSuite) TestTokenNotFound() {
+	_, err := suite.client.Token(context.Background(), "unknown")
+	suite.ErrorIs(err, domain.ErrKeyNotFound)
+
+	_, err = suite.client.Validate(context.Background(), "unknown")
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+}
+
+func (suite *ClientTestSuite) TestRetryIdempotentCall() {
+	suite.fake.failures = []error{
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Unavailable, "connection refused"),
+	}
+
+	token, err := suite.client.Token(context.Background(), "alice-token")
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), token.AccountID)
+	suite.Equal(3, suite.fake.calls)
+
+	// AppError 不會重試
+	suite.fake.calls = 0
+	suite.fake.failures = []error{appStatusError(domain.ErrAccountDisabled)}
+	_, err = suite.client.Token(context.Background(), "short-token")
+	suite.ErrorIs(err, domain.ErrAccountDisabled)
+	suite.Equal(1, suite.fake.calls)
+}
+
+func (suite *ClientTestSuite) TestRetryGiveUp() {
+	suite.fake.failures = []error{
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.Unavailable, "connection refused"),
+	}
+
+	_, err := suite.client.Token(context.Background(), "alice-token")
+	suite.Equal(codes.Unavailable, status.Code(err))
+	suite.Equal(defaultMaxRetries+1, suite.fake.calls)
+}
+
+func (suite *ClientTestSuite) TestNoRetryNonIdempotentCall() {
+	suite.fake.failures = []error{status.Error(codes.Unavailable, "connection refused")}
+
+	_, _, err := suite.client.RefreshToken(context.Background(), "refresh-token")
+	suite.Equal(codes.Unavailable, status.Code(err))
+	suite.Equal(1, suite.fake.calls)
+
+	accessToken, refreshToken, err := suite.client.RefreshToken(context.Background(), "refresh-token")
+	suite.Require().NoError(err)
+	suite.Equal("new-access", accessToken)
+	suite.Equal("new-refresh", refreshToken)
+}
+
+func (c *fakeIdentityClient) Watch(ctx context.Context, in *identityProto.WatchRequest, opts ...grpc.CallOption) (identityProto.IdentityService_WatchClient, error) {
+	c.watchCursors = append(c.watchCursors, in.Cursor)
+	if len(c.watchStreams) == 0 {
+		return nil, status.Error(codes.Unavailable, "connection refused")
+	}
+
+	stream := c.watchStreams[0]
+	c.watchStreams = c.watchStreams[1:]
+	return stream, nil
+}
+
+// fakeWatchStream 依序回傳 responses, 用完之後回傳 err
+type fakeWatchStream struct {
+	grpc.ClientStream
+	responses []*identityProto.WatchResponse
+	err       error
+}
+
+func (s *fakeWatchStream) Recv() (*identityProto.WatchResponse, error) {
+	if len(s.responses) == 0 {
+		return nil, s.err
+	}
+
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+func (suite *ClientTestSuite) TestWatchResumesFromCursor() {
+	suite.fake.watchStreams = []*fakeWatchStream{
+		{
+			responses: []*identityProto.WatchResponse{
+				{Cursor: 10, Type: domain.ChangeEventHeartbeat},
+				{Cursor: 11, Type: domain.WebhookEventAccountCreated, TargetId: "1"},
+			},
+			err: status.Error(codes.Unavailable, "transport is closing"),
+		},
+		{
+			responses: []*identityProto.WatchResponse{
+				{Cursor: 12, Type: domain.WebhookEventAccountLocked, TargetId: "1"},
+			},
+			err: status.Error(codes.PermissionDenied, "permission denied"),
+		},
+	}
+
+	events := []domain.ChangeEvent{}
+	err := suite.client.Watch(context.Background(), domain.WatchRequest{Namespace: "test.identity"}, func(event *domain.ChangeEvent) error {
+		events = append(events, *event)
+		return nil
+	})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+
+	// heartbeat 不會交給 handler, 重新連線時從最後收到的 cursor 接續
+	suite.Equal([]uint64{0, 11}, suite.fake.watchCursors)
+	suite.Require().Len(events, 2)
+	suite.Equal(domain.WebhookEventAccountCreated, events[0].Type)
+	suite.Equal(domain.WebhookEventAccountLocked, events[1].Type)
+}
+
+func (suite *ClientTestSuite) TestWatchHandlerError() {
+	suite.fake.watchStreams = []*fakeWatchStream{
+		{responses: []*identityProto.WatchResponse{{Cursor: 1, Type: domain.WebhookEventRoleAssigned}}},
+	}
+
+	stop := errors.New("stop")
+	err := suite.client.Watch(context.Background(), domain.WatchRequest{Namespace: "test.identity"}, func(event *domain.ChangeEvent) error {
+		return stop
+	})
+	suite.ErrorIs(err, stop)
+	suite.Len(suite.fake.watchCursors, 1)
+}
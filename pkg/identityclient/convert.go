@@ -0,0 +1,105 @@
+package identityclient
+
+import (
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"strings"
+	"time"
+)
+
+func toTokenProto(token *domain.Token) *identityProto.Token {
+	return &identityProto.Token{
+		AccountId:        token.AccountID,
+		Namespace:        token.Namespace,
+		ExpiresIn:        token.ExpiresIn,
+		TokenString:      token.TokenString,
+		Claims:           token.Claims,
+		Username:         token.Username,
+		AccountType:      token.AccountType,
+		RefreshExpiresIn: token.RefreshExpiresIn,
+	}
+}
+
+func fromTokenProto(token *identityProto.Token) domain.Token {
+	return domain.Token{
+		AccountID:        token.AccountId,
+		Namespace:        token.Namespace,
+		ExpiresIn:        token.ExpiresIn,
+		TokenString:      token.TokenString,
+		Claims:           token.Claims,
+		Username:         token.Username,
+		AccountType:      token.AccountType,
+		RefreshExpiresIn: token.RefreshExpiresIn,
+	}
+}
+
+func fromSessionProto(session *identityProto.Session) domain.Session {
+	return domain.Session{
+		ID:          session.Id,
+		Namespace:   session.Namespace,
+		AccountID:   session.AccountId,
+		DeviceType:  domain.DeviceType(session.DeviceType),
+		ClientIP:    session.ClientIp,
+		CountryCode: session.CountryCode,
+		CityName:    session.CityName,
+		UserAgent:   session.UserAgent,
+		CreatedAt:   session.CreatedAt.AsTime(),
+		LastSeenAt:  session.LastSeenAt.AsTime(),
+	}
+}
+
+func fromOAuthClientProto(client *identityProto.OAuthClient) domain.OAuthClient {
+	result := domain.OAuthClient{
+		ClientID:     client.GetClientId(),
+		Namespace:    client.GetNamespace(),
+		Name:         client.GetName(),
+		RedirectURIs: strings.Join(client.GetRedirectUris(), " "),
+		GrantTypes:   strings.Join(client.GetGrantTypes(), " "),
+		Scopes:       strings.Join(client.GetScopes(), " "),
+		CreatorID:    uint64(client.GetCreatorId()),
+		CreatorName:  client.GetCreatorName(),
+		CreatedAt:    client.GetCreatedAt().AsTime(),
+	}
+
+	if client.GetPublic() {
+		result.Public = 1
+	}
+
+	return result
+}
+
+// fromAPIKeyProto 沒有 ExpiresAt 或 LastUsedAt 時與資料庫相同, 使用 1970-01-01
+func fromAPIKeyProto(key *identityProto.APIKey) domain.APIKey {
+	result := domain.APIKey{
+		KeyID:      key.GetKeyId(),
+		Namespace:  key.GetNamespace(),
+		AccountID:  uint64(key.GetAccountId()),
+		Name:       key.GetName(),
+		Scopes:     key.GetScopes(),
+		ExpiresAt:  time.Unix(0, 0).UTC(),
+		LastUsedAt: time.Unix(0, 0).UTC(),
+		CreatedAt:  key.GetCreatedAt().AsTime(),
+	}
+
+	if key.GetExpiresAt() != nil {
+		result.ExpiresAt = key.GetExpiresAt().AsTime()
+	}
+
+	if key.GetLastUsedAt() != nil {
+		result.LastUsedAt = key.GetLastUsedAt().AsTime()
+	}
+
+	return result
+}
+
+func fromWatchResponseProto(resp *identityProto.WatchResponse) domain.ChangeEvent {
+	return domain.ChangeEvent{
+		Cursor:     resp.GetCursor(),
+		Type:       resp.GetType(),
+		Namespace:  resp.GetNamespace(),
+		TargetID:   resp.GetTargetId(),
+		Actor:      resp.GetActor(),
+		Data:       []byte(resp.GetData()),
+		OccurredAt: resp.GetOccurredAt().AsTime(),
+	}
+}
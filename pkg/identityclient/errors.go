@@ -0,0 +1,48 @@
+package identityclient
+
+import (
+	"fmt"
+	"identity/pkg/domain"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain 與 identity 回傳的 ErrorInfo.Domain 相同
+const errorDomain = "identity"
+
+// FromStatusError 把 identity 回傳的 grpc status 還原成 domain.AppError,
+// 讓呼叫端可以用 errors.Is(err, domain.ErrAccountLocked) 判斷, 無法還原時回傳原本的錯誤
+func FromStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+
+		if appErr, ok := domain.LookupAppError(info.Reason); ok {
+			return fmt.Errorf("identityclient: %s. %w", st.Message(), appErr)
+		}
+	}
+
+	return err
+}
+
+// retryable 只有連線問題才重試, identity 回傳的 AppError 重試也不會成功
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
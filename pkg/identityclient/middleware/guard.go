@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"identity/pkg/domain"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Guard 檢查 context 裡面的 claims, 不通過時回傳錯誤, 必須放在驗證 token 的 middleware 之後
+type Guard func(ctx context.Context) error
+
+// RequireRole 需要擁有其中一個 role, 對應 claims 的 domain.ClaimRole
+func RequireRole(roles ...string) Guard {
+	return func(ctx context.Context) error {
+		claims, ok := domain.FromContext(ctx)
+		if !ok {
+			return ErrMissingToken
+		}
+
+		owned := claimValues(claims, domain.ClaimRole)
+		for _, role := range roles {
+			if owned[role] {
+				return nil
+			}
+		}
+		return ErrForbidden
+	}
+}
+
+// RequirePermission 需要擁有全部的 permission, 對應 claims 的 domain.ClaimPermission
+func RequirePermission(permissions ...string) Guard {
+	return func(ctx context.Context) error {
+		claims, ok := domain.FromContext(ctx)
+		if !ok {
+			return ErrMissingToken
+		}
+
+		owned := claimValues(claims, domain.ClaimPermission)
+		for _, permission := range permissions {
+			if !owned[permission] {
+				return ErrForbidden
+			}
+		}
+		return nil
+	}
+}
+
+// UnaryGuard 把 guard 套用在 fullMethod 以 prefix 開頭的 RPC, prefix 為空字串時套用在全部的 RPC
+func UnaryGuard(prefix string, guard Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, prefix) {
+			if err := guard(ctx); err != nil {
+				return nil, toStatusError(err)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// HTTPGuard 把 guard 套用在 http.Handler
+func HTTPGuard(guard Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := guard(r.Context()); err != nil {
+				writeError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// claimValues claims 的值是用空白分隔的字串
+func claimValues(claims domain.Claims, key string) map[string]bool {
+	val, _ := claims[key].(string)
+
+	result := map[string]bool{}
+	for _, v := range strings.Fields(val) {
+		result[v] = true
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+// Package middleware 給其他 service 驗證 identity 簽發的 token,
+// 驗證通過後把 domain.Claims 放進 context, handler 可以用 domain.FromContext 取得
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"identity/pkg/domain"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain 與 identity 回傳的 ErrorInfo.Domain 相同
+const errorDomain = "identity"
+
+var (
+	// ErrMissingToken request 沒有帶 bearer token
+	ErrMissingToken = &domain.AppError{Code: "MISSING_TOKEN", Message: "bearer token is required", Status: codes.Unauthenticated}
+	// ErrForbidden token 沒有需要的 role 或 permission
+	ErrForbidden = &domain.AppError{Code: "FORBIDDEN", Message: "the token does not have the required role or permission", Status: codes.PermissionDenied}
+)
+
+// Options 是 middleware 的設定
+type Options struct {
+	// AllowAnonymous 為 true 時沒有帶 token 的 request 也可以通過, 但是 context 裡面不會有 claims
+	// 帶了不正確的 token 還是會被拒絕
+	AllowAnonymous bool
+}
+
+// NewUnaryServerInterceptor 從 metadata 的 authorization 取得 bearer token
+func NewUnaryServerInterceptor(validator Validator, options Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, validator, options, bearerFromMetadata(ctx))
+		if err != nil {
+			return nil, toStatusError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewStreamServerInterceptor 與 NewUnaryServerInterceptor 相同, 用在 streaming RPC
+func NewStreamServerInterceptor(validator Validator, options Options) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), validator, options, bearerFromMetadata(ss.Context()))
+		if err != nil {
+			return toStatusError(err)
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// NewHTTPMiddleware 從 Authorization header 取得 bearer token
+func NewHTTPMiddleware(validator Validator, options Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, err := authenticate(r.Context(), validator, options, bearerToken(r.Header.Get("Authorization")))
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func authenticate(ctx context.Context, validator Validator, options Options, tokenString string) (context.Context, error) {
+	if tokenString == "" {
+		if options.AllowAnonymous {
+			return ctx, nil
+		}
+		return nil, ErrMissingToken
+	}
+
+	token, err := validator.Validate(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.NewContext(ctx, domain.NewClaims(token)), nil
+}
+
+func bearerFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	return bearerToken(values[0])
+}
+
+// bearerToken 取出 "Bearer xxx" 的 token, 沒有 Bearer 前綴時整個值都當成 token
+func bearerToken(val string) string {
+	val = strings.TrimSpace(val)
+	if len(val) > 7 && strings.EqualFold(val[:7], "bearer ") {
+		return strings.TrimSpace(val[7:])
+	}
+	return val
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// toStatusError 把 AppError 轉成 grpc status, 其他錯誤 (例如 identity 無法連線) 回傳 Unavailable
+func toStatusError(err error) error {
+	var appErr *domain.AppError
+	if !errors.As(err, &appErr) {
+		if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+			return st.Err()
+		}
+		return status.Error(codes.Unavailable, "token validation is unavailable")
+	}
+
+	st := status.New(appErr.Status, appErr.Message)
+	detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: appErr.Code,
+		Domain: errorDomain,
+	})
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, resp := http.StatusServiceUnavailable, errorResponse{Code: "UNAVAILABLE", Message: "token validation is unavailable"}
+
+	var appErr *domain.AppError
+	if errors.As(err, &appErr) {
+		resp = errorResponse{Code: appErr.Code, Message: appErr.Message}
+		switch appErr.Status {
+		case codes.Unauthenticated:
+			code = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		case codes.PermissionDenied:
+			code = http.StatusForbidden
+		default:
+			code = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+	validator fakeValidator
+}
+
+func TestMiddlewareTestSuite(t *testing.T) {
+	suite.Run(t, &MiddlewareTestSuite{})
+}
+
+func (suite *MiddlewareTestSuite) SetupTest() {
+	suite.validator = fakeValidator{
+		"alice-token": {AccountID: 1, Namespace: "test.identity", Username: "alice", Claims: map[string]string{
+			domain.ClaimRole:       "editor viewer",
+			domain.ClaimPermission: "post.read post.write",
+			domain.PairTokenKey:    "refresh-token",
+		}},
+	}
+}
+
+type fakeValidator map[string]*domain.Token
+
+func (v fakeValidator) Validate(ctx context.Context, tokenString string) (*domain.Token, error) {
+	token, ok := v[tokenString]
+	if !ok {
+		return nil, domain.ErrInvalidToken
+	}
+	return token, nil
+}
+
+func (suite *MiddlewareTestSuite) serve(handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *MiddlewareTestSuite) TestHTTPMiddleware() {
+	var claims domain.Claims
+	handler := NewHTTPMiddleware(suite.validator, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = domain.FromContext(r.Context())
+	}))
+
+	rec := suite.serve(handler, "")
+	suite.Equal(http.StatusUnauthorized, rec.Code)
+	suite.Contains(rec.Body.String(), ErrMissingToken.Code)
+
+	rec = suite.serve(handler, "Bearer unknown")
+	suite.Equal(http.StatusUnauthorized, rec.Code)
+	suite.Contains(rec.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	rec = suite.serve(handler, "Bearer alice-token")
+	suite.Equal(http.StatusOK, rec.Code)
+	suite.Equal(int64(1), claims[domain.ClaimAccountID])
+	suite.Equal("alice", claims[domain.ClaimUsername])
+	suite.NotContains(claims, domain.PairTokenKey)
+
+	anonymous := NewHTTPMiddleware(suite.validator, Options{AllowAnonymous: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	suite.Equal(http.StatusOK, suite.serve(anonymous, "").Code)
+	suite.Equal(http.StatusUnauthorized, suite.serve(anonymous, "Bearer unknown").Code)
+}
+
+func (suite *MiddlewareTestSuite) TestHTTPGuard() {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	authenticate := NewHTTPMiddleware(suite.validator, Options{})
+
+	suite.Equal(http.StatusOK, suite.serve(authenticate(HTTPGuard(RequireRole("admin", "editor"))(ok)), "Bearer alice-token").Code)
+	suite.Equal(http.StatusForbidden, suite.serve(authenticate(HTTPGuard(RequireRole("admin"))(ok)), "Bearer alice-token").Code)
+	suite.Equal(http.StatusOK, suite.serve(authenticate(HTTPGuard(RequirePermission("post.read", "post.write"))(ok)), "Bearer alice-token").Code)
+	suite.Equal(http.StatusForbidden, suite.serve(authenticate(HTTPGuard(RequirePermission("post.read", "post.delete"))(ok)), "Bearer alice-token").Code)
+}
+
+func (suite *MiddlewareTestSuite) TestUnaryServerInterceptor() {
+	interceptor := NewUnaryServerInterceptor(suite.validator, Options{})
+	guard := UnaryGuard("/blog.BlogService/", RequireRole("admin"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/blog.BlogService/DeletePost"}
+
+	var claims domain.Claims
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		claims, _ = domain.FromContext(ctx)
+		return "ok", nil
+	}
+
+	_, err := interceptor(context.Background(), nil, info, handler)
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer alice-token"))
+	resp, err := interceptor(ctx, nil, info, handler)
+	suite.Require().NoError(err)
+	suite.Equal("ok", resp)
+	suite.Equal("editor viewer", claims[domain.ClaimRole])
+
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return guard(ctx, req, info, handler)
+	})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+// fakeIdentityClient 只實作 Token
+type fakeIdentityClient struct {
+	identityProto.IdentityServiceClient
+	calls int
+}
+
+func (c *fakeIdentityClient) Token(ctx context.Context, in *identityProto.TokenRequest, opts ...grpc.CallOption) (*identityProto.TokenResponse, error) {
+	c.calls++
+	if in.TokenKey != "alice-token" {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &identityProto.TokenResponse{Token: &identityProto.Token{AccountId: 1, Namespace: "test.identity", ExpiresIn: 60}}, nil
+}
+
+func (suite *MiddlewareTestSuite) TestRemoteValidator() {
+	client := &fakeIdentityClient{}
+	validator := NewRemoteValidator(client, RemoteValidatorOptions{CacheTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		token, err := validator.Validate(context.Background(), "alice-token")
+		suite.Require().NoError(err)
+		suite.Equal(int64(1), token.AccountID)
+	}
+	suite.Equal(1, client.calls)
+
+	_, err := validator.Validate(context.Background(), "unknown")
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+}
+
+func (suite *MiddlewareTestSuite) TestJWTValidator() {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: privateKey.Public(), KeyID: "key-1", Algorithm: "ES256", Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+
+	sign := func(issuer string, expiresAt time.Time) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, accessTokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				ExpiresAt: jwt.NewNumericDate(expiresAt),
+				ID:        "jti",
+			},
+			AccountID: 1,
+			Namespace: "test.identity",
+			SessionID: "session-1",
+			Claims:    map[string]string{domain.ClaimRole: "admin"},
+		})
+		token.Header["kid"] = "key-1"
+
+		tokenString, err := token.SignedString(privateKey)
+		suite.Require().NoError(err)
+		return tokenString
+	}
+
+	validator := NewJWTValidator(JWTValidatorOptions{JWKSURL: server.URL, Issuer: "https://identity.test"})
+
+	token, err := validator.Validate(context.Background(), sign("https://identity.test", time.Now().Add(time.Minute)))
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), token.AccountID)
+	suite.Equal("session-1", token.Claims[domain.SessionKey])
+	suite.Equal("admin", token.Claims[domain.ClaimRole])
+
+	_, err = validator.Validate(context.Background(), sign("https://evil.test", time.Now().Add(time.Minute)))
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+
+	_, err = validator.Validate(context.Background(), sign("https://identity.test", time.Now().Add(-time.Minute)))
+	suite.ErrorIs(err, domain.ErrInvalidToken)
+}
@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"identity/pkg/domain"
+	identityProto "identity/pkg/identity/proto"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	defaultCacheTTL        = 30 * time.Second
+	defaultCacheMaxEntries = 10000
+	defaultRefreshInterval = 5 * time.Minute
+)
+
+// Validator 驗證 bearer token 並回傳 token 的內容, token 不正確時回傳 domain.ErrInvalidToken
+type Validator interface {
+	Validate(ctx context.Context, tokenString string) (*domain.Token, error)
+}
+
+// RemoteValidatorOptions 是 RemoteValidator 的設定
+type RemoteValidatorOptions struct {
+	// CacheTTL 驗證結果在本地快取的時間, 不會超過 token 本身的期限, 小於 0 代表不快取
+	CacheTTL time.Duration
+	// MaxEntries 快取的上限
+	MaxEntries int
+}
+
+// RemoteValidator 呼叫 identity 的 Token RPC 驗證 token, 支援 opaque token, JWT 與 API key
+// token 被撤銷之後, 最多還會被快取 CacheTTL 的時間
+type RemoteValidator struct {
+	client  identityProto.IdentityServiceClient
+	options RemoteValidatorOptions
+
+	mu    sync.Mutex
+	cache map[string]cachedToken
+}
+
+type cachedToken struct {
+	token     domain.Token
+	expiresAt time.Time
+}
+
+func NewRemoteValidator(client identityProto.IdentityServiceClient, options RemoteValidatorOptions) *RemoteValidator {
+	if options.CacheTTL == 0 {
+		options.CacheTTL = defaultCacheTTL
+	}
+
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = defaultCacheMaxEntries
+	}
+
+	return &RemoteValidator{
+		client:  client,
+		options: options,
+		cache:   map[string]cachedToken{},
+	}
+}
+
+func (v *RemoteValidator) Validate(ctx context.Context, tokenString string) (*domain.Token, error) {
+	if token, ok := v.cached(tokenString); ok {
+		return token, nil
+	}
+
+	resp, err := v.client.Token(ctx, &identityProto.TokenRequest{TokenKey: tokenString})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound, codes.Unauthenticated:
+			return nil, domain.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if resp.Token == nil {
+		return nil, domain.ErrInvalidToken
+	}
+
+	token := domain.Token{
+		AccountID:        resp.Token.AccountId,
+		Namespace:        resp.Token.Namespace,
+		ExpiresIn:        resp.Token.ExpiresIn,
+		TokenString:      resp.Token.TokenString,
+		Claims:           resp.Token.Claims,
+		Username:         resp.Token.Username,
+		AccountType:      resp.Token.AccountType,
+		RefreshExpiresIn: resp.Token.RefreshExpiresIn,
+	}
+	v.store(tokenString, token)
+
+	return &token, nil
+}
+
+func (v *RemoteValidator) cached(tokenString string) (*domain.Token, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, ok := v.cache[tokenString]
+	if !ok {
+		return nil, false
+	}
+
+	if !time.Now().Before(entry.expiresAt) {
+		delete(v.cache, tokenString)
+		return nil, false
+	}
+
+	token := entry.token
+	return &token, true
+}
+
+// store 快取驗證結果, 快取滿了時先清掉過期的, 還是滿的話隨機移除一筆
+func (v *RemoteValidator) store(tokenString string, token domain.Token) {
+	if v.options.CacheTTL < 0 {
+		return
+	}
+
+	ttl := v.options.CacheTTL
+	if token.ExpiresIn > 0 && time.Duration(token.ExpiresIn)*time.Second < ttl {
+		ttl = time.Duration(token.ExpiresIn) * time.Second
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if len(v.cache) >= v.options.MaxEntries {
+		for k, entry := range v.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(v.cache, k)
+			}
+		}
+	}
+
+	if len(v.cache) >= v.options.MaxEntries {
+		for k := range v.cache {
+			delete(v.cache, k)
+			break
+		}
+	}
+
+	v.cache[tokenString] = cachedToken{token: token, expiresAt: now.Add(ttl)}
+}
+
+// JWTValidatorOptions 是 JWTValidator 的設定
+type JWTValidatorOptions struct {
+	// JWKSURL identity 的 /.well-known/jwks.json
+	JWKSURL string
+	// Issuer 有設定時會檢查 JWT 的 iss
+	Issuer string
+	// RefreshInterval 重新下載 JWKS 的間隔, 遇到不認得的 kid 時也會重新下載
+	RefreshInterval time.Duration
+	HTTPClient      *http.Client
+}
+
+// JWTValidator 用 JWKS 離線驗證 JWT 格式的 accessToken, 不需要呼叫 identity,
+// 但是無法得知 token 已經被撤銷, 需要即時撤銷的 service 請使用 RemoteValidator
+type JWTValidator struct {
+	options JWTValidatorOptions
+
+	mu        sync.Mutex
+	keys      map[string]jose.JSONWebKey
+	fetchedAt time.Time
+}
+
+func NewJWTValidator(options JWTValidatorOptions) *JWTValidator {
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = defaultRefreshInterval
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &JWTValidator{
+		options: options,
+		keys:    map[string]jose.JSONWebKey{},
+	}
+}
+
+// accessTokenClaims 是 identity 簽發的 JWT accessToken 的內容
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	AccountID   int64             `json:"account_id"`
+	Namespace   string            `json:"namespace"`
+	AccountType int32             `json:"account_type"`
+	Username    string            `json:"username,omitempty"`
+	SessionID   string            `json:"sid,omitempty"`
+	Actor       *domain.Actor     `json:"act,omitempty"`
+	Claims      map[string]string `json:"claims,omitempty"`
+}
+
+func (v *JWTValidator) Validate(ctx context.Context, tokenString string) (*domain.Token, error) {
+	var claims accessTokenClaims
+
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+
+		return key.Key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("middleware: parse jwt failed: %v. %w", err, domain.ErrInvalidToken)
+	}
+
+	if v.options.Issuer != "" && !claims.VerifyIssuer(v.options.Issuer, true) {
+		return nil, fmt.Errorf("middleware: jwt issuer is invalid. %w", domain.ErrInvalidToken)
+	}
+
+	token := domain.Token{
+		AccountID:   claims.AccountID,
+		Namespace:   claims.Namespace,
+		AccountType: claims.AccountType,
+		Username:    claims.Username,
+		TokenString: claims.ID,
+		Claims:      map[string]string{},
+	}
+	for k, val := range claims.Claims {
+		token.Claims[k] = val
+	}
+
+	if claims.SessionID != "" {
+		token.Claims[domain.SessionKey] = claims.SessionID
+	}
+
+	if claims.Actor != nil {
+		actor, err := json.Marshal(claims.Actor)
+		if err != nil {
+			return nil, err
+		}
+		token.Claims[domain.ClaimActor] = string(actor)
+	}
+
+	if claims.ExpiresAt != nil {
+		token.ExpiresIn = int64(time.Until(claims.ExpiresAt.Time) / time.Second)
+	}
+
+	return &token, nil
+}
+
+// key 回傳 kid 對應的公鑰, 找不到時重新下載 JWKS, 但是同一個 RefreshInterval 內最多下載一次
+func (v *JWTValidator) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	if ok && time.Since(v.fetchedAt) < v.options.RefreshInterval {
+		return &key, nil
+	}
+
+	if !ok && time.Since(v.fetchedAt) < v.options.RefreshInterval && len(v.keys) > 0 {
+		return nil, fmt.Errorf("signing key %s was not found", kid)
+	}
+
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		// 下載失敗時先用舊的金鑰
+		if ok {
+			return &key, nil
+		}
+		return nil, err
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("signing key %s was not found", kid)
+	}
+	return &key, nil
+}
+
+func (v *JWTValidator) fetchKeys(ctx context.Context) (map[string]jose.JSONWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.options.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := v.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("middleware: fetch jwks failed with status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	var jwks jose.JSONWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jose.JSONWebKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys[key.KeyID] = key
+	}
+	return keys, nil
+}